@@ -3,6 +3,7 @@ package ast
 
 import (
 	"bytes"
+	"strconv"
 	"strings"
 
 	"github.com/ajwerner/monkey/token"
@@ -11,6 +12,9 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Line returns the 1-based line of the node's first token, or 0 if it
+	// is unknown.
+	Line() int
 }
 
 type Statement interface {
@@ -25,6 +29,8 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	// Comments holds every comment in the source, in order.
+	Comments []token.Token
 }
 
 func (p *Program) String() string {
@@ -45,20 +51,33 @@ func (p *Program) TokenLiteral() string {
 	return p.Statements[0].TokenLiteral()
 }
 
+// Line returns the line of the program's first statement.
+func (p *Program) Line() int {
+	if len(p.Statements) == 0 {
+		return 0
+	}
+	return p.Statements[0].Line()
+}
+
 type LetStatement struct {
 	Token token.Token
 	Name  *Identifier
+	Type  *TypeExpr `ast:"optional"` // nil if the binding is not annotated
 	Value Expression
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Line() int            { return ls.Token.Line }
 
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -70,6 +89,64 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// ProtocolStatement declares a protocol: the members, methods and fields,
+// which a hash must have to be used where the protocol is annotated.
+type ProtocolStatement struct {
+	Token   token.Token // The 'protocol' token
+	Name    *Identifier
+	Members []*ProtocolMember
+}
+
+func (ps *ProtocolStatement) statementNode()       {}
+func (ps *ProtocolStatement) TokenLiteral() string { return ps.Token.Literal }
+func (ps *ProtocolStatement) Line() int            { return ps.Token.Line }
+func (ps *ProtocolStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("protocol " + ps.Name.String() + " { ")
+	for _, m := range ps.Members {
+		out.WriteString(m.String() + "; ")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+// ProtocolMember is a method, fn name(params) -> result, or a field,
+// name: type, of a protocol.
+type ProtocolMember struct {
+	Token      token.Token // The 'fn' token of a method or the name of a field
+	Name       *Identifier
+	Method     bool
+	Parameters []*Identifier
+	Type       *TypeExpr // the type of a field or the result of a method, if given
+}
+
+func (pm *ProtocolMember) TokenLiteral() string { return pm.Token.Literal }
+func (pm *ProtocolMember) Line() int            { return pm.Token.Line }
+func (pm *ProtocolMember) String() string {
+	if !pm.Method {
+		if pm.Type == nil {
+			return pm.Name.String()
+		}
+		return pm.Name.String() + ": " + pm.Type.String()
+	}
+	var out bytes.Buffer
+	out.WriteString("fn " + pm.Name.String() + "(")
+	for i, p := range pm.Parameters {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(p.String())
+		if p.Type != nil {
+			out.WriteString(": " + p.Type.String())
+		}
+	}
+	out.WriteString(")")
+	if pm.Type != nil {
+		out.WriteString(" -> " + pm.Type.String())
+	}
+	return out.String()
+}
+
 type ReturnStatement struct {
 	Token       token.Token // the 'return' token
 	ReturnValue Expression
@@ -77,6 +154,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Line() int            { return rs.Token.Line }
 
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
@@ -92,6 +170,26 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// BreakStatement ends the innermost loop.
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Line() int            { return bs.Token.Line }
+func (bs *BreakStatement) String() string       { return bs.TokenLiteral() + ";" }
+
+// ContinueStatement starts the next iteration of the innermost loop.
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Line() int            { return cs.Token.Line }
+func (cs *ContinueStatement) String() string       { return cs.TokenLiteral() + ";" }
+
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
 	Expression Expression
@@ -99,6 +197,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Line() int            { return es.Token.Line }
 
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
@@ -110,10 +209,12 @@ func (es *ExpressionStatement) String() string {
 type Identifier struct {
 	Token token.Token
 	Value string
+	Type  *TypeExpr `ast:"optional"` // the annotation of a function parameter, if any
 }
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Line() int            { return i.Token.Line }
 func (i *Identifier) String() string       { return i.Value }
 
 type IntegerLiteral struct {
@@ -123,6 +224,7 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Line() int            { return il.Token.Line }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 type FloatLiteral struct {
@@ -132,6 +234,7 @@ type FloatLiteral struct {
 
 func (il *FloatLiteral) expressionNode()      {}
 func (il *FloatLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *FloatLiteral) Line() int            { return il.Token.Line }
 func (il *FloatLiteral) String() string       { return il.Token.Literal }
 
 type PrefixExpression struct {
@@ -142,6 +245,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Line() int            { return pe.Token.Line }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -162,6 +266,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Line() int            { return ie.Token.Line }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -181,8 +286,18 @@ type Bool struct {
 
 func (b *Bool) expressionNode()      {}
 func (b *Bool) TokenLiteral() string { return b.Token.Literal }
+func (b *Bool) Line() int            { return b.Token.Line }
 func (b *Bool) String() string       { return b.Token.Literal }
 
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (n *NullLiteral) expressionNode()      {}
+func (n *NullLiteral) TokenLiteral() string { return n.Token.Literal }
+func (n *NullLiteral) Line() int            { return n.Token.Line }
+func (n *NullLiteral) String() string       { return n.Token.Literal }
+
 type IfExpression struct {
 	Token       token.Token // The 'if' token
 	Condition   Expression
@@ -192,6 +307,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Line() int            { return ie.Token.Line }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -208,6 +324,68 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// WhileExpression evaluates Body as long as Condition is truthy, and then
+// evaluates to null.
+type WhileExpression struct {
+	Token     token.Token // The 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (we *WhileExpression) expressionNode()      {}
+func (we *WhileExpression) TokenLiteral() string { return we.Token.Literal }
+func (we *WhileExpression) Line() int            { return we.Token.Line }
+func (we *WhileExpression) String() string {
+	return "while" + we.Condition.String() + " " + we.Body.String()
+}
+
+// ForExpression evaluates Body with Value bound to each element of the array
+// Iterable and Key, if any, to its index, or with Value bound to each value
+// of the hash Iterable and Key to its key. With only one variable, Value is
+// bound to the keys of a hash. The loop evaluates to null.
+type ForExpression struct {
+	Token    token.Token // The 'for' token
+	Key      *Identifier // nil unless the loop has two variables
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fe *ForExpression) expressionNode()      {}
+func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) Line() int            { return fe.Token.Line }
+func (fe *ForExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fe.Key != nil {
+		out.WriteString(fe.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fe.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fe.Body.String())
+
+	return out.String()
+}
+
+// AssignExpression rebinds the variable Name to Value, and evaluates to
+// Value.
+type AssignExpression struct {
+	Token token.Token // The '=' token
+	Name  *Identifier
+	Value Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Line() int            { return ae.Token.Line }
+func (ae *AssignExpression) String() string {
+	return "(" + ae.Name.String() + " = " + ae.Value.String() + ")"
+}
+
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
@@ -215,6 +393,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Line() int            { return bs.Token.Line }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -226,24 +405,34 @@ func (bs *BlockStatement) String() string {
 }
 
 type FunctionLiteral struct {
-	Token      token.Token // The 'fn' token
+	Token      token.Token   // The 'fn' token
+	TypeParams []*Identifier `ast:"optional"` // the names of the type parameters of a generic function
 	Parameters []*Identifier
+	Result     *TypeExpr `ast:"optional"` // nil if the result type is not annotated
 	Body       *BlockStatement
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Line() int            { return fl.Token.Line }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString(fl.TokenLiteral())
+	writeTypeParams(&out, fl.TypeParams)
 	out.WriteString("(")
 	for i, p := range fl.Parameters {
 		if i > 0 {
 			out.WriteString(", ")
 		}
 		out.WriteString(p.String())
+		if p.Type != nil {
+			out.WriteString(": " + p.Type.String())
+		}
 	}
 	out.WriteString(") ")
+	if fl.Result != nil {
+		out.WriteString("-> " + fl.Result.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
@@ -257,6 +446,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Line() int            { return ce.Token.Line }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -273,6 +463,22 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// TypeAssertion tests whether the value of Left has a type, evaluating to a
+// Bool for "is" and to the value, or an error, for "as".
+type TypeAssertion struct {
+	Token    token.Token // The 'is' or 'as' token
+	Left     Expression
+	Operator string
+	Type     *TypeExpr
+}
+
+func (ta *TypeAssertion) expressionNode()      {}
+func (ta *TypeAssertion) TokenLiteral() string { return ta.Token.Literal }
+func (ta *TypeAssertion) Line() int            { return ta.Token.Line }
+func (ta *TypeAssertion) String() string {
+	return "(" + ta.Left.String() + " " + ta.Operator + " " + ta.Type.String() + ")"
+}
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -280,8 +486,34 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Line() int            { return sl.Token.Line }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
+// ImportExpression evaluates to the module named by Module.
+type ImportExpression struct {
+	Token  token.Token // the 'import' token
+	Module *StringLiteral
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) Line() int            { return ie.Token.Line }
+func (ie *ImportExpression) String() string {
+	return "import " + strconv.Quote(ie.Module.Value)
+}
+
+// AwaitExpression waits for the Future which Value evaluates to and
+// evaluates to its result.
+type AwaitExpression struct {
+	Token token.Token // the 'await' token
+	Value Expression
+}
+
+func (ae *AwaitExpression) expressionNode()      {}
+func (ae *AwaitExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AwaitExpression) Line() int            { return ae.Token.Line }
+func (ae *AwaitExpression) String() string       { return "(await " + ae.Value.String() + ")" }
+
 type ArrayLiteral struct {
 	Token    token.Token // the '[' token
 	Elements []Expression
@@ -289,6 +521,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Line() int            { return al.Token.Line }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString("[")
@@ -310,6 +543,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Line() int            { return ie.Token.Line }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -324,19 +558,78 @@ func (ie *IndexExpression) String() string {
 
 type HashLiteral struct {
 	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Pairs []HashPair  // in the order of the source text
+}
+
+// HashPair is a key and its value in a HashLiteral.
+type HashPair struct {
+	Key   Expression
+	Value Expression
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Line() int            { return hl.Token.Line }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString("{")
-	for k, v := range hl.Pairs {
-		out.WriteString(k.String())
+	for _, pair := range hl.Pairs {
+		out.WriteString(pair.Key.String())
 		out.WriteString(":")
-		out.WriteString(v.String())
+		out.WriteString(pair.Value.String())
 	}
 	out.WriteString("}")
 	return out.String()
 }
+
+// TypeExpr is a type annotation: a name such as int, optionally followed by
+// type arguments as in array<int>, or a function type fn(int) -> bool.
+// Annotations are checked by the types package and ignored when running.
+type TypeExpr struct {
+	Token      token.Token // The name or 'fn' token
+	Name       string
+	TypeParams []*Identifier // the type parameters of a generic fn
+	Args       []*TypeExpr   // type arguments, or the parameter types of fn; nil for any function
+	Result     *TypeExpr     // the result type of fn, if given
+}
+
+func (t *TypeExpr) TokenLiteral() string { return t.Token.Literal }
+func (t *TypeExpr) Line() int            { return t.Token.Line }
+func (t *TypeExpr) String() string {
+	var out bytes.Buffer
+	out.WriteString(t.Name)
+	writeTypeParams(&out, t.TypeParams)
+	lb, rb := "<", ">"
+	if t.Name == "fn" {
+		lb, rb = "(", ")"
+	}
+	if len(t.Args) > 0 || t.Name == "fn" && t.Args != nil {
+		out.WriteString(lb)
+		for i, a := range t.Args {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(a.String())
+		}
+		out.WriteString(rb)
+	}
+	if t.Result != nil {
+		out.WriteString(" -> " + t.Result.String())
+	}
+	return out.String()
+}
+
+// writeTypeParams writes the type parameters of a generic function.
+func writeTypeParams(out *bytes.Buffer, params []*Identifier) {
+	if len(params) == 0 {
+		return
+	}
+	out.WriteString("<")
+	for i, p := range params {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		out.WriteString(p.String())
+	}
+	out.WriteString(">")
+}
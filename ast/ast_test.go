@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/ajwerner/monkey/token"
@@ -27,3 +28,127 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestDumpSExpr(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token: token.Token{Type: token.LET, Literal: "let", Line: 1},
+				Name: &Identifier{
+					Token: token.Token{Type: token.IDENT, Literal: "x", Line: 1},
+					Value: "x",
+				},
+				Value: &InfixExpression{
+					Token:    token.Token{Type: token.PLUS, Literal: "+", Line: 1},
+					Left:     &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1", Line: 1}, Value: 1},
+					Operator: "+",
+					Right:    &StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a", Line: 1}, Value: "a"},
+				},
+			},
+			&ExpressionStatement{
+				Token:      token.Token{Type: token.IDENT, Literal: "x", Line: 2},
+				Expression: &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x", Line: 2}, Value: "x"},
+			},
+		},
+	}
+
+	var out strings.Builder
+	if err := DumpSExpr(&out, program); err != nil {
+		t.Fatal(err)
+	}
+	expected := `(LetStatement (Identifier "x") (InfixExpression (IntegerLiteral 1) "+" (StringLiteral "a")))
+(ExpressionStatement (Identifier "x"))
+`
+	if out.String() != expected {
+		t.Errorf("DumpSExpr wrong.\nwant=%q\ngot =%q", expected, out.String())
+	}
+
+	out.Reset()
+	if err := DumpJSON(&out, program.Statements[1]); err != nil {
+		t.Fatal(err)
+	}
+	expected = `{
+  "type": "ExpressionStatement",
+  "line": 2,
+  "Expression": {
+    "type": "Identifier",
+    "line": 2,
+    "Value": "x"
+  }
+}
+`
+	if out.String() != expected {
+		t.Errorf("DumpJSON wrong.\nwant=%q\ngot =%q", expected, out.String())
+	}
+}
+
+func TestDumpHashLiteral(t *testing.T) {
+	hash := &HashLiteral{Pairs: []HashPair{
+		{Key: &StringLiteral{Value: "b"}, Value: &IntegerLiteral{Value: 1}},
+		{Key: &StringLiteral{Value: "a"}, Value: &IntegerLiteral{Value: 2}},
+	}}
+
+	var out strings.Builder
+	if err := DumpSExpr(&out, hash); err != nil {
+		t.Fatal(err)
+	}
+	expected := `(HashLiteral (((StringLiteral "b") (IntegerLiteral 1)) ((StringLiteral "a") (IntegerLiteral 2))))
+`
+	if out.String() != expected {
+		t.Errorf("DumpSExpr wrong.\nwant=%q\ngot =%q", expected, out.String())
+	}
+
+	out.Reset()
+	if err := DumpJSON(&out, &HashLiteral{Pairs: hash.Pairs[:1]}); err != nil {
+		t.Fatal(err)
+	}
+	expected = `{
+  "type": "HashLiteral",
+  "Pairs": [
+    {
+      "key": {
+        "type": "StringLiteral",
+        "Value": "b"
+      },
+      "value": {
+        "type": "IntegerLiteral",
+        "Value": 1
+      }
+    }
+  ]
+}
+`
+	if out.String() != expected {
+		t.Errorf("DumpJSON wrong.\nwant=%q\ngot =%q", expected, out.String())
+	}
+}
+
+func TestDumpPretty(t *testing.T) {
+	str := func(s string) Expression { return &StringLiteral{Value: s} }
+	hash := &HashLiteral{Pairs: []HashPair{
+		{Key: str("name"), Value: str("monkey")},
+		{Key: str("description"), Value: str("a language for the curious")},
+	}}
+
+	var out strings.Builder
+	if err := DumpSExpr(&out, hash); err != nil {
+		t.Fatal(err)
+	}
+	expected := `(HashLiteral (((StringLiteral "name") (StringLiteral "monkey")) ((StringLiteral "description") (StringLiteral "a language for the curious"))))
+`
+	if out.String() != expected {
+		t.Errorf("DumpSExpr wrong.\nwant=%q\ngot =%q", expected, out.String())
+	}
+
+	out.Reset()
+	if err := DumpPretty(&out, hash); err != nil {
+		t.Fatal(err)
+	}
+	expected = `(HashLiteral
+  (((StringLiteral "name") (StringLiteral "monkey"))
+   ((StringLiteral "description") (StringLiteral "a language for the curious"))))
+`
+	if out.String() != expected {
+		t.Errorf("DumpPretty wrong.\nwant=\n%s\ngot =\n%s", expected, out.String())
+	}
+}
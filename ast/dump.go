@@ -0,0 +1,253 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// DumpJSON writes an indented JSON serialization of the tree rooted at node.
+// Each node is an object with a "type" key naming the node type, a "line" key
+// with the line of its token if it has one, and one key per exported field
+// other than absent optional fields, such as type annotations.
+func DumpJSON(w io.Writer, node Node) error {
+	data, err := json.Marshal(dump(reflect.ValueOf(node)))
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// DumpSExpr writes the tree rooted at node as an S-expression. Programs are
+// written one statement per line.
+func DumpSExpr(w io.Writer, node Node) error {
+	var out strings.Builder
+	if p, ok := node.(*Program); ok {
+		for _, s := range p.Statements {
+			writeSExpr(&out, dump(reflect.ValueOf(s)))
+			out.WriteByte('\n')
+		}
+	} else {
+		writeSExpr(&out, dump(reflect.ValueOf(node)))
+		out.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// DumpPretty writes the tree rooted at node as DumpSExpr does, but writes a
+// node or list which does not fit in a line of 80 columns over several
+// lines, one field or element per line. A node's fields are indented by two
+// spaces and a list's elements are aligned after its opening parenthesis.
+func DumpPretty(w io.Writer, node Node) error {
+	var out strings.Builder
+	nodes := []Node{node}
+	if p, ok := node.(*Program); ok {
+		nodes = nodes[:0]
+		for _, s := range p.Statements {
+			nodes = append(nodes, s)
+		}
+	}
+	for _, n := range nodes {
+		writePretty(&out, dump(reflect.ValueOf(n)), 0)
+		out.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// prettyWidth is the width of the lines which DumpPretty keeps nodes within.
+const prettyWidth = 80
+
+// dumpedNode is the generic form of a node used by the Dump functions. Field
+// values are nil, primitives, *dumpedNode, []interface{} or []dumpedPair.
+type dumpedNode struct {
+	typ    string
+	line   int
+	fields []dumpedField
+}
+
+type dumpedField struct {
+	name  string
+	value interface{}
+}
+
+type dumpedPair struct {
+	Key   interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+func (n *dumpedNode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"type":%q`, n.typ)
+	if n.line > 0 {
+		fmt.Fprintf(&buf, `,"line":%d`, n.line)
+	}
+	for _, f := range n.fields {
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, ",%q:%s", f.name, v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+
+func dump(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			return dump(v.Elem())
+		}
+		if v.Type().Implements(nodeType) && v.Elem().Kind() == reflect.Struct {
+			return dumpStruct(v.Elem())
+		}
+		return dump(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if pairs, ok := v.Interface().([]HashPair); ok {
+			return dumpPairs(pairs)
+		}
+		elems := make([]interface{}, v.Len())
+		for i := range elems {
+			elems[i] = dump(v.Index(i))
+		}
+		return elems
+	default:
+		return v.Interface()
+	}
+}
+
+func dumpStruct(v reflect.Value) *dumpedNode {
+	n := &dumpedNode{typ: v.Type().Name()}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		switch {
+		case f.PkgPath != "":
+			continue
+		case f.Name == "Token":
+			n.line = int(v.Field(i).FieldByName("Line").Int())
+			continue
+		case f.Tag.Get("ast") == "optional" && v.Field(i).IsNil():
+			// Most code is not annotated.
+			continue
+		}
+		n.fields = append(n.fields, dumpedField{f.Name, dump(v.Field(i))})
+	}
+	return n
+}
+
+// dumpPairs converts the pairs of a hash literal, in source order.
+func dumpPairs(pairs []HashPair) []dumpedPair {
+	dumped := make([]dumpedPair, len(pairs))
+	for i, p := range pairs {
+		dumped[i] = dumpedPair{dump(reflect.ValueOf(p.Key)), dump(reflect.ValueOf(p.Value))}
+	}
+	return dumped
+}
+
+func writeSExpr(out *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		out.WriteString("nil")
+	case *dumpedNode:
+		out.WriteString("(")
+		out.WriteString(v.typ)
+		for _, f := range v.fields {
+			out.WriteString(" ")
+			writeSExpr(out, f.value)
+		}
+		out.WriteString(")")
+	case []interface{}:
+		out.WriteString("(")
+		for i, e := range v {
+			if i > 0 {
+				out.WriteString(" ")
+			}
+			writeSExpr(out, e)
+		}
+		out.WriteString(")")
+	case []dumpedPair:
+		out.WriteString("(")
+		for i, p := range v {
+			if i > 0 {
+				out.WriteString(" ")
+			}
+			writeSExpr(out, p)
+		}
+		out.WriteString(")")
+	case dumpedPair:
+		out.WriteString("(")
+		writeSExpr(out, v.Key)
+		out.WriteString(" ")
+		writeSExpr(out, v.Value)
+		out.WriteString(")")
+	case string:
+		fmt.Fprintf(out, "%q", v)
+	default:
+		fmt.Fprint(out, v)
+	}
+}
+
+// writePretty writes v as writeSExpr does if it fits on the rest of a line
+// indented by indent columns, and otherwise writes its parts on lines
+// indented further.
+func writePretty(out *strings.Builder, v interface{}, indent int) {
+	var flat strings.Builder
+	writeSExpr(&flat, v)
+	if indent+flat.Len() <= prettyWidth {
+		out.WriteString(flat.String())
+		return
+	}
+	var head string
+	var parts []interface{}
+	switch v := v.(type) {
+	case *dumpedNode:
+		head = v.typ
+		for _, f := range v.fields {
+			parts = append(parts, f.value)
+		}
+	case []interface{}:
+		parts = v
+	case []dumpedPair:
+		for _, p := range v {
+			parts = append(parts, p)
+		}
+	case dumpedPair:
+		parts = []interface{}{v.Key, v.Value}
+	default:
+		out.WriteString(flat.String())
+		return
+	}
+	out.WriteString("(")
+	out.WriteString(head)
+	inner := indent + 1
+	if head != "" {
+		inner = indent + 2
+	}
+	for i, part := range parts {
+		if i > 0 || head != "" {
+			out.WriteString("\n")
+			out.WriteString(strings.Repeat(" ", inner))
+		}
+		writePretty(out, part, inner)
+	}
+	out.WriteString(")")
+}
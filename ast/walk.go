@@ -0,0 +1,120 @@
+package ast
+
+// Inspect traverses the AST rooted at node in depth-first order. It calls f
+// for each node; if f returns false, the children of that node are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *LetStatement:
+		Inspect(n.Name, f)
+		if n.Type != nil {
+			Inspect(n.Type, f)
+		}
+		Inspect(n.Value, f)
+	case *Identifier:
+		if n.Type != nil {
+			Inspect(n.Type, f)
+		}
+	case *ProtocolStatement:
+		Inspect(n.Name, f)
+		for _, m := range n.Members {
+			Inspect(m, f)
+		}
+	case *ProtocolMember:
+		Inspect(n.Name, f)
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		if n.Type != nil {
+			Inspect(n.Type, f)
+		}
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		if n.Consequence != nil {
+			Inspect(n.Consequence, f)
+		}
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+	case *WhileExpression:
+		Inspect(n.Condition, f)
+		if n.Body != nil {
+			Inspect(n.Body, f)
+		}
+	case *AssignExpression:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *ForExpression:
+		if n.Key != nil {
+			Inspect(n.Key, f)
+		}
+		Inspect(n.Value, f)
+		Inspect(n.Iterable, f)
+		if n.Body != nil {
+			Inspect(n.Body, f)
+		}
+	case *FunctionLiteral:
+		for _, p := range n.TypeParams {
+			Inspect(p, f)
+		}
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		if n.Result != nil {
+			Inspect(n.Result, f)
+		}
+		if n.Body != nil {
+			Inspect(n.Body, f)
+		}
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	case *ArrayLiteral:
+		for _, e := range n.Elements {
+			Inspect(e, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *ImportExpression:
+		Inspect(n.Module, f)
+	case *AwaitExpression:
+		Inspect(n.Value, f)
+	case *TypeAssertion:
+		Inspect(n.Left, f)
+		Inspect(n.Type, f)
+	case *TypeExpr:
+		for _, a := range n.Args {
+			Inspect(a, f)
+		}
+		if n.Result != nil {
+			Inspect(n.Result, f)
+		}
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			Inspect(pair.Key, f)
+			Inspect(pair.Value, f)
+		}
+	}
+}
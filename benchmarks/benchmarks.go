@@ -0,0 +1,237 @@
+// Package benchmarks contains a standard set of monkey workloads and the
+// machinery to time them against each execution engine.
+package benchmarks
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/vm"
+)
+
+// Workload is a monkey program used for benchmarking.
+type Workload struct {
+	Name   string
+	Source string
+	// Expected is the Inspect output of the program's result.
+	Expected string
+}
+
+// Workloads is the standard benchmark set.
+var Workloads = []Workload{
+	{
+		Name: "fib",
+		Source: `
+let fib = fn(n) {
+  if (n < 2) {
+    return n;
+  }
+  fib(n - 1) + fib(n - 2);
+};
+fib(15);`,
+		Expected: "610",
+	},
+	{
+		Name: "loop",
+		Source: `
+let loop = fn(i, acc) {
+  if (i == 0) {
+    return acc;
+  }
+  loop(i - 1, acc + i);
+};
+loop(1000, 0);`,
+		Expected: "500500",
+	},
+	{
+		Name: "strings",
+		Source: `
+let build = fn(i, s) {
+  if (i == 0) {
+    return s;
+  }
+  build(i - 1, s + "monkey");
+};
+len(build(500, ""));`,
+		Expected: "3000",
+	},
+	{
+		Name: "hash",
+		Source: `
+let churn = fn(i, acc) {
+  if (i == 0) {
+    return acc;
+  }
+  let h = {"a": i, "b": i * 2, i: acc, true: "t"};
+  churn(i - 1, h["a"] + h["b"] + h[i] - acc);
+};
+churn(500, 0);`,
+		Expected: "3",
+	},
+	{
+		Name: "record",
+		Source: `
+let step = fn(i, acc) {
+  if (i == 0) {
+    return acc;
+  }
+  let p = {"x": i, "y": i * 2, "total": acc};
+  step(i - 1, p["total"] + p["y"] - p["x"]);
+};
+step(500, 0);`,
+		Expected: "125250",
+	},
+	{
+		Name: "nbody",
+		Source: `
+let advance = fn(i, x, y, vx, vy) {
+  if (i == 0) {
+    return x * x + y * y;
+  }
+  let d = x * x + y * y + 0.01;
+  let f = 0.001 / (d * d);
+  let vx = vx - x * f;
+  let vy = vy - y * f;
+  advance(i - 1, x + vx * 0.01, y + vy * 0.01, vx, vy);
+};
+advance(500, 1.0, 0.0, 0.0, 0.5);`,
+		Expected: "4.783722501611891",
+	},
+	{
+		Name: "closures",
+		Source: `
+let compose = fn(f, g) { fn(x) { g(f(x)) } };
+let adder = fn(n) { fn(x) { x + n } };
+let build = fn(i, f) {
+  if (i == 0) {
+    return f;
+  }
+  build(i - 1, compose(f, adder(i)));
+};
+build(100, adder(0))(0);`,
+		Expected: "5050",
+	},
+}
+
+// LargeProgram returns the source of a program defining n functions, each
+// binding several locals and building a hash with string keys, and then
+// calling each of them. It evaluates to LargeProgramResult(n). It exercises
+// the parser and the lookup of identifiers and hash keys across many
+// distinct names.
+func LargeProgram(n int) string {
+	var out strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&out, `let %s = fn(count, offset) {
+  let base = count + offset;
+  let record = {"count": count, "offset": offset, "base": base, "name": "%[1]s"};
+  let total = record["count"] + record["offset"] + record["base"];
+  total - base + %d
+};
+`, funcName(i), i)
+	}
+	out.WriteString("let sum = 0;\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&out, "let sum = sum + %s(3, 2);\n", funcName(i))
+	}
+	out.WriteString("sum\n")
+	return out.String()
+}
+
+// LargeProgramResult returns the result of LargeProgram(n).
+func LargeProgramResult(n int) int64 {
+	return int64(5*n + n*(n-1)/2)
+}
+
+// funcName returns a distinct identifier for each i.
+func funcName(i int) string {
+	name := []byte("fn")
+	for {
+		name = append(name, byte('a'+i%26))
+		if i /= 26; i == 0 {
+			return string(name)
+		}
+	}
+}
+
+// Engine executes parsed programs.
+type Engine struct {
+	Name string
+	// Prepare performs any ahead-of-time work for program and returns a
+	// function which executes it once.
+	Prepare func(program *ast.Program) (run func() (object.Object, error), err error)
+}
+
+// Engines are the available execution engines.
+var Engines = []Engine{
+	{Name: "eval", Prepare: prepareEval},
+	{Name: "vm", Prepare: prepareVM},
+}
+
+func prepareEval(program *ast.Program) (func() (object.Object, error), error) {
+	return func() (object.Object, error) {
+		result := evaluator.Eval(program, object.NewEnvironment())
+		if errObj, ok := result.(object.Error); ok {
+			return nil, errObj.Err
+		}
+		return result, nil
+	}, nil
+}
+
+func prepareVM(program *ast.Program) (func() (object.Object, error), error) {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	bytecode := comp.Bytecode()
+	return func() (object.Object, error) {
+		machine := vm.New(bytecode)
+		if err := machine.Run(); err != nil {
+			return nil, err
+		}
+		return machine.LastPoppedStackElem(), nil
+	}, nil
+}
+
+// Prepare parses w and prepares it for e, verifying that a single execution
+// produces the expected result.
+func Prepare(w Workload, e Engine) (run func() (object.Object, error), err error) {
+	p := parser.New(lexer.New(w.Source))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, fmt.Errorf("%s: parse error: %v", w.Name, errs[0])
+	}
+	if run, err = e.Prepare(program); err != nil {
+		return nil, fmt.Errorf("%s: %v", w.Name, err)
+	}
+	result, err := run()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", w.Name, err)
+	}
+	if result == nil || result.Inspect() != w.Expected {
+		return nil, fmt.Errorf("%s: unexpected result %v, want %s",
+			w.Name, result, w.Expected)
+	}
+	return run, nil
+}
+
+// Bench runs w on e as a Go benchmark.
+func Bench(b *testing.B, w Workload, e Engine) {
+	run, err := Prepare(w, e)
+	if err != nil {
+		b.Skip(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
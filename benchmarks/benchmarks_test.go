@@ -0,0 +1,99 @@
+package benchmarks
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+)
+
+func TestWorkloadsEval(t *testing.T) {
+	for _, w := range Workloads {
+		if _, err := Prepare(w, Engines[0]); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func BenchmarkWorkloads(b *testing.B) {
+	for _, e := range Engines {
+		for _, w := range Workloads {
+			b.Run(e.Name+"/"+w.Name, func(b *testing.B) {
+				Bench(b, w, e)
+			})
+		}
+	}
+}
+
+// BenchmarkParse parses a large program with and without an arena,
+// reporting the garbage collections each parse causes.
+func BenchmarkParse(b *testing.B) {
+	src := LargeProgram(500)
+	for _, bc := range []struct {
+		name string
+		new  func(*lexer.Lexer) *parser.Parser
+	}{
+		{"heap", parser.New},
+		{"arena", parser.NewWithArena},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			for i := 0; i < b.N; i++ {
+				p := bc.new(lexer.New(src))
+				p.ParseProgram()
+				if errs := p.Errors(); len(errs) != 0 {
+					b.Fatal(errs[0])
+				}
+			}
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "gcs/op")
+		})
+	}
+}
+
+// BenchmarkParseSmall parses a short program, as a server running many
+// small programs does, with new and pooled parsers.
+func BenchmarkParseSmall(b *testing.B) {
+	const src = `let total = fn(xs) { if (len(xs) == 0) { 0 } else { first(xs) + total(rest(xs)) } }; total([1, 2, 3])`
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parser.New(lexer.New(src)).ParseProgram()
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		var pool parser.Pool
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p := pool.Get(src)
+			p.ParseProgram()
+			pool.Put(p)
+		}
+	})
+}
+
+// BenchmarkParseEval parses and evaluates a large program, as a script run
+// once by the monkey command is.
+func BenchmarkParseEval(b *testing.B) {
+	const n = 500
+	src := LargeProgram(n)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	for i := 0; i < b.N; i++ {
+		p := parser.New(lexer.New(src))
+		program := p.ParseProgram()
+		if errs := p.Errors(); len(errs) != 0 {
+			b.Fatal(errs[0])
+		}
+		result := evaluator.Eval(program, object.NewEnvironment())
+		if result != object.Integer(LargeProgramResult(n)) {
+			b.Fatalf("unexpected result %v", result)
+		}
+	}
+}
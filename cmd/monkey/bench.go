@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"text/tabwriter"
+
+	"github.com/ajwerner/monkey/benchmarks"
+)
+
+func benchCmd(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	pattern := fs.String("run", "", "only run workloads matching `regexp`")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("unexpected arguments")
+	}
+	re, err := regexp.Compile(*pattern)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "workload\tengine\tns/op\tB/op\tallocs/op\t")
+	for _, w := range benchmarks.Workloads {
+		if !re.MatchString(w.Name) {
+			continue
+		}
+		for _, e := range benchmarks.Engines {
+			if _, err := benchmarks.Prepare(w, e); err != nil {
+				fmt.Fprintf(tw, "%s\t%s\tunsupported\t\t\t\n", w.Name, e.Name)
+				continue
+			}
+			r := testing.Benchmark(func(b *testing.B) {
+				benchmarks.Bench(b, w, e)
+			})
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t\n",
+				w.Name, e.Name, r.NsPerOp(), r.AllocedBytesPerOp(), r.AllocsPerOp())
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/ajwerner/monkey/cover"
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/feature"
+)
+
+func coverCmd(args []string) error {
+	fs := flag.NewFlagSet("cover", flag.ExitOnError)
+	htmlOut := fs.String("html", "", "write an HTML coverage report to `file`")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected exactly one file")
+	}
+	path := fs.Arg(0)
+	src, program, err := parseFile(path, feature.Extended)
+	if err != nil {
+		return err
+	}
+	prof := cover.New(path, src, program)
+	evalErr := evalProgram(context.Background(), &evaluator.Evaluator{Trace: prof.Trace}, program)
+	if *htmlOut != "" {
+		f, err := os.Create(*htmlOut)
+		if err != nil {
+			return err
+		}
+		if err := prof.WriteHTML(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	} else if err := prof.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	return evalErr
+}
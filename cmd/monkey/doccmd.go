@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ajwerner/monkey/doc"
+	"github.com/ajwerner/monkey/feature"
+)
+
+func docCmd(args []string) error {
+	fs := flag.NewFlagSet("doc", flag.ExitOnError)
+	format := fs.String("format", "markdown", "output `format`: markdown or json")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected exactly one file")
+	}
+	path := fs.Arg(0)
+	_, program, err := parseFile(path, feature.Extended)
+	if err != nil {
+		return err
+	}
+	entries := doc.Extract(program)
+	switch *format {
+	case "markdown":
+		title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		return doc.WriteMarkdown(os.Stdout, title, entries)
+	case "json":
+		return doc.WriteJSON(os.Stdout, entries)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
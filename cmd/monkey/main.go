@@ -0,0 +1,124 @@
+// Command monkey executes monkey programs.
+//
+// Usage:
+//
+//	monkey [-engine eval|vm] [-vm] [-history FILE]
+//	                              start the repl, running lines with the
+//	                              evaluator or on the VM where it supports
+//	                              them; -vm is short for -engine vm and
+//	                              -history defaults to ~/.monkey_history
+//	monkey run [-tokens] [-ast [-format sexp|json]] FILE
+//	                              evaluate FILE, or print its tokens or AST
+//	monkey run -vm FILE           run FILE on the VM, or with the evaluator
+//	                              if it uses features the VM lacks
+//	monkey run -lang EDITION FILE evaluate FILE written in a language
+//	                              edition, such as classic
+//	monkey run -strict-index FILE fail on indexing out of range or with a
+//	                              missing key instead of returning null
+//	monkey run -check [-strict] FILE
+//	                              type check FILE before evaluating it
+//	monkey run [-cpuprofile F] [-memprofile F] [-trace F] FILE
+//	                              evaluate FILE while profiling
+//	monkey run [-max-steps N] [-max-depth N] [-max-memory SIZE]
+//	           [-timeout DURATION] [-no-io] FILE
+//	                              evaluate an untrusted FILE
+//	monkey cover [-html OUT] FILE evaluate FILE and report statement coverage
+//	monkey bench [-run REGEXP]    compare the evaluator and the VM on the
+//	                              standard benchmark workloads
+//	monkey playground [-addr ADDR] serve the web playground
+//	monkey doc [-format markdown|json] FILE
+//	                              print documentation from /// comments
+//	monkey types FILE             print the inferred types of the top-level
+//	                              bindings of FILE
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ajwerner/monkey/repl"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"run":        {"run [-tokens] [-ast [-format sexp|json]] [-vm] [-lang EDITION] [-strict-index] FILE", runCmd},
+	"cover":      {"cover [-html OUT] FILE", coverCmd},
+	"bench":      {"bench [-run REGEXP]", benchCmd},
+	"playground": {"playground [-addr ADDR]", playgroundCmd},
+	"doc":        {"doc [-format markdown|json] FILE", docCmd},
+	"types":      {"types FILE", typesCmd},
+}
+
+func main() {
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		if err := startRepl(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "monkey: %v\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "monkey %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out strings.Builder
+	out.WriteString("usage:\n\tmonkey [-engine eval|vm] [-vm] [-history FILE]\n")
+	for _, name := range names {
+		fmt.Fprintf(&out, "\tmonkey %s\n", commands[name].usage)
+	}
+	fmt.Fprint(os.Stderr, out.String())
+}
+
+func startRepl(args []string) error {
+	fs := flag.NewFlagSet("monkey", flag.ExitOnError)
+	engineName := fs.String("engine", "eval", "`engine` running each line: eval or vm; the :engine command switches it")
+	useVM := fs.Bool("vm", false, "short for -engine vm")
+	var history string
+	if home, err := os.UserHomeDir(); err == nil {
+		history = filepath.Join(home, ".monkey_history")
+	}
+	fs.StringVar(&history, "history", history, "`file` keeping the lines typed in earlier sessions, or empty for none")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usage()
+		os.Exit(2)
+	}
+	engine, err := repl.ParseEngine(*engineName)
+	if err != nil {
+		return err
+	}
+	if *useVM {
+		engine = repl.VM
+	}
+	user, err := user.Current()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
+		user.Username)
+	fmt.Printf("Feel free to type in commands\n")
+	repl.Start(os.Stdin, os.Stdout, repl.WithEngine(engine), repl.WithHistoryFile(history))
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/ajwerner/monkey/playground"
+)
+
+func playgroundCmd(args []string) error {
+	fs := flag.NewFlagSet("playground", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "listen on `address`")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("unexpected arguments")
+	}
+	fmt.Printf("Serving the playground on http://%s/\n", *addr)
+	return http.ListenAndServe(*addr, playground.NewHandler(playground.DefaultConfig))
+}
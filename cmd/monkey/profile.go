@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// profileFlags holds the profiling flags for commands which execute programs.
+type profileFlags struct {
+	cpu, mem, trace string
+}
+
+func (p *profileFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.cpu, "cpuprofile", "", "write a CPU profile to `file`")
+	fs.StringVar(&p.mem, "memprofile", "", "write a heap profile to `file` after execution")
+	fs.StringVar(&p.trace, "trace", "", "write an execution trace to `file`")
+}
+
+// start begins the requested profiles. The returned function stops them and
+// writes the heap profile; it must be called even if execution fails.
+func (p *profileFlags) start() (stop func() error, err error) {
+	var stops []func() error
+	stop = func() error {
+		var firstErr error
+		for i := len(stops) - 1; i >= 0; i-- {
+			if err := stops[i](); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+	if p.cpu != "" {
+		f, err := os.Create(p.cpu)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+	if p.trace != "" {
+		f, err := os.Create(p.trace)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return nil, err
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+	if p.mem != "" {
+		stops = append(stops, func() error {
+			f, err := os.Create(p.mem)
+			if err != nil {
+				return err
+			}
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	}
+	return stop, nil
+}
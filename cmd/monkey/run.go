@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/feature"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/monkeyerr"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/token"
+	"github.com/ajwerner/monkey/types"
+	"github.com/ajwerner/monkey/vm"
+)
+
+func runCmd(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	tokens := fs.Bool("tokens", false, "print the token stream instead of executing")
+	dumpAST := fs.Bool("ast", false, "print the AST instead of executing")
+	format := fs.String("format", "sexp", "AST output `format`: sexp or json")
+	check := fs.Bool("check", false, "type check the program and print mismatches before executing")
+	strict := fs.Bool("strict", false, "with -check, do not execute a program with type mismatches")
+	strictIndex := fs.Bool("strict-index", false, "fail on indexing an array out of range or a hash with a missing key instead of returning null")
+	lang := fs.String("lang", "extended", "language `edition` or features: classic, extended or a comma-separated list such as classic,import")
+	useVM := fs.Bool("vm", false, "compile the program and run it on the VM, or with the evaluator if it uses features the compiler lacks")
+	var prof profileFlags
+	prof.register(fs)
+	var sandbox sandboxFlags
+	sandbox.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected exactly one file")
+	}
+	if *format != "sexp" && *format != "json" {
+		return fmt.Errorf("unknown AST format %q", *format)
+	}
+	features, err := feature.Parse(*lang)
+	if err != nil {
+		return err
+	}
+	path := fs.Arg(0)
+	if *tokens {
+		if err := printTokens(path, features); err != nil {
+			return err
+		}
+		if !*dumpAST {
+			return nil
+		}
+	}
+	_, program, err := parseFile(path, features)
+	if err != nil {
+		return err
+	}
+	switch {
+	case *dumpAST && *format == "json":
+		return ast.DumpJSON(os.Stdout, program)
+	case *dumpAST:
+		return ast.DumpSExpr(os.Stdout, program)
+	}
+	e, ctx, cancel := sandbox.evaluator()
+	defer cancel()
+	e.StrictIndex = *strictIndex
+	e.IntegerDivision = !features.Has(feature.Division)
+	if *check {
+		if err := checkProgram(path, e.Builtins, program, *strict); err != nil {
+			return diagnose(path, err)
+		}
+	}
+	stop, err := prof.start()
+	if err != nil {
+		return err
+	}
+	if *useVM {
+		err = runVM(ctx, path, e, program)
+	} else {
+		err = evalProgram(ctx, e, program)
+	}
+	if err != nil {
+		err = diagnose(path, err)
+	}
+	if stopErr := stop(); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// printTokens writes the tokens of the file at path, lexed with features,
+// one per line with its line and column.
+func printTokens(path string, features feature.Feature) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	l := lexer.NewWithFeatures(string(data), features)
+	for l.Next() {
+		tok := l.Token()
+		fmt.Printf("%d:%d\t%s\t%q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %v", path, l.Err())
+}
+
+// parseFile reads and parses the monkey source in path, which may use
+// features.
+func parseFile(path string, features feature.Feature) (src string, program *ast.Program, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	src = string(data)
+	p := parser.NewWithArena(lexer.NewWithFeatures(src, features))
+	program = p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return "", nil, diagnose(path, errors.Join(errs...))
+	}
+	return src, program, nil
+}
+
+// diagnose returns an error listing the diagnostics of err, a problem found
+// in the program in path, one per line.
+func diagnose(path string, err error) error {
+	ds := monkeyerr.From(err)
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = path + ": " + d.String()
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// printWarnings writes the type mismatches found in the program in path
+// without strict checking to standard error.
+func printWarnings(path string, info *types.Info) {
+	for _, d := range info.Diagnostics() {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, d)
+	}
+}
+
+// checkProgram type checks program, the program in path, printing any
+// mismatches to standard error, or returning them if strict.
+func checkProgram(path string, b *object.Builtins, program *ast.Program, strict bool) error {
+	c := types.NewChecker(b)
+	c.Strict = strict
+	info, err := c.Check(program)
+	if err != nil {
+		return err
+	}
+	printWarnings(path, info)
+	return nil
+}
+
+// runVM compiles program, the program in path, and runs it on the VM with
+// e's builtins, importer and limits. If program uses a feature which the
+// compiler does not support, runVM warns and evaluates it with e instead.
+func runVM(ctx context.Context, path string, e *evaluator.Evaluator, program *ast.Program) (err error) {
+	defer recoverInternal(&err)
+	b := e.Builtins
+	comp := compiler.NewWithBuiltins(b)
+	comp.Importer = e.Importer
+	comp.Engine = e.BuiltinContext(ctx, nil)
+	if err := comp.CompileContext(ctx, program); errors.Is(err, compiler.ErrUnsupported) {
+		for _, d := range monkeyerr.From(err) {
+			d.Severity = monkeyerr.SeverityWarning
+			fmt.Fprintf(os.Stderr, "%s: %s; running with the evaluator\n", path, d)
+		}
+		return evalProgram(ctx, e, program)
+	} else if err != nil {
+		return err
+	}
+	machine := vm.NewWithBuiltins(comp.Bytecode(), b)
+	machine.Limits = e.Limits
+	machine.Truthiness = e.Truthiness
+	machine.StrictIndex = e.StrictIndex
+	machine.IntegerDivision = e.IntegerDivision
+	machine.Evaluator = e
+	if err := machine.RunContext(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return errors.New("timeout exceeded")
+		}
+		return err
+	}
+	return nil
+}
+
+// evalProgram evaluates program in a fresh environment and converts an error
+// result into a Go error.
+func evalProgram(ctx context.Context, e *evaluator.Evaluator, program *ast.Program) (err error) {
+	defer recoverInternal(&err)
+	result := e.EvalContext(ctx, program, object.NewEnvironment())
+	if errObj, ok := result.(object.Error); ok {
+		if errors.Is(errObj.Err, context.DeadlineExceeded) {
+			return errors.New("timeout exceeded")
+		}
+		return errObj.Err
+	}
+	return nil
+}
+
+// recoverInternal, deferred by the functions running programs, turns a
+// panic in the interpreter into an InternalError in *err, which is reported
+// like any other error rather than crashing the command.
+func recoverInternal(err *error) {
+	if r := recover(); r != nil {
+		*err = &object.InternalError{Value: r, Stack: debug.Stack()}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/stdlib"
+)
+
+// sandboxFlags holds the flags which restrict the resources available to a
+// program.
+type sandboxFlags struct {
+	limits    sandbox.Limits
+	memory    byteSize
+	maxString byteSize
+	timeout   time.Duration
+	noIO      bool
+}
+
+func (s *sandboxFlags) register(fs *flag.FlagSet) {
+	fs.Int64Var(&s.limits.MaxSteps, "max-steps", 0, "stop after executing `n` statements")
+	fs.IntVar(&s.limits.MaxDepth, "max-depth", 0, "limit function calls to a nesting depth of `n`")
+	fs.Var(&s.maxString, "max-string", "limit strings to `size` bytes (e.g. 1M)")
+	fs.IntVar(&s.limits.MaxArrayLen, "max-array", 0, "limit arrays and hashes to `n` elements, and channels to `n` buffered values")
+	fs.Var(&s.memory, "max-memory", "limit strings, arrays and hashes to approximately `size` bytes (e.g. 64M)")
+	fs.DurationVar(&s.timeout, "timeout", 0, "stop after `duration`")
+	fs.BoolVar(&s.noIO, "no-io", false, "disable builtins which access files, the network or processes, and imports from $MONKEY_PATH")
+}
+
+// evaluator returns an Evaluator configured with the sandbox's restrictions
+// and a context which enforces the timeout.
+func (s *sandboxFlags) evaluator() (*evaluator.Evaluator, context.Context, context.CancelFunc) {
+	e := &evaluator.Evaluator{Limits: s.limits, Builtins: object.NewBuiltins()}
+	e.Limits.MaxMemory = int64(s.memory)
+	e.Limits.MaxStringLen = int(s.maxString)
+	var denied object.Capability
+	path := stdlib.EnvPath()
+	if s.noIO {
+		denied = object.CapIO
+		path = nil
+		e.Builtins.RemoveIO()
+	}
+	e.Importer = stdlib.NewImporter(denied, path...)
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	return e, ctx, cancel
+}
+
+// byteSize is a flag.Value holding a number of bytes, which may be suffixed
+// with K, M or G.
+type byteSize int64
+
+func (b *byteSize) String() string { return strconv.FormatInt(int64(*b), 10) }
+
+func (b *byteSize) Set(s string) error {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
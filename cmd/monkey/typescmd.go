@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ajwerner/monkey/feature"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/types"
+)
+
+func typesCmd(args []string) error {
+	fs := flag.NewFlagSet("types", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected exactly one file")
+	}
+	path := fs.Arg(0)
+	_, program, err := parseFile(path, feature.Extended)
+	if err != nil {
+		return err
+	}
+	info, err := types.NewChecker(object.NewBuiltins()).Check(program)
+	if err != nil {
+		return diagnose(path, err)
+	}
+	var out strings.Builder
+	for _, b := range info.Bindings {
+		fmt.Fprintf(&out, "let %s: %s\n", b.Name, b.Type)
+	}
+	if _, err := os.Stdout.WriteString(out.String()); err != nil {
+		return err
+	}
+	printWarnings(path, info)
+	return nil
+}
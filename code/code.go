@@ -14,6 +14,46 @@ const (
 	OpConstant Opcode = iota
 	OpAdd
 	OpPop
+	OpGetBuiltin
+	OpCall
+	OpSub
+	OpMul
+	OpDiv
+	OpTrue
+	OpFalse
+	OpNull
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpMinus
+	OpBang
+	OpJumpNotTruthy
+	OpJump
+	OpGetGlobal
+	OpSetGlobal
+	OpArray
+	OpHash
+	OpIndex
+	OpAwait
+	OpIs
+	OpAs
+	OpConstHash
+	OpFloorDiv
+	// OpIter starts iterating over the array or hash on top of the stack.
+	OpIter
+	// OpIterNext pushes the next key and value of the innermost iteration,
+	// or only the one a loop with a single variable binds if its second
+	// operand is 1, or jumps to its first operand once there are no more.
+	OpIterNext
+	// OpIterEnd ends the innermost iteration.
+	OpIterEnd
+	OpGreaterThanOrEqual
+	OpMod
+	OpLessThan
+	OpLessThanOrEqual
+	// OpNotFound fails with an error naming the string constant at its
+	// operand, an identifier which no scope binds.
+	OpNotFound
 )
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -27,9 +67,14 @@ func (ins Instructions) String() string {
 	for i < len(ins) {
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			i++
 			continue
 		}
+		if width := def.operandsWidth(); i+1+width > len(ins) {
+			fmt.Fprintf(&out, "%04d ERROR: %s truncated\n", i, def.Name)
+			break
+		}
 
 		operands, read := ReadOperands(def, ins[i+1:])
 
@@ -54,6 +99,8 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 		return def.Name
 	case 1:
 		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
 	}
 
 	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
@@ -69,8 +116,42 @@ type Definition struct {
 }
 
 var definitions = map[Opcode]*Definition{
-	OpConstant: {"OpConstant", []int{2}},
-	OpAdd:      {"OpAdd", []int{}},
+	OpConstant:           {"OpConstant", []int{2}},
+	OpAdd:                {"OpAdd", []int{}},
+	OpPop:                {"OpPop", []int{}},
+	OpGetBuiltin:         {"OpGetBuiltin", []int{2}},
+	OpCall:               {"OpCall", []int{1}},
+	OpSub:                {"OpSub", []int{}},
+	OpMul:                {"OpMul", []int{}},
+	OpDiv:                {"OpDiv", []int{}},
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpNull:               {"OpNull", []int{}},
+	OpEqual:              {"OpEqual", []int{}},
+	OpNotEqual:           {"OpNotEqual", []int{}},
+	OpGreaterThan:        {"OpGreaterThan", []int{}},
+	OpMinus:              {"OpMinus", []int{}},
+	OpBang:               {"OpBang", []int{}},
+	OpJumpNotTruthy:      {"OpJumpNotTruthy", []int{2}},
+	OpJump:               {"OpJump", []int{2}},
+	OpGetGlobal:          {"OpGetGlobal", []int{2}},
+	OpSetGlobal:          {"OpSetGlobal", []int{2}},
+	OpArray:              {"OpArray", []int{2}},
+	OpHash:               {"OpHash", []int{2}},
+	OpIndex:              {"OpIndex", []int{}},
+	OpAwait:              {"OpAwait", []int{}},
+	OpIs:                 {"OpIs", []int{2}},
+	OpAs:                 {"OpAs", []int{2}},
+	OpConstHash:          {"OpConstHash", []int{2}},
+	OpFloorDiv:           {"OpFloorDiv", []int{}},
+	OpIter:               {"OpIter", []int{}},
+	OpIterNext:           {"OpIterNext", []int{2, 1}},
+	OpIterEnd:            {"OpIterEnd", []int{}},
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+	OpMod:                {"OpMod", []int{}},
+	OpLessThan:           {"OpLessThan", []int{}},
+	OpLessThanOrEqual:    {"OpLessThanOrEqual", []int{}},
+	OpNotFound:           {"OpNotFound", []int{2}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -102,6 +183,8 @@ func Make(op Opcode, operands ...int) []byte {
 		switch width {
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
 		}
 		offset += width
 	}
@@ -109,6 +192,15 @@ func Make(op Opcode, operands ...int) []byte {
 	return instruction
 }
 
+// operandsWidth returns the number of bytes taken by the operands of def.
+func (def *Definition) operandsWidth() int {
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	return width
+}
+
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	operands := make([]int, len(def.OperandWidths))
 	offset := 0
@@ -117,6 +209,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 		switch width {
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
 		}
 
 		offset += width
@@ -128,3 +222,7 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
@@ -10,6 +10,9 @@ func TestMake(t *testing.T) {
 	}{
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetBuiltin, []int{256}, []byte{byte(OpGetBuiltin), 1, 0}},
+		{OpCall, []int{255}, []byte{byte(OpCall), 255}},
+		{OpIterNext, []int{65534, 2}, []byte{byte(OpIterNext), 255, 254, 2}},
 	}
 
 	for _, tt := range tests {
@@ -32,13 +35,17 @@ func TestMake(t *testing.T) {
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
 		Make(OpAdd),
+		Make(OpGetBuiltin, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpIterNext, 3, 1),
 	}
 
 	expected := `0000 OpAdd
-0001 OpConstant 2
-0004 OpConstant 65535
+0001 OpGetBuiltin 1
+0004 OpConstant 2
+0007 OpConstant 65535
+0010 OpIterNext 3 1
 `
 
 	concatted := Instructions{}
@@ -52,6 +59,21 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringMalformed(t *testing.T) {
+	ins := Instructions{byte(OpAdd), 255, byte(OpPop)}
+	ins = append(ins, Make(OpConstant, 7)[:2]...)
+
+	expected := `0000 OpAdd
+0001 ERROR: opcode 255 undefined
+0002 OpPop
+0003 ERROR: OpConstant truncated
+`
+	if ins.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, ins.String())
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -59,6 +81,8 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65535}, 2},
+		{OpCall, []int{255}, 1},
+		{OpIterNext, []int{65535, 2}, 3},
 	}
 
 	for _, tt := range tests {
@@ -1,26 +1,106 @@
 package compiler
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/ajwerner/monkey/ast"
 	"github.com/ajwerner/monkey/code"
+	"github.com/ajwerner/monkey/monkeyerr"
 	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/types"
 )
 
 type Compiler struct {
+	// Importer resolves import expressions, which are compiled to the
+	// resulting module as a constant. If nil, imports fail to compile.
+	Importer object.Importer
+	// Engine, if not nil, is passed to Importer, so that the source
+	// modules a program imports are evaluated with the context, limits and
+	// policies of the engine which is to run it.
+	Engine object.BuiltinContext
+
 	instructions code.Instructions
 	constants    []object.Object
+
+	// lines locates the instructions emitted so far in the source, and line
+	// is the line of the statement being compiled.
+	lines LineTable
+	line  int
+
+	// index, if non-nil, locates the constants which are stored once, for a
+	// Session.
+	index map[constKey]int
+
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	symbolTable *SymbolTable
+
+	// loops are the loops being compiled, innermost last.
+	loops []*loop
+
+	ctx context.Context
 }
 
+// loop records the jumps of the break statements in a loop, which are
+// patched to its end once it is compiled, and where its continue statements
+// jump to.
+type loop struct {
+	next   int
+	breaks []int
+}
+
+// EmittedInstruction records the opcode and position of an emitted
+// instruction.
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// New creates a Compiler which resolves the standard builtins.
 func New() *Compiler {
+	return NewWithBuiltins(object.NewBuiltins())
+}
+
+// NewWithBuiltins creates a Compiler which resolves the builtins in b. The
+// resulting bytecode must be run with the same table.
+func NewWithBuiltins(b *object.Builtins) *Compiler {
+	return NewWithState(NewBuiltinSymbolTable(b), []object.Object{})
+}
+
+// NewWithState creates a Compiler which resolves names in s and appends to
+// constants, so that globals and constants persist across compilations.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	return &Compiler{
 		instructions: code.Instructions{},
-		constants:    []object.Object{},
+		constants:    constants,
+		symbolTable:  s,
 	}
 }
 
+// CompileContext is like Compile but stops with ctx.Err() once ctx is done.
+func (c *Compiler) CompileContext(ctx context.Context, node ast.Node) error {
+	c.ctx = ctx
+	defer func() { c.ctx = nil }()
+	return c.Compile(node)
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
+	if stmt, ok := node.(ast.Statement); ok {
+		if c.ctx != nil {
+			if err := c.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		outer := c.line
+		c.setLine(stmt.Line())
+		defer c.setLine(outer)
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -37,6 +117,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *ast.ProtocolStatement:
+		// Protocols constrain only the type checker.
+
+	case *ast.BreakStatement:
+		l := c.loops[len(c.loops)-1]
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		c.emit(code.OpJump, c.loops[len(c.loops)-1].next)
+
+	case *ast.LetStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		symbol := c.symbolTable.Define(node.Name.Value)
+		c.emit(code.OpSetGlobal, symbol.Index)
+
 	case *ast.InfixExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -50,35 +156,440 @@ func (c *Compiler) Compile(node ast.Node) error {
 		switch node.Operator {
 		case "+":
 			c.emit(code.OpAdd)
+		case "-":
+			c.emit(code.OpSub)
+		case "*":
+			c.emit(code.OpMul)
+		case "/":
+			c.emit(code.OpDiv)
+		case "~/":
+			c.emit(code.OpFloorDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case ">":
+			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
+		case "<":
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessThanOrEqual)
+		case "==":
+			c.emit(code.OpEqual)
+		case "!=":
+			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return errorf(node, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.PrefixExpression:
+		err := c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return errorf(node, "unknown operator %s", node.Operator)
+		}
+
+	case *ast.IfExpression:
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		// Emit an `OpJumpNotTruthy` with a bogus value
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		err = c.Compile(node.Consequence)
+		if err != nil {
+			return err
+		}
+		if c.lastInstructionIsPop() {
+			c.removeLastPop()
+		} else {
+			c.emit(code.OpNull)
 		}
 
+		// Emit an `OpJump` with a bogus value
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(jumpNotTruthyPos, len(c.instructions))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			err := c.Compile(node.Alternative)
+			if err != nil {
+				return err
+			}
+			if c.lastInstructionIsPop() {
+				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
+			}
+		}
+
+		c.changeOperand(jumpPos, len(c.instructions))
+
+	case *ast.WhileExpression:
+		start := len(c.instructions)
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.pushLoop(start)
+		err = c.Compile(node.Body)
+		l := c.popLoop()
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+
+		// The loop evaluates to null once the condition is not truthy.
+		c.changeOperand(jumpNotTruthyPos, len(c.instructions))
+		c.patchBreaks(l)
+		c.emit(code.OpNull)
+
+	case *ast.AssignExpression:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		// As in the evaluator, assigning to a name which no scope binds,
+		// builtins included, fails once the value is evaluated.
+		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if !ok || symbol.Scope != GlobalScope {
+			c.emitNotFound(node.Name.Value)
+			break
+		}
+		c.emit(code.OpSetGlobal, symbol.Index)
+		c.emit(code.OpGetGlobal, symbol.Index)
+
+	case *ast.ForExpression:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+
+		vars := 1
+		if node.Key != nil {
+			vars = 2
+		}
+		start := c.emit(code.OpIterNext, 9999, vars)
+		// OpIterNext pushes the key below the value.
+		value := c.symbolTable.Define(node.Value.Value)
+		c.emit(code.OpSetGlobal, value.Index)
+		if node.Key != nil {
+			key := c.symbolTable.Define(node.Key.Value)
+			c.emit(code.OpSetGlobal, key.Index)
+		}
+
+		c.pushLoop(start)
+		err = c.Compile(node.Body)
+		l := c.popLoop()
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+
+		c.replaceInstruction(start, code.Make(code.OpIterNext, len(c.instructions), vars))
+		// Breaking out of the loop must end the iteration too.
+		c.patchBreaks(l)
+		c.emit(code.OpIterEnd)
+		c.emit(code.OpNull)
+
 	case *ast.IntegerLiteral:
 		integer := object.Integer(node.Value)
 		c.emit(code.OpConstant, c.addConstant(integer))
-		// TODO: What now?!
+
+	case *ast.FloatLiteral:
+		float := object.Float(node.Value)
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
+	case *ast.Bool:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		if l := object.NewHashLayout(node); l != nil {
+			// The VM finds the keys in an Array constant.
+			for _, v := range l.Values {
+				err := c.Compile(v)
+				if err != nil {
+					return err
+				}
+			}
+			keys := object.Array(l.Keys)
+			c.emit(code.OpConstHash, c.addConstant(&keys))
+			return nil
+		}
+
+		for _, pair := range node.Pairs {
+			err := c.Compile(pair.Key)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(pair.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpIndex)
+
+	case *ast.StringLiteral:
+		str := object.String(node.Value)
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			c.emitNotFound(node.Value)
+			break
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.ImportExpression:
+		if c.Importer == nil {
+			return errorf(node, "no module named %q", node.Module.Value)
+		}
+		module, err := c.Importer(c.Engine, node.Module.Value)
+		if err != nil {
+			return errorf(node, "%v", err)
+		}
+		c.emit(code.OpConstant, c.addConstant(module))
+
+	case *ast.AwaitExpression:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpAwait)
+
+	case *ast.TypeAssertion:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		t, err := types.Resolve(node.Type)
+		if err != nil {
+			return errorf(node, "%v", err)
+		}
+		// The VM finds the type in an External constant.
+		op := code.OpIs
+		if node.Operator == "as" {
+			op = code.OpAs
+		}
+		c.emit(op, c.addConstant(&object.External{Value: t}))
+
+	case *ast.CallExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return &Error{Line: node.Line(), Msg: fmt.Sprintf("unsupported node type %T", node), Err: ErrUnsupported}
 	}
 
 	return nil
 }
 
+// Error is an error encountered while compiling.
+type Error struct {
+	Line int // 1-based line of the offending node, if known
+	Msg  string
+	Err  error // ErrUnsupported, if the program is valid but uses a feature the compiler lacks
+}
+
+// ErrUnsupported is wrapped by the errors of programs which use a feature
+// of the language that the compiler does not support yet, such as function
+// literals. Such programs may still be run with the evaluator.
+var ErrUnsupported = errors.New("not supported by the compiler")
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Diagnostic implements monkeyerr.Diagnoser.
+func (e *Error) Diagnostic() monkeyerr.Diagnostic {
+	return monkeyerr.Diagnostic{Phase: monkeyerr.PhaseCompile, Pos: monkeyerr.Position{Line: e.Line}, Msg: e.Msg, Err: e}
+}
+
+func errorf(node ast.Node, format string, a ...interface{}) error {
+	return &Error{Line: node.Line(), Msg: fmt.Sprintf(format, a...)}
+}
+
+// emitNotFound emits the instruction failing as the evaluator does on name,
+// which no scope binds.
+func (c *Compiler) emitNotFound(name string) {
+	c.emit(code.OpNotFound, c.addConstant(object.String(name)))
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	}
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
+	k, shared := constKeyOf(obj)
+	if shared && c.index != nil {
+		if i, ok := c.index[k]; ok {
+			return i
+		}
+	}
 	c.constants = append(c.constants, obj)
+	if shared && c.index != nil {
+		c.index[k] = len(c.constants) - 1
+	}
 	return len(c.constants) - 1
 }
 
+// pushLoop starts compiling the body of a loop whose continue statements
+// jump to next.
+func (c *Compiler) pushLoop(next int) {
+	c.loops = append(c.loops, &loop{next: next})
+}
+
+// popLoop finishes compiling the body of the innermost loop.
+func (c *Compiler) popLoop() *loop {
+	l := c.loops[len(c.loops)-1]
+	c.loops = c.loops[:len(c.loops)-1]
+	return l
+}
+
+// patchBreaks makes the break statements of l jump to the next instruction.
+func (c *Compiler) patchBreaks(l *loop) {
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, len(c.instructions))
+	}
+}
+
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
 	return pos
 }
 
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.previousInstruction = previous
+	c.lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIsPop() bool {
+	return c.lastInstruction.Opcode == code.OpPop
+}
+
+func (c *Compiler) removeLastPop() {
+	c.instructions = c.instructions[:c.lastInstruction.Position]
+	c.lastInstruction = c.previousInstruction
+	c.setLine(c.line)
+}
+
+// setLine records that the instructions emitted from now on are compiled
+// from line, forgetting the lines recorded for removed instructions.
+func (c *Compiler) setLine(line int) {
+	pos := len(c.instructions)
+	c.lines = c.lines.upTo(pos)
+	if n := len(c.lines); n == 0 || c.lines[n-1].Line != line {
+		c.lines = append(c.lines, LineStart{Pos: pos, Line: line})
+	}
+	c.line = line
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	for i := 0; i < len(newInstruction); i++ {
+		c.instructions[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.instructions[opPos])
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.instructions,
 		Constants:    c.constants,
+		GlobalNames:  globalNames(c.symbolTable),
+		Lines:        c.lines.upTo(len(c.instructions)),
+	}
+}
+
+// globalNames returns the names of the globals defined in s by index.
+func globalNames(s *SymbolTable) []string {
+	globals := s.Globals()
+	names := make([]string, len(globals))
+	for i, sym := range globals {
+		names[i] = sym.Name
 	}
+	return names
 }
 
 func (c *Compiler) addInstruction(ins []byte) (pos int) {
@@ -90,4 +601,57 @@ func (c *Compiler) addInstruction(ins []byte) (pos int) {
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	// GlobalNames holds the name of each global by index, so that errors
+	// can name the globals they are about.
+	GlobalNames []string
+	// Lines locates the instructions in the source, so that errors can
+	// name the line of the statement which failed.
+	Lines LineTable
+}
+
+// LineTable maps the positions of instructions to the lines of the
+// statements they were compiled from. Its entries are in order of Pos.
+type LineTable []LineStart
+
+// LineStart records that the instructions from Pos up to the Pos of the
+// next entry were compiled from the statement on line Line.
+type LineStart struct {
+	Pos  int
+	Line int
+}
+
+// upTo returns the entries of t which locate instructions before pos.
+func (t LineTable) upTo(pos int) LineTable {
+	n := len(t)
+	for n > 0 && t[n-1].Pos >= pos {
+		n--
+	}
+	return t[:n]
+}
+
+// Line returns the line of the instruction at pos, or 0 if it is unknown.
+func (t LineTable) Line(pos int) int {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Pos > pos })
+	if i == 0 {
+		return 0
+	}
+	return t[i-1].Line
+}
+
+// String disassembles the instructions of b and lists its constants by
+// index, quoting strings.
+func (b *Bytecode) String() string {
+	var out strings.Builder
+	out.WriteString(b.Instructions.String())
+	if len(b.Constants) > 0 {
+		out.WriteString("constants:\n")
+	}
+	for i, c := range b.Constants {
+		if s, ok := c.(object.String); ok {
+			fmt.Fprintf(&out, "%04d %q\n", i, string(s))
+		} else {
+			fmt.Fprintf(&out, "%04d %s %s\n", i, c.Type(), c.Inspect())
+		}
+	}
+	return out.String()
 }
@@ -1,6 +1,8 @@
 package compiler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/ajwerner/monkey/lexer"
 	"github.com/ajwerner/monkey/object"
 	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/types"
 )
 
 type compilerTestCase struct {
@@ -26,6 +29,82 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 % 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2; 1 <= 2;",
+			expectedConstants: []interface{}{1, 2, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpLessThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 < 2) { 10 } else { 20 };",
+			expectedConstants: []interface{}{1, 2, 10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpConstant, 1),
+				// 0006
+				code.Make(code.OpLessThan),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0010
+				code.Make(code.OpConstant, 2),
+				// 0013
+				code.Make(code.OpJump, 19),
+				// 0016
+				code.Make(code.OpConstant, 3),
+				// 0019
+				code.Make(code.OpPop),
 			},
 		},
 	}
@@ -33,6 +112,276 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestWhile(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpPop),
+				// 0013
+				code.Make(code.OpConstant, 1),
+				// 0016
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { break; continue; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 13),
+				// 0004
+				code.Make(code.OpJump, 13),
+				// 0007
+				code.Make(code.OpJump, 0),
+				// 0010
+				code.Make(code.OpJump, 0),
+				// 0013
+				code.Make(code.OpNull),
+				// 0014
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (x in []) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpArray, 0),
+				// 0003
+				code.Make(code.OpIter),
+				// 0004
+				code.Make(code.OpIterNext, 17, 1),
+				// 0008
+				code.Make(code.OpSetGlobal, 0),
+				// 0011
+				code.Make(code.OpJump, 17),
+				// 0014
+				code.Make(code.OpJump, 4),
+				// 0017
+				code.Make(code.OpIterEnd),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssign(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 1; x = 2;",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "y = 1",
+			expectedConstants: []interface{}{1, "y"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNotFound, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "len = 1",
+			expectedConstants: []interface{}{1, "len"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNotFound, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one; -two;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpMinus),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCollections(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][0]",
+			expectedConstants: []interface{}{1, 2, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{"b": 2, "a": 1}.a`,
+			expectedConstants: []interface{}{2, 1, []interface{}{"b", "a"}, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstHash, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `{1 + 1: 2}`,
+			expectedConstants: []interface{}{1, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAwait(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "await 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAwait),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTypeAssertions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 is int; 1 as array<int>",
+			expectedConstants: []interface{}{1, types.Int, 1, &types.Array{Elem: types.Int}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIs, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAs, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	if err := New().Compile(parse("1 is integer")); err == nil || err.Error() != "line 1: unknown type integer" {
+		t.Errorf("wrong error for unknown type: %v", err)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `len("monkey"); push`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 6),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestUndefinedVariable(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "nope",
+			expectedConstants: []interface{}{"nope"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpNotFound, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	err := New().Compile(parse("1;\nimport \"nope\""))
+	if compErr, ok := err.(*Error); !ok || compErr.Line != 2 || compErr.Msg != `no module named "nope"` {
+		t.Fatalf("expected missing module error, got %v", err)
+	}
+	if errors.Is(err, ErrUnsupported) {
+		t.Errorf("missing module reported as unsupported: %v", err)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	err := New().Compile(parse("let x = 1;\nlet f = fn() { x };"))
+	if compErr, ok := err.(*Error); !ok || compErr.Line != 2 || !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected unsupported error on line 2, got %v", err)
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 
@@ -107,6 +456,26 @@ func testConstants(t *testing.T, expected []interface{}, actual []object.Object)
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s",
 					i, err)
 			}
+		case string:
+			err := testStringObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testStringObject failed: %s",
+					i, err)
+			}
+		case []interface{}:
+			arr, ok := actual[i].(*object.Array)
+			if !ok {
+				return fmt.Errorf("constant %d - not an array. got=%T", i, actual[i])
+			}
+			if err := testConstants(t, constant, *arr); err != nil {
+				return fmt.Errorf("constant %d - %s", i, err)
+			}
+		case types.Type:
+			ext, ok := actual[i].(*object.External)
+			if !ok || !types.Identical(ext.Value.(types.Type), constant) {
+				return fmt.Errorf("constant %d - wrong type. want=%s, got=%+v",
+					i, constant, actual[i])
+			}
 		}
 	}
 
@@ -127,3 +496,128 @@ func testIntegerObject(expected object.Integer, actual object.Object) error {
 
 	return nil
 }
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if string(result) != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q",
+			result, expected)
+	}
+
+	return nil
+}
+
+func TestCompileContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := New().CompileContext(ctx, parse("1; 2")); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := New().CompileContext(context.Background(), parse("1; 2")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	c := New()
+	if err := c.Compile(parse("1;\n2;\nif (true) {\n  3\n} else {\n  4\n}\nlet a = 5;")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	want := LineTable{{0, 1}, {4, 2}, {8, 3}, {12, 4}, {15, 3}, {18, 6}, {21, 3}, {22, 8}}
+	if got := c.Bytecode().Lines; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("wrong lines.\nwant=%v\ngot=%v", want, got)
+	}
+	tests := []struct {
+		pos  int
+		line int
+	}{
+		{0, 1}, {3, 1}, {4, 2}, {13, 4}, {15, 3}, {25, 8}, {-1, 0},
+	}
+	for _, tt := range tests {
+		if got := want.Line(tt.pos); got != tt.line {
+			t.Errorf("Line(%d): want=%d, got=%d", tt.pos, tt.line, got)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := NewSession(NewBuiltinSymbolTable(object.NewBuiltins()))
+	first, err := s.Compile(parse(`let a = 1; "x"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Compile(parse(`3; let b = 2; let len = 3; import "nope"`)); err == nil {
+		t.Fatal("expected an error compiling a missing module")
+	}
+	if _, ok := s.Symbols().Resolve("b"); ok {
+		t.Error("expected b to be undefined after its program failed to compile")
+	}
+	if sym, _ := s.Symbols().Resolve("len"); sym.Scope != BuiltinScope {
+		t.Errorf("expected len to be the builtin again, got %+v", sym)
+	}
+	second, err := s.Compile(parse(`a + 1; "x"; 3; 3`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testConstants(t, []interface{}{1, "x"}, first.Constants); err != nil {
+		t.Errorf("first program: %s", err)
+	}
+	if err := testConstants(t, []interface{}{1, "x", 3}, second.Constants); err != nil {
+		t.Errorf("second program: %s", err)
+	}
+	expected := concatInstructions([]code.Instructions{
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpPop),
+		code.Make(code.OpConstant, 2),
+		code.Make(code.OpPop),
+	})
+	if err := testInstructions([]code.Instructions{expected}, second.Instructions); err != nil {
+		t.Errorf("second program: %s", err)
+	}
+}
+
+func TestSessionPreview(t *testing.T) {
+	s := NewSession(NewBuiltinSymbolTable(object.NewBuiltins()))
+	if _, err := s.Compile(parse(`let a = 1`)); err != nil {
+		t.Fatal(err)
+	}
+	preview, err := s.Preview(parse(`let b = a + 2; "x"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `0000 OpGetGlobal 0
+0003 OpConstant 1
+0006 OpAdd
+0007 OpSetGlobal 1
+0010 OpConstant 2
+0013 OpPop
+constants:
+0000 INTEGER 1
+0001 INTEGER 2
+0002 "x"
+`
+	if preview.String() != expected {
+		t.Errorf("preview wrong.\nwant=%q\ngot =%q", expected, preview.String())
+	}
+	if _, ok := s.Symbols().Resolve("b"); ok {
+		t.Error("expected b to be undefined after a preview")
+	}
+	next, err := s.Compile(parse(`"y"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testConstants(t, []interface{}{1, "y"}, next.Constants); err != nil {
+		t.Errorf("program after preview: %s", err)
+	}
+}
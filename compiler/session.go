@@ -0,0 +1,105 @@
+package compiler
+
+import (
+	"context"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/object"
+)
+
+// Session compiles many related programs, such as the lines of a REPL or
+// scripts loaded together, against one symbol table and one pool of
+// constants in which equal integers, floats and strings are stored once.
+// The bytecode of each program indexes the same pool and must be run with
+// globals shared by every program of the session. A Session is safe for
+// concurrent use.
+type Session struct {
+	// Importer resolves import expressions, and Engine evaluates the
+	// source modules they import, as for a Compiler.
+	Importer object.Importer
+	Engine   object.BuiltinContext
+
+	mu        sync.Mutex
+	symbols   *SymbolTable
+	constants []object.Object
+	index     map[constKey]int
+}
+
+// NewSession creates a Session which resolves names in s, for example a
+// table made by NewBuiltinSymbolTable.
+func NewSession(s *SymbolTable) *Session {
+	return &Session{symbols: s, index: map[constKey]int{}}
+}
+
+// Symbols returns the session's symbol table, which must not be changed
+// while a program is being compiled.
+func (s *Session) Symbols() *SymbolTable { return s.symbols }
+
+// Compile compiles program and returns its bytecode, whose constants are
+// those of every program compiled so far. If compilation fails the constants
+// and symbols are left unchanged, so that none of the globals which program
+// defines, and which never get set, is defined.
+func (s *Session) Compile(program ast.Node) (*Bytecode, error) {
+	return s.CompileContext(context.Background(), program)
+}
+
+// CompileContext is like Compile but stops with ctx.Err() once ctx is done.
+func (s *Session) CompileContext(ctx context.Context, program ast.Node) (*Bytecode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := NewWithState(s.symbols, s.constants)
+	c.Importer, c.Engine = s.Importer, s.Engine
+	c.index = s.index
+	n := len(s.constants)
+	symbols := s.symbols.clone()
+	if err := c.CompileContext(ctx, program); err != nil {
+		*s.symbols = *symbols
+		for _, obj := range c.constants[n:] {
+			if k, ok := constKeyOf(obj); ok && s.index[k] >= n {
+				delete(s.index, k)
+			}
+		}
+		return nil, err
+	}
+	s.constants = c.constants
+	return c.Bytecode(), nil
+}
+
+// Preview compiles program as Compile does but leaves the session
+// unchanged, so that the bytecode of a program can be shown without
+// running it.
+func (s *Session) Preview(program ast.Node) (*Bytecode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := NewWithState(s.symbols.clone(), slices.Clone(s.constants))
+	c.Importer, c.Engine = s.Importer, s.Engine
+	c.index = maps.Clone(s.index)
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+	return c.Bytecode(), nil
+}
+
+// constKey identifies a constant which a Session stores once. Floats are
+// identified by their bits, so that 0.0 and -0.0 are different constants.
+type constKey struct {
+	typ  object.ObjectType
+	bits uint64
+	str  string
+}
+
+func constKeyOf(obj object.Object) (constKey, bool) {
+	switch obj := obj.(type) {
+	case object.Integer:
+		return constKey{typ: object.INTEGER, bits: uint64(obj)}, true
+	case object.Float:
+		return constKey{typ: object.FLOAT, bits: math.Float64bits(float64(obj))}, true
+	case object.String:
+		return constKey{typ: object.STRING, str: string(obj)}, true
+	}
+	return constKey{}, false
+}
@@ -0,0 +1,78 @@
+package compiler
+
+import (
+	"maps"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+type SymbolTable struct {
+	store          map[string]Symbol
+	numDefinitions int
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: map[string]Symbol{}}
+}
+
+// NewBuiltinSymbolTable returns a SymbolTable in which the builtins in b are
+// defined.
+func NewBuiltinSymbolTable(b *object.Builtins) *SymbolTable {
+	s := NewSymbolTable()
+	for i, name := range b.Names() {
+		s.DefineBuiltin(i, name)
+	}
+	return s
+}
+
+// Define defines name as a global, reusing its slot if it is already a
+// global.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && symbol.Scope == GlobalScope {
+		return symbol
+	}
+	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: GlobalScope}
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	return obj, ok
+}
+
+// clone returns a copy of s, which later definitions in s do not change.
+func (s *SymbolTable) clone() *SymbolTable {
+	return &SymbolTable{store: maps.Clone(s.store), numDefinitions: s.numDefinitions}
+}
+
+// Globals returns the global symbols ordered by index.
+func (s *SymbolTable) Globals() []Symbol {
+	globals := make([]Symbol, s.numDefinitions)
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope {
+			globals[symbol.Index] = symbol
+		}
+	}
+	return globals
+}
@@ -0,0 +1,158 @@
+// Package cover records statement coverage for monkey programs.
+//
+// A Profile is attached to an evaluator through its Trace hook and counts how
+// many times each statement executes. Reports are rendered per source line.
+package cover
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/ajwerner/monkey/ast"
+)
+
+// Profile counts statement executions for a single source file.
+type Profile struct {
+	Name string
+
+	src    string
+	counts map[ast.Statement]int
+}
+
+// New creates a Profile for program, which must have been parsed from src.
+// Every statement in program other than block statements is considered
+// coverable.
+func New(name, src string, program *ast.Program) *Profile {
+	p := &Profile{
+		Name:   name,
+		src:    src,
+		counts: map[ast.Statement]int{},
+	}
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Program, *ast.BlockStatement:
+		case ast.Statement:
+			p.counts[n] = 0
+		}
+		return true
+	})
+	return p
+}
+
+// Trace records an execution of stmt. It has the signature of
+// evaluator.Evaluator.Trace.
+func (p *Profile) Trace(stmt ast.Statement) {
+	if _, ok := p.counts[stmt]; ok {
+		p.counts[stmt]++
+	}
+}
+
+// Percent returns the percentage of statements which executed at least once.
+// A program without statements is fully covered.
+func (p *Profile) Percent() float64 {
+	if len(p.counts) == 0 {
+		return 100
+	}
+	var covered int
+	for _, c := range p.counts {
+		if c > 0 {
+			covered++
+		}
+	}
+	return 100 * float64(covered) / float64(len(p.counts))
+}
+
+// Line is the coverage of a single source line.
+type Line struct {
+	Number int
+	Text   string
+	// Statements is the number of statements starting on the line.
+	Statements int
+	// Count is the largest execution count of the statements on the line.
+	Count int
+}
+
+// Covered returns whether the line has statements and one of them executed.
+func (l Line) Covered() bool { return l.Statements > 0 && l.Count > 0 }
+
+// Uncovered returns whether the line has statements and none of them
+// executed.
+func (l Line) Uncovered() bool { return l.Statements > 0 && l.Count == 0 }
+
+// Lines returns the coverage of every line in the source.
+func (p *Profile) Lines() []Line {
+	texts := strings.Split(p.src, "\n")
+	lines := make([]Line, len(texts))
+	for i, t := range texts {
+		lines[i] = Line{Number: i + 1, Text: t}
+	}
+	for stmt, c := range p.counts {
+		n := stmt.Line()
+		if n < 1 || n > len(lines) {
+			continue
+		}
+		l := &lines[n-1]
+		l.Statements++
+		if c > l.Count {
+			l.Count = c
+		}
+	}
+	return lines
+}
+
+// WriteText writes a summary line followed by the source annotated with
+// execution counts. Lines without statements have a blank count column and
+// lines which never executed are marked with "!".
+func (p *Profile) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s: %.1f%% of statements\n", p.Name, p.Percent()); err != nil {
+		return err
+	}
+	for _, l := range p.Lines() {
+		var count string
+		switch {
+		case l.Uncovered():
+			count = "!"
+		case l.Covered():
+			count = fmt.Sprint(l.Count)
+		}
+		if _, err := fmt.Fprintf(w, "%5d %6s | %s\n", l.Number, count, l.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteHTML writes a self-contained HTML page rendering the source with
+// covered lines in green and uncovered lines in red.
+func (p *Profile) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, struct {
+		Name    string
+		Percent float64
+		Lines   []Line
+	}{p.Name, p.Percent(), p.Lines()})
+}
+
+var htmlTemplate = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} coverage</title>
+<style>
+body { background: #fff; color: #333; font-family: monospace; }
+table { border-collapse: collapse; }
+td { padding: 0 8px; white-space: pre; }
+td.num, td.count { color: #999; text-align: right; }
+tr.cov td.src { color: #2a2; }
+tr.uncov td.src { color: #c00; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}: {{printf "%.1f" .Percent}}% of statements</h1>
+<table>
+{{range .Lines}}<tr class="{{if .Covered}}cov{{else if .Uncovered}}uncov{{end}}"><td class="num">{{.Number}}</td><td class="count">{{if .Statements}}{{.Count}}{{end}}</td><td class="src">{{.Text}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,92 @@
+package cover
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+)
+
+const input = `let f = fn(x) {
+  if (x > 1) {
+    return x;
+  }
+  0;
+};
+f(2);
+`
+
+func runProfile(t *testing.T) *Profile {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	prof := New("test.monkey", input, program)
+	e := evaluator.Evaluator{Trace: prof.Trace}
+	e.Eval(program, object.NewEnvironment())
+	return prof
+}
+
+func TestLines(t *testing.T) {
+	prof := runProfile(t)
+	lines := prof.Lines()
+	expected := []struct {
+		covered, uncovered bool
+	}{
+		{true, false}, // let f
+		{true, false}, // if
+		{true, false}, // return x
+		{false, false},
+		{false, true}, // 0
+		{false, false},
+		{true, false}, // f(2)
+	}
+	for i, exp := range expected {
+		l := lines[i]
+		if l.Covered() != exp.covered || l.Uncovered() != exp.uncovered {
+			t.Errorf("line %d: covered=%t uncovered=%t, want covered=%t uncovered=%t",
+				l.Number, l.Covered(), l.Uncovered(), exp.covered, exp.uncovered)
+		}
+	}
+}
+
+func TestLinesProtocol(t *testing.T) {
+	src := "1;\nprotocol Shape {\n  name: string\n}\n"
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	lines := New("test.monkey", src, program).Lines()
+	if lines[1].Statements != 1 {
+		t.Errorf("expected the protocol on line 2, got %+v", lines)
+	}
+}
+
+func TestPercent(t *testing.T) {
+	prof := runProfile(t)
+	// let, if statement, return, 0, call: 4 of 5 executed.
+	if got := prof.Percent(); got != 80 {
+		t.Errorf("Percent() = %v, want 80", got)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	prof := runProfile(t)
+	var out strings.Builder
+	if err := prof.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(out.String(), "\n")
+	if got[0] != "test.monkey: 80.0% of statements" {
+		t.Errorf("wrong summary line: %q", got[0])
+	}
+	if want := "    5      ! |   0;"; got[5] != want {
+		t.Errorf("wrong uncovered line.\nwant=%q\ngot =%q", want, got[5])
+	}
+}
@@ -0,0 +1,116 @@
+// Package doc extracts documentation from monkey programs.
+//
+// A top-level let statement is documented by the "///" comments on the lines
+// immediately preceding it:
+//
+//	/// add returns the sum of a and b.
+//	let add = fn(a, b) { a + b };
+package doc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/token"
+)
+
+// Kinds of documented bindings.
+const (
+	Function = "function"
+	Value    = "value"
+)
+
+// Entry documents a single top-level binding.
+type Entry struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Params holds the parameter names of functions.
+	Params []string `json:"params,omitempty"`
+	Doc    string   `json:"doc"`
+	Line   int      `json:"line"`
+}
+
+// Signature returns the entry's name followed, for functions, by its
+// parameter list.
+func (e Entry) Signature() string {
+	if e.Kind != Function {
+		return e.Name
+	}
+	return fmt.Sprintf("%s(%s)", e.Name, strings.Join(e.Params, ", "))
+}
+
+// Extract returns an Entry for each top-level let statement in program, in
+// source order.
+func Extract(program *ast.Program) []Entry {
+	docs := docComments(program.Comments)
+	var entries []Entry
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || let == nil || let.Name == nil {
+			continue
+		}
+		e := Entry{
+			Name: let.Name.Value,
+			Kind: Value,
+			Line: let.Token.Line,
+		}
+		if fn, ok := let.Value.(*ast.FunctionLiteral); ok {
+			e.Kind = Function
+			e.Params = []string{}
+			for _, p := range fn.Parameters {
+				e.Params = append(e.Params, p.Value)
+			}
+		}
+		var lines []string
+		for l := e.Line - 1; docs[l] != nil; l-- {
+			lines = append(lines, *docs[l])
+		}
+		for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+			lines[i], lines[j] = lines[j], lines[i]
+		}
+		e.Doc = strings.Join(lines, "\n")
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// docComments indexes the text of "///" comments by line.
+func docComments(comments []token.Token) map[int]*string {
+	docs := make(map[int]*string)
+	for _, c := range comments {
+		if !strings.HasPrefix(c.Literal, "///") {
+			continue
+		}
+		text := strings.TrimSuffix(c.Literal[len("///"):], "\r")
+		text = strings.TrimPrefix(text, " ")
+		docs[c.Line] = &text
+	}
+	return docs
+}
+
+// WriteMarkdown writes entries as a Markdown document with the given title.
+func WriteMarkdown(w io.Writer, title string, entries []Entry) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s\n", title)
+	for _, e := range entries {
+		fmt.Fprintf(&out, "\n## %s\n\n```\n%s\n```\n", e.Name, e.Signature())
+		if e.Doc != "" {
+			fmt.Fprintf(&out, "\n%s\n", e.Doc)
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// WriteJSON writes entries as an indented JSON array.
+func WriteJSON(w io.Writer, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
@@ -0,0 +1,51 @@
+package doc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/parser"
+)
+
+const input = `/// add returns the sum
+/// of a and b.
+let add = fn(a, b) { a + b };
+
+// Not documentation.
+let answer = 42;
+
+/// Detached.
+
+let f = fn() {
+  /// Not top-level.
+  let inner = 1;
+};
+`
+
+func TestExtract(t *testing.T) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	expected := []Entry{
+		{Name: "add", Kind: Function, Params: []string{"a", "b"}, Doc: "add returns the sum\nof a and b.", Line: 3},
+		{Name: "answer", Kind: Value, Line: 6},
+		{Name: "f", Kind: Function, Params: []string{}, Line: 10},
+	}
+	got := Extract(program)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("wrong entries.\nwant=%+v\ngot =%+v", expected, got)
+	}
+
+	var out strings.Builder
+	if err := WriteMarkdown(&out, "lib", got[:2]); err != nil {
+		t.Fatal(err)
+	}
+	expectedMarkdown := "# lib\n\n## add\n\n```\nadd(a, b)\n```\n\nadd returns the sum\nof a and b.\n\n## answer\n\n```\nanswer\n```\n"
+	if out.String() != expectedMarkdown {
+		t.Errorf("wrong markdown.\nwant=%q\ngot =%q", expectedMarkdown, out.String())
+	}
+}
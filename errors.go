@@ -0,0 +1,47 @@
+package monkey
+
+import (
+	"runtime/debug"
+
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/types"
+)
+
+// The errors returned by Eval, Compile and Script.Run are, or wrap, one of
+// the following kinds, so that callers can branch on the phase which failed
+// with errors.As. Parse failures are joined with errors.Join; errors.As
+// finds the first of them. monkeyerr.From describes any of them, and each of
+// several joined ones, as a monkeyerr.Diagnostic with its phase and line.
+type (
+	// LexError reports malformed source such as an unterminated string.
+	LexError = lexer.Error
+	// ParseError reports source which is not a valid program.
+	ParseError = parser.Error
+	// CompileError reports a program which cannot be compiled for the VM,
+	// such as one importing a missing module.
+	CompileError = compiler.Error
+	// RuntimeError reports an error raised while running a program. It
+	// wraps the underlying error, such as sandbox.ErrStepLimit or
+	// context.Canceled, which remains reachable with errors.Is.
+	RuntimeError = object.RuntimeError
+	// TypeError reports a type mismatch found before running a program
+	// by an Interpreter created with WithTypeCheck.
+	TypeError = types.Error
+	// InternalError reports a bug in the interpreter: a panic while
+	// parsing, compiling or running a program, which Eval, Compile and
+	// Script.Run recover from rather than crash the host, as do the tasks
+	// a program spawns. The interpreter may be left in an inconsistent
+	// state and should not be used again.
+	InternalError = object.InternalError
+)
+
+// recoverInternal, deferred by the entry points of the package, turns a
+// panic into an InternalError in *err.
+func recoverInternal(err *error) {
+	if r := recover(); r != nil {
+		*err = &InternalError{Value: r, Stack: debug.Stack()}
+	}
+}
@@ -0,0 +1,103 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+)
+
+// builtinContext is the object.BuiltinContext of the builtins called by an
+// Evaluator.
+type builtinContext struct {
+	e     *Evaluator
+	ctx   context.Context // if not nil, in place of the Evaluator's
+	usage *sandbox.Usage  // if not nil, in place of the Evaluator's
+}
+
+// BuiltinContext returns the object.BuiltinContext which e gives the
+// builtins it calls, but whose context is ctx and which, if usage is not
+// nil, counts the resources it uses in usage. A Compiler passes it to its
+// Importer, so that the source modules imported by a program compiled ahead
+// of running are evaluated with the context, limits and policies of e, and
+// the VM evaluates the code it has not compiled with it, counting against
+// its own resources. With a usage, the context applies and evaluates on a
+// new Evaluator configured like e, so that tasks calling BuiltinContext
+// concurrently do not share one.
+func (e *Evaluator) BuiltinContext(ctx context.Context, usage *sandbox.Usage) object.BuiltinContext {
+	c := builtinContext{e: e, ctx: ctx, usage: usage}
+	if usage != nil {
+		return builtinContext{e: c.child()}
+	}
+	return c
+}
+
+func (c builtinContext) Context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	if c.e.ctx == nil {
+		return context.Background()
+	}
+	return c.e.ctx
+}
+
+func (c builtinContext) Apply(fn object.Object, args ...object.Object) object.Object {
+	return c.e.applyFunction(fn, args)
+}
+
+func (c builtinContext) Truthiness() object.Truthiness { return c.e.Truthiness }
+
+// Eval evaluates node on the calling Evaluator, so that it counts against
+// the same limits, or on a child if the context or usage are its own.
+func (c builtinContext) Eval(node ast.Node, env *object.Environment) object.Object {
+	if c.ctx != nil || c.usage != nil {
+		return c.child().Eval(node, env)
+	}
+	return c.e.Eval(node, env)
+}
+
+// EvalModule evaluates program on a new Evaluator with the limits and
+// policies of the caller, whose resources are counted against the same
+// limits. Modules are parsed with every feature, so / divides exactly.
+func (c builtinContext) EvalModule(program ast.Node, env *object.Environment, builtins *object.Builtins, importer object.Importer) object.Object {
+	child := c.child()
+	if builtins != nil {
+		child.Builtins = builtins
+	}
+	child.Importer = importer
+	child.IntegerDivision = false
+	return child.Eval(program, env)
+}
+
+// Go runs fn on a new Evaluator with the same builtins, importer, limits and
+// policies, whose resources are counted against the same limits as the
+// caller's, so that spawning tasks does not escape them.
+func (c builtinContext) Go(fn object.Object, args ...object.Object) *object.Future {
+	argv := object.Array(args)
+	call := *object.Isolate(&object.Array{fn, &argv}).(*object.Array)
+	fn, args = call[0], *call[1].(*object.Array)
+	child := c.child()
+	return object.StartFuture(func() object.Object { return child.applyFunction(fn, args) })
+}
+
+// child returns a new Evaluator configured like the caller's, sharing its
+// context and the resources counted against its limits.
+func (c builtinContext) child() *Evaluator {
+	usage := c.usage
+	if usage == nil {
+		usage = c.e.share()
+	}
+	return &Evaluator{
+		Builtins:        c.e.Builtins,
+		Importer:        c.e.Importer,
+		Limits:          c.e.Limits,
+		Lazy:            c.e.Lazy,
+		Truthiness:      c.e.Truthiness,
+		StrictIndex:     c.e.StrictIndex,
+		IntegerDivision: c.e.IntegerDivision,
+		ctx:             c.Context(),
+		usage:           usage,
+	}
+}
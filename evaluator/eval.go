@@ -1,44 +1,113 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/ajwerner/monkey/ast"
 	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/types"
 )
 
-const TRUE = object.Bool(true)
-const FALSE = object.Bool(false)
-
-var NULL = object.Null{}
+// Evaluator is a tree-walking interpreter for monkey programs. The zero value
+// is ready to use.
+type Evaluator struct {
+	// Trace, if non-nil, is called with each statement immediately before it
+	// is evaluated.
+	Trace func(ast.Statement)
+
+	// Limits bounds the resources used by evaluation.
+	Limits sandbox.Limits
+
+	// Builtins are the builtin functions; if nil, the standard builtins are
+	// used.
+	Builtins *object.Builtins
+
+	// Importer resolves import expressions. If nil, every import fails.
+	Importer object.Importer
+
+	// Truthiness decides which values conditions and the ! operator treat
+	// as true.
+	Truthiness object.Truthiness
+
+	// StrictIndex makes indexing an array out of range or a hash with a
+	// missing key an error rather than NULL.
+	StrictIndex bool
+
+	// IntegerDivision makes / truncate the quotient of two integers to an
+	// integer, as in programs without language feature division, rather
+	// than evaluate to a float.
+	IntegerDivision bool
+
+	// Lazy defers the evaluation of the values of let statements and of the
+	// arguments of calls to functions, but not to builtins, until they are
+	// first used. See the README for how this changes the meaning of
+	// programs.
+	Lazy bool
+
+	ctx   context.Context
+	steps int64
+	depth int
+	mem   int64
+	// usage, once set, counts steps and memory in place of steps and mem,
+	// shared with the tasks the Evaluator spawned.
+	usage *sandbox.Usage
+	stack []object.Frame
+
+	concat object.Appender
+
+	// frames are the environments of returned calls of leaf functions,
+	// kept for reuse by later calls.
+	frames []*object.Environment
+}
 
+// Eval evaluates node in env with a zero Evaluator.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	var e Evaluator
+	return e.Eval(node, env)
+}
+
+// Eval evaluates node in env. The result is never nil: statements without a
+// value, such as let statements, evaluate to NULL, and nodes which cannot be
+// evaluated, such as type annotations, to an Error.
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
 
 	switch node := node.(type) {
 
 	// Statements
 	case *ast.Program:
-		return evalProgram(node, env)
+		return e.evalProgram(node, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return e.Eval(node.Expression, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return e.evalBlockStatement(node, env)
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := e.delay(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		bind(node.Name, val, env)
+		return object.Null{}
+
+	case *ast.ProtocolStatement:
+		// Protocols constrain only the type checker.
+		return object.Null{}
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := e.Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return object.ReturnValue{Value: val}
+	case *ast.BreakStatement:
+		return object.Break{}
+	case *ast.ContinueStatement:
+		return object.Continue{}
 		// Expressions
 	case *ast.IntegerLiteral:
 		return object.Integer(node.Value)
@@ -49,68 +118,123 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Env: env, Body: body, Scope: scopeOf(node, env)}
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := e.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return (*object.Array)(&elements)
+		return e.charge((*object.Array)(&elements))
 	case *ast.Bool:
 		return object.Bool(node.Value)
+	case *ast.NullLiteral:
+		return object.Null{}
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return e.evalIdentifier(node, env)
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return e.evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		right := Eval(node.Right, env)
+		right := e.Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return e.charge(e.evalInfixExpression(node.Operator, left, right))
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return e.evalIfExpression(node, env)
+	case *ast.WhileExpression:
+		return e.evalWhileExpression(node, env)
+	case *ast.ForExpression:
+		return e.evalForExpression(node, env)
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(node, env)
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := e.Eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
+		var args []object.Object
+		if _, ok := function.(*object.Function); ok && e.Lazy {
+			args = make([]object.Object, len(node.Arguments))
+			for i, arg := range node.Arguments {
+				args[i] = e.delay(arg, env)
+			}
+		} else {
+			args = e.evalExpressions(node.Arguments, env)
+			if len(args) == 1 && isError(args[0]) {
+				return args[0]
+			}
 		}
 
-		return applyFunction(function, args)
+		e.stack = append(e.stack, object.Frame{Function: node.Function.String(), Line: node.Token.Line})
+		defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+		return e.applyFunction(function, args)
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := e.Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := e.Eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return e.evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return e.charge(e.evalHashLiteral(node, env))
+	case *ast.ImportExpression:
+		return e.evalImportExpression(node)
+	case *ast.AwaitExpression:
+		val := e.Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		f, ok := val.(*object.Future)
+		if !ok {
+			return newError("cannot await %s", val.Type())
+		}
+		return f.WaitContext(builtinContext{e: e}.Context())
+	case *ast.TypeAssertion:
+		val := e.Eval(node.Left, env)
+		if isError(val) {
+			return val
+		}
+		t, err := types.Resolve(node.Type)
+		if err != nil {
+			return object.Error{Err: err}
+		}
+		result, err := types.Assert(node.Operator, val, t)
+		if err != nil {
+			return object.Error{Err: err}
+		}
+		return result
 	}
 
-	return nil
+	return newError("cannot evaluate %T", node)
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (e *Evaluator) evalImportExpression(node *ast.ImportExpression) object.Object {
+	if e.Importer == nil {
+		return newError("no module named %q", node.Module.Value)
+	}
+	module, err := e.Importer(builtinContext{e: e}, node.Module.Value)
+	if err != nil {
+		return object.Error{Err: err}
+	}
+	return module
+}
+
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
-	for _, e := range exps {
-		evaluated := Eval(e, env)
+	for _, exp := range exps {
+		evaluated := e.Eval(exp, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -120,23 +244,110 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// Apply calls fn, a function or builtin, with args as a call expression
+// would, for hosts and builtins which call back into monkey code.
+func (e *Evaluator) Apply(fn object.Object, args ...object.Object) object.Object {
+	return e.ApplyContext(context.Background(), fn, args...)
+}
+
+// ApplyContext is like Apply but stops with an error wrapping ctx.Err() once
+// ctx is done.
+func (e *Evaluator) ApplyContext(ctx context.Context, fn object.Object, args ...object.Object) object.Object {
+	prev := e.ctx
+	e.ctx = ctx
+	defer func() { e.ctx = prev }()
+	if err := ctx.Err(); err != nil {
+		return object.Error{Err: err}
+	}
+	return e.applyFunction(fn, args)
+}
+
+func (e *Evaluator) applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), len(fn.Parameters))
+		}
+		if e.depth >= e.maxDepth() {
+			return object.Error{Err: sandbox.ErrDepthLimit}
+		}
+		e.depth++
+		defer func() { e.depth-- }()
+		if e.reusable(fn) {
+			return e.applyLeaf(fn, args)
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		evaluated := e.Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		result := fn.Call(builtinContext{e: e}, args...)
+		if result == nil {
+			// Builtins registered by hosts may return nil.
+			return object.Null{}
+		}
+		return e.charge(result)
 
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// defaultMaxDepth is the deepest calls nest when Limits.MaxDepth is zero.
+// Runaway recursion then fails with sandbox.ErrDepthLimit rather than
+// exhausting the Go stack, which would crash the host.
+const defaultMaxDepth = 10000
+
+func (e *Evaluator) maxDepth() int {
+	if e.Limits.MaxDepth > 0 {
+		return e.Limits.MaxDepth
+	}
+	return defaultMaxDepth
+}
+
+// maxFrames is the most environments an Evaluator keeps for reuse, which is
+// enough for calls of leaf functions nested that deep.
+const maxFrames = 64
+
+// reusable reports whether the environment of a call of fn may be reused
+// once the call returns, which it may unless something made by the call, a
+// closure or, with lazy evaluation, a thunk, refers to it.
+func (e *Evaluator) reusable(fn *object.Function) bool {
+	return fn.Scope != nil && fn.Scope.Leaf && !e.Lazy
+}
+
+// applyLeaf calls fn, which must be reusable, in an environment from
+// e.frames, returning it there afterwards.
+func (e *Evaluator) applyLeaf(fn *object.Function, args []object.Object) object.Object {
+	var env *object.Environment
+	if n := len(e.frames); n > 0 {
+		env = e.frames[n-1]
+		e.frames = e.frames[:n-1]
+		env.Reset(fn.Scope, fn.Env)
+	} else {
+		env = object.NewScopedEnvironment(fn.Scope, fn.Env)
+	}
+	for paramIdx := range fn.Parameters {
+		env.SetSlot(paramIdx, args[paramIdx])
+	}
+	evaluated := e.Eval(fn.Body, env)
+	if len(e.frames) < maxFrames {
+		env.Reset(nil, nil)
+		e.frames = append(e.frames, env)
+	}
+	return unwrapReturnValue(evaluated)
+}
+
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+	if fn.Scope != nil {
+		// The parameters are the first names of the scope.
+		env := object.NewScopedEnvironment(fn.Scope, fn.Env)
+		for paramIdx := range fn.Parameters {
+			env.SetSlot(paramIdx, args[paramIdx])
+		}
+		return env
+	}
 	env := object.NewEnclosedEnvironment(fn.Env)
 
 	for paramIdx, param := range fn.Parameters {
@@ -154,22 +365,94 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
-	if val, ok := env.Get(node.Value); ok {
+// delay returns the value of exp in env, or with lazy evaluation a thunk
+// evaluating it, unless it is a literal which is as cheap to evaluate now.
+func (e *Evaluator) delay(exp ast.Expression, env *object.Environment) object.Object {
+	if !e.Lazy {
+		return e.Eval(exp, env)
+	}
+	switch exp.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Bool,
+		*ast.NullLiteral, *ast.FunctionLiteral:
+		return e.Eval(exp, env)
+	}
+	return object.NewThunk(func() object.Object { return e.Eval(exp, env) })
+}
+
+// bind binds name, the name of a let statement or loop variable, to val in
+// env.
+func bind(name *ast.Identifier, val object.Object, env *object.Environment) {
+	if s := env.Scope(); s != nil {
+		if ref, ok := s.Refs[name]; ok {
+			env.SetSlot(ref.Index, val)
+			return
+		}
+	}
+	env.Set(name.Value, val)
+}
+
+// evalAssignExpression evaluates the value even with e.Lazy, since a thunk
+// of x + 1 assigned to x would refer to itself.
+func (e *Evaluator) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	val := e.Eval(node.Value, env)
+	if isError(val) {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if s := env.Scope(); s != nil {
+		if ref, ok := s.Refs[node.Name]; ok {
+			if env.Assign(ref, node.Name.Value, val) {
+				return val
+			}
+			return newError("identifier not found: %s", node.Name.Value)
+		}
+	}
+	if !env.Update(node.Name.Value, val) {
+		return newError("identifier not found: %s", node.Name.Value)
+	}
+	return val
+}
+
+func (e *Evaluator) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if s := env.Scope(); s != nil {
+		if ref, ok := s.Refs[node]; ok {
+			if val, ok := env.Lookup(ref, node.Value); ok {
+				return object.Force(val)
+			}
+			return e.evalBuiltin(node)
+		}
+	}
+	if val, ok := env.Get(node.Value); ok {
+		return object.Force(val)
+	}
+	return e.evalBuiltin(node)
+}
+
+// standardBuiltins is the table of the Evaluators whose Builtins is nil.
+// It is built on first use and never modified, so that Evaluators running
+// concurrently may share it.
+var standardBuiltins = sync.OnceValue(object.NewBuiltins)
+
+// evalBuiltin evaluates node, an identifier which no environment binds.
+func (e *Evaluator) evalBuiltin(node *ast.Identifier) object.Object {
+	builtins := e.Builtins
+	if builtins == nil {
+		builtins = standardBuiltins()
+	}
+	if builtin, ok := builtins.Lookup(node.Value); ok {
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError("identifier not found: %s", node.Value)
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	var result object.Object = object.Null{}
 
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		if err := e.step(statement); err != nil {
+			return e.locate(statement, err)
+		}
+		result = e.locate(statement, e.Eval(statement, env))
 
 		switch result := result.(type) {
 		case object.ReturnValue:
@@ -182,26 +465,45 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
-	var result object.Object
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	var result object.Object = object.Null{}
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE || rt == object.ERROR {
-				return result
-			}
+		if err := e.step(statement); err != nil {
+			return e.locate(statement, err)
+		}
+		result = e.locate(statement, e.Eval(statement, env))
+		switch result.Type() {
+		case object.RETURN_VALUE, object.ERROR, object.BREAK, object.CONTINUE:
+			return result
 		}
 	}
 
 	return result
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+// locate wraps the error of an Error result of stmt in an
+// *object.RuntimeError recording the statement's line and the call stack,
+// unless it has already been located by an inner statement.
+func (e *Evaluator) locate(stmt ast.Statement, result object.Object) object.Object {
+	errObj, ok := result.(object.Error)
+	if !ok {
+		return result
+	}
+	if _, located := errObj.Err.(*object.RuntimeError); located {
+		return result
+	}
+	return object.Error{Err: &object.RuntimeError{
+		Line:  stmt.Line(),
+		Err:   errObj.Err,
+		Stack: append([]object.Frame(nil), e.stack...),
+	}}
+}
+
+func (e *Evaluator) evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
-		return evalBangOperatorExpression(right)
+		return object.Bool(!e.Truthiness.IsTruthy(right))
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
@@ -209,18 +511,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	}
 }
 
-func evalBangOperatorExpression(right object.Object) object.Object {
-	switch v := right.(type) {
-	case object.Bool:
-		return !v
-	case object.Null:
-		return TRUE
-	default:
-		return FALSE
-	}
-}
-
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func (e *Evaluator) evalInfixExpression(operator string, left, right object.Object) object.Object {
 	lt, rt := left.Type(), right.Type()
 	if lt == object.INTEGER && rt == object.FLOAT {
 		left, lt = object.Float(left.(object.Integer)), object.FLOAT
@@ -229,11 +520,21 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 	switch {
 	case lt == object.INTEGER && rt == object.INTEGER:
+		if operator == "/" && e.IntegerDivision {
+			quo, err := object.Truncate(left.(object.Integer), right.(object.Integer))
+			if err != nil {
+				return object.Error{Err: err}
+			}
+			return quo
+		}
 		return evalIntegerInfixExpression(operator, left.(object.Integer), right.(object.Integer))
 	case lt == object.FLOAT && rt == object.FLOAT:
 		return evalFloatInfixExpression(operator, left.(object.Float), right.(object.Float))
 	case lt == object.STRING && rt == object.STRING:
-		return evalStringInfixExpression(operator, left.(object.String), right.(object.String))
+		return e.evalStringInfixExpression(operator, left.(object.String), right.(object.String))
+	case lt == rt && !object.Comparable(left):
+		return newError("unknown operator: %s %s %s",
+			lt, operator, rt)
 	case operator == "==":
 		return object.Bool(left == right)
 	case operator == "!=":
@@ -248,12 +549,18 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 }
 
-func evalStringInfixExpression(operator string, left, right object.String) object.Object {
-	if operator != "+" {
+func (e *Evaluator) evalStringInfixExpression(operator string, left, right object.String) object.Object {
+	switch operator {
+	case "+":
+		return e.concat.Concat(left, right)
+	case "==":
+		return object.Bool(left == right)
+	case "!=":
+		return object.Bool(left != right)
+	default:
 		return newError("unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
-	return left + right
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Integer) object.Object {
@@ -265,11 +572,31 @@ func evalIntegerInfixExpression(operator string, left, right object.Integer) obj
 	case "*":
 		return left * right
 	case "/":
-		return left / right
+		quo, err := object.Divide(left, right)
+		if err != nil {
+			return object.Error{Err: err}
+		}
+		return quo
+	case "~/":
+		quo, err := object.FloorDivide(left, right)
+		if err != nil {
+			return object.Error{Err: err}
+		}
+		return quo
+	case "%":
+		rem, err := object.Modulo(left, right)
+		if err != nil {
+			return object.Error{Err: err}
+		}
+		return rem
 	case "<":
 		return object.Bool(left < right)
 	case ">":
 		return object.Bool(left > right)
+	case "<=":
+		return object.Bool(left <= right)
+	case ">=":
+		return object.Bool(left >= right)
 	case "==":
 		return object.Bool(left == right)
 	case "!=":
@@ -290,10 +617,18 @@ func evalFloatInfixExpression(operator string, left, right object.Float) object.
 		return left * right
 	case "/":
 		return left / right
+	case "~/":
+		return object.FloorDivideFloat(left, right)
+	case "%":
+		return object.ModuloFloat(left, right)
 	case "<":
 		return object.Bool(left < right)
 	case ">":
 		return object.Bool(left > right)
+	case "<=":
+		return object.Bool(left <= right)
+	case ">=":
+		return object.Bool(left >= right)
 	case "==":
 		return object.Bool(left == right)
 	case "!=":
@@ -304,96 +639,167 @@ func evalFloatInfixExpression(operator string, left, right object.Float) object.
 	}
 }
 
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := e.Eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
-	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+	if e.Truthiness.IsTruthy(condition) {
+		return e.Eval(ie.Consequence, env)
 	}
 	if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return e.Eval(ie.Alternative, env)
+	}
+	return object.Null{}
+}
+
+// evalWhileExpression counts each iteration as a step, so that a loop with an
+// empty body is still bounded by the limits and the context.
+func (e *Evaluator) evalWhileExpression(we *ast.WhileExpression, env *object.Environment) object.Object {
+	for {
+		if err := e.tick(); err != nil {
+			return err
+		}
+		condition := e.Eval(we.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !e.Truthiness.IsTruthy(condition) {
+			return object.Null{}
+		}
+		result := e.Eval(we.Body, env)
+		switch result.Type() {
+		case object.RETURN_VALUE, object.ERROR:
+			return result
+		case object.BREAK:
+			return object.Null{}
+		}
+	}
+}
+
+// evalForExpression counts each iteration as a step, as evalWhileExpression
+// does.
+func (e *Evaluator) evalForExpression(fe *ast.ForExpression, env *object.Environment) object.Object {
+	iterable := e.Eval(fe.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	it, err := object.NewIterator(iterable)
+	if err != nil {
+		return object.Error{Err: err}
+	}
+	for {
+		if err := e.tick(); err != nil {
+			return err
+		}
+		key, value, ok := it.Next()
+		if !ok {
+			return object.Null{}
+		}
+		if fe.Key != nil {
+			bind(fe.Key, key, env)
+			bind(fe.Value, value, env)
+		} else {
+			bind(fe.Value, it.Single(key, value), env)
+		}
+		result := e.Eval(fe.Body, env)
+		switch result.Type() {
+		case object.RETURN_VALUE, object.ERROR:
+			return result
+		case object.BREAK:
+			return object.Null{}
+		}
 	}
-	return NULL
 }
 
-func evalIndexExpression(left, index object.Object) object.Object {
+func (e *Evaluator) evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY && index.Type() == object.INTEGER:
-		return evalArrayIndexExpression(left, index)
+		return e.evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH:
-		return evalHashIndexExpression(left, index)
+		return e.evalHashIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
-func evalHashIndexExpression(hash, index object.Object) object.Object {
+func (e *Evaluator) evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(object.Hash)
 	if !object.Hashable(index) {
-		return newError("unusable as hash key: %v", index.Type())
+		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	got, ok := hashObject[index]
+	got, ok := hashObject.Get(index)
 	if !ok {
-		return NULL
+		if e.StrictIndex {
+			return object.Error{Err: object.KeyError(index)}
+		}
+		return object.Null{}
 	}
 	return got
 
 }
 
-func evalArrayIndexExpression(array, index object.Object) object.Object {
+func (e *Evaluator) evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
 	idx := index.(object.Integer)
 	max := object.Integer(len(*arrayObject) - 1)
 
 	if idx < 0 || idx > max {
-		return NULL
+		if e.StrictIndex {
+			return object.Error{Err: object.IndexError(*arrayObject, idx)}
+		}
+		return object.Null{}
 	}
 
 	return (*arrayObject)[idx]
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) (o object.Object) {
-	defer func() {
-		if r := recover(); r != nil {
-			o = newError("unhashable key: %v", r)
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	if s := env.Scope(); s != nil {
+		if l, ok := s.Hashes[node]; ok {
+			return e.evalHashLayout(l, env)
 		}
-	}()
-	m := make(object.Hash, len(node.Pairs))
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	}
+	m := object.NewHash(len(node.Pairs))
+	for _, pair := range node.Pairs {
+		key := e.Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
-		value := Eval(valueNode, env)
+		if !object.Hashable(key) {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+		value := e.Eval(pair.Value, env)
 		if isError(value) {
 			return value
 		}
-		m[key] = value
+		m.Set(key, value)
 	}
 	return m
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
-		return false
-	default:
-		return true
+func (e *Evaluator) evalHashLayout(l *object.HashLayout, env *object.Environment) object.Object {
+	m := object.NewHash(len(l.Keys))
+	for i, key := range l.Keys {
+		value := e.Eval(l.Values[i], env)
+		if isError(value) {
+			return value
+		}
+		m.Set(key, value)
 	}
+	return m
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER {
+	switch right := right.(type) {
+	case object.Integer:
+		return -right
+	case object.Float:
+		return -right
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	return -1 * right.(object.Integer)
 }
 
 func newError(format string, a ...interface{}) object.Error {
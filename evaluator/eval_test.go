@@ -1,13 +1,19 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ajwerner/monkey/ast"
 	"github.com/ajwerner/monkey/lexer"
 	"github.com/ajwerner/monkey/object"
 	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/sandbox"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -25,11 +31,20 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"5 * 2 + 10", 20},
 		{"5 + 2 * 10", 25},
 		{"20 + 2 * -10", 0},
-		{"50 / 2 * 2 + 10", 60},
+		{"50 ~/ 2 * 2 + 10", 60},
 		{"2 * (5 + 10)", 30},
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
-		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"(5 + 10 * 2 + 15 ~/ 3) * 2 + -10", 50},
+		{"7 ~/ 2", 3},
+		{"-7 ~/ 2", -4},
+		{"7 ~/ -2", -4},
+		{"-7 ~/ -2", 3},
+		{"7 % 3", 1},
+		{"-7 % 3", 2},
+		{"7 % -3", -2},
+		{"-7 % -3", -1},
+		{"1 + 10 % 4 * 2", 5},
 	}
 
 	for _, tt := range tests {
@@ -62,7 +77,7 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 }
 
 func testNullObject(t *testing.T, obj object.Object) bool {
-	if obj != NULL {
+	if obj != (object.Null{}) {
 		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
 		return false
 	}
@@ -76,6 +91,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}{
 		{"true", true},
 		{"false", false},
+		{`"a" == "a"`, true},
+		{`"a" == "b"`, false},
+		{`"a" != "b"`, true},
+		{`"a" + "b" != "ab"`, false},
 	}
 
 	for _, tt := range tests {
@@ -113,6 +132,12 @@ func TestBangOperator(t *testing.T) {
 		{"1 > 2", false},
 		{"1 < 1", false},
 		{"1 > 1", false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"1.5 <= 2", true},
+		{"1.5 >= 1.5", true},
 		{"1 == 1", true},
 		{"1 != 1", false},
 		{"1 == 2", false},
@@ -159,6 +184,141 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 }
 
+func TestWhileExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"while (false) { 10 }", nil},
+		{"let i = 0; while (i < 3) { let i = i + 1; }; i", 3},
+		{"let i = 0; while (i < 3) { let i = i + 1; }", nil},
+		{"let f = fn() { while (true) { return 5; } }; f()", 5},
+		{"let f = fn(n) { let i = 0; while (i < n) { let i = i + 1; }; i }; f(4)", 4},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"while (true) { break; }", nil},
+		{"let i = 0; while (true) { i = i + 1; if (i == 3) { break; } }; i", 3},
+		{"let i = 0; let s = 0; while (i < 5) { i = i + 1; if (i == 2) { continue; } s = s + i; }; s", 13},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } s = s + x; }; s", 3},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x % 2 == 0) { continue; } s = s + x; }; s", 4},
+		{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break; } n = n + 1; } }; n", 2},
+		{"let f = fn(xs) { let n = 0; for (x in xs) { if (x > 2) { break; } n = n + x; }; n }; f([1, 2, 3, 4])", 3},
+		{"let f = fn() { while (true) { while (true) { break; } return 7; } }; f()", 7},
+		{"let i = 0; while (i < 5000) { i = i + 1; if (true) { continue; } }; i", 5000},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+// TestLoopControlInExpressions checks that break and continue cannot give a
+// value to an expression, which the engines would otherwise disagree on.
+func TestLoopControlInExpressions(t *testing.T) {
+	for _, input := range []string{
+		"for (x in [1, 2, 3]) { let y = if (x == 2) { break; } else { x }; puts(y) }",
+		"while (true) { puts(if (true) { continue; }) }",
+	} {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected a parse error", input)
+		}
+	}
+}
+
+func TestForExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (i, x in [5, 6, 7]) { let sum = sum + i * x; }; sum", 20},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2}) { let sum = sum + v + len(k); }; sum`, 5},
+		{`let sum = 0; for (k in {1: "a", 2: "b"}) { let sum = sum + k; }; sum`, 3},
+		{"for (x in []) { x }", nil},
+		{"for (x in [1]) { x }", nil},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } }; f([1, 2, 3])", 2},
+		{"let f = fn(xs) { let n = 0; for (x in xs) { let n = n + 1; }; n }; f([4, 4])", 2},
+		{"let f = fn(xs) { for (x in xs) { } ; x }; f([4, 5])", 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+
+	evaluated := testEval("for (x in 1) { x }")
+	if errObj, ok := evaluated.(object.Error); !ok || !errors.Is(errObj.Err, object.ErrNotIterable) ||
+		!strings.HasSuffix(errObj.Err.Error(), "not iterable: INTEGER") {
+		t.Errorf("expected a not iterable error, got %v", evaluated)
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let i = 0; let sum = 0; while (i < 4) { sum = sum + i; i = i + 1; }; sum", 6},
+		{"let c = 0; let inc = fn() { c = c + 1 }; inc(); inc(); c", 2},
+		{"let f = fn() { let x = 1; x = 2; x }; f()", 2},
+		{"let f = fn(x) { x = x * 2; x }; f(4)", 8},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c()", 2},
+		// x is assigned before f binds its own x, so the global is assigned.
+		{"let x = 1; let f = fn() { x = 5; let x = 3; x }; f() + x", 8},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), int64(tt.expected.(int)))
+	}
+
+	errs := []struct {
+		input string
+		msg   string
+	}{
+		{"y = 1", "identifier not found: y"},
+		{"let f = fn() { y = 1 }; f()", "identifier not found: y"},
+		{"len = 1", "identifier not found: len"},
+	}
+	for _, tt := range errs {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(object.Error); !ok || !strings.HasSuffix(errObj.Err.Error(), tt.msg) {
+			t.Errorf("%s: expected %q, got %v", tt.input, tt.msg, evaluated)
+		}
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -205,10 +365,38 @@ func TestErrorHandling(t *testing.T) {
 			"-true",
 			"unknown operator: -BOOL",
 		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"fn(a, b) { a }(1)",
+			"wrong number of arguments. got=1, want=2",
+		},
+		{
+			"let f = fn(a) { a * a }; f(f(1), 2)",
+			"wrong number of arguments. got=2, want=1",
+		},
+		{
+			"1 ~/ 0",
+			"division by zero",
+		},
+		{
+			"1 % 0",
+			"division by zero",
+		},
 		{
 			"true + false;",
 			"unknown operator: BOOL + BOOL",
 		},
+		{
+			"{} == {}",
+			"unknown operator: HASH == HASH",
+		},
+		{
+			`{"a": 1} != {"a": 1}`,
+			"unknown operator: HASH != HASH",
+		},
 		{
 			"5; true + false; 5",
 			"unknown operator: BOOL + BOOL",
@@ -241,6 +429,22 @@ if (10 > 1) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			`{[1]: 2}`,
+			"unusable as hash key: ARRAY",
+		},
+		{
+			`let f = fn(k) { {k: 1} }; f(fn() { 1 })`,
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`"a" as int`,
+			"type assertion failed: STRING is not int",
+		},
+		{
+			"1 is integer",
+			"unknown type integer",
+		},
 	}
 
 	for _, tt := range tests {
@@ -253,13 +457,43 @@ if (10 > 1) {
 			continue
 		}
 
-		if errObj.Inspect() != tt.expectedMessage {
+		var rtErr *object.RuntimeError
+		if !errors.As(errObj.Err, &rtErr) {
+			t.Errorf("error is not a RuntimeError. got=%T", errObj.Err)
+			continue
+		}
+		if rtErr.Err.Error() != tt.expectedMessage {
 			t.Errorf("wrong error message. expected=%q, got=%q",
-				tt.expectedMessage, errObj.Inspect())
+				tt.expectedMessage, rtErr.Err)
 		}
 	}
 }
 
+func TestTypeAssertions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 is int", true},
+		{"1.5 is int", false},
+		{`"a" is string`, true},
+		{"null is any", true},
+		{`[1, 2.5] is array`, true},
+		{`[1, 2.5] is array<int>`, false},
+		{`{"a": [1]} is hash<string, array<int>>`, true},
+		{"fn(a, b) { a } is fn(int, int) -> int", true},
+		{"fn(a) { a } is fn(int, int)", false},
+		{"fn(a) { a } is fn", true},
+		{"len is fn", true},
+		{`let f = fn(x) { if (x is int) { x } else { 0 } }; f("a") == 0`, true},
+		{"(1 as int) is int", true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -276,6 +510,51 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+// TestEvalNeverNil evaluates every node of a program using each kind of
+// node, on its own, and checks that the result is an Object.
+func TestEvalNeverNil(t *testing.T) {
+	input := `
+protocol Shape { fn area() -> float; name: string; }
+let x: int = 5;
+let f = fn(a: int, b) -> bool { let c = a; return c; };
+let g = fn() {};
+if (x > 1) { x } else { -x };
+if (false) { 1 };
+!true; null; 1.5; "s" + "t";
+[1, 2][0]; {"a": 1, x: 2}["a"];
+f(1, 2) is int; x as int;
+await spawn(g);
+import "nope";
+`
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		t.Fatal(errs[0])
+	}
+	seen := map[string]bool{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		seen[fmt.Sprintf("%T", n)] = true
+		if got := Eval(n, object.NewEnvironment()); got == nil {
+			t.Errorf("Eval(%T %q) returned nil", n, n.String())
+		}
+		return true
+	})
+	if len(seen) < 24 {
+		t.Errorf("expected the program to use every kind of node, got %v", seen)
+	}
+	if got := Eval(nil, object.NewEnvironment()); got == nil {
+		t.Error("Eval(nil) returned nil")
+	}
+
+	b := object.NewBuiltins()
+	b.Register("nothing", func(args ...object.Object) object.Object { return nil })
+	e := Evaluator{Builtins: b}
+	program = parser.New(lexer.New("nothing()")).ParseProgram()
+	if got := e.Eval(program, object.NewEnvironment()); got != (object.Null{}) {
+		t.Errorf("expected a builtin returning nil to give NULL, got %v", got)
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 
@@ -312,6 +591,9 @@ func TestFunctionApplication(t *testing.T) {
 		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
 		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
 		{"fn(x) { x; }(5)", 5},
+		{`let add = fn(x: int, y: string) -> int { x + len(y) }; let z: int = add(2, "abc"); z`, 5},
+		{`let head = fn<T>(xs: array<T>) -> T { xs[0] }; head([5, 6])`, 5},
+		{`protocol Adder { fn add(a, b) }; let f = fn(x: Adder) { x.add(2, 3) }; f({"add": fn(a, b) { a + b }})`, 5},
 	}
 
 	for _, tt := range tests {
@@ -331,6 +613,101 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// A local read before it is bound refers to the global.
+		{"let x = 1; let f = fn() { let y = x; let x = 2; y + x }; f()", 3},
+		{"let x = 1; let f = fn(x) { let x = x + 1; x }; f(5) + x", 7},
+		{"let f = fn(a, a) { a }; f(1, 2)", 2},
+		// Closures share the bindings of their enclosing calls, including
+		// those made after they are created.
+		{"let f = fn() { let g = fn() { y * 2 }; let y = 3; g() }; f()", 6},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { n + g(n - 1) } }; g(4) }; f()", 10},
+		// Globals bound after a function is created are visible to it.
+		{"let f = fn() { later }; let later = 5; f()", 5},
+		{"let f = fn() { if (true) { let z = 4; } z }; f()", 4},
+		// Leaf functions reuse the environments of returned calls, which
+		// must not leak bindings into later calls.
+		{"let f = fn(a, b) { [a, b] }; let x = f(1, 2); let y = f(3, 4); x[0] + y[1]", 5},
+		{"let y = 10; let f = fn(n) { if (n > 0) { let y = n; } y }; f(2) + f(0)", 12},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestMemo(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// Without memo, this would make billions of calls.
+		{`let fib = memo(fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }); fib(60)`, 1548008755920},
+		{`let calls = atomic(0);
+let sq = memo(fn(n) { atomicAdd(calls, 1); n * n });
+sq(3) + sq(3) + sq(4) + atomicAdd(calls, 0)`, 36},
+		{`let calls = atomic(0);
+let add = memo(fn(a, b) { atomicAdd(calls, 1); a + b });
+add(1, 2) + add(2, 1) + add(1, 2) + atomicAdd(calls, 0)`, 11},
+		// Unhashable arguments are not cached.
+		{`let calls = atomic(0);
+let count = memo(fn(xs) { atomicAdd(calls, 1); len(xs) });
+count([1]) + count([1]) + atomicAdd(calls, 0)`, 4},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+
+	errObj, ok := testEval(`memo(1)`).(object.Error)
+	if !ok || errObj.Err.Error() != "line 1: argument to `memo` must be FUNCTION, got INTEGER" {
+		t.Errorf("unexpected result %v", errObj)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	streams := `
+let cons = fn(head, tail) { fn(f) { f(head, tail) } };
+let head = fn(s) { s(fn(h, t) { h }) };
+let tail = fn(s) { s(fn(h, t) { t }) };
+let from = fn(n) { cons(n, from(n + 1)) };
+let take = fn(s, n) { if (n == 0) { [] } else { push(take(tail(s), n - 1), head(s)) } };
+`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// Arguments which are never used are never evaluated.
+		{`let first = fn(a, b) { a }; first(1, nope)`, 1},
+		{`let calls = atomic(0); let x = atomicAdd(calls, 1); atomicAdd(calls, 0)`, 0},
+		{`let calls = atomic(0); let x = atomicAdd(calls, 1); x + x + atomicAdd(calls, 0)`, 3},
+		{`let f = fn() { let y = x; let x = 2; y }; f()`, 2},
+		{streams + `head(tail(tail(from(1))))`, 3},
+		{streams + `len(take(from(1), 100))`, 100},
+		{`let x = nope; let y = 1; y`, 1},
+		{`let x = nope;
+x + 1`, "line 2: identifier not found: nope"},
+		{`let f = fn() { let x = x + 1; x }; f()`, "line 1: lazy binding depends on itself"},
+	}
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		e := Evaluator{Lazy: true}
+		got := e.Eval(program, object.NewEnvironment())
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, got, int64(expected))
+		case string:
+			errObj, ok := got.(object.Error)
+			if !ok || errObj.Err.Error() != expected {
+				t.Errorf("%s: got %v, want error %q", tt.input, got, expected)
+			}
+		}
+	}
+}
+
 func TestRecursion(t *testing.T) {
 	input := `
 let f = fn(x) {
@@ -383,6 +760,44 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("hello world")`, 11},
 		{`len(1)`, fmt.Errorf("argument to `len` not supported, got INTEGER")},
 		{`len("one", "two")`, fmt.Errorf("wrong number of arguments. got=2, want=1")},
+		{`len(pop([1, 2, 3]))`, 2},
+		{`last(pop([1, 2, 3]))`, 2},
+		{`first(reverse([1, 2, 3]))`, 3},
+		{`len(reverse([]))`, 0},
+		{`len(concat([1], [2, 3], []))`, 3},
+		{`last(concat([1], [2, 3]))`, 3},
+		{`pop(1)`, fmt.Errorf("argument to `pop` must be ARRAY, got INTEGER")},
+		{`reverse([1], [2])`, fmt.Errorf("wrong number of arguments. got=2, want=1")},
+		{`concat()`, fmt.Errorf("wrong number of arguments. got=0, want at least 1")},
+		{`concat([1], "a")`, fmt.Errorf("argument 2 to `concat` must be ARRAY, got STRING")},
+		{`let h = {"a": 1, "b": 2}; len(keys(h)) + len(values(h))`, 4},
+		{`let s = 0; for (v in values({"a": 1, "b": 2})) { s = s + v; }; s`, 3},
+		{`let h = {"a": 1, "b": 2}; len(keys(delete(h, "a"))) * 10 + len(keys(h))`, 12},
+		{`merge({"a": 1, "b": 2}, {"b": 3}, {})["b"]`, 3},
+		{`if (hasKey({"a": null}, "a")) { 1 } else { 0 }`, 1},
+		{`if (hasKey({"a": null}, "b")) { 1 } else { 0 }`, 0},
+		{`keys([1])`, fmt.Errorf("argument to `keys` must be HASH, got ARRAY")},
+		{`delete({}, [1])`, fmt.Errorf("unusable as hash key: ARRAY")},
+		{`merge({}, 1)`, fmt.Errorf("argument 2 to `merge` must be HASH, got INTEGER")},
+		{`hasKey({})`, fmt.Errorf("wrong number of arguments. got=1, want=2")},
+		{`int("42") + int(7.9) + int(0 - 7.9) + int(true)`, 43},
+		{`int(float("2.5") * 2) + int(float(3))`, 8},
+		{`len(str([1, "a"])) + len(str("abc"))`, 11},
+		{`if (type([]) == "ARRAY") { 1 } else { 0 }`, 1},
+		{`if (bool(0)) { 1 } else { 0 }`, 1},
+		{`if (bool(null)) { 1 } else { 0 }`, 0},
+		{`int("abc")`, fmt.Errorf("cannot convert \"abc\" to INTEGER")},
+		{`int(1e19)`, fmt.Errorf("cannot convert 1e+19 to INTEGER")},
+		{`float([1])`, fmt.Errorf("argument to `float` not supported, got ARRAY")},
+		{`str()`, fmt.Errorf("wrong number of arguments. got=0, want=1")},
+		{`len(split("a,b,c", ","))`, 3},
+		{`len(join(["a", "b"], ", ")) + len(trim("  x "))`, 5},
+		{`int(upper("ab") == "AB") + int(lower("AB") == "ab")`, 2},
+		{`if (replace("a-b-c", "-", "") == "abc") { 1 } else { 0 }`, 1},
+		{`int(contains("monkey", "key")) + int(startsWith("monkey", "key"))`, 1},
+		{`upper(1)`, fmt.Errorf("argument 1 to `upper` must be STRING, got INTEGER")},
+		{`replace("a", "b")`, fmt.Errorf("wrong number of arguments. got=2, want=3")},
+		{`join(["a", 1], "")`, fmt.Errorf("element 1 of the array passed to `join` must be STRING, got INTEGER")},
 	}
 
 	for _, tt := range tests {
@@ -398,7 +813,7 @@ func TestBuiltinFunctions(t *testing.T) {
 					evaluated, evaluated)
 				continue
 			}
-			if errObj.Err.Error() != expected.Error() {
+			if errors.Unwrap(errObj.Err).Error() != expected.Error() {
 				t.Errorf("wrong error message. expected=%q, got=%q",
 					expected, errObj.Err)
 			}
@@ -406,6 +821,17 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestStandardBuiltinsShared(t *testing.T) {
+	// Evaluation reads the configuration of an Evaluator but never sets it,
+	// so that the tasks and handlers sharing it do not race.
+	var e Evaluator
+	program := parser.New(lexer.New("len(pmap([1, 2, 3], fn(x) { len(str(x)) }, 2))")).ParseProgram()
+	testIntegerObject(t, e.Eval(program, object.NewEnvironment()), 3)
+	if e.Builtins != nil {
+		t.Errorf("expected evaluation to leave Builtins unset, got %v", e.Builtins.Names())
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -488,7 +914,7 @@ func TestHashLiterals(t *testing.T) {
     {
         "one": 10 - 9,
         two: 1 + 1,
-        "thr" + "ee": 6 / 2,
+        "thr" + "ee": 6 ~/ 2,
         4: 4,
         true: 5,
         false: 6
@@ -499,14 +925,14 @@ func TestHashLiterals(t *testing.T) {
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
 	}
-	expected := object.Hash{
-		object.String("one"):   object.Integer(1),
-		object.String("two"):   object.Integer(2),
-		object.String("three"): object.Integer(3),
-		object.Integer(4):      object.Integer(4),
-		object.Bool(true):      object.Integer(5),
-		object.Bool(false):     object.Integer(6),
-	}
+	expected := object.HashOf(
+		object.String("one"), object.Integer(1),
+		object.String("two"), object.Integer(2),
+		object.String("three"), object.Integer(3),
+		object.Integer(4), object.Integer(4),
+		object.Bool(true), object.Integer(5),
+		object.Bool(false), object.Integer(6),
+	)
 	if !reflect.DeepEqual(result, expected) {
 		t.Fatalf("Eval didn't match %v %v", evaluated, expected)
 	}
@@ -541,10 +967,22 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{true: 5}[true]`,
 			5,
 		},
+		{
+			`let f = fn(x) { {"a": x, "b": x * 2, 1: "c"} }; f(1); f(2)["b"]`,
+			4,
+		},
 		{
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{"ab": 5}["a" + "b"]`,
+			5,
+		},
+		{
+			`{1 + 1: 5}[4 ~/ 2]`,
+			5,
+		},
 	}
 
 	for _, tt := range tests {
@@ -557,3 +995,371 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   sandbox.Limits
+		expected error
+	}{
+		{
+			"let f = fn(x) { f(x + 1) }; f(0);",
+			sandbox.Limits{MaxSteps: 100},
+			sandbox.ErrStepLimit,
+		},
+		{
+			"while (true) {}",
+			sandbox.Limits{MaxSteps: 100},
+			sandbox.ErrStepLimit,
+		},
+		{
+			"let f = fn(x) { f(x + 1) }; f(0);",
+			sandbox.Limits{MaxDepth: 100},
+			sandbox.ErrDepthLimit,
+		},
+		{
+			"let f = fn(x) { 1 + f(x + 1) }; f(0);",
+			sandbox.Limits{},
+			sandbox.ErrDepthLimit,
+		},
+		{
+			`let f = fn(s) { f(s + s) }; f("ab");`,
+			sandbox.Limits{MaxMemory: 1 << 20},
+			sandbox.ErrMemoryLimit,
+		},
+		{
+			"let f = fn(x) { if (x > 0) { f(x - 1) } else { x } }; f(10);",
+			sandbox.Limits{MaxSteps: 100, MaxDepth: 100, MaxMemory: 100},
+			nil,
+		},
+		{
+			`let s = "abc"; s + s`,
+			sandbox.Limits{MaxStringLen: 5},
+			sandbox.ErrStringLimit,
+		},
+		{
+			"[1, 2, 3]",
+			sandbox.Limits{MaxArrayLen: 2},
+			sandbox.ErrArrayLimit,
+		},
+		{
+			"push([1, 2], 3)",
+			sandbox.Limits{MaxArrayLen: 2},
+			sandbox.ErrArrayLimit,
+		},
+		{
+			"chan(3)",
+			sandbox.Limits{MaxArrayLen: 2},
+			sandbox.ErrArrayLimit,
+		},
+		{
+			"[chan(1000), chan(1000)]",
+			sandbox.Limits{MaxMemory: 20000},
+			sandbox.ErrMemoryLimit,
+		},
+		{
+			// Each task stays within the limit, but together they do not.
+			`let f = fn() { let i = 0; while (i < 30) { i = i + 1; } i };
+			let ts = [spawn(f), spawn(f), spawn(f), spawn(f), spawn(f), spawn(f)];
+			wait(ts[0]) + wait(ts[1]) + wait(ts[2]) + wait(ts[3]) + wait(ts[4]) + wait(ts[5])`,
+			sandbox.Limits{MaxSteps: 150},
+			sandbox.ErrStepLimit,
+		},
+		{
+			`let f = fn() { let s = "abcdefgh"; let i = 0; while (i < 6) { s = s + s; i = i + 1; } len(s) };
+			let ts = [spawn(f), spawn(f), spawn(f), spawn(f), spawn(f), spawn(f)];
+			wait(ts[0]) + wait(ts[1]) + wait(ts[2]) + wait(ts[3]) + wait(ts[4]) + wait(ts[5])`,
+			sandbox.Limits{MaxMemory: 2000},
+			sandbox.ErrMemoryLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		e := Evaluator{Limits: tt.limits}
+		evaluated := e.Eval(program, object.NewEnvironment())
+		errObj, isErr := evaluated.(object.Error)
+		switch {
+		case tt.expected == nil && isErr:
+			t.Errorf("%q: unexpected error %v", tt.input, errObj.Err)
+		case tt.expected != nil && (!isErr || !errors.Is(errObj.Err, tt.expected)):
+			t.Errorf("%q: expected error %v, got %T (%+v)",
+				tt.input, tt.expected, evaluated, evaluated)
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(x) { f(x + 1) }; f(0);")).ParseProgram()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var e Evaluator
+	evaluated := e.EvalContext(ctx, program, object.NewEnvironment())
+	errObj, ok := evaluated.(object.Error)
+	if !ok || !errors.Is(errObj.Err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestRuntimeErrorLocation(t *testing.T) {
+	input := `let inner = fn(x) {
+  x + true
+};
+let outer = fn(x) { inner(x) };
+1;
+outer(1);`
+	errObj, ok := testEval(input).(object.Error)
+	if !ok {
+		t.Fatalf("no error object returned")
+	}
+	var rtErr *object.RuntimeError
+	if !errors.As(errObj.Err, &rtErr) {
+		t.Fatalf("error is not a RuntimeError. got=%T", errObj.Err)
+	}
+	if rtErr.Line != 2 || rtErr.Err.Error() != "type mismatch: INTEGER + BOOL" {
+		t.Errorf("wrong error. got line %d: %v", rtErr.Line, rtErr.Err)
+	}
+	expected := []object.Frame{{Function: "outer", Line: 6}, {Function: "inner", Line: 4}}
+	if !reflect.DeepEqual(rtErr.Stack, expected) {
+		t.Errorf("wrong stack. want=%+v, got=%+v", expected, rtErr.Stack)
+	}
+}
+
+func TestImportExpression(t *testing.T) {
+	double := &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		return args[0].(object.Integer) * 2
+	}}
+	e := Evaluator{Importer: func(_ object.BuiltinContext, name string) (object.Object, error) {
+		if name != "nums" {
+			return nil, fmt.Errorf("no module named %q", name)
+		}
+		return object.HashOf(object.String("double"), double), nil
+	}}
+	program := parser.New(lexer.New(`let n = import "nums"; n.double(21)`)).ParseProgram()
+	testIntegerObject(t, e.Eval(program, object.NewEnvironment()), 42)
+
+	noImporter := Evaluator{}
+	tests := []struct {
+		e     *Evaluator
+		input string
+	}{
+		{&e, `import "missing"`},
+		{&noImporter, `import "nums"`},
+	}
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		errObj, ok := tt.e.Eval(program, object.NewEnvironment()).(object.Error)
+		if !ok || !strings.HasPrefix(errors.Unwrap(errObj.Err).Error(), "no module named") {
+			t.Errorf("%q: expected no module error, got %v", tt.input, errObj)
+		}
+	}
+}
+
+func TestJSONLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.Object
+	}{
+		{"null", object.Null{}},
+		{"-1.5", object.Float(-1.5)},
+		{"5 / 2", object.Float(2.5)},
+		{"4 / 2", object.Float(2)},
+		{"7.5 ~/ 2", object.Float(3)},
+		{"-7.5 ~/ 2", object.Float(-4)},
+		{"7.5 % 2", object.Float(1.5)},
+		{"-7.5 % 2", object.Float(0.5)},
+		{"7 % 2.5", object.Float(2)},
+		{`{"a": [null, -2]}["a"]`, &object.Array{object.Null{}, object.Integer(-2)}},
+	}
+	for _, tt := range tests {
+		if got := testEval(tt.input); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong result. want=%v, got=%v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	fn := testEval("let base = 10; fn(x) { base + x }")
+	var e Evaluator
+	testIntegerObject(t, e.Apply(fn, object.Integer(5)), 15)
+
+	errObj, ok := e.Apply(fn).(object.Error)
+	if !ok || errObj.Err.Error() != "wrong number of arguments. got=0, want=1" {
+		t.Errorf("expected arity error, got %v", errObj)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if errObj, ok := e.ApplyContext(ctx, fn, object.Integer(1)).(object.Error); !ok || !errors.Is(errObj.Err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", errObj)
+	}
+}
+
+func TestSpawn(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let add = fn(a, b) { a + b }; wait(spawn(add, 1, 2))", 3},
+		{"let x = 1; let f = fn() { x }; let t = spawn(f); let x = 2; wait(t) + x", 3},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; wait(spawn(fib, 15))", 610},
+		{"let ts = [spawn(fn() { 1 }), spawn(fn() { 2 })]; wait(ts[0]) + wait(ts[1])", 3},
+		{"wait(spawn(fn() { 1 + true }))", "line 1: type mismatch: INTEGER + BOOL"},
+		{"wait(spawn(fn(x) { x }))", "line 1: wrong number of arguments. got=0, want=1"},
+		{"wait(spawn(1))", "line 1: not a function: INTEGER"},
+		{"wait(1)", "line 1: argument to `wait` must be FUTURE, got INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(object.Error)
+			if !ok || errObj.Err.Error() != expected {
+				t.Errorf("%q: expected error %q, got %v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let ch = chan(); spawn(fn() { send(ch, 1); send(ch, 2); closeChan(ch) }); recv(ch) + recv(ch)", 3},
+		{"let ch = chan(2); send(ch, 1); send(ch, 2); closeChan(ch); recv(ch) + recv(ch)", 3},
+		{"let ch = chan(1); closeChan(ch); recv(ch)", nil},
+		{"let ch = chan(1); closeChan(ch); send(ch, 1)", "line 1: channel is closed"},
+		{"let ch = chan(1); closeChan(ch); closeChan(ch)", "line 1: channel is closed"},
+		{"chan(-1)", "line 1: argument to `chan` must be a non-negative INTEGER, got -1"},
+		{"chan(100000000000000)", "line 1: argument to `chan` must be at most 1048576, got 100000000000000"},
+		{"recv(1)", "line 1: argument to `recv` must be CHANNEL, got INTEGER"},
+		{"let a = chan(1); let b = chan(1); send(b, 2); select([a, fn(v) { v }], [b, fn(v) { v * 10 }])", 20},
+		{"select([chan(), fn(v) { 1 }], [5, fn() { 2 }])", 2},
+		{"let a = chan(); closeChan(a); select([a, fn(v) { v }], [1000, fn() { 1 }])", nil},
+		{"select([chan(), 1], [0, fn(x) { x }])", "line 1: wrong number of arguments. got=0, want=1"},
+		{"select(1)", "line 1: case 0 of `select` must be [CHANNEL or INTEGER, FUNCTION], got 1"},
+		{"let c = atomic(0); let inc = fn() { atomicAdd(c, 1) }; let ts = [spawn(inc), spawn(inc), spawn(inc)]; wait(ts[0]); wait(ts[1]); wait(ts[2]); atomicAdd(c, 0)", 3},
+		{"let m = mutex(); lock(m); let t = spawn(fn() { lock(m); 1 }); unlock(m); wait(t)", 1},
+		{"unlock(mutex())", "line 1: unlock of unlocked mutex"},
+		{"await spawn(fn(x) { x * 2 }, 21)", 42},
+		{"let fs = all([spawn(fn() { 1 }), spawn(fn() { 2 })]); let r = await fs; r[0] + r[1]", 3},
+		{"await race([spawn(fn() { select([1000, fn() { 1 }]) }), spawn(fn() { 2 })])", 2},
+		{"await all([spawn(fn() { 1 }), spawn(fn() { 1 + true })])", "line 1: type mismatch: INTEGER + BOOL"},
+		{"race([])", "line 1: argument to `race` must not be empty"},
+		{"all([1])", "line 1: argument to `all` must be an ARRAY of FUTURE, got INTEGER at index 0"},
+		{"await 1", "line 1: cannot await INTEGER"},
+		{"let r = pmap([1, 2, 3, 4, 5], fn(x) { x * x }, 2); r[0] + r[1] + r[2] + r[3] + r[4]", 55},
+		{"len(pmap([], fn(x) { x }, 4))", 0},
+		{"pmap([1, true, 3], fn(x) { x + 1 }, 8)", "line 1: type mismatch: BOOL + INTEGER"},
+		{"let p = pool(2); submit(p, fn(x) { x }, 1); submit(p, fn(x, y) { x + y }, 2, 3); let r = drain(p); r[0] + r[1] + len(drain(p))", 6},
+		{"let p = pool(1); let n = atomic(0); let f = fn() { let k = atomicAdd(n, 1); select([5, fn() { atomicAdd(n, -1) }]); k }; submit(p, f); submit(p, f); submit(p, f); let r = drain(p); r[0] + r[1] + r[2]", 3},
+		{"let p = pool(2); let f = submit(p, fn() { 42 }); await f", 42},
+		{"let p = pool(2); submit(p, fn() { 1 }); submit(p, fn() { -true }); drain(p)", "line 1: unknown operator: -BOOL"},
+		{"pool(0)", "line 1: argument to `pool` must be a positive INTEGER, got 0"},
+		{"pmap([1], fn(x) { x }, 0)", "line 1: third argument to `pmap` must be a positive INTEGER, got 0"},
+		{"atomicAdd(atomic(1), true)", "line 1: second argument to `atomicAdd` must be INTEGER, got BOOL"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(object.Error)
+			if !ok || errObj.Err.Error() != expected {
+				t.Errorf("%q: expected error %q, got %v", tt.input, expected, evaluated)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	program := parser.New(lexer.New("recv(chan())")).ParseProgram()
+	var e Evaluator
+	errObj, ok := e.EvalContext(ctx, program, object.NewEnvironment()).(object.Error)
+	if !ok || !errors.Is(errObj.Err, context.DeadlineExceeded) {
+		t.Errorf("expected a blocked recv to stop at the deadline, got %v", errObj)
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let r = map([1, 2, 3], fn(x) { x * 10 }); r[0] + r[1] + r[2]", 60},
+		{"len(map([], fn(x) { x }))", 0},
+		{`let r = map(["a", "bc"], len); r[1]`, 2},
+		{"let r = filter([1, 2, 3, 4], fn(x) { x % 2 == 0 }); r[0] * 10 + r[1]", 24},
+		{"len(filter([1, null, false, 0], fn(x) { x }))", 2},
+		{"reduce([1, 2, 3, 4], fn(acc, x) { acc + x }, 0)", 10},
+		{"reduce([], fn(acc, x) { acc + x }, 5)", 5},
+		{"reduce(map(filter([1, 2, 3, 4, 5], fn(x) { x > 2 }), fn(x) { x * x }), fn(a, b) { a + b }, 0)", 50},
+		{"map([1, true], fn(x) { x + 1 })", "line 1: type mismatch: BOOL + INTEGER"},
+		{"reduce([1], fn(acc) { acc }, 0)", "line 1: wrong number of arguments. got=2, want=1"},
+		{"map(1, fn(x) { x })", "line 1: first argument to `map` must be ARRAY, got INTEGER"},
+		{"filter([1], 2)", "line 1: second argument to `filter` must be FUNCTION, got INTEGER"},
+		{"reduce([1], fn(a, b) { a })", "line 1: wrong number of arguments. got=2, want=3"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(object.Error)
+			if !ok || errObj.Err.Error() != expected {
+				t.Errorf("%q: expected error %q, got %v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
+func TestTimers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"sleep(1)", nil},
+		{"let ch = chan(1); after(5, fn() { send(ch, 7) }); recv(ch)", 7},
+		{"let ch = chan(1); let t = after(20, fn() { send(ch, 1) }); cancel(t); select([ch, fn(v) { v }], [60, fn() { 0 }])", 0},
+		{"let ch = chan(10); let t = every(1, fn() { send(ch, 1) }); let n = recv(ch) + recv(ch) + recv(ch); cancel(t); n", 3},
+		{"after(0, fn() { 1 })", "line 1: first argument to `after` must be a positive INTEGER, got 0"},
+		{"cancel(1)", "line 1: argument to `cancel` must be TIMER, got INTEGER"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(object.Error)
+			if !ok || errObj.Err.Error() != expected {
+				t.Errorf("%q: expected error %q, got %v", tt.input, expected, evaluated)
+			}
+		}
+	}
+
+	// Canceling the program's context stops its timers.
+	ctx, cancel := context.WithCancel(context.Background())
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New("let n = atomic(0); every(1, fn() { atomicAdd(n, 1) }); sleep(10)")).ParseProgram()
+	var e Evaluator
+	e.EvalContext(ctx, program, env)
+	cancel()
+	n, _ := env.Get("n")
+	before := n.(*object.Atomic).Add(0)
+	time.Sleep(20 * time.Millisecond)
+	if after := n.(*object.Atomic).Add(0); before == 0 || after > before+1 {
+		t.Errorf("expected the timer to run until canceled, got %d calls then %d", before, after)
+	}
+}
@@ -0,0 +1,94 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+)
+
+// ctxCheckInterval is the number of steps between checks of the context.
+const ctxCheckInterval = 1 << 10
+
+// EvalContext is like Eval but stops with an error wrapping ctx.Err() once ctx
+// is done.
+func (e *Evaluator) EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	prev := e.ctx
+	e.ctx = ctx
+	defer func() { e.ctx = prev }()
+	if err := ctx.Err(); err != nil {
+		return object.Error{Err: err}
+	}
+	return e.Eval(node, env)
+}
+
+// Reset clears the resources counted against e.Limits, so that e can be
+// reused for an unrelated evaluation.
+func (e *Evaluator) Reset() {
+	e.steps = 0
+	e.mem = 0
+	e.usage = nil
+}
+
+// share returns the Usage e counts its resources in from now on, so that
+// the tasks it spawns count theirs against the same limits.
+func (e *Evaluator) share() *sandbox.Usage {
+	if e.usage == nil {
+		e.usage = new(sandbox.Usage)
+		e.usage.Add(e.steps, e.mem)
+	}
+	return e.usage
+}
+
+// step is called before each statement is executed. It returns a non-nil
+// Error if evaluation must stop.
+func (e *Evaluator) step(stmt ast.Statement) object.Object {
+	if e.Trace != nil {
+		e.Trace(stmt)
+	}
+	return e.tick()
+}
+
+// tick counts a step against the limits and checks the context every
+// ctxCheckInterval steps. It returns a non-nil Error if evaluation must stop.
+func (e *Evaluator) tick() object.Object {
+	steps := e.steps + 1
+	if e.usage != nil {
+		steps = e.usage.Step()
+	} else {
+		e.steps = steps
+	}
+	if e.Limits.MaxSteps > 0 && steps > e.Limits.MaxSteps {
+		return object.Error{Err: sandbox.ErrStepLimit}
+	}
+	if e.ctx != nil && steps%ctxCheckInterval == 0 {
+		if err := e.ctx.Err(); err != nil {
+			return object.Error{Err: err}
+		}
+	}
+	return nil
+}
+
+// charge checks a newly allocated obj against the size limits and accounts
+// for the memory it uses, returning an Error in its place if a limit is
+// exceeded.
+func (e *Evaluator) charge(obj object.Object) object.Object {
+	if err := e.Limits.CheckSize(obj); err != nil {
+		return object.Error{Err: err}
+	}
+	if e.Limits.MaxMemory <= 0 {
+		return obj
+	}
+	n := sandbox.SizeOf(obj)
+	mem := e.mem + n
+	if e.usage != nil {
+		mem = e.usage.Alloc(n)
+	} else {
+		e.mem = mem
+	}
+	if mem > e.Limits.MaxMemory {
+		return object.Error{Err: sandbox.ErrMemoryLimit}
+	}
+	return obj
+}
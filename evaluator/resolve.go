@@ -0,0 +1,101 @@
+package evaluator
+
+import (
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/object"
+)
+
+// resolve returns the scope of the calls of fn, a function literal nested in
+// the functions with scopes outer, outermost first. Each identifier in fn's
+// body is resolved to the innermost of fn and the functions in outer which
+// binds its name, and the function literals in the body are resolved in
+// turn. Names which none of them bind are looked up by name in the
+// environment in which the outermost function was created, which is usually
+// the map-backed environment of the program. The keys of the hash literals
+// in the body are evaluated too, if they are all literals, and the scope is a
+// leaf if the body has no function literals.
+func resolve(fn *ast.FunctionLiteral, outer []*object.Scope) *object.Scope {
+	names := make([]string, 0, len(fn.Parameters))
+	for _, p := range fn.Parameters {
+		names = append(names, p.Value)
+	}
+	bound := func(name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	idents := 0
+	inspectBody(fn, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.Identifier:
+			idents++
+		case *ast.LetStatement:
+			if !bound(n.Name.Value) {
+				names = append(names, n.Name.Value)
+			}
+		case *ast.ForExpression:
+			for _, v := range []*ast.Identifier{n.Key, n.Value} {
+				if v != nil && !bound(v.Value) {
+					names = append(names, v.Value)
+				}
+			}
+		}
+	})
+
+	s := object.NewScope(names)
+	s.Refs = make(map[*ast.Identifier]object.Ref, idents)
+	chain := append(outer[:len(outer):len(outer)], s)
+	inspectBody(fn, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.Identifier:
+			s.Refs[n] = lookup(chain, n.Value)
+		case *ast.FunctionLiteral:
+			if s.Funcs == nil {
+				s.Funcs = map[*ast.FunctionLiteral]*object.Scope{}
+			}
+			s.Funcs[n] = resolve(n, chain)
+		case *ast.HashLiteral:
+			if l := object.NewHashLayout(n); l != nil {
+				if s.Hashes == nil {
+					s.Hashes = map[*ast.HashLiteral]*object.HashLayout{}
+				}
+				s.Hashes[n] = l
+			}
+		}
+	})
+	s.Leaf = s.Funcs == nil
+	return s
+}
+
+// lookup returns the Ref of name in the innermost function of chain.
+func lookup(chain []*object.Scope, name string) object.Ref {
+	for depth := 0; depth < len(chain); depth++ {
+		if i, ok := chain[len(chain)-1-depth].Index(name); ok {
+			return object.Ref{Depth: depth, Index: i}
+		}
+	}
+	return object.Ref{Depth: len(chain), Index: -1}
+}
+
+// inspectBody calls f with each node in the body of fn, including the
+// function literals in it but not their parameters or bodies.
+func inspectBody(fn *ast.FunctionLiteral, f func(ast.Node)) {
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		f(n)
+		_, nested := n.(*ast.FunctionLiteral)
+		return !nested
+	})
+}
+
+// scopeOf returns the scope of the function literal node evaluated in env.
+func scopeOf(node *ast.FunctionLiteral, env *object.Environment) *object.Scope {
+	if s := env.Scope(); s != nil {
+		if fs, ok := s.Funcs[node]; ok {
+			return fs
+		}
+	}
+	return resolve(node, nil)
+}
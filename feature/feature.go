@@ -0,0 +1,134 @@
+// Package feature defines the optional features of the language and the
+// editions which group them, so that syntax beyond classic Monkey, the
+// language of the book, can be added without breaking classic programs
+// which use its keywords as names.
+//
+// The lexer and parser accept a program's features: with a feature off,
+// its keywords are ordinary identifiers and its other syntax is a parse
+// error naming the feature.
+package feature
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ajwerner/monkey/token"
+)
+
+// Feature is a set of optional features of the language.
+type Feature uint
+
+const (
+	// Types enables type annotations and protocol statements, and the
+	// keywords protocol, is and as.
+	Types Feature = 1 << iota
+	// Import enables import expressions and the keyword import.
+	Import
+	// Await enables await expressions and the keyword await.
+	Await
+	// Loops enables while and for loops and the keywords while, for, in,
+	// break and continue.
+	Loops
+	// Assignment enables assignment expressions, x = value.
+	Assignment
+	// Division enables floor division, ~/, and the remainder operator, %,
+	// and makes / divide integers exactly, evaluating to a float. Without
+	// it / truncates the quotient of integers, as in the book.
+	Division
+
+	// Classic is the language of the book, without optional features.
+	Classic Feature = 0
+	// Extended enables every feature. It is the default, and grows as
+	// features are added.
+	Extended = Types | Import | Await | Loops | Assignment | Division
+)
+
+// names are the names of the single features, as accepted by Parse.
+var names = []struct {
+	f    Feature
+	name string
+}{
+	{Types, "types"},
+	{Import, "import"},
+	{Await, "await"},
+	{Loops, "loops"},
+	{Assignment, "assignment"},
+	{Division, "division"},
+}
+
+// keywords maps the keywords of optional features to the feature which
+// makes them keywords.
+var keywords = map[token.TokenType]Feature{
+	token.PROTOCOL: Types,
+	token.IS:       Types,
+	token.AS:       Types,
+	token.IMPORT:   Import,
+	token.AWAIT:    Await,
+	token.WHILE:    Loops,
+	token.FOR:      Loops,
+	token.IN:       Loops,
+	token.BREAK:    Loops,
+	token.CONTINUE: Loops,
+}
+
+// Has reports whether f includes every feature of g.
+func (f Feature) Has(g Feature) bool { return f&g == g }
+
+// String returns "classic", "extended", or the names of the features of
+// f separated by commas, which Parse accepts.
+func (f Feature) String() string {
+	switch f {
+	case Classic:
+		return "classic"
+	case Extended:
+		return "extended"
+	}
+	var parts []string
+	for _, n := range names {
+		if f&n.f != 0 {
+			parts = append(parts, n.name)
+			f &^= n.f
+		}
+	}
+	if f != 0 {
+		parts = append(parts, fmt.Sprintf("Feature(%#x)", uint(f)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse returns the features named by s, a list separated by commas of
+// editions, "classic" or "extended", and names of single features, such as
+// "classic,import".
+func Parse(s string) (Feature, error) {
+	var f Feature
+	for _, name := range strings.Split(s, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "classic":
+			continue
+		case "extended":
+			f |= Extended
+			continue
+		}
+		g, ok := lookup(name)
+		if !ok {
+			return 0, fmt.Errorf("unknown language feature %q", name)
+		}
+		f |= g
+	}
+	return f, nil
+}
+
+func lookup(name string) (Feature, bool) {
+	for _, n := range names {
+		if n.name == name {
+			return n.f, true
+		}
+	}
+	return 0, false
+}
+
+// Keyword returns the feature which makes tok a keyword, or Classic if tok
+// is a keyword of classic Monkey or no keyword.
+func Keyword(tok token.TokenType) Feature {
+	return keywords[tok]
+}
@@ -0,0 +1,33 @@
+package feature
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Feature
+		str   string
+	}{
+		{"classic", Classic, "classic"},
+		{"extended", Extended, "extended"},
+		{"classic,import", Import, "import"},
+		{"types, await", Types | Await, "types,await"},
+		{"import,extended", Extended, "extended"},
+		{"classic,division,loops", Loops | Division, "loops,division"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v; expected %v", tt.input, got, err, tt.want)
+		}
+		if s := got.String(); s != tt.str {
+			t.Errorf("%q: String() = %q, expected %q", tt.input, s, tt.str)
+		}
+		if again, err := Parse(got.String()); err != nil || again != got {
+			t.Errorf("%q: Parse(String()) = %v, %v", tt.input, again, err)
+		}
+	}
+	if _, err := Parse("classic,macros"); err == nil || err.Error() != `unknown language feature "macros"` {
+		t.Errorf("expected an unknown feature error, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/token"
+)
+
+// fuzzSeeds are programs exercising each part of the language, from which
+// the fuzzers start.
+var fuzzSeeds = []string{
+	"let x = 5; x * (2 + 3) ~/ 2 - -1",
+	"1 / 0; 1 ~/ 0; 1 % 0; 1.5 / 0",
+	`let add = fn(a, b) { a + b }; add(1); add(1, 2, 3); add("a", "b")`,
+	`let h = {"a": [1, 2.5, true, null]}; h["a"][3]; h[[1]]; [1, 2][-1]`,
+	`if (len("abc") > 2) { first([1]) } else { rest([]) }`,
+	"let f = fn(n) { if (n < 1) { 0 } else { f(n - 1) } }; f(10)",
+	`let m = memo(fn(x) { x }); m(1); push([], m)`,
+	"fn(x) { return x; }(1)",
+	`"a" + 1; -true; !"b"; 1 is int; "a" as int`,
+	"((((((((((1))))))))))",
+	"[[[[[[[[[[1]]]]]]]]]]",
+	`{} == {}; {"a": 1} != {"a": 1}; [1] == [1]`,
+	"let p = fn(f: fn(int) -> int, x: int) -> int { f(x) }; p(fn(y) { y }, 1)",
+	"let c = chan(1); send(c, 1); recv(c); closeChan(c); wait(spawn(fn(x) { x * 2 }, 21))",
+	"let p = pool(2); submit(p, fn() { 1 }); drain(p); let t = every(1, fn() { 1 }); cancel(t)",
+	`let a = atomic(0); atomicAdd(a, 1); let m = mutex(); lock(m); unlock(m); await all([spawn(fn() { 1 })])`,
+	`map([1, 2], fn(x) { x }); reduce(split("a,b", ","), fn(a, b) { a + b }, ""); int("42")`,
+}
+
+// fuzzInterpreter returns an interpreter for untrusted fuzz input: with
+// small limits and every builtin but those doing I/O. Builtins which block,
+// such as recv, are stopped by the context of checkNoPanic.
+func fuzzInterpreter(t *testing.T, engine Engine) *Interpreter {
+	return newInterpreter(t,
+		WithEngine(engine),
+		WithLimits(sandbox.Limits{MaxSteps: 10000, MaxDepth: 100, MaxMemory: 1 << 20, MaxStringLen: 1 << 12, MaxArrayLen: 1 << 10}),
+		WithOutput(io.Discard),
+		WithoutCapabilities(object.CapIO),
+		WithForbiddenSyntax(token.IMPORT),
+	)
+}
+
+// checkNoPanic fails if evaluating src with engine panics, which Eval
+// reports as an InternalError, as it does a panic in a task src spawned.
+func checkNoPanic(t *testing.T, engine Engine, src string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := fuzzInterpreter(t, engine).EvalContext(ctx, src)
+	var ie *InternalError
+	if errors.As(err, &ie) {
+		t.Fatalf("%v: %q: %v\n%s", engine, src, ie, ie.Stack)
+	}
+}
+
+func FuzzEval(f *testing.F) {
+	for _, src := range fuzzSeeds {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		checkNoPanic(t, EngineEval, src)
+	})
+}
+
+func FuzzVM(f *testing.F) {
+	for _, src := range fuzzSeeds {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		checkNoPanic(t, EngineVM, src)
+	})
+}
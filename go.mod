@@ -1 +1,3 @@
 module github.com/ajwerner/monkey
+
+go 1.23
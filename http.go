@@ -0,0 +1,174 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/object"
+)
+
+// httpModule builds the "http" module. Its serve builtin listens on an
+// address and calls a monkey handler function for each request:
+//
+//	let http = import "http";
+//	http.serve(":8080", fn(req) {
+//	  {"status": 200, "headers": {"Content-Type": "text/plain"}, "body": "hi " + req.path}
+//	});
+//
+// A request is a hash with the keys method, path, query, headers and body,
+// where query and headers map names to their first value. A handler returns
+// a hash with the optional keys status, headers and body, or just the body.
+// Requests are served concurrently, each on an evaluator from a pool
+// configured like the interpreter's, with its own resource limits. Each
+// request calls its own copy of the handler, so variables a handler assigns
+// are not seen by other requests. serve returns once the program's context
+// is done, after shutting the server down.
+func (in *Interpreter) httpModule() *object.Builtins {
+	b := &object.Builtins{}
+	b.RegisterContext("serve", object.CapNetwork, func(ctx object.BuiltinContext, args ...object.Object) object.Object {
+		if len(args) != 2 {
+			return errorf("wrong number of arguments. got=%d, want=2", len(args))
+		}
+		addr, ok := args[0].(object.String)
+		if !ok {
+			return errorf("argument to `serve` must be STRING, got %s", args[0].Type())
+		}
+		handler, err := in.httpHandler(args[1])
+		if err != nil {
+			return object.Error{Err: err}
+		}
+		ln, err := net.Listen("tcp", string(addr))
+		if err != nil {
+			return object.Error{Err: err}
+		}
+		return object.Error{Err: serveHTTP(ctx.Context(), ln, handler)}
+	})
+	return b
+}
+
+// serveHTTP serves handler on ln until ctx is done, when it shuts the server
+// down, canceling the requests in progress, and returns ctx.Err().
+func serveHTTP(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	stop := context.AfterFunc(ctx, func() { srv.Shutdown(context.Background()) })
+	defer stop()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return ctx.Err()
+}
+
+// maxHTTPBody is the size of the largest request body a handler receives,
+// unless the interpreter's MaxStringLen is smaller.
+const maxHTTPBody = 10 << 20
+
+// httpHandler returns an http.Handler which calls fn, a monkey function of
+// one argument, on evaluators configured like the interpreter's. Each
+// request calls a copy of fn made by object.Isolate, as spawn does, since
+// requests run concurrently.
+func (in *Interpreter) httpHandler(fn object.Object) (http.Handler, error) {
+	if fn.Type() != object.FUNCTION {
+		return nil, fmt.Errorf("handler must be FUNCTION, got %s", fn.Type())
+	}
+	// Isolating fn now forces the thunks it reaches, so that the copies
+	// made for requests only read it.
+	fn = object.Isolate(fn)
+	evaluators := sync.Pool{New: func() interface{} { return in.newEvaluator() }}
+	maxBody := int64(maxHTTPBody)
+	if n := in.eval.Limits.MaxStringLen; n > 0 && int64(n) < maxBody {
+		maxBody = int64(n)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := httpRequest(http.MaxBytesReader(w, r.Body, maxBody), r)
+		if err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		e := evaluators.Get().(*evaluator.Evaluator)
+		e.Reset()
+		resp := e.ApplyContext(r.Context(), object.Isolate(fn), req)
+		evaluators.Put(e)
+		writeHTTPResponse(w, resp)
+	}), nil
+}
+
+// httpRequest converts r, whose body is read from body, to the hash passed
+// to handlers.
+func httpRequest(body io.Reader, r *http.Request) (object.Hash, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return object.Hash{}, err
+	}
+	req := object.NewHash(5)
+	req.Set(object.String("method"), object.String(r.Method))
+	req.Set(object.String("path"), object.String(r.URL.Path))
+	req.Set(object.String("query"), firstValues(r.URL.Query()))
+	req.Set(object.String("headers"), firstValues(r.Header))
+	req.Set(object.String("body"), object.String(data))
+	return req, nil
+}
+
+// firstValues returns a hash from each name in m, in sorted order, to its
+// first value.
+func firstValues(m map[string][]string) object.Hash {
+	h := object.NewHash(len(m))
+	for _, name := range slices.Sorted(maps.Keys(m)) {
+		h.Set(object.String(name), object.String(m[name][0]))
+	}
+	return h
+}
+
+// writeHTTPResponse writes the value returned by a handler to w.
+func writeHTTPResponse(w http.ResponseWriter, resp object.Object) {
+	status := http.StatusOK
+	var body object.Object = resp
+	switch resp := resp.(type) {
+	case object.Error:
+		http.Error(w, resp.Err.Error(), http.StatusInternalServerError)
+		return
+	case object.Hash:
+		if s, ok := hashField(resp, "status").(object.Integer); ok {
+			if s < 100 || s > 999 {
+				http.Error(w, fmt.Sprintf("invalid status %d", s), http.StatusInternalServerError)
+				return
+			}
+			status = int(s)
+		}
+		if headers, ok := hashField(resp, "headers").(object.Hash); ok {
+			for name, value := range headers.All() {
+				w.Header().Set(name.Inspect(), value.Inspect())
+			}
+		}
+		body = hashField(resp, "body")
+	}
+	w.WriteHeader(status)
+	if body != nil && body.Type() != object.NULL {
+		io.WriteString(w, body.Inspect())
+	}
+}
+
+// hashField returns the value of the key name in h, or nil if it has none.
+func hashField(h object.Hash, name string) object.Object {
+	v, _ := h.Get(object.String(name))
+	return v
+}
+
+func errorf(format string, a ...interface{}) object.Error {
+	return object.Error{Err: fmt.Errorf(format, a...)}
+}
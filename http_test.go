@@ -0,0 +1,156 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	interp := newInterpreter(t)
+	tests := []struct {
+		handler string
+		request *http.Request
+		status  int
+		header  string
+		body    string
+	}{
+		{
+			`let greeting = "hello"; fn(req) {
+			  {"status": 201, "headers": {"X-Greeting": greeting}, "body": greeting + " " + req.query.name + " " + req.body}
+			}`,
+			httptest.NewRequest("POST", "/greet?name=monkey", strings.NewReader("!")),
+			http.StatusCreated, "hello", "hello monkey !",
+		},
+		{
+			`fn(req) { req.method + " " + req.path }`,
+			httptest.NewRequest("GET", "/a/b", nil),
+			http.StatusOK, "", "GET /a/b",
+		},
+		{
+			`fn(req) { 1 + true }`,
+			httptest.NewRequest("GET", "/", nil),
+			http.StatusInternalServerError, "", "line 1: type mismatch: INTEGER + BOOL\n",
+		},
+		{
+			`fn(a, b) { a }`,
+			httptest.NewRequest("GET", "/", nil),
+			http.StatusInternalServerError, "", "wrong number of arguments. got=1, want=2\n",
+		},
+		{
+			`fn(req) { {"status": 0} }`,
+			httptest.NewRequest("GET", "/", nil),
+			http.StatusInternalServerError, "", "invalid status 0\n",
+		},
+		{
+			`fn(req) { {"status": 1000} }`,
+			httptest.NewRequest("GET", "/", nil),
+			http.StatusInternalServerError, "", "invalid status 1000\n",
+		},
+	}
+	for _, tt := range tests {
+		fn, err := interp.Eval(tt.handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler, err := interp.httpHandler(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, tt.request)
+		body, _ := io.ReadAll(rec.Body)
+		if rec.Code != tt.status || rec.Header().Get("X-Greeting") != tt.header || string(body) != tt.body {
+			t.Errorf("%s: wrong response %d %q %q", tt.handler, rec.Code, rec.Header().Get("X-Greeting"), body)
+		}
+	}
+
+	if _, err := interp.httpHandler(object.Integer(1)); err == nil {
+		t.Error("expected error for a non-function handler")
+	}
+}
+
+func TestHTTPHandlerIsolatesRequests(t *testing.T) {
+	interp := newInterpreter(t, WithTruthiness(object.ExtendedTruthiness), WithStrictIndex())
+	fn, err := interp.Eval(`let n = 0; fn(req) { n = n + 1; if (0) { "truthy" } else { str(n) } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := interp.httpHandler(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if body := rec.Body.String(); body != "1" {
+				t.Errorf("expected each request to see n = 0, got %q", body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fn, err = interp.Eval(`fn(req) { req.headers["X-Missing"] }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err = interp.httpHandler(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected strict indexing to fail the request, got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestHTTPHandlerLimitsBody(t *testing.T) {
+	interp := newInterpreter(t, WithLimits(sandbox.Limits{MaxStringLen: 4}))
+	fn, err := interp.Eval(`fn(req) { req.body }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := interp.httpHandler(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for body, status := range map[string]int{"abcd": http.StatusOK, "abcde": http.StatusRequestEntityTooLarge} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		if rec.Code != status {
+			t.Errorf("%q: expected status %d, got %d %q", body, status, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestHTTPServe(t *testing.T) {
+	_, err := newInterpreter(t).Eval(`import "http".serve("no port", fn(req) { "" })`)
+	if err == nil || !strings.Contains(err.Error(), "missing port in address") {
+		t.Errorf("expected listen error, got %v", err)
+	}
+	result, err := newInterpreter(t, WithoutCapabilities(object.CapNetwork)).Eval(`import "http".serve`)
+	if err != nil || result != (object.Null{}) {
+		t.Errorf("expected serve to be removed, got %v, %v", result, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = newInterpreter(t).EvalContext(ctx, `import "http".serve("127.0.0.1:0", fn(req) { "" })`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected serve to stop when the context is done, got %v", err)
+	}
+}
@@ -2,10 +2,16 @@
 package lexer
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 
+	"github.com/ajwerner/monkey/feature"
+	"github.com/ajwerner/monkey/monkeyerr"
 	"github.com/ajwerner/monkey/token"
 )
 
@@ -24,6 +30,34 @@ func New(input string) *Lexer {
 	return &l
 }
 
+// NewWithFeatures creates a new Lexer for an input string in which the
+// keywords of the features missing from f are lexed as identifiers.
+func NewWithFeatures(input string, f feature.Feature) *Lexer {
+	l := New(input)
+	l.disabled = feature.Extended &^ f
+	return l
+}
+
+// NewJSON creates a new Lexer for JSON text, whose strings may also use the
+// escape sequences \/, \b and \f and write runes outside the Basic
+// Multilingual Plane as a pair of \u escapes of UTF-16 surrogates.
+func NewJSON(input string) *Lexer {
+	l := New(input)
+	l.json = true
+	return l
+}
+
+// Features returns the features of the language the lexer accepts.
+func (l *Lexer) Features() feature.Feature {
+	return feature.Extended &^ l.disabled
+}
+
+// Reserved returns the identifiers lexed so far which would be keywords of
+// features the lexer does not accept, in source order.
+func (l *Lexer) Reserved() []token.Token {
+	return l.reserved
+}
+
 var zeroToken = token.Token{}
 
 func (l *Lexer) Next() bool {
@@ -37,10 +71,59 @@ func (l *Lexer) Token() token.Token {
 	return l.cur
 }
 
+// Err returns the error which stopped the lexer, if any, as an *Error.
 func (l *Lexer) Err() error {
 	return l.err
 }
 
+// Error is an error encountered while lexing, located at the start of the
+// token being lexed.
+type Error struct {
+	Line   int // 1-based line at which the error occurred
+	Column int // 1-based column, in runes, at which the error occurred
+	Offset int // 0-based byte offset at which the error occurred
+	Msg    string
+
+	// Incomplete reports that the input ended within the token, such as an
+	// unterminated string, so that more input may complete it.
+	Incomplete bool
+}
+
+// errUnterminatedString is the error of a string which the input ends
+// within.
+var errUnterminatedString = errors.New("unterminated string")
+
+// Unwrap returns io.ErrUnexpectedEOF if e is Incomplete.
+func (e *Error) Unwrap() error {
+	if e.Incomplete {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// Diagnostic implements monkeyerr.Diagnoser.
+func (e *Error) Diagnostic() monkeyerr.Diagnostic {
+	pos := monkeyerr.Position{Line: e.Line, Column: e.Column, Offset: e.Offset}
+	return monkeyerr.Diagnostic{Phase: monkeyerr.PhaseLex, Pos: pos, Msg: e.Msg, Err: e}
+}
+
+// Comments returns the COMMENT tokens skipped so far, in source order. Each
+// literal includes the leading "//", or the "/*" and "*/" of a block
+// comment.
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // lexFuncs
 ////////////////////////////////////////////////////////////////////////////////
@@ -50,12 +133,23 @@ type lexFunc func(l *state) (cur token.Token, err error)
 func lexNext(s *state) (token.Token, error) {
 	next, err := s.skipWhitespace()
 	if err != nil {
-		return token.Token{}, err
+		var lexErr *Error
+		if !errors.As(err, &lexErr) {
+			lexErr = &Error{Line: s.line, Column: s.col, Offset: s.readPos, Msg: err.Error()}
+		}
+		return token.Token{}, lexErr
 	}
-	if f := lexFuncs[next]; f != nil {
-		return f(s)
+	f := lexFuncs[next]
+	if f == nil {
+		f = lexDefault
+	}
+	tok, err := f(s)
+	if err != nil {
+		return token.Token{}, &Error{Line: s.tokLine, Column: s.tokCol, Offset: s.tokPos, Msg: err.Error(),
+			Incomplete: err == errUnterminatedString}
 	}
-	return lexDefault(s)
+	tok.Line, tok.Column, tok.Offset = s.tokLine, s.tokCol, s.tokPos
+	return tok, nil
 }
 
 func lexDefault(s *state) (token.Token, error) {
@@ -63,10 +157,14 @@ func lexDefault(s *state) (token.Token, error) {
 	if isLetter(next) {
 		return lexIdentifier(s)
 	}
+	if next == '.' && !s.decimalAfterPeek() {
+		return dot(s)
+	}
 	if next == '.' || isDecimal(next) {
 		return lexNumber(s)
 	}
-	return token.Token{}, fmt.Errorf("Illegal token %q at position %d", s.curLit(), s.tokPos)
+	s.readRune()
+	return token.Token{}, fmt.Errorf("Illegal token %q", s.curLit())
 }
 
 func nextTok(typ token.TokenType) lexFunc {
@@ -87,19 +185,65 @@ func eat(f lexFunc) lexFunc {
 }
 
 var (
+	arrow  = nextTok(token.ARROW)
 	assign = litTok(token.ASSIGN)
 	bang   = litTok(token.BANG)
+	dot    = nextTok(token.DOT)
 	eq     = nextTok(token.EQ)
+	floor  = nextTok(token.FLOORSLASH)
+	gt     = litTok(token.GT)
+	gte    = nextTok(token.GTE)
+	lt     = litTok(token.LT)
+	lte    = nextTok(token.LTE)
+	minus  = litTok(token.MINUS)
 	neq    = nextTok(token.NEQ)
 )
 
 var lexFuncs = map[rune]lexFunc{
 	'+': nextTok(token.PLUS),
-	'-': nextTok(token.MINUS),
+	'-': func(s *state) (token.Token, error) {
+		next, err := s.readRune()
+		if err != nil {
+			return token.Token{}, err
+		}
+		if next == '>' {
+			return arrow(s)
+		}
+		return minus(s)
+	},
 	'/': nextTok(token.SLASH),
+	'~': func(s *state) (token.Token, error) {
+		next, err := s.readRune()
+		if err != nil {
+			return token.Token{}, err
+		}
+		if next != '/' {
+			return token.Token{}, fmt.Errorf("Illegal token %q", s.curLit())
+		}
+		return floor(s)
+	},
 	'*': nextTok(token.STAR),
-	'<': nextTok(token.LT),
-	'>': nextTok(token.GT),
+	'%': nextTok(token.PERCENT),
+	'<': func(s *state) (token.Token, error) {
+		next, err := s.readRune()
+		if err != nil {
+			return token.Token{}, err
+		}
+		if next == '=' {
+			return lte(s)
+		}
+		return lt(s)
+	},
+	'>': func(s *state) (token.Token, error) {
+		next, err := s.readRune()
+		if err != nil {
+			return token.Token{}, err
+		}
+		if next == '=' {
+			return gte(s)
+		}
+		return gt(s)
+	},
 	';': nextTok(token.SEMICOLON),
 	',': nextTok(token.COMMA),
 	'(': nextTok(token.LPAREN),
@@ -144,7 +288,7 @@ func lexNumber(s *state) (token.Token, error) {
 			return token.Token{}, err
 		}
 		if !isDecimal(next) {
-			return token.Token{}, fmt.Errorf("Illegal character '%c' after . at position %d", next, s.readPos)
+			return token.Token{}, fmt.Errorf("Illegal character '%c' after .", next)
 		}
 		if next, err = s.readDecimals(); err != nil {
 			return token.Token{}, err
@@ -170,40 +314,159 @@ func lexNumber(s *state) (token.Token, error) {
 	}, nil
 }
 
+// lexString lexes a string literal, decoding its escape sequences: \n, \t,
+// \r, \\, \" and \u followed by four hexadecimal digits.
 func lexString(s *state) (token.Token, error) {
+	// decoded holds the literal read so far once it has an escape sequence;
+	// until then the literal is a slice of the input.
+	var decoded *strings.Builder
+	next, err := s.readRune()
 	for {
-		next, err := s.readRune()
 		if err != nil {
 			return token.Token{}, err
 		}
-		if next == 0 {
-			return token.Token{}, fmt.Errorf("unterminated string at position %d:%d", s.tokPos, s.readPos)
-		}
-		if next == '"' {
+		switch next {
+		case 0:
+			return token.Token{}, errUnterminatedString
+		case '"':
+			lit := s.input[s.tokPos+1 : s.readPos]
+			if decoded != nil {
+				lit = decoded.String()
+			}
 			if _, err = s.readRune(); err != nil {
 				return token.Token{}, err
 			}
-			break
+			return token.Token{Type: token.STRING, Literal: s.intern(lit)}, nil
+		case '\\':
+			if decoded == nil {
+				decoded = new(strings.Builder)
+				decoded.WriteString(s.input[s.tokPos+1 : s.readPos])
+			}
+			var r rune
+			if r, next, err = lexEscape(s); err != nil {
+				return token.Token{}, err
+			}
+			decoded.WriteRune(r)
+		default:
+			if decoded != nil {
+				decoded.WriteRune(next)
+			}
+			next, err = s.readRune()
 		}
 	}
-	return token.Token{
-		Type:    token.STRING,
-		Literal: s.input[s.tokPos+1 : s.runePos],
-	}, nil
+}
+
+// escapes maps the runes which may follow a backslash in a string literal,
+// other than u, to the runes they stand for.
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'\\': '\\',
+	'"':  '"',
+}
+
+// jsonEscapes maps the runes which may also follow a backslash in JSON
+// text to the runes they stand for.
+var jsonEscapes = map[rune]rune{
+	'/': '/',
+	'b': '\b',
+	'f': '\f',
+}
+
+// lexEscape consumes the escape sequence starting at the peeked backslash,
+// returning the rune it stands for and the rune after it.
+func lexEscape(s *state) (r rune, next rune, err error) {
+	c, err := s.readRune()
+	if err != nil {
+		return 0, 0, err
+	}
+	if c == 0 {
+		return 0, 0, errUnterminatedString
+	}
+	r, ok := escapes[c]
+	if !ok && s.json {
+		r, ok = jsonEscapes[c]
+	}
+	if ok {
+		next, err = s.readRune()
+		return r, next, err
+	}
+	if c != 'u' {
+		return 0, 0, fmt.Errorf("invalid escape sequence \\%c in string", c)
+	}
+	start := s.readPos - 1
+	if r, next, err = lexUnicodeEscape(s, start); err != nil {
+		return 0, 0, err
+	}
+	if s.json && utf16.IsSurrogate(r) {
+		if next != '\\' {
+			return 0, 0, fmt.Errorf("invalid escape sequence %s in string: a surrogate must be followed by another", s.input[start:s.readPos])
+		}
+		if c, err = s.readRune(); err != nil || c != 'u' {
+			return 0, 0, fmt.Errorf("invalid escape sequence %s in string: a surrogate must be followed by another", s.input[start:s.readPos])
+		}
+		var low rune
+		if low, next, err = lexUnicodeEscape(s, start); err != nil {
+			return 0, 0, err
+		}
+		if r = utf16.DecodeRune(r, low); r == unicode.ReplacementChar {
+			return 0, 0, fmt.Errorf("invalid escape sequence %s in string: not a surrogate pair", s.input[start:s.readPos])
+		}
+		return r, next, nil
+	}
+	if !utf8.ValidRune(r) {
+		return 0, 0, fmt.Errorf("invalid escape sequence %s in string: not a Unicode code point", s.input[start:s.readPos])
+	}
+	return r, next, err
+}
+
+// lexUnicodeEscape consumes the four hexadecimal digits after the u of an
+// escape sequence starting at start, returning the rune they encode and the
+// rune after them.
+func lexUnicodeEscape(s *state, start int) (r rune, next rune, err error) {
+	next, err = s.readRune()
+	for i := 0; i < 4; i++ {
+		d, ok := hexValue(next)
+		if err != nil || !ok {
+			return 0, 0, fmt.Errorf("invalid escape sequence %s in string: \\u must be followed by four hexadecimal digits", s.input[start:s.readPos])
+		}
+		r = r<<4 | d
+		next, err = s.readRune()
+	}
+	return r, next, err
+}
+
+func hexValue(r rune) (rune, bool) {
+	switch {
+	case '0' <= r && r <= '9':
+		return r - '0', true
+	case 'a' <= r && r <= 'f':
+		return r - 'a' + 10, true
+	case 'A' <= r && r <= 'F':
+		return r - 'A' + 10, true
+	}
+	return 0, false
 }
 
 func lexIdentifier(s *state) (token.Token, error) {
 	next, err := s.peek()
-	for err == nil && isLetter(next) {
+	for err == nil && (isLetter(next) || isDecimal(next)) {
 		next, err = s.readRune()
 	}
 	if err != nil {
 		return token.Token{}, err
 	}
-	return token.Token{
-		Type:    token.LookupIdent(s.curLit()),
-		Literal: s.curLit(),
-	}, nil
+	lit := s.curLit()
+	typ := token.LookupIdent(lit)
+	if f := feature.Keyword(typ); f != 0 && s.disabled&f != 0 {
+		typ = token.IDENT
+		s.reserved = append(s.reserved, token.Token{Type: typ, Literal: lit, Line: s.tokLine})
+	}
+	if typ == token.IDENT {
+		lit = s.intern(lit)
+	}
+	return token.Token{Type: typ, Literal: lit}, nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -211,8 +474,14 @@ func lexIdentifier(s *state) (token.Token, error) {
 ////////////////////////////////////////////////////////////////////////////////
 
 type state struct {
-	input  string
-	tokPos int
+	input   string
+	tokPos  int
+	tokLine int
+	tokCol  int
+
+	// line and col locate readPos, col counting runes from 1.
+	line int
+	col  int
 
 	rune     rune
 	runeSize int
@@ -223,24 +492,70 @@ type state struct {
 	peekSize int
 
 	readPos int
+
+	comments []token.Token
+
+	// disabled holds the features whose keywords are identifiers, so that
+	// the zero value accepts every feature; reserved records those
+	// identifiers.
+	disabled feature.Feature
+	reserved []token.Token
+
+	interned map[string]string // see intern
+
+	json bool // see NewJSON
 }
 
 func initState(s *state, input string) {
 	*s = state{
 		input: input,
+		line:  1,
+		col:   1,
 	}
 }
 
+// skipWhitespace consumes whitespace and comments, recording the comments.
+// An unterminated block comment is an *Error located at its start.
 func (s *state) skipWhitespace() (next rune, err error) {
-	next, err = s.readWhitespace()
-	if err == nil {
+	for {
+		if next, err = s.readWhitespace(); err != nil {
+			return next, err
+		}
 		s.reset()
+		rest := s.input[s.readPos:]
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			for err == nil && next != '\n' && next != 0 {
+				next, err = s.readRune()
+			}
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[len("/*"):], "*/")
+			if end < 0 {
+				return next, &Error{Line: s.tokLine, Column: s.tokCol, Offset: s.tokPos, Msg: "unterminated block comment", Incomplete: true}
+			}
+			for end := s.readPos + len("/*") + end + len("*/"); err == nil && s.readPos < end; {
+				next, err = s.readRune()
+			}
+		default:
+			return next, nil
+		}
+		if err != nil {
+			return next, err
+		}
+		s.comments = append(s.comments, token.Token{
+			Type:    token.COMMENT,
+			Literal: s.curLit(),
+			Line:    s.tokLine,
+			Column:  s.tokCol,
+			Offset:  s.tokPos,
+		})
 	}
-	return next, err
 }
 
 func (s *state) reset() {
 	s.tokPos = s.readPos
+	s.tokLine = s.line
+	s.tokCol = s.col
 	s.runePos = s.readPos
 	s.runeSize = 0
 	s.rune = 0
@@ -272,7 +587,7 @@ func (s *state) peek() (rune, error) {
 	s.peekRune, s.peekSize = utf8.DecodeRuneInString(s.input[s.readPos:])
 	s.peeked = true
 	if s.peekRune == utf8.RuneError {
-		return utf8.RuneError, fmt.Errorf("failed to decode from utf8 at position %d", s.readPos)
+		return utf8.RuneError, errors.New("failed to decode from utf8")
 	}
 	return s.peekRune, nil
 }
@@ -284,6 +599,11 @@ func (s *state) readRune() (rune, error) {
 		return p, err
 	}
 	s.rune = s.peekRune
+	if s.rune == '\n' {
+		s.line, s.col = s.line+1, 1
+	} else {
+		s.col++
+	}
 	s.runePos = s.readPos
 	s.readPos += s.peekSize
 	s.runeSize = s.peekSize
@@ -293,6 +613,12 @@ func (s *state) readRune() (rune, error) {
 	return s.peek()
 }
 
+// decimalAfterPeek reports whether the rune following the single-byte peeked
+// rune is a decimal digit, distinguishing ".5" from "x.y".
+func (s *state) decimalAfterPeek() bool {
+	return s.readPos+1 < len(s.input) && isDecimal(rune(s.input[s.readPos+1]))
+}
+
 func (s *state) curLit() string {
 	return s.input[s.tokPos:s.readPos]
 }
@@ -301,6 +627,29 @@ func (s *state) curLit() string {
 // helpers
 ////////////////////////////////////////////////////////////////////////////////
 
+// maxInterned is the length of the longest string literal which is interned.
+// Longer literals are rarely used as hash keys or compared.
+const maxInterned = 64
+
+// intern returns the first occurrence in the input of lit if it is short
+// enough to be an identifier or hash key. Equal identifiers and string
+// literals then share their bytes, so that environment lookups and hash key
+// comparisons, which check for identical pointers before comparing bytes,
+// rarely compare bytes.
+func (s *state) intern(lit string) string {
+	if len(lit) > maxInterned {
+		return lit
+	}
+	if canon, ok := s.interned[lit]; ok {
+		return canon
+	}
+	if s.interned == nil {
+		s.interned = make(map[string]string)
+	}
+	s.interned[lit] = lit
+	return lit
+}
+
 func isLetter(r rune) bool {
 	return unicode.IsLetter(r) || r == '_'
 }
@@ -3,7 +3,9 @@ package lexer
 import (
 	"strconv"
 	"testing"
+	"unsafe"
 
+	"github.com/ajwerner/monkey/feature"
 	"github.com/ajwerner/monkey/token"
 )
 
@@ -31,7 +33,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -146,6 +148,107 @@ if (5 < 10) {
 			{token.EOF, ""},
 		},
 	},
+	{
+		`// leading comment
+let x = 1; // trailing comment
+/// doc
+x / 2 //`,
+		tokenCases{
+			{token.LET, "let"},
+			{token.IDENT, "x"},
+			{token.ASSIGN, "="},
+			{token.INT, "1"},
+			{token.SEMICOLON, ";"},
+			{token.IDENT, "x"},
+			{token.SLASH, "/"},
+			{token.INT, "2"},
+			{token.EOF, ""},
+		},
+	},
+	{
+		"a <= b >= c < d > e",
+		tokenCases{
+			{token.IDENT, "a"},
+			{token.LTE, "<="},
+			{token.IDENT, "b"},
+			{token.GTE, ">="},
+			{token.IDENT, "c"},
+			{token.LT, "<"},
+			{token.IDENT, "d"},
+			{token.GT, ">"},
+			{token.IDENT, "e"},
+			{token.EOF, ""},
+		},
+	},
+	{
+		"x ~/ 2",
+		tokenCases{
+			{token.IDENT, "x"},
+			{token.FLOORSLASH, "~/"},
+			{token.INT, "2"},
+			{token.EOF, ""},
+		},
+	},
+	{
+		"x % 2",
+		tokenCases{
+			{token.IDENT, "x"},
+			{token.PERCENT, "%"},
+			{token.INT, "2"},
+			{token.EOF, ""},
+		},
+	},
+	{
+		`db.Get(.5)`,
+		tokenCases{
+			{token.IDENT, "db"},
+			{token.DOT, "."},
+			{token.IDENT, "Get"},
+			{token.LPAREN, "("},
+			{token.FLOAT, ".5"},
+			{token.RPAREN, ")"},
+			{token.EOF, ""},
+		},
+	},
+	{
+		"fn(a: int) -> int { a - -1 }",
+		tokenCases{
+			{token.FUNCTION, "fn"},
+			{token.LPAREN, "("},
+			{token.IDENT, "a"},
+			{token.COLON, ":"},
+			{token.IDENT, "int"},
+			{token.RPAREN, ")"},
+			{token.ARROW, "->"},
+			{token.IDENT, "int"},
+			{token.LBRACE, "{"},
+			{token.IDENT, "a"},
+			{token.MINUS, "-"},
+			{token.MINUS, "-"},
+			{token.INT, "1"},
+			{token.RBRACE, "}"},
+			{token.EOF, ""},
+		},
+	},
+	{
+		"let x2 = 5; vec3d_1 + _9 + 2x + é2",
+		tokenCases{
+			{token.LET, "let"},
+			{token.IDENT, "x2"},
+			{token.ASSIGN, "="},
+			{token.INT, "5"},
+			{token.SEMICOLON, ";"},
+			{token.IDENT, "vec3d_1"},
+			{token.PLUS, "+"},
+			{token.IDENT, "_9"},
+			{token.PLUS, "+"},
+			{token.INT, "2"},
+			{token.IDENT, "x"},
+			{token.PLUS, "+"},
+			{token.IDENT, "é2"},
+			{token.EOF, ""},
+		},
+	},
 }
 
 type tokenCases []struct {
@@ -182,3 +285,226 @@ func TestNextToken(t *testing.T) {
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+
+"multi
+line" y
+"é" + z
+`
+	expected := []struct {
+		literal              string
+		line, column, offset int
+	}{
+		{"let", 1, 1, 0}, {"x", 1, 5, 4}, {"=", 1, 7, 6}, {"5", 1, 9, 8}, {";", 1, 10, 9},
+		{"multi\nline", 3, 1, 12}, {"y", 4, 7, 25},
+		{"é", 5, 1, 27}, {"+", 5, 5, 32}, {"z", 5, 7, 34}, {"", 6, 1, 36},
+	}
+	l := New(input)
+	for i, exp := range expected {
+		if !l.Next() {
+			t.Fatalf("tests[%d] - no token %v", i, l.Err())
+		}
+		tok := l.Token()
+		if tok.Literal != exp.literal || tok.Line != exp.line || tok.Column != exp.column || tok.Offset != exp.offset {
+			t.Errorf("tests[%d] - expected %q at %d:%d (offset %d), got %q at %d:%d (offset %d)",
+				i, exp.literal, exp.line, exp.column, exp.offset, tok.Literal, tok.Line, tok.Column, tok.Offset)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"plain"`, "plain"},
+		{`"a\nb\tc\rd"`, "a\nb\tc\rd"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\u00e9t\u00C9 \u2603"`, "étÉ ☃"},
+		{`"é\n"`, "é\n"},
+	}
+	for _, tt := range tests {
+		l := New(tt.input)
+		if !l.Next() {
+			t.Fatalf("%s: %v", tt.input, l.Err())
+		}
+		if tok := l.Token(); tok.Type != token.STRING || tok.Literal != tt.want {
+			t.Errorf("%s: expected %q, got %v %q", tt.input, tt.want, tok.Type, tok.Literal)
+		}
+	}
+
+	errs := []struct {
+		input string
+		msg   string
+	}{
+		{`"\q"`, `line 1, column 1: invalid escape sequence \q in string`},
+		{`x = "ab\u12x"`, `line 1, column 5: invalid escape sequence \u12 in string: \u must be followed by four hexadecimal digits`},
+		{`"\ud800"`, `line 1, column 1: invalid escape sequence \ud800 in string: not a Unicode code point`},
+		{`"abc\`, `line 1, column 1: unterminated string`},
+		{`"abc\"`, `line 1, column 1: unterminated string`},
+	}
+	for _, tt := range errs {
+		l := New(tt.input)
+		for l.Next() && l.Token().Type != token.EOF {
+		}
+		if err := l.Err(); err == nil || err.Error() != tt.msg {
+			t.Errorf("%s: expected error %q, got %v", tt.input, tt.msg, err)
+		}
+	}
+}
+
+func TestJSONStringEscapes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // or the error
+	}{
+		{`"x\/y\b\f"`, "x/y\b\f"},
+		{`"\ud83d\ude00!"`, "\U0001F600!"},
+		{`"\u00e9"`, "é"},
+		{`"\ud83d"`, `line 1, column 1: invalid escape sequence \ud83d in string: a surrogate must be followed by another`},
+		{`"\ud83d\u0041"`, `line 1, column 1: invalid escape sequence \ud83d\u0041 in string: not a surrogate pair`},
+		{`"\q"`, `line 1, column 1: invalid escape sequence \q in string`},
+	}
+	for _, tt := range tests {
+		l := NewJSON(tt.input)
+		if !l.Next() {
+			if err := l.Err(); err.Error() != tt.want {
+				t.Errorf("%s: expected %q, got error %v", tt.input, tt.want, err)
+			}
+			continue
+		}
+		if tok := l.Token(); tok.Type != token.STRING || tok.Literal != tt.want {
+			t.Errorf("%s: expected %q, got %v %q", tt.input, tt.want, tok.Type, tok.Literal)
+		}
+	}
+	if l := New(`"x\/y"`); l.Next() {
+		t.Errorf("expected \\/ to be an error outside JSON, got %q", l.Token().Literal)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Error
+		msg   string
+	}{
+		{`let x = "abc`, Error{Line: 1, Column: 9, Offset: 8, Msg: "unterminated string", Incomplete: true}, "line 1, column 9: unterminated string"},
+		{"x;\n  é = 1.x", Error{Line: 2, Column: 7, Offset: 10, Msg: "Illegal character 'x' after ."}, "line 2, column 7: Illegal character 'x' after ."},
+		{"1 +\n /* two\n*", Error{Line: 2, Column: 2, Offset: 5, Msg: "unterminated block comment", Incomplete: true}, "line 2, column 2: unterminated block comment"},
+		{"1 +\n\t$", Error{Line: 2, Column: 2, Offset: 5, Msg: `Illegal token "$"`}, `line 2, column 2: Illegal token "$"`},
+	}
+	for _, tt := range tests {
+		l := New(tt.input)
+		for l.Next() && l.Token().Type != token.EOF {
+		}
+		err, ok := l.Err().(*Error)
+		if !ok || *err != tt.want || err.Error() != tt.msg {
+			t.Errorf("%q: expected %+v, got %#v", tt.input, tt.want, l.Err())
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// one
+let x = 1; // two
+/// three
+x /* four
+still four */ + /**/ 2 /* six */`
+	l := New(input)
+	for l.Next() && l.Token().Type != token.EOF {
+	}
+	if l.Err() != nil {
+		t.Fatal(l.Err())
+	}
+	expected := []token.Token{
+		{Type: token.COMMENT, Literal: "// one", Line: 1, Column: 1, Offset: 0},
+		{Type: token.COMMENT, Literal: "// two", Line: 2, Column: 12, Offset: 18},
+		{Type: token.COMMENT, Literal: "/// three", Line: 3, Column: 1, Offset: 25},
+		{Type: token.COMMENT, Literal: "/* four\nstill four */", Line: 4, Column: 3, Offset: 37},
+		{Type: token.COMMENT, Literal: "/**/", Line: 5, Column: 17, Offset: 61},
+		{Type: token.COMMENT, Literal: "/* six */", Line: 5, Column: 24, Offset: 68},
+	}
+	got := l.Comments()
+	if len(got) != len(expected) {
+		t.Fatalf("wrong number of comments. expected %d, got %d", len(expected), len(got))
+	}
+	for i, c := range expected {
+		if got[i] != c {
+			t.Errorf("comments[%d] - expected %+v, got %+v", i, c, got[i])
+		}
+	}
+}
+
+func TestIntern(t *testing.T) {
+	l := New(`let name = {"name": name}; name["name"]`)
+	var idents, strs []string
+	for l.Next() && l.Token().Type != token.EOF {
+		switch tok := l.Token(); tok.Type {
+		case token.IDENT:
+			idents = append(idents, tok.Literal)
+		case token.STRING:
+			strs = append(strs, tok.Literal)
+		}
+	}
+	if l.Err() != nil {
+		t.Fatal(l.Err())
+	}
+	for _, lits := range [][]string{idents, strs} {
+		if len(lits) < 2 {
+			t.Fatalf("expected repeated literals, got %q", lits)
+		}
+		for _, lit := range lits[1:] {
+			if unsafe.StringData(lit) != unsafe.StringData(lits[0]) {
+				t.Errorf("%q is not interned", lit)
+			}
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	const input = "let import = await;\nx is int"
+	for _, tt := range []struct {
+		features feature.Feature
+		types    []token.TokenType
+		reserved []string
+	}{
+		{feature.Extended, []token.TokenType{token.LET, token.IMPORT, token.ASSIGN, token.AWAIT, token.SEMICOLON, token.IDENT, token.IS, token.IDENT}, nil},
+		{feature.Classic, []token.TokenType{token.LET, token.IDENT, token.ASSIGN, token.IDENT, token.SEMICOLON, token.IDENT, token.IDENT, token.IDENT}, []string{"import", "await", "is"}},
+		{feature.Import, []token.TokenType{token.LET, token.IMPORT, token.ASSIGN, token.IDENT, token.SEMICOLON, token.IDENT, token.IDENT, token.IDENT}, []string{"await", "is"}},
+	} {
+		l := NewWithFeatures(input, tt.features)
+		if got := l.Features(); got != tt.features {
+			t.Errorf("%v: Features() = %v", tt.features, got)
+		}
+		var types []token.TokenType
+		for l.Next() && l.Token().Type != token.EOF {
+			types = append(types, l.Token().Type)
+		}
+		if l.Err() != nil {
+			t.Fatal(l.Err())
+		}
+		if len(types) != len(tt.types) {
+			t.Fatalf("%v: expected %v, got %v", tt.features, tt.types, types)
+		}
+		for i := range types {
+			if types[i] != tt.types[i] {
+				t.Errorf("%v: token %d: expected %v, got %v", tt.features, i, tt.types[i], types[i])
+			}
+		}
+		var reserved []string
+		for _, tok := range l.Reserved() {
+			reserved = append(reserved, tok.Literal)
+		}
+		if len(reserved) != len(tt.reserved) {
+			t.Fatalf("%v: expected reserved %q, got %q", tt.features, tt.reserved, reserved)
+		}
+		for i := range reserved {
+			if reserved[i] != tt.reserved[i] {
+				t.Errorf("%v: reserved %d: expected %q, got %q", tt.features, i, tt.reserved[i], reserved[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package lexer
+
+import "sync"
+
+// Reset makes l lex input from the start, as New(input) would, reusing the
+// memory it allocated for its previous input. The lexer accepts every
+// feature again.
+func (l *Lexer) Reset(input string) {
+	interned := l.interned
+	clear(interned)
+	*l = Lexer{}
+	initState(&l.state, input)
+	l.interned = interned
+}
+
+// Pool recycles Lexers, for servers which lex many small programs. It is
+// safe for concurrent use, and the zero value is ready to use.
+type Pool struct {
+	pool sync.Pool
+}
+
+// Get returns a Lexer of input, reusing one put in the pool if there is one.
+func (p *Pool) Get(input string) *Lexer {
+	l, ok := p.pool.Get().(*Lexer)
+	if !ok {
+		return New(input)
+	}
+	l.Reset(input)
+	return l
+}
+
+// Put returns l to the pool. The tokens and comments l returned remain
+// valid, but l must not be used again.
+func (p *Pool) Put(l *Lexer) {
+	p.pool.Put(l)
+}
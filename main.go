@@ -1,21 +0,0 @@
-// Command monkey executes the monkey repl.
-package main
-
-import (
-	"fmt"
-	"os"
-	"os/user"
-
-	"github.com/ajwerner/monkey/repl"
-)
-
-func main() {
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
-	}
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
-		user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
-}
@@ -0,0 +1,403 @@
+// Package monkey is the high-level API for embedding the monkey language.
+//
+// Run evaluates a standalone program:
+//
+//	result, err := monkey.Run(`let add = fn(a, b) { a + b }; add(1, 2)`)
+//
+// An Interpreter keeps its bindings between calls to Eval:
+//
+//	interp, err := monkey.New(monkey.WithLimits(limits))
+//	interp.Eval(`let x = 41;`)
+//	result, err := interp.Eval(`x + 1`)
+package monkey
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/feature"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/objconv"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/stdlib"
+	"github.com/ajwerner/monkey/token"
+	"github.com/ajwerner/monkey/types"
+	"github.com/ajwerner/monkey/vm"
+)
+
+// Run evaluates src with a new Interpreter.
+func Run(src string) (object.Object, error) {
+	in, err := New()
+	if err != nil {
+		return nil, err
+	}
+	return in.Eval(src)
+}
+
+// Interpreter evaluates monkey source in a persistent environment. An
+// Interpreter must not be used concurrently.
+type Interpreter struct {
+	engine    Engine
+	eval      evaluator.Evaluator
+	env       *object.Environment
+	builtins  *object.Builtins
+	forbidden []token.TokenType
+	features  feature.Feature
+	checker   *types.Checker
+
+	// Modules are built on first import with the denied capabilities and
+	// the builtins named in excluded removed, and their output directed to
+	// output.
+	modules  map[string]stdlib.Builder
+	resolver *stdlib.Resolver
+	denied   object.Capability
+	excluded []string
+	output   io.Writer
+
+	// State persisted between programs run by EngineVM.
+	session *compiler.Session
+	symbols *compiler.SymbolTable // the session's
+	globals []object.Object
+}
+
+// New creates an Interpreter with an empty environment, configured by opts.
+func New(opts ...Option) (*Interpreter, error) {
+	var cfg Config
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Builtins == nil {
+		cfg.Builtins = object.NewBuiltins()
+	}
+	if cfg.Output != nil {
+		// The interpreter's builtins and those of its modules share one
+		// lock on the output.
+		cfg.Output = object.SyncWriter(cfg.Output)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	in := &Interpreter{
+		engine:    cfg.Engine,
+		env:       object.NewEnvironment(),
+		builtins:  cfg.builtins(),
+		forbidden: cfg.ForbiddenSyntax,
+		features:  feature.Extended &^ cfg.DisabledFeatures,
+		modules:   cfg.Modules,
+		denied:    cfg.DeniedCapabilities,
+		output:    cfg.Output,
+	}
+	// Modules must not offer the builtins which the options remove under
+	// the same names.
+	for _, name := range cfg.Builtins.Names() {
+		if _, ok := in.builtins.Lookup(name); !ok {
+			in.excluded = append(in.excluded, name)
+		}
+	}
+	in.resolver = &stdlib.Resolver{
+		Lookup:   in.lookupModule,
+		Prepare:  in.prepareModule,
+		Builtins: in.builtins,
+		Path:     cfg.modulePath(),
+	}
+	if cfg.TypeCheck {
+		in.checker = types.NewChecker(in.builtins)
+		in.checker.Strict = true
+	}
+	in.eval.Builtins = in.builtins
+	in.eval.Importer = in.importModule
+	in.eval.Limits = cfg.Limits
+	in.eval.Lazy = cfg.Lazy
+	in.eval.Truthiness = cfg.Truthiness
+	in.eval.StrictIndex = cfg.StrictIndex
+	in.eval.IntegerDivision = !in.features.Has(feature.Division)
+	if in.engine == EngineVM {
+		in.newSession()
+		in.globals = make([]object.Object, vm.GlobalsSize)
+	}
+	return in, nil
+}
+
+// RegisterBuiltin makes fn callable from scripts as name, replacing any
+// builtin of the same name. Bindings created by scripts shadow builtins.
+func (in *Interpreter) RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	in.builtins.Register(name, fn)
+}
+
+// Bind exposes the exported methods of v to scripts as the namespace name, so
+// that a method Get is called as name.Get(...). Arguments and results are
+// converted as by objconv.Func; a non-nil error result is returned to the
+// script as an Error. Methods with pointer receivers are bound only if v is
+// a pointer.
+func (in *Interpreter) Bind(name string, v interface{}) error {
+	methods, err := objconv.Methods(v)
+	if err != nil {
+		return err
+	}
+	in.define(name, methods)
+	return nil
+}
+
+// hostModule returns the builder of a module provided by the interpreter
+// itself, which takes precedence over stdlib modules.
+func (in *Interpreter) hostModule(name string) (stdlib.Builder, bool) {
+	switch name {
+	case "http":
+		return in.httpModule, true
+	}
+	return nil, false
+}
+
+// importModule returns the module named name, loading it on first use. Go
+// modules come from the interpreter's own modules or those registered with
+// stdlib, and take precedence over source modules on the module path.
+// Handlers served by the http module import concurrently, which the
+// resolver allows.
+func (in *Interpreter) importModule(ctx object.BuiltinContext, name string) (object.Object, error) {
+	return in.resolver.Import(ctx, name)
+}
+
+func (in *Interpreter) lookupModule(name string) (stdlib.Builder, bool) {
+	if build, ok := in.modules[name]; ok {
+		return build, true
+	}
+	if build, ok := in.hostModule(name); ok {
+		return build, true
+	}
+	return stdlib.Lookup(name)
+}
+
+func (in *Interpreter) prepareModule(b *object.Builtins) {
+	b.Restrict(in.denied)
+	for _, name := range in.excluded {
+		b.Remove(name)
+	}
+	if in.output != nil {
+		b.SetOutput(in.output)
+	}
+}
+
+// Get returns the value of the global binding name, as left by the programs
+// run so far. Builtins are not globals.
+func (in *Interpreter) Get(name string) (object.Object, bool) {
+	if in.engine == EngineVM {
+		sym, ok := in.symbols.Resolve(name)
+		if !ok || sym.Scope != compiler.GlobalScope || in.globals[sym.Index] == nil {
+			return nil, false
+		}
+		return in.globals[sym.Index], true
+	}
+	val, ok := in.env.Get(name)
+	if !ok {
+		return nil, false
+	}
+	return object.Force(val), true
+}
+
+// Set binds name to value, converted with objconv.FromGo, in the
+// interpreter's global scope, replacing any existing binding. Later programs
+// see value as if it had been bound with let.
+func (in *Interpreter) Set(name string, value interface{}) error {
+	obj, err := objconv.FromGo(value)
+	if err != nil {
+		return err
+	}
+	in.define(name, obj)
+	return nil
+}
+
+// Reload replaces the interpreter's program with src, keeping accumulated
+// state: src is run in a fresh global scope and then each of its globals
+// which was also a global before, other than functions, is set back to its
+// previous value. Functions take their new definitions. If src fails to
+// parse, compile or run, the interpreter is left unchanged.
+func (in *Interpreter) Reload(src string) error {
+	return in.ReloadContext(context.Background(), src)
+}
+
+// ReloadContext is like Reload but stops with an error wrapping ctx.Err()
+// once ctx is done.
+func (in *Interpreter) ReloadContext(ctx context.Context, src string) error {
+	fresh := *in
+	fresh.env = object.NewEnvironment()
+	if in.engine == EngineVM {
+		fresh.newSession()
+		fresh.globals = make([]object.Object, vm.GlobalsSize)
+	}
+	if _, err := fresh.EvalContext(ctx, src); err != nil {
+		return err
+	}
+	for _, name := range in.globalNames() {
+		old, ok := in.Get(name)
+		if !ok || old.Type() == object.FUNCTION || old.Type() == object.BUILTIN {
+			continue
+		}
+		if _, ok := fresh.Get(name); ok {
+			fresh.define(name, old)
+		}
+	}
+	*in = fresh
+	return nil
+}
+
+// globalNames returns the names of the interpreter's globals.
+func (in *Interpreter) globalNames() []string {
+	if in.engine == EngineVM {
+		var names []string
+		for _, sym := range in.symbols.Globals() {
+			names = append(names, sym.Name)
+		}
+		return names
+	}
+	return in.env.Names()
+}
+
+// define binds name to obj in the interpreter's global scope.
+func (in *Interpreter) define(name string, obj object.Object) {
+	if in.engine == EngineVM {
+		in.globals[in.symbols.Define(name).Index] = obj
+		return
+	}
+	in.env.Set(name, obj)
+}
+
+// Builtins returns the interpreter's builtin table, including registered
+// builtins. Programs compiled for the VM with compiler.NewWithBuiltins must be
+// run with vm.NewWithBuiltins using the same table.
+func (in *Interpreter) Builtins() *object.Builtins {
+	return in.builtins
+}
+
+// Eval evaluates src and returns the value of its last statement, or NULL if
+// it has none. Parse errors and Error results are returned as errors.
+func (in *Interpreter) Eval(src string) (object.Object, error) {
+	return in.EvalContext(context.Background(), src)
+}
+
+// EvalContext is like Eval but stops parsing or evaluation with an error
+// wrapping ctx.Err() once ctx is done. The timers the program schedules are
+// canceled when it returns.
+func (in *Interpreter) EvalContext(ctx context.Context, src string) (_ object.Object, err error) {
+	defer recoverInternal(&err)
+	ctx, stopTimers := object.WithTimers(ctx)
+	defer stopTimers()
+	program, err := in.parse(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if in.engine == EngineVM {
+		return in.runVM(ctx, program)
+	}
+	in.eval.Reset()
+	result := in.eval.EvalContext(ctx, program, in.env)
+	switch result := result.(type) {
+	case object.Error:
+		return nil, result.Err
+	default:
+		return result, nil
+	}
+}
+
+// newEvaluator returns an Evaluator configured like the interpreter's, with
+// resources of its own counted against the limits.
+func (in *Interpreter) newEvaluator() *evaluator.Evaluator {
+	return &evaluator.Evaluator{
+		Builtins:        in.builtins,
+		Importer:        in.importModule,
+		Limits:          in.eval.Limits,
+		Lazy:            in.eval.Lazy,
+		Truthiness:      in.eval.Truthiness,
+		StrictIndex:     in.eval.StrictIndex,
+		IntegerDivision: in.eval.IntegerDivision,
+	}
+}
+
+// newSession starts the compiler session of the programs run by EngineVM.
+func (in *Interpreter) newSession() {
+	in.symbols = compiler.NewSymbolTable()
+	in.session = compiler.NewSession(in.symbols)
+}
+
+// runVM compiles program in the interpreter's session, which keeps its
+// symbols and constants, and runs it with the persistent globals.
+func (in *Interpreter) runVM(ctx context.Context, program *ast.Program) (object.Object, error) {
+	// Builtins may have been registered since the last program; they must
+	// not shadow globals.
+	for i, name := range in.builtins.Names() {
+		if sym, ok := in.symbols.Resolve(name); !ok || sym.Scope == compiler.BuiltinScope {
+			in.symbols.DefineBuiltin(i, name)
+		}
+	}
+	in.session.Importer = in.importModule
+	in.session.Engine = in.newEvaluator().BuiltinContext(ctx, nil)
+	bytecode, err := in.session.CompileContext(ctx, program)
+	if err != nil {
+		return nil, err
+	}
+	machine := vm.NewWithGlobalsStore(bytecode, in.builtins, in.globals)
+	machine.Limits = in.eval.Limits
+	machine.Truthiness = in.eval.Truthiness
+	machine.StrictIndex = in.eval.StrictIndex
+	machine.IntegerDivision = in.eval.IntegerDivision
+	machine.Evaluator = in.newEvaluator()
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	return result(machine, endsWithExpression(program)), nil
+}
+
+// endsWithExpression reports whether the last statement of program is an
+// expression statement, whose value the VM leaves as the last popped element.
+func endsWithExpression(program *ast.Program) bool {
+	n := len(program.Statements)
+	if n == 0 {
+		return false
+	}
+	_, ok := program.Statements[n-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// result returns the value of a program run by machine, as for Eval.
+func result(machine *vm.VM, endsWithExpression bool) object.Object {
+	if obj := machine.LastPoppedStackElem(); endsWithExpression && obj != nil {
+		return obj
+	}
+	return object.Null{}
+}
+
+// parsers recycles parsers across interpreters, which may each parse many
+// small programs.
+var parsers parser.Pool
+
+// parse checks src for forbidden syntax, parses it and, if configured,
+// type checks it.
+func (in *Interpreter) parse(ctx context.Context, src string) (*ast.Program, error) {
+	if err := sandbox.CheckSyntax(src, in.forbidden); err != nil {
+		return nil, err
+	}
+	var p *parser.Parser
+	if in.features == feature.Extended {
+		p = parsers.Get(src)
+		defer parsers.Put(p)
+	} else {
+		p = parser.New(lexer.NewWithFeatures(src, in.features))
+	}
+	program := p.ParseProgramContext(ctx)
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	if in.checker != nil {
+		if _, err := in.checker.Check(program); err != nil {
+			return nil, err
+		}
+	}
+	return program, nil
+}
@@ -0,0 +1,848 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/feature"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/stdlib"
+	"github.com/ajwerner/monkey/token"
+	"github.com/ajwerner/monkey/vm"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.Object
+		err      string
+	}{
+		{"let add = fn(a, b) { a + b }; add(1, 2)", object.Integer(3), ""},
+		{`"a" + "b"`, object.String("ab"), ""},
+		{"let x = 1;", object.Null{}, ""},
+		{"1 + true", nil, "line 1: type mismatch: INTEGER + BOOL"},
+		{"let = 1", nil, "line 1: expected next token to be IDENT, got = instead\nline 1: no prefix parse function for = found"},
+	}
+
+	for _, tt := range tests {
+		result, err := Run(tt.input)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+		case result != tt.expected:
+			t.Errorf("%q: wrong result. want=%v, got=%v", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func newInterpreter(t *testing.T, opts ...Option) *Interpreter {
+	t.Helper()
+	interp, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return interp
+}
+
+func TestInterpreterKeepsBindings(t *testing.T) {
+	interp := newInterpreter(t)
+	if _, err := interp.Eval("let x = 41;"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := interp.Eval("x + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != object.Integer(42) {
+		t.Errorf("wrong result. want=42, got=%v", result)
+	}
+}
+
+func TestWithLimits(t *testing.T) {
+	interp := newInterpreter(t, WithLimits(sandbox.Limits{MaxDepth: 10}))
+	_, err := interp.Eval("let f = fn() { f() }; f()")
+	if !errors.Is(err, sandbox.ErrDepthLimit) {
+		t.Errorf("expected %v, got %v", sandbox.ErrDepthLimit, err)
+	}
+}
+
+func TestSandboxOptions(t *testing.T) {
+	withFiles := object.NewBuiltins()
+	withFiles.RegisterWithCapabilities("readFile", object.CapFile, func(args ...object.Object) object.Object {
+		return object.String("secret")
+	})
+	tests := []struct {
+		opts  []Option
+		input string
+		err   string
+	}{
+		{[]Option{WithoutBuiltins("puts")}, `puts("x")`, "line 1: identifier not found: puts"},
+		{[]Option{WithOnlyBuiltins("len")}, `first([1])`, "line 1: identifier not found: first"},
+		{[]Option{WithOnlyBuiltins("len")}, `len([1])`, ""},
+		{[]Option{WithoutBuiltins("upper")}, `import "strings".upper("a")`, "line 1: not a function: NULL"},
+		{[]Option{WithOnlyBuiltins("len")}, `import "strings".upper("a")`, "line 1: not a function: NULL"},
+		{[]Option{WithOnlyBuiltins("len")}, `import "strings".hasPrefix("ab", "a")`, ""},
+		{[]Option{WithBuiltins(withFiles), WithoutCapabilities(object.CapIO)}, `readFile("/etc/passwd")`, "line 1: identifier not found: readFile"},
+		{[]Option{WithForbiddenSyntax(token.FUNCTION)}, "let f = 1;\nlet g = fn() { 1 };", `line 2: "fn" is not allowed`},
+		{[]Option{WithLimits(sandbox.Limits{MaxStringLen: 3})}, `"ab" + "cd"`, "line 1: string length limit exceeded"},
+	}
+	for _, tt := range tests {
+		interp := newInterpreter(t, tt.opts...)
+		_, err := interp.Eval(tt.input)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+		}
+		if tt.err == "" {
+			continue
+		}
+		// The VM enforces the same restrictions.
+		script, err := interp.Compile(tt.input)
+		if err == nil {
+			_, err = script.Run(nil)
+		}
+		if err == nil {
+			t.Errorf("%q: expected compiled script to fail", tt.input)
+		}
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	interp := newInterpreter(t)
+	interp.RegisterBuiltin("fetchUser", func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return object.Error{Err: fmt.Errorf("wrong number of arguments")}
+		}
+		return object.String("user-" + args[0].Inspect())
+	})
+	result, err := interp.Eval("fetchUser(7)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != object.String("user-7") {
+		t.Errorf("wrong result. want=user-7, got=%v", result)
+	}
+	if _, err := interp.Eval("fetchUser()"); err == nil || err.Error() != "line 1: wrong number of arguments" {
+		t.Errorf("expected builtin error, got %v", err)
+	}
+
+	comp := compiler.NewWithBuiltins(interp.Builtins())
+	if err := comp.Compile(parser.New(lexer.New(`fetchUser("x")`)).ParseProgram()); err != nil {
+		t.Fatal(err)
+	}
+	machine := vm.NewWithBuiltins(comp.Bytecode(), interp.Builtins())
+	if err := machine.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := machine.LastPoppedStackElem(); got != object.String("user-x") {
+		t.Errorf("wrong VM result. want=user-x, got=%v", got)
+	}
+}
+
+type store struct {
+	data map[string]int
+}
+
+func (s *store) Get(key string) (int, error) {
+	v, ok := s.data[key]
+	if !ok {
+		return 0, fmt.Errorf("no such key: %s", key)
+	}
+	return v, nil
+}
+
+func (s *store) Put(key string, value int) {
+	s.data[key] = value
+}
+
+func (s *store) Keys() []string {
+	var keys []string
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestBind(t *testing.T) {
+	interp := newInterpreter(t)
+	db := &store{data: map[string]int{"a": 1}}
+	if err := interp.Bind("db", db); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input    string
+		expected object.Object
+		err      string
+	}{
+		{`db.Get("a") + 1`, object.Integer(2), ""},
+		{`db.Put("b", 41); db.Get("b") + 1`, object.Integer(42), ""},
+		{`len(db.Keys())`, object.Integer(2), ""},
+		{`db.Get("missing")`, nil, "line 1: no such key: missing"},
+		{`db.Get(1)`, nil, "line 1: objconv: argument 1: cannot convert INTEGER to string"},
+		{`db.Put("c")`, nil, "line 1: wrong number of arguments. got=1, want=2"},
+	}
+	for _, tt := range tests {
+		result, err := interp.Eval(tt.input)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+		case result != tt.expected:
+			t.Errorf("%q: wrong result. want=%v, got=%v", tt.input, tt.expected, result)
+		}
+	}
+	if db.data["b"] != 41 {
+		t.Errorf("expected Put to update the store, got %v", db.data)
+	}
+}
+
+func TestScript(t *testing.T) {
+	script, err := Compile(`
+let limit = if (user.admin) { 100 } else { 10 };
+requests < limit
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(script.Params()), "[user requests]"; got != want {
+		t.Errorf("wrong params. want=%s, got=%s", want, got)
+	}
+	tests := []struct {
+		params   map[string]interface{}
+		expected object.Object
+		err      string
+	}{
+		{map[string]interface{}{"user": map[string]bool{"admin": false}, "requests": 5}, object.Bool(true), ""},
+		{map[string]interface{}{"user": map[string]bool{"admin": false}, "requests": 50}, object.Bool(false), ""},
+		{map[string]interface{}{"user": map[string]bool{"admin": true}, "requests": 50, "extra": 1}, object.Bool(true), ""},
+		{map[string]interface{}{"user": map[string]bool{}}, nil, "missing parameter requests"},
+		{map[string]interface{}{"user": map[string]bool{}, "requests": func() {}}, nil, "parameter requests: objconv: cannot convert func()"},
+		{map[string]interface{}{"user": 1, "requests": 1}, nil, "line 2: index operator not supported: INTEGER"},
+	}
+	for i, tt := range tests {
+		result, err := script.Run(tt.params)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%d: expected error %q, got %v", i, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%d: unexpected error %v", i, err)
+		case result != tt.expected:
+			t.Errorf("%d: wrong result. want=%v, got=%v", i, tt.expected, result)
+		}
+	}
+
+	if _, err := Compile("let x = ;"); err == nil {
+		t.Error("expected parse error")
+	}
+	if result, err := script.Run(map[string]interface{}{"user": map[string]bool{}, "requests": 1}); err != nil || result != object.Bool(true) {
+		t.Errorf("expected script to be reusable, got %v, %v", result, err)
+	}
+}
+
+func TestScriptForLoop(t *testing.T) {
+	script, err := Compile(`
+let s = 0;
+for (x in [1, 2]) { s = s + x; }
+for (k, v in h) { s = s + v; }
+s
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(script.Params()), "[h]"; got != want {
+		t.Errorf("wrong params. want=%s, got=%s", want, got)
+	}
+	result, err := script.Run(map[string]interface{}{"h": map[string]int{"a": 3, "b": 4}})
+	if err != nil || result != object.Integer(10) {
+		t.Errorf("expected 10, got %v, %v", result, err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	interp := newInterpreter(t)
+	if _, err := interp.EvalContext(ctx, "1 + 1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected parsing to be canceled, got %v", err)
+	}
+	if _, err := interp.CompileContext(ctx, "1 + 1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected compiling to be canceled, got %v", err)
+	}
+	script, err := Compile("x + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := script.RunContext(ctx, map[string]interface{}{"x": 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected run to be canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = interp.EvalContext(ctx, "let f = fn(x) { f(x + 1) + f(x + 1) }; f(0)")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected evaluation to hit the deadline, got %v", err)
+	}
+}
+
+func TestTimersStopWithRun(t *testing.T) {
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		for _, compiled := range []bool{false, true} {
+			// The first call to tick lets the program return and then
+			// blocks until the run has stopped its timers, after which
+			// tick must not be called again.
+			var ticks atomic.Int64
+			started, release := make(chan struct{}), make(chan struct{})
+			interp := newInterpreter(t, WithEngine(engine))
+			interp.RegisterBuiltin("tick", func(args ...object.Object) object.Object {
+				if ticks.Add(1) == 1 {
+					close(started)
+				}
+				<-release
+				return object.Null{}
+			})
+			interp.RegisterBuiltin("started", func(args ...object.Object) object.Object {
+				<-started
+				return object.Null{}
+			})
+			const src = "every(1, tick); started()"
+			var err error
+			if compiled {
+				var script *Script
+				if script, err = interp.Compile(src); err == nil {
+					_, err = script.Run(nil)
+				}
+			} else {
+				_, err = interp.Eval(src)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", engine, err)
+			}
+			close(release)
+			// Give a timer which was not stopped time to tick again.
+			time.Sleep(5 * time.Millisecond)
+			if n := ticks.Load(); n != 1 {
+				t.Errorf("%s: expected the timer to stop with the run, got %d calls", engine, n)
+			}
+		}
+	}
+}
+
+func TestWithOutput(t *testing.T) {
+	var out strings.Builder
+	interp := newInterpreter(t, WithOutput(&out))
+	if _, err := interp.Eval(`puts("hello", 1); print("a", [1]); print("\n")`); err != nil {
+		t.Fatal(err)
+	}
+	script, err := interp.Compile(`puts("from the vm")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := script.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "hello\n1\na [1]\nfrom the vm\n"; out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+
+	interp = newInterpreter(t, WithoutBuiltins("puts"), WithOutput(&out))
+	if _, err := interp.Eval(`puts("x")`); err == nil {
+		t.Error("expected WithOutput not to restore a removed puts")
+	}
+}
+
+func TestOutputFromTasks(t *testing.T) {
+	var out strings.Builder
+	interp := newInterpreter(t, WithOutput(&out))
+	_, err := interp.Eval(`let loop = fn() { let i = 0; while (i < 1000) { puts(1, 2); i = i + 1 } };
+		await all([spawn(loop), spawn(loop), spawn(loop)])`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lines of each call to puts are written together.
+	if expected := strings.Repeat("1\n2\n", 3000); out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		opts []Option
+		err  string
+	}{
+		{[]Option{WithEngine(Engine(7))}, "unknown engine Engine(7)"},
+		{[]Option{WithLimits(sandbox.Limits{MaxSteps: -1})}, "limits must not be negative"},
+		{[]Option{WithOutput(nil)}, "nil output writer"},
+		{[]Option{WithBuiltins(nil)}, "nil builtins"},
+		{[]Option{WithoutBuiltins("nope")}, `unknown builtin "nope"`},
+		{[]Option{WithBuiltins(object.NewBuiltins()), WithOnlyBuiltins("len", "nope")}, `unknown builtin "nope"`},
+		{[]Option{WithEngine(EngineVM), WithLazyEvaluation()}, "lazy evaluation is not supported by engine vm"},
+		{[]Option{WithTruthiness(object.Truthiness(5))}, "unknown truthiness Truthiness(5)"},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.opts...); err == nil || err.Error() != tt.err {
+			t.Errorf("expected error %q, got %v", tt.err, err)
+		}
+	}
+}
+
+func TestEngines(t *testing.T) {
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		t.Run(engine.String(), func(t *testing.T) {
+			var out strings.Builder
+			interp := newInterpreter(t, WithEngine(engine), WithOutput(&out))
+			interp.RegisterBuiltin("double", func(args ...object.Object) object.Object {
+				return args[0].(object.Integer) * 2
+			})
+			if err := interp.Bind("db", &store{data: map[string]int{"a": 20}}); err != nil {
+				t.Fatal(err)
+			}
+			steps := []struct {
+				input    string
+				expected object.Object
+			}{
+				{"let x = db.Get(\"a\");", object.Null{}},
+				{"let y: string = x;", object.Null{}},
+				{"let len = fn(s) { 0 };", nil},
+				{"double(x) + 2", object.Integer(42)},
+				{`puts(if (x > 10) { "big" } else { "small" })`, object.Null{}},
+			}
+			if engine == EngineVM {
+				// The VM does not support functions.
+				steps = append(steps[:2], steps[3:]...)
+			}
+			for _, step := range steps {
+				result, err := interp.Eval(step.input)
+				if err != nil {
+					t.Fatalf("%q: %v", step.input, err)
+				}
+				if step.expected != nil && result != step.expected {
+					t.Errorf("%q: wrong result. want=%v, got=%v", step.input, step.expected, result)
+				}
+			}
+			if out.String() != "big\n" {
+				t.Errorf("wrong output %q", out.String())
+			}
+		})
+	}
+}
+
+func TestTruthiness(t *testing.T) {
+	tests := []struct {
+		input             string
+		classic, extended bool
+	}{
+		{"0", true, false},
+		{"0.0", true, false},
+		{"7", true, true},
+		{`""`, true, false},
+		{`"a"`, true, true},
+		{"[]", true, false},
+		{"[0]", true, true},
+		{"{}", true, false},
+		{"null", false, false},
+		{"false", false, false},
+	}
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		for _, truthiness := range []object.Truthiness{object.ClassicTruthiness, object.ExtendedTruthiness} {
+			interp := newInterpreter(t, WithEngine(engine), WithTruthiness(truthiness))
+			for _, tt := range tests {
+				want := tt.classic
+				if truthiness == object.ExtendedTruthiness {
+					want = tt.extended
+				}
+				for _, src := range []string{
+					"if (" + tt.input + ") { true } else { false }",
+					"!!" + tt.input,
+					"len(filter([[" + tt.input + "]], first)) == 1",
+				} {
+					got, err := interp.Eval(src)
+					if err != nil || got != object.Bool(want) {
+						t.Errorf("%v, %v: %s: expected %t, got %v (%v)", engine, truthiness, src, want, got, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestStrictIndex(t *testing.T) {
+	tests := []struct {
+		input string
+		want  error
+		msg   string
+	}{
+		{"[1, 2, 3][3]", object.ErrIndexOutOfRange, "index out of range: 3 with length 3"},
+		{"[1, 2, 3][-1]", object.ErrIndexOutOfRange, "index out of range: -1 with length 3"},
+		{`{"a": 1}["b"]`, object.ErrKeyNotFound, `key not found: "b"`},
+		{`{"a": 1}[2]`, object.ErrKeyNotFound, "key not found: 2"},
+	}
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		lax := newInterpreter(t, WithEngine(engine))
+		strict := newInterpreter(t, WithEngine(engine), WithStrictIndex())
+		for _, tt := range tests {
+			if got, err := lax.Eval(tt.input); err != nil || got != (object.Null{}) {
+				t.Errorf("%v: %s: expected null, got %v (%v)", engine, tt.input, got, err)
+			}
+			_, err := strict.Eval(tt.input)
+			if !errors.Is(err, tt.want) || !strings.Contains(err.Error(), tt.msg) {
+				t.Errorf("%v: %s: expected error %q, got %v", engine, tt.input, tt.msg, err)
+			}
+		}
+		if got, err := strict.Eval(`[1, 2, 3][2] + {"a": 1}["a"]`); err != nil || got != object.Integer(4) {
+			t.Errorf("%v: expected 4, got %v (%v)", engine, got, err)
+		}
+	}
+}
+
+func TestWithFeatures(t *testing.T) {
+	classic := `let import = 21; import * 2`
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		in := newInterpreter(t, WithEngine(engine), WithFeatures(feature.Classic))
+		if got, err := in.Eval(classic); err != nil || got != object.Integer(42) {
+			t.Errorf("%v: expected 42, got %v (%v)", engine, got, err)
+		}
+		_, err := in.Eval("let x: int = 1;")
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Msg != "type annotations require language feature types" {
+			t.Errorf("%v: expected a parse error naming the feature, got %v", engine, err)
+		}
+		if got, err := in.Eval("[7 / 2, -7 / 2, 7.0 / 2]"); err != nil || got.Inspect() != "[3, -3, 3.5]" {
+			t.Errorf("%v: expected / to truncate integers, got %v (%v)", engine, got, err)
+		}
+		if _, err := in.Eval("7 % 2"); err == nil {
+			t.Errorf("%v: expected the classic language to reject %%", engine)
+		}
+		script, err := in.Compile("n / 2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := script.Run(map[string]interface{}{"n": 7}); err != nil || got != object.Integer(3) {
+			t.Errorf("%v: expected a script to truncate integers, got %v (%v)", engine, got, err)
+		}
+	}
+	in := newInterpreter(t)
+	if _, err := in.Eval(classic); err == nil {
+		t.Errorf("expected the extended language to reject import as a name")
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	boom := func(args ...object.Object) object.Object { panic("boom") }
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		interp := newInterpreter(t, WithEngine(engine))
+		interp.RegisterBuiltin("boom", boom)
+		_, err := interp.Eval("boom()")
+		var ie *InternalError
+		if !errors.As(err, &ie) || ie.Value != "boom" || len(ie.Stack) == 0 {
+			t.Errorf("%v: expected internal error, got %v", engine, err)
+		}
+		script, err := interp.Compile("boom()")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := script.Run(nil); !errors.As(err, &ie) {
+			t.Errorf("%v: expected internal error from Run, got %v", engine, err)
+		}
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	interp := newInterpreter(t)
+	vmInterp := newInterpreter(t, WithEngine(EngineVM))
+	checked := newInterpreter(t, WithTypeCheck())
+	tests := []struct {
+		interp *Interpreter
+		input  string
+		check  func(error) bool
+	}{
+		{interp, `"abc`, func(err error) bool { var e *LexError; return errors.As(err, &e) && e.Line == 1 }},
+		{interp, "1;\nlet = 1", func(err error) bool { var e *ParseError; return errors.As(err, &e) && e.Line == 2 }},
+		{vmInterp, "1;\nimport \"nope\"", func(err error) bool { var e *CompileError; return errors.As(err, &e) && e.Line == 2 }},
+		{vmInterp, "1;\nnope", func(err error) bool { var e *RuntimeError; return errors.As(err, &e) && e.Line == 2 }},
+		{interp, "1;\n1 + true", func(err error) bool { var e *RuntimeError; return errors.As(err, &e) && e.Line == 2 }},
+		{vmInterp, "1 + true", func(err error) bool { var e *RuntimeError; return errors.As(err, &e) }},
+		{checked, "puts(1);\n1 + true", func(err error) bool { var e *TypeError; return errors.As(err, &e) && e.Line == 2 }},
+	}
+	for _, tt := range tests {
+		_, err := tt.interp.Eval(tt.input)
+		if err == nil || !tt.check(err) {
+			t.Errorf("%q: wrong error kind: %T (%v)", tt.input, err, err)
+		}
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		interp := newInterpreter(t, WithEngine(engine))
+		if err := interp.Set("input", []int{1, 2, 3}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := interp.Eval(`let total = input[0] + input[2]; let label = "done";`); err != nil {
+			t.Fatalf("%v: %v", engine, err)
+		}
+		if got, ok := interp.Get("total"); !ok || got != object.Integer(4) {
+			t.Errorf("%v: wrong total. got=%v (%t)", engine, got, ok)
+		}
+		if got, ok := interp.Get("label"); !ok || got != object.String("done") {
+			t.Errorf("%v: wrong label. got=%v (%t)", engine, got, ok)
+		}
+		if err := interp.Set("total", object.Integer(10)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := interp.Eval("total + 1"); err != nil || got != object.Integer(11) {
+			t.Errorf("%v: wrong result after Set. got=%v, %v", engine, got, err)
+		}
+		for _, name := range []string{"missing", "len"} {
+			if got, ok := interp.Get(name); ok {
+				t.Errorf("%v: expected no global %s, got %v", engine, name, got)
+			}
+		}
+		if err := interp.Set("ch", make(chan int)); err == nil {
+			t.Errorf("%v: expected error setting a channel", engine)
+		}
+	}
+
+	// Get forces the globals of a lazy interpreter.
+	lazy := newInterpreter(t, WithLazyEvaluation())
+	if _, err := lazy.Eval(`let total = 1 + 2;`); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := lazy.Get("total"); !ok || got != object.Integer(3) {
+		t.Errorf("lazy: wrong total. got=%v (%t)", got, ok)
+	}
+}
+
+func TestPool(t *testing.T) {
+	script, err := Compile(`let doubled = n * 2; let items = [doubled, doubled + 1]; items[1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(script)
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				result, err := pool.Run(context.Background(), map[string]interface{}{"n": n})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if result != object.Integer(2*n+1) {
+					errs <- fmt.Errorf("n=%d: wrong result %v", n, result)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if _, err := pool.Run(context.Background(), nil); err == nil || err.Error() != "missing parameter n" {
+		t.Errorf("expected missing parameter error, got %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		interp := newInterpreter(t, WithEngine(engine))
+		if _, err := interp.Eval(`let count = 0; let rate = 2; let old = true;`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := interp.Eval(`let count = count + 5;`); err != nil {
+			t.Fatal(err)
+		}
+		if err := interp.Reload(`let count = 0; let rate = 3; let added = "x";`); err != nil {
+			t.Fatalf("%v: %v", engine, err)
+		}
+		for name, expected := range map[string]object.Object{
+			"count": object.Integer(5),
+			"rate":  object.Integer(2),
+			"added": object.String("x"),
+		} {
+			if got, ok := interp.Get(name); !ok || got != expected {
+				t.Errorf("%v: wrong %s after Reload. want=%v, got=%v", engine, name, expected, got)
+			}
+		}
+		if got, ok := interp.Get("old"); ok {
+			t.Errorf("%v: expected old to be dropped, got %v", engine, got)
+		}
+
+		if err := interp.Reload(`let count = ;`); err == nil {
+			t.Errorf("%v: expected parse error", engine)
+		}
+		if got, _ := interp.Get("added"); got != object.String("x") {
+			t.Errorf("%v: failed Reload changed the interpreter, added=%v", engine, got)
+		}
+	}
+
+	interp := newInterpreter(t)
+	if _, err := interp.Eval(`let rule = fn(x) { x + 1 }; let hits = 7;`); err != nil {
+		t.Fatal(err)
+	}
+	if err := interp.Reload(`let rule = fn(x) { x * 10 }; let hits = 0;`); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := interp.Eval(`rule(hits)`); err != nil || got != object.Integer(70) {
+		t.Errorf("wrong result after reloading a function. want=70, got=%v, %v", got, err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	geo := func() *object.Builtins {
+		b := &object.Builtins{}
+		b.Register("origin", func(args ...object.Object) object.Object { return object.Integer(0) })
+		b.RegisterWithCapabilities("lookup", object.CapNetwork, func(args ...object.Object) object.Object {
+			return object.String("somewhere")
+		})
+		return b
+	}
+	t.Setenv(stdlib.PathEnv, "")
+	lib := fstest.MapFS{
+		"utils.monkey":   {Data: []byte(`let double = fn(x) { x * 2 }; let answer = double(21);`)},
+		"strings.monkey": {Data: []byte(`let upper = 1;`)},
+	}
+	extra := fstest.MapFS{
+		"extra.monkey": {Data: []byte(`let n = 1;`)},
+		"utils.monkey": {Data: []byte(`let answer = 0;`)},
+	}
+	tests := []struct {
+		opts     []Option
+		input    string
+		expected object.Object
+		err      string
+	}{
+		{nil, `let s = import "strings"; s.upper("abc")`, object.String("ABC"), ""},
+		{nil, `(import "math").floor(2.5)`, object.Float(2), ""},
+		{nil, `import "nope"`, nil, `line 1: no module named "nope"`},
+		{[]Option{WithModule("geo", geo)}, `import "geo".lookup("x")`, object.String("somewhere"), ""},
+		{[]Option{WithModule("geo", geo), WithoutCapabilities(object.CapNetwork)}, `import "geo".lookup`, object.Null{}, ""},
+		{[]Option{WithForbiddenSyntax(token.IMPORT)}, `import "strings"`, nil, `line 1: "import" is not allowed`},
+		{[]Option{WithModuleFS("lib", lib)}, `import "utils".answer`, object.Integer(42), ""},
+		{[]Option{WithModuleFS("lib", lib)}, `import "strings".upper("a")`, object.String("A"), ""},
+		{[]Option{WithModuleFS("lib", lib), WithModuleFS("extra", extra)}, `import "extra".n`, object.Integer(1), ""},
+		{[]Option{WithModuleFS("extra", extra), WithModuleFS("lib", lib)}, `import "utils".answer`, object.Integer(0), ""},
+		{[]Option{WithModuleFS("lib", lib), WithModuleFS("extra", extra)}, `import "nope"`, nil, `line 1: no module named "nope", searched: lib/nope.monkey, extra/nope.monkey`},
+	}
+	for _, tt := range tests {
+		for _, engine := range []Engine{EngineEval, EngineVM} {
+			interp := newInterpreter(t, append(tt.opts, WithEngine(engine))...)
+			result, err := interp.Eval(tt.input)
+			switch {
+			case tt.err != "":
+				if err == nil || err.Error() != tt.err {
+					t.Errorf("%v: %q: expected error %q, got %v", engine, tt.input, tt.err, err)
+				}
+			case err != nil:
+				t.Errorf("%v: %q: unexpected error %v", engine, tt.input, err)
+			case result != tt.expected:
+				t.Errorf("%v: %q: wrong result. want=%v, got=%v", engine, tt.input, tt.expected, result)
+			}
+		}
+	}
+
+	if _, err := New(WithModule("geo", nil)); err == nil {
+		t.Error("expected error for a nil module builder")
+	}
+	if _, err := New(WithModuleFS("lib", nil)); err == nil {
+		t.Error("expected error for a nil module file system")
+	}
+}
+
+func TestTemplateTasks(t *testing.T) {
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		interp := newInterpreter(t, WithEngine(engine), WithLimits(sandbox.Limits{MaxSteps: 1000000}))
+		// Tasks rendering at once count against the same limits.
+		got, err := interp.Eval(`let t = import "template";
+			let xs = []; let i = 0; while (i < 200) { xs = push(xs, i); i = i + 1 }
+			let tmpl = "{% for x in xs %}{{ x % 10 }}{% endfor %}";
+			let want = t.render(tmpl, {"xs": xs});
+			let got = await all([spawn(t.render, tmpl, {"xs": xs}), spawn(t.render, tmpl, {"xs": xs}), spawn(t.render, tmpl, {"xs": xs})]);
+			[want, got]`)
+		if err != nil {
+			t.Fatalf("%v: %v", engine, err)
+		}
+		want := (*got.(*object.Array))[0]
+		expected := fmt.Sprintf("[%q, %[1]q, %[1]q]", want)
+		if len(want.(object.String)) != 200 || (*got.(*object.Array))[1].Inspect() != expected {
+			t.Errorf("%v: expected every task to render %q, got %v", engine, want, got)
+		}
+	}
+}
+
+func TestTemplateSandbox(t *testing.T) {
+	for _, engine := range []Engine{EngineEval, EngineVM} {
+		interp := newInterpreter(t, WithEngine(engine), WithOnlyBuiltins("len"),
+			WithLimits(sandbox.Limits{MaxSteps: 1000}), WithTruthiness(object.ExtendedTruthiness))
+		_, err := interp.Eval(`import "template".render("{{ puts(1) }}", {})`)
+		if err == nil || !strings.Contains(err.Error(), "identifier not found: puts") {
+			t.Errorf("%v: expected puts to be unavailable, got %v", engine, err)
+		}
+		_, err = interp.Eval(`import "template".render("{{ while (true) { 1 } }}", {})`)
+		if !errors.Is(err, sandbox.ErrStepLimit) {
+			t.Errorf("%v: expected %v, got %v", engine, sandbox.ErrStepLimit, err)
+		}
+		got, err := interp.Eval(`import "template".render("{% if 0 %}yes{% else %}no{% endif %}", {})`)
+		if err != nil || got != object.String("no") {
+			t.Errorf("%v: expected the interpreter's truthiness, got %v (%v)", engine, got, err)
+		}
+		// Each render stays within the limit, but together they do not.
+		_, err = interp.Eval(`let r = import "template".render; let i = 0;
+			while (i < 20) { r("{{ fn() { let j = 0; while (j < 40) { j = j + 1; } j }() }}", {}); i = i + 1 }`)
+		if !errors.Is(err, sandbox.ErrStepLimit) {
+			t.Errorf("%v: expected renders to share the step limit, got %v", engine, err)
+		}
+		got, err = newInterpreter(t, WithEngine(engine)).Eval(`import "template".render("{{ import \"strings\".upper(\"a\") }}", {})`)
+		if err != nil || got != object.String("A") {
+			t.Errorf("%v: expected templates to import modules, got %v (%v)", engine, got, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		interp = newInterpreter(t, WithEngine(engine))
+		_, err = interp.EvalContext(ctx, `import "template".render("{{ while (true) { 1 } }}", {})`)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%v: expected the render to hit the deadline, got %v", engine, err)
+		}
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var out strings.Builder
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	interp := newInterpreter(t, WithLogger(logger))
+	if _, err := interp.Eval(`let log = import "log"; log.info("hidden"); log.error("failed", {"code": 7})`); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); strings.Contains(got, "hidden") || !strings.Contains(got, `level=ERROR msg=failed code=7`) {
+		t.Errorf("wrong log output: %q", got)
+	}
+}
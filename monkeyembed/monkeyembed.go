@@ -0,0 +1,100 @@
+// Package monkeyembed prepares monkey scripts embedded in a Go binary.
+//
+// Scripts are typically embedded with go:embed and compiled when the
+// program initializes, so that syntax errors are caught at startup:
+//
+//	//go:embed rules/*.monkey
+//	var rulesFS embed.FS
+//
+//	var rules = monkeyembed.MustCompileFS(rulesFS, "rules/*.monkey")
+//
+//	func discount(ctx context.Context, total int) (object.Object, error) {
+//		return rules.Lookup("rules/discount").RunContext(ctx, map[string]interface{}{"total": total})
+//	}
+package monkeyembed
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/ajwerner/monkey"
+)
+
+// Ext is the file extension of monkey scripts.
+const Ext = ".monkey"
+
+// Scripts is a set of compiled scripts indexed by their paths in their file
+// system with Ext removed.
+type Scripts struct {
+	byName map[string]*monkey.Script
+}
+
+// CompileFS compiles every file in fsys matching pattern, as interpreted by
+// fs.Glob, with an Interpreter configured by opts, so that the scripts
+// share its builtins, limits and policies. The identifiers a script uses
+// without defining are its parameters, as for Interpreter.Compile. It is an
+// error for pattern to match no files, or for a file not to compile.
+func CompileFS(fsys fs.FS, pattern string, opts ...monkey.Option) (*Scripts, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("monkeyembed: pattern %q matched no files", pattern)
+	}
+	in, err := monkey.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("monkeyembed: %w", err)
+	}
+	s := &Scripts{byName: make(map[string]*monkey.Script, len(paths))}
+	for _, path := range paths {
+		src, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		script, err := in.CompileContext(context.Background(), string(src))
+		if err != nil {
+			return nil, fmt.Errorf("monkeyembed: %s: %w", path, err)
+		}
+		s.byName[strings.TrimSuffix(path, Ext)] = script
+	}
+	return s, nil
+}
+
+// MustCompileFS is like CompileFS but panics on error. It is intended for
+// initializing package-level variables.
+func MustCompileFS(fsys fs.FS, pattern string, opts ...monkey.Option) *Scripts {
+	s, err := CompileFS(fsys, pattern, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Get returns the script with the given name.
+func (s *Scripts) Get(name string) (*monkey.Script, bool) {
+	script, ok := s.byName[name]
+	return script, ok
+}
+
+// Lookup returns the script with the given name and panics if there is none.
+func (s *Scripts) Lookup(name string) *monkey.Script {
+	script, ok := s.byName[name]
+	if !ok {
+		panic(fmt.Sprintf("monkeyembed: no script named %q", name))
+	}
+	return script
+}
+
+// Names returns the sorted names of the scripts.
+func (s *Scripts) Names() []string {
+	names := make([]string, 0, len(s.byName))
+	for name := range s.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,72 @@
+package monkeyembed
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ajwerner/monkey"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+)
+
+var testFS = fstest.MapFS{
+	"rules/double.monkey": {Data: []byte("let doubled = x * 2; doubled;")},
+	"rules/greet.monkey":  {Data: []byte(`"hello " + "world"`)},
+	"rules/fail.monkey":   {Data: []byte(`1 + "a"`)},
+	"broken/bad.monkey":   {Data: []byte("let = ;")},
+	"loop/spin.monkey":    {Data: []byte("while (true) { 1 }")},
+}
+
+func TestCompileFS(t *testing.T) {
+	scripts := MustCompileFS(testFS, "rules/*.monkey")
+	expectedNames := []string{"rules/double", "rules/fail", "rules/greet"}
+	if names := scripts.Names(); !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("wrong names. want=%v, got=%v", expectedNames, names)
+	}
+
+	double := scripts.Lookup("rules/double")
+	if params := double.Params(); !reflect.DeepEqual(params, []string{"x"}) {
+		t.Errorf("wrong params. want=[x], got=%v", params)
+	}
+	result, err := double.RunContext(context.Background(), map[string]interface{}{"x": 21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != object.Integer(42) {
+		t.Errorf("wrong result. want=42, got=%v", result)
+	}
+
+	_, err = scripts.Lookup("rules/fail").Run(nil)
+	var rerr *monkey.RuntimeError
+	if !errors.As(err, &rerr) || err.Error() != "line 1: type mismatch: INTEGER + STRING" {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	if _, ok := scripts.Get("rules/missing"); ok {
+		t.Errorf("expected no script named rules/missing")
+	}
+}
+
+func TestCompileFSOptions(t *testing.T) {
+	scripts := MustCompileFS(testFS, "loop/*.monkey", monkey.WithLimits(sandbox.Limits{MaxSteps: 1000}))
+	_, err := scripts.Lookup("loop/spin").Run(nil)
+	if !errors.Is(err, sandbox.ErrStepLimit) {
+		t.Errorf("expected the interpreter's step limit to stop the script, got %v", err)
+	}
+}
+
+func TestCompileFSErrors(t *testing.T) {
+	for pattern, expected := range map[string]string{
+		"broken/*.monkey": "monkeyembed: broken/bad.monkey: line 1: expected next token",
+		"none/*.monkey":   `monkeyembed: pattern "none/*.monkey" matched no files`,
+	} {
+		_, err := CompileFS(testFS, pattern)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%s: expected error %q, got %v", pattern, expected, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package monkeyerr is the model of diagnostics shared by every phase of
+// running a program, from lexing to evaluation, so that the REPL and the
+// command line render the problems found alike and hosts can filter them by
+// phase and severity:
+//
+//	for _, d := range monkeyerr.From(err) {
+//		if d.Phase == monkeyerr.PhaseParse {
+//			...
+//		}
+//	}
+//
+// The error types of the lexer, parser, types, compiler and object packages
+// implement Diagnoser.
+package monkeyerr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Phase is the phase of running a program which found a problem.
+type Phase int
+
+const (
+	PhaseUnknown Phase = iota
+	PhaseLex
+	PhaseParse
+	PhaseCheck
+	PhaseCompile
+	PhaseRun
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseUnknown:
+		return ""
+	case PhaseLex:
+		return "lex"
+	case PhaseParse:
+		return "parse"
+	case PhaseCheck:
+		return "type"
+	case PhaseCompile:
+		return "compile"
+	case PhaseRun:
+		return "runtime"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+// Severity is how serious a problem is.
+type Severity int
+
+const (
+	// SeverityError is a problem which stops the program from running.
+	SeverityError Severity = iota
+	// SeverityWarning is a problem which does not, such as a type mismatch
+	// found without strict checking.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Position locates a problem in the source of a program.
+type Position struct {
+	Line   int // 1-based, or 0 if unknown
+	Column int // 1-based, in runes, or 0 if unknown
+	Offset int // 0-based byte offset, meaningful only if Column is known
+}
+
+// String renders p as "line 3, column 5", "line 3", or "" if p is unknown.
+func (p Position) String() string {
+	switch {
+	case p.Line > 0 && p.Column > 0:
+		return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+	case p.Line > 0:
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return ""
+}
+
+// Diagnostic is a problem found in a program.
+type Diagnostic struct {
+	Phase    Phase
+	Severity Severity
+	Pos      Position
+	Msg      string
+	// Err is the error describing the problem, if any, for errors.Is and
+	// errors.As.
+	Err error
+}
+
+// String renders d as the REPL and the command line print it, for example
+// "line 3: parse error: expected next token to be ), got ; instead".
+func (d Diagnostic) String() string {
+	kind := d.Severity.String()
+	if p := d.Phase.String(); p != "" {
+		kind = p + " " + kind
+	}
+	if pos := d.Pos.String(); pos != "" {
+		return fmt.Sprintf("%s: %s: %s", pos, kind, d.Msg)
+	}
+	return fmt.Sprintf("%s: %s", kind, d.Msg)
+}
+
+// Diagnoser is implemented by errors which describe a problem in a program.
+type Diagnoser interface {
+	Diagnostic() Diagnostic
+}
+
+// From returns the diagnostics of err: one for each error joined by
+// errors.Join, which is the Diagnostic of the first Diagnoser in its chain
+// or, if there is none, an error of unknown phase.
+func From(err error) []Diagnostic {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var ds []Diagnostic
+		for _, err := range joined.Unwrap() {
+			ds = append(ds, From(err)...)
+		}
+		return ds
+	}
+	var d Diagnoser
+	if errors.As(err, &d) {
+		return []Diagnostic{d.Diagnostic()}
+	}
+	return []Diagnostic{{Severity: SeverityError, Msg: err.Error(), Err: err}}
+}
+
+// Fprint writes each of ds to w on a line of its own.
+func Fprint(w io.Writer, ds []Diagnostic) error {
+	for _, d := range ds {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package monkeyerr_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/monkeyerr"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/types"
+)
+
+func TestFrom(t *testing.T) {
+	runtime := &object.RuntimeError{Line: 4, Err: errors.New("identifier not found: x")}
+	err := errors.Join(
+		&lexer.Error{Line: 1, Column: 9, Offset: 8, Msg: "unterminated string"},
+		&parser.Error{Line: 2, Msg: "unexpected )"},
+		fmt.Errorf("wrapped: %w", &compiler.Error{Line: 3, Msg: "undefined variable x"}),
+		runtime,
+		context.Canceled,
+	)
+	expected := []string{
+		"line 1, column 9: lex error: unterminated string",
+		"line 2: parse error: unexpected )",
+		"line 3: compile error: undefined variable x",
+		"line 4: runtime error: identifier not found: x",
+		"error: context canceled",
+	}
+	ds := monkeyerr.From(err)
+	if len(ds) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), ds)
+	}
+	for i, d := range ds {
+		if got := d.String(); got != expected[i] {
+			t.Errorf("diagnostic %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+	if pos := ds[0].Pos; pos != (monkeyerr.Position{Line: 1, Column: 9, Offset: 8}) {
+		t.Errorf("wrong lex error position %+v", pos)
+	}
+	if ds[3].Phase != monkeyerr.PhaseRun || ds[3].Pos.Line != 4 || ds[3].Err != runtime {
+		t.Errorf("wrong runtime diagnostic %+v", ds[3])
+	}
+	if ds := monkeyerr.From(nil); ds != nil {
+		t.Errorf("expected no diagnostics for nil, got %v", ds)
+	}
+
+	info := &types.Info{Warnings: []*types.Error{{Line: 5, Msg: "type mismatch: int + string"}}}
+	var out strings.Builder
+	if err := monkeyerr.Fprint(&out, info.Diagnostics()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "line 5: type warning: type mismatch: int + string\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
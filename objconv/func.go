@@ -0,0 +1,95 @@
+package objconv
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Func wraps the Go function fn as a builtin. Arguments are converted with
+// ToGo into fn's parameter types and results with FromGo. If fn's last result
+// is an error, a non-nil error is returned to the script as an Error object.
+// Of the remaining results, none yields NULL, one yields its value and
+// several yield an ARRAY.
+func Func(fn interface{}) (object.BuiltinFunction, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("objconv: expected a function, got %T", fn)
+	}
+	return funcOf(v), nil
+}
+
+func funcOf(fn reflect.Value) object.BuiltinFunction {
+	t := fn.Type()
+	numIn := t.NumIn()
+	returnsErr := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	return func(args ...object.Object) object.Object {
+		if t.IsVariadic() && len(args) < numIn-1 {
+			return newError("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+		} else if !t.IsVariadic() && len(args) != numIn {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var pt reflect.Type
+			if t.IsVariadic() && i >= numIn-1 {
+				pt = t.In(numIn - 1).Elem()
+			} else {
+				pt = t.In(i)
+			}
+			in[i] = reflect.New(pt).Elem()
+			if err := toGo(arg, in[i], fmt.Sprintf("argument %d", i+1)); err != nil {
+				return object.Error{Err: err}
+			}
+		}
+		out := fn.Call(in)
+		if returnsErr {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return object.Error{Err: err}
+			}
+			out = out[:len(out)-1]
+		}
+		switch len(out) {
+		case 0:
+			return object.Null{}
+		case 1:
+			obj, err := fromGo(out[0], "")
+			if err != nil {
+				return object.Error{Err: err}
+			}
+			return obj
+		}
+		results := make(object.Array, len(out))
+		for i, r := range out {
+			obj, err := fromGo(r, fmt.Sprintf("[%d]", i))
+			if err != nil {
+				return object.Error{Err: err}
+			}
+			results[i] = obj
+		}
+		return &results
+	}
+}
+
+// Methods returns a HASH mapping the names of the exported methods of v to
+// builtins that call them, as with Func. Methods with pointer receivers are
+// included only if v is a pointer.
+func Methods(v interface{}) (object.Hash, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return object.Hash{}, fmt.Errorf("objconv: cannot bind methods of nil")
+	}
+	t := rv.Type()
+	methods := object.NewHash(t.NumMethod())
+	for i := 0; i < t.NumMethod(); i++ {
+		methods.Set(object.String(t.Method(i).Name), &object.Builtin{Fn: funcOf(rv.Method(i))})
+	}
+	return methods, nil
+}
+
+func newError(format string, a ...interface{}) object.Error {
+	return object.Error{Err: fmt.Errorf(format, a...)}
+}
@@ -0,0 +1,402 @@
+// Package objconv converts between Go values and monkey objects.
+//
+// The conversions are:
+//
+//	Go                            monkey
+//	nil, nil pointer              NULL
+//	bool                          BOOL
+//	signed and unsigned integers  INTEGER
+//	float32, float64              FLOAT
+//	string, []byte                STRING
+//	slices and arrays             ARRAY
+//	maps                          HASH with sorted keys
+//	structs                       HASH keyed by field name
+//
+// Struct fields may be renamed with a `monkey:"name"` tag and skipped with
+// `monkey:"-"`. Unexported fields are ignored. FromGo does not detect cycles.
+//
+// ToGo unwraps an EXTERNAL into any target its value is assignable to.
+package objconv
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	bytesType  = reflect.TypeOf([]byte(nil))
+)
+
+// FromGo converts v into a monkey object. Values which are already objects
+// are returned unchanged.
+func FromGo(v interface{}) (object.Object, error) {
+	return fromGo(reflect.ValueOf(v), "")
+}
+
+func fromGo(v reflect.Value, path string) (object.Object, error) {
+	if !v.IsValid() {
+		return object.Null{}, nil
+	}
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil() {
+			return object.Null{}, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return object.Bool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return object.Integer(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, errorf(path, "%d overflows INTEGER", v.Uint())
+		}
+		return object.Integer(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return object.Float(v.Float()), nil
+	case reflect.String:
+		return object.String(v.String()), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return object.Null{}, nil
+		}
+		return fromGo(v.Elem(), path)
+	case reflect.Slice:
+		if v.Type() == bytesType {
+			return object.String(v.Bytes()), nil
+		}
+		if v.IsNil() {
+			return object.Null{}, nil
+		}
+		return fromGoArray(v, path)
+	case reflect.Array:
+		return fromGoArray(v, path)
+	case reflect.Map:
+		if v.IsNil() {
+			return object.Null{}, nil
+		}
+		type pair struct{ key, val object.Object }
+		pairs := make([]pair, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			keyPath := fmt.Sprintf("%s[%v]", path, iter.Key())
+			key, err := fromGo(iter.Key(), keyPath)
+			if err != nil {
+				return nil, err
+			}
+			if !object.Hashable(key) {
+				return nil, errorf(keyPath, "unusable as hash key: %s", key.Type())
+			}
+			val, err := fromGo(iter.Value(), keyPath)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, pair{key, val})
+		}
+		// A Go map has no order, so sort the keys for deterministic output.
+		slices.SortFunc(pairs, func(a, b pair) int {
+			return compareKeys(a.key, b.key)
+		})
+		h := object.NewHash(len(pairs))
+		for _, p := range pairs {
+			h.Set(p.key, p.val)
+		}
+		return h, nil
+	case reflect.Struct:
+		h := object.NewHash(v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := fieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			val, err := fromGo(v.Field(i), path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			h.Set(object.String(name), val)
+		}
+		return h, nil
+	default:
+		return nil, errorf(path, "cannot convert %s", v.Type())
+	}
+}
+
+func fromGoArray(v reflect.Value, path string) (object.Object, error) {
+	arr := make(object.Array, v.Len())
+	for i := range arr {
+		el, err := fromGo(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = el
+	}
+	return &arr, nil
+}
+
+// ToGo stores obj in the value pointed to by target, which must be a non-nil
+// pointer. Integers widen to floats and narrow to smaller integer types when
+// they fit. When target points to an empty interface, obj is converted to
+// int64, float64, string, bool, nil, []interface{} or, for hashes,
+// map[string]interface{} if every key is a string and map[interface{}]interface{}
+// otherwise. When target points to a monkey object type, such as object.Hash,
+// obj is stored as it is if it has that type.
+func ToGo(obj object.Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("objconv: target must be a non-nil pointer, got %T", target)
+	}
+	return toGo(obj, v.Elem(), "")
+}
+
+func toGo(obj object.Object, v reflect.Value, path string) error {
+	if obj == nil {
+		obj = object.Null{}
+	}
+	if v.Type() == objectType {
+		v.Set(reflect.ValueOf(&obj).Elem())
+		return nil
+	}
+	if ov := reflect.ValueOf(obj); v.Type().Implements(objectType) && ov.Type().AssignableTo(v.Type()) {
+		v.Set(ov)
+		return nil
+	}
+	if ext, ok := obj.(*object.External); ok && ext.Value != nil {
+		if ev := reflect.ValueOf(ext.Value); ev.Type().AssignableTo(v.Type()) {
+			v.Set(ev)
+			return nil
+		}
+	}
+	if _, isNull := obj.(object.Null); isNull {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			break
+		}
+		natural, err := toNatural(obj, path)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(natural))
+		}
+		return nil
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err := toGo(obj, p.Elem(), path); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	case reflect.Bool:
+		if b, ok := obj.(object.Bool); ok {
+			v.SetBool(bool(b))
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(object.Integer); ok {
+			if v.OverflowInt(int64(i)) {
+				return errorf(path, "%d overflows %s", i, v.Type())
+			}
+			v.SetInt(int64(i))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(object.Integer); ok {
+			if i < 0 || v.OverflowUint(uint64(i)) {
+				return errorf(path, "%d overflows %s", i, v.Type())
+			}
+			v.SetUint(uint64(i))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch f := obj.(type) {
+		case object.Float:
+			v.SetFloat(float64(f))
+			return nil
+		case object.Integer:
+			v.SetFloat(float64(f))
+			return nil
+		}
+	case reflect.String:
+		if s, ok := obj.(object.String); ok {
+			v.SetString(string(s))
+			return nil
+		}
+	case reflect.Slice:
+		if s, ok := obj.(object.String); ok && v.Type() == bytesType {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		if arr, ok := asArray(obj); ok {
+			s := reflect.MakeSlice(v.Type(), len(arr), len(arr))
+			for i, el := range arr {
+				if err := toGo(el, s.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			v.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		if arr, ok := asArray(obj); ok {
+			if len(arr) != v.Len() {
+				return errorf(path, "cannot convert ARRAY of length %d to %s", len(arr), v.Type())
+			}
+			for i, el := range arr {
+				if err := toGo(el, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if h, ok := obj.(object.Hash); ok {
+			m := reflect.MakeMapWithSize(v.Type(), h.Len())
+			for key, val := range h.All() {
+				keyPath := fmt.Sprintf("%s[%s]", path, key.Inspect())
+				k := reflect.New(v.Type().Key()).Elem()
+				if err := toGo(key, k, keyPath); err != nil {
+					return err
+				}
+				e := reflect.New(v.Type().Elem()).Elem()
+				if err := toGo(val, e, keyPath); err != nil {
+					return err
+				}
+				m.SetMapIndex(k, e)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if h, ok := obj.(object.Hash); ok {
+			for i := 0; i < v.NumField(); i++ {
+				name, ok := fieldName(v.Type().Field(i))
+				if !ok {
+					continue
+				}
+				val, ok := h.Get(object.String(name))
+				if !ok {
+					continue
+				}
+				if err := toGo(val, v.Field(i), path+"."+name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return errorf(path, "cannot convert %s to %s", obj.Type(), v.Type())
+}
+
+// toNatural converts obj into the Go value used for empty interface targets.
+func toNatural(obj object.Object, path string) (interface{}, error) {
+	switch obj := obj.(type) {
+	case object.Null:
+		return nil, nil
+	case object.Bool:
+		return bool(obj), nil
+	case object.Integer:
+		return int64(obj), nil
+	case object.Float:
+		return float64(obj), nil
+	case object.String:
+		return string(obj), nil
+	case *object.External:
+		return obj.Value, nil
+	case object.Hash:
+		allStrings := true
+		for _, k := range obj.Keys() {
+			if _, ok := k.(object.String); !ok {
+				allStrings = false
+				break
+			}
+		}
+		var target interface{} = &map[interface{}]interface{}{}
+		if allStrings {
+			target = &map[string]interface{}{}
+		}
+		if err := toGo(obj, reflect.ValueOf(target).Elem(), path); err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(target).Elem().Interface(), nil
+	}
+	if arr, ok := asArray(obj); ok {
+		s := make([]interface{}, len(arr))
+		if err := toGo(obj, reflect.ValueOf(&s).Elem(), path); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, errorf(path, "cannot convert %s to a Go value", obj.Type())
+}
+
+// compareKeys orders hashable keys by type and then by value.
+func compareKeys(a, b object.Object) int {
+	if c := cmp.Compare(a.Type(), b.Type()); c != 0 {
+		return c
+	}
+	switch a := a.(type) {
+	case object.Integer:
+		return cmp.Compare(a, b.(object.Integer))
+	case object.String:
+		return cmp.Compare(a, b.(object.String))
+	case object.Bool:
+		if a == b.(object.Bool) {
+			return 0
+		} else if a {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+func asArray(obj object.Object) (object.Array, bool) {
+	switch arr := obj.(type) {
+	case *object.Array:
+		return *arr, true
+	case object.Array:
+		return arr, true
+	}
+	return nil, false
+}
+
+// fieldName returns the name of a struct field as seen by monkey programs
+// and whether the field is converted at all.
+func fieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := f.Tag.Get("monkey")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return f.Name, true
+}
+
+func errorf(path, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if path != "" {
+		return fmt.Errorf("objconv: %s: %s", strings.TrimPrefix(path, "."), msg)
+	}
+	return fmt.Errorf("objconv: %s", msg)
+}
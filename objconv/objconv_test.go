@@ -0,0 +1,198 @@
+package objconv
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+type user struct {
+	Name    string
+	Age     uint8 `monkey:"age"`
+	Tags    []string
+	Secret  string `monkey:"-"`
+	private int
+}
+
+func array(elems ...object.Object) *object.Array {
+	arr := object.Array(elems)
+	return &arr
+}
+
+func TestFromGo(t *testing.T) {
+	var nilPtr *user
+	tests := []struct {
+		input    interface{}
+		expected object.Object
+	}{
+		{nil, object.Null{}},
+		{nilPtr, object.Null{}},
+		{true, object.Bool(true)},
+		{int8(-3), object.Integer(-3)},
+		{uint32(7), object.Integer(7)},
+		{float32(1.5), object.Float(1.5)},
+		{"hi", object.String("hi")},
+		{[]byte("raw"), object.String("raw")},
+		{object.Integer(4), object.Integer(4)},
+		{[]int{1, 2}, array(object.Integer(1), object.Integer(2))},
+		{[2]bool{true, false}, array(object.Bool(true), object.Bool(false))},
+		{map[string]int{"a": 1}, object.HashOf(object.String("a"), object.Integer(1))},
+		{
+			map[string]int{"c": 3, "a": 1, "b": 2},
+			object.HashOf(object.String("a"), object.Integer(1), object.String("b"), object.Integer(2), object.String("c"), object.Integer(3)),
+		},
+		{map[int]bool{10: true, -1: false, 2: true}, object.HashOf(object.Integer(-1), object.Bool(false), object.Integer(2), object.Bool(true), object.Integer(10), object.Bool(true))},
+		{
+			&user{Name: "ann", Age: 30, Tags: []string{"x"}, Secret: "s", private: 1},
+			object.HashOf(
+				object.String("Name"), object.String("ann"),
+				object.String("age"), object.Integer(30),
+				object.String("Tags"), array(object.String("x")),
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v): unexpected error %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("FromGo(%#v) = %#v, want %#v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestFromGoErrors(t *testing.T) {
+	tests := []struct {
+		input interface{}
+		err   string
+	}{
+		{uint64(math.MaxUint64), "objconv: 18446744073709551615 overflows INTEGER"},
+		{map[float64]int{1.5: 1}, "objconv: [1.5]: unusable as hash key: FLOAT"},
+		{[]interface{}{1, func() {}}, "objconv: [1]: cannot convert func()"},
+	}
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("FromGo(%#v): expected error %q, got %v", tt.input, tt.err, err)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	var u user
+	err := ToGo(object.HashOf(
+		object.String("Name"), object.String("bob"),
+		object.String("age"), object.Integer(41),
+		object.String("Tags"), array(object.String("a"), object.String("b")),
+		object.String("Secret"), object.String("ignored"),
+	), &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedUser := user{Name: "bob", Age: 41, Tags: []string{"a", "b"}}
+	if !reflect.DeepEqual(u, expectedUser) {
+		t.Errorf("wrong struct. want=%+v, got=%+v", expectedUser, u)
+	}
+
+	var f float64
+	if err := ToGo(object.Integer(3), &f); err != nil || f != 3 {
+		t.Errorf("expected widening to 3, got %v (%v)", f, err)
+	}
+
+	var m map[int][]float32
+	if err := ToGo(object.HashOf(object.Integer(1), array(object.Float(0.5), object.Integer(2))), &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[int][]float32{1: {0.5, 2}}) {
+		t.Errorf("wrong map: %v", m)
+	}
+
+	var any interface{}
+	if err := ToGo(object.HashOf(object.String("k"), array(object.Integer(1), object.Null{})), &any); err != nil {
+		t.Fatal(err)
+	}
+	expectedAny := map[string]interface{}{"k": []interface{}{int64(1), nil}}
+	if !reflect.DeepEqual(any, expectedAny) {
+		t.Errorf("wrong interface value. want=%#v, got=%#v", expectedAny, any)
+	}
+
+	var h object.Hash
+	if err := ToGo(object.HashOf(object.Integer(1), object.Null{}), &h); err != nil || h.Len() != 1 {
+		t.Errorf("expected the hash to be stored as it is, got %v (%v)", h, err)
+	}
+
+	var p *int
+	if err := ToGo(object.Integer(5), &p); err != nil || p == nil || *p != 5 {
+		t.Errorf("expected pointer to 5, got %v (%v)", p, err)
+	}
+	if err := ToGo(object.Null{}, &p); err != nil || p != nil {
+		t.Errorf("expected nil pointer, got %v (%v)", p, err)
+	}
+
+	ext := &object.External{Value: &u}
+	var up *user
+	if err := ToGo(ext, &up); err != nil || up != &u {
+		t.Errorf("expected external value %p, got %p (%v)", &u, up, err)
+	}
+	if err := ToGo(ext, &any); err != nil || any != &u {
+		t.Errorf("expected external value %p, got %v (%v)", &u, any, err)
+	}
+}
+
+func TestToGoErrors(t *testing.T) {
+	var i8 int8
+	var n int
+	var s string
+	var tags []string
+	tests := []struct {
+		obj    object.Object
+		target interface{}
+		err    string
+	}{
+		{object.Integer(300), &i8, "objconv: 300 overflows int8"},
+		{object.Float(1.5), &n, "objconv: cannot convert FLOAT to int"},
+		{object.Integer(1), &s, "objconv: cannot convert INTEGER to string"},
+		{array(object.String("a"), object.Integer(1)), &tags, "objconv: [1]: cannot convert INTEGER to string"},
+		{object.Integer(1), n, "objconv: target must be a non-nil pointer, got int"},
+		{&object.External{Value: 1}, &s, "objconv: cannot convert EXTERNAL to string"},
+	}
+	for _, tt := range tests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("ToGo(%v): expected error %q, got %v", tt.obj, tt.err, err)
+		}
+	}
+}
+
+func TestFunc(t *testing.T) {
+	sum, err := Func(func(base float64, xs ...int) float64 {
+		for _, x := range xs {
+			base += float64(x)
+		}
+		return base
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sum(object.Float(0.5), object.Integer(1), object.Integer(2)); got != object.Float(3.5) {
+		t.Errorf("wrong result. want=3.5, got=%v", got)
+	}
+	if got, ok := sum().(object.Error); !ok || got.Err.Error() != "wrong number of arguments. got=0, want at least 1" {
+		t.Errorf("expected argument count error, got %v", got)
+	}
+
+	divmod, _ := Func(func(a, b int) (int, int) { return a / b, a % b })
+	expected := array(object.Integer(3), object.Integer(1))
+	if got := divmod(object.Integer(7), object.Integer(2)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong result. want=%v, got=%v", expected, got)
+	}
+
+	if _, err := Func(42); err == nil || err.Error() != "objconv: expected a function, got int" {
+		t.Errorf("expected error for non-function, got %v", err)
+	}
+}
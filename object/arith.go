@@ -0,0 +1,71 @@
+package object
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrDivisionByZero is the error of dividing an integer by zero, with the /,
+// ~/ or % operator. Dividing a float by zero follows IEEE 754.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// Divide returns the quotient of a and b as a float, which / evaluates to
+// for integer operands whether or not b divides a exactly.
+func Divide(a, b Integer) (Float, error) {
+	if b == 0 {
+		return 0, ErrDivisionByZero
+	}
+	return Float(a) / Float(b), nil
+}
+
+// Truncate returns a divided by b truncated towards zero, which / evaluates
+// to for integer operands without language feature division.
+func Truncate(a, b Integer) (Integer, error) {
+	if b == 0 {
+		return 0, ErrDivisionByZero
+	}
+	return a / b, nil
+}
+
+// FloorDivide returns the largest integer not greater than a divided by b,
+// which ~/ evaluates to for integer operands.
+func FloorDivide(a, b Integer) (Integer, error) {
+	if b == 0 {
+		return 0, ErrDivisionByZero
+	}
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q, nil
+}
+
+// FloorDivideFloat returns a divided by b rounded down, which ~/ evaluates
+// to when either operand is a float.
+func FloorDivideFloat(a, b Float) Float {
+	return Float(math.Floor(float64(a / b)))
+}
+
+// Modulo returns the remainder of the floor division of a by b, which %
+// evaluates to for integer operands. It has the sign of b, so that
+// a == (a ~/ b) * b + a % b.
+func Modulo(a, b Integer) (Integer, error) {
+	if b == 0 {
+		return 0, ErrDivisionByZero
+	}
+	r := a % b
+	if r != 0 && (r < 0) != (b < 0) {
+		r += b
+	}
+	return r, nil
+}
+
+// ModuloFloat returns the remainder of the floor division of a by b, which %
+// evaluates to when either operand is a float.
+func ModuloFloat(a, b Float) Float {
+	r := Float(math.Mod(float64(a), float64(b)))
+	if r != 0 && (r < 0) != (b < 0) {
+		r += b
+	}
+	return r
+}
@@ -0,0 +1,55 @@
+package object
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Arrays are immutable, so the array builtins share elements between their
+// arguments and results rather than copying them. A slice of an array shares
+// its backing array, and pushing onto an array appends in place when its
+// backing array has room after the last element which no other push has
+// claimed. A slot is claimed once it holds an element, so arrays must not
+// contain nil elements.
+
+// claimLocks serialize pushes which might claim the same slot, keyed by
+// the address of the slot.
+var claimLocks [64]sync.Mutex
+
+// Push returns a new array holding the elements of arr followed by elems.
+// It takes time proportional to len(elems) in the common case of pushing
+// onto the result of the last push onto the same array.
+func Push(arr Array, elems ...Object) *Array {
+	if len(elems) == 0 {
+		return &arr
+	}
+	if cap(arr)-len(arr) >= len(elems) && claim(arr, elems) {
+		grown := arr[:len(arr)+len(elems)]
+		return &grown
+	}
+	grown := make(Array, len(arr)+len(elems), 2*(len(arr)+len(elems)))
+	copy(grown, arr)
+	copy(grown[len(arr):], elems)
+	return &grown
+}
+
+// claim stores elems in the spare capacity of arr and reports whether it
+// could, which it cannot if another push onto arr already has.
+func claim(arr Array, elems []Object) bool {
+	spare := arr[len(arr) : len(arr)+len(elems)]
+	mu := &claimLocks[uintptr(unsafe.Pointer(&spare[0]))/unsafe.Sizeof(spare[0])%uintptr(len(claimLocks))]
+	mu.Lock()
+	defer mu.Unlock()
+	if spare[0] != nil {
+		return false
+	}
+	copy(spare, elems)
+	return true
+}
+
+// Slice returns the elements of arr from index lo up to but excluding hi,
+// sharing them with arr.
+func Slice(arr Array, lo, hi int) *Array {
+	s := arr[lo:hi]
+	return &s
+}
@@ -0,0 +1,1245 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Builtins is an ordered table of builtin functions. The position of a
+// builtin is its index in the compiler's builtin scope and the operand of
+// OpGetBuiltin, so a table must not be modified between compiling a program
+// and running it. The zero value is an empty table.
+type Builtins struct {
+	names []string
+	fns   []*Builtin
+	caps  []Capability
+	index map[string]int
+}
+
+// Capability is a set of host resources which a builtin may access.
+type Capability uint
+
+const (
+	CapFile Capability = 1 << iota
+	CapNetwork
+	CapExec
+
+	// CapIO is every capability.
+	CapIO = CapFile | CapNetwork | CapExec
+)
+
+// NewBuiltins returns a table holding the standard builtins.
+func NewBuiltins() *Builtins {
+	b := &Builtins{index: map[string]int{}}
+	for _, def := range standardBuiltins {
+		b.Register(def.name, def.fn)
+	}
+	for _, def := range contextBuiltins {
+		b.RegisterContext(def.name, 0, def.fn)
+	}
+	return b
+}
+
+// Register adds a builtin named name, replacing any existing builtin of that
+// name in place.
+func (b *Builtins) Register(name string, fn BuiltinFunction) {
+	b.RegisterWithCapabilities(name, 0, fn)
+}
+
+// RegisterWithCapabilities is like Register for a builtin which accesses the
+// host resources in caps.
+func (b *Builtins) RegisterWithCapabilities(name string, caps Capability, fn BuiltinFunction) {
+	b.set(name, caps, &Builtin{Fn: fn})
+}
+
+// RegisterContext is like RegisterWithCapabilities for a builtin which calls
+// back into the engine running it.
+func (b *Builtins) RegisterContext(name string, caps Capability, fn ContextFunction) {
+	b.set(name, caps, &Builtin{ContextFn: fn})
+}
+
+func (b *Builtins) set(name string, caps Capability, fn *Builtin) {
+	if i, ok := b.index[name]; ok {
+		b.fns[i] = fn
+		b.caps[i] = caps
+		return
+	}
+	if b.index == nil {
+		b.index = map[string]int{}
+	}
+	b.index[name] = len(b.names)
+	b.names = append(b.names, name)
+	b.fns = append(b.fns, fn)
+	b.caps = append(b.caps, caps)
+}
+
+// Remove removes the builtin named name, if any. The indexes of the builtins
+// after it shift down by one.
+func (b *Builtins) Remove(name string) {
+	i, ok := b.index[name]
+	if !ok {
+		return
+	}
+	b.names = append(b.names[:i], b.names[i+1:]...)
+	b.fns = append(b.fns[:i], b.fns[i+1:]...)
+	b.caps = append(b.caps[:i], b.caps[i+1:]...)
+	delete(b.index, name)
+	for j := i; j < len(b.names); j++ {
+		b.index[b.names[j]] = j
+	}
+}
+
+// RemoveIO removes the builtins which access the host's files, network or
+// processes. Output from puts is not considered IO.
+func (b *Builtins) RemoveIO() {
+	b.Restrict(CapIO)
+}
+
+// Restrict removes the builtins which require any of caps.
+func (b *Builtins) Restrict(caps Capability) {
+	for _, name := range b.Names() {
+		if b.caps[b.index[name]]&caps != 0 {
+			b.Remove(name)
+		}
+	}
+}
+
+// Keep removes every builtin not named in names.
+func (b *Builtins) Keep(names ...string) {
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	for _, name := range b.Names() {
+		if !keep[name] {
+			b.Remove(name)
+		}
+	}
+}
+
+// Capabilities returns the capabilities required by the builtin named name.
+func (b *Builtins) Capabilities(name string) Capability {
+	if i, ok := b.index[name]; ok {
+		return b.caps[i]
+	}
+	return 0
+}
+
+// SetOutput directs the output of the standard output builtins, such as puts,
+// to w, replacing those which remain in b. A nil w means os.Stdout. Writes
+// to w are serialized with SyncWriter, so that tasks may print concurrently.
+func (b *Builtins) SetOutput(w io.Writer) {
+	if w == nil {
+		w = stdout
+	}
+	for name, fn := range outputBuiltins(SyncWriter(w)) {
+		if _, ok := b.index[name]; ok {
+			b.RegisterWithCapabilities(name, b.Capabilities(name), fn)
+		}
+	}
+}
+
+// Lookup returns the builtin named name.
+func (b *Builtins) Lookup(name string) (*Builtin, bool) {
+	i, ok := b.index[name]
+	if !ok {
+		return nil, false
+	}
+	return b.fns[i], true
+}
+
+// Index returns the position of the builtin named name.
+func (b *Builtins) Index(name string) (int, bool) {
+	i, ok := b.index[name]
+	return i, ok
+}
+
+// At returns the builtin at position i.
+func (b *Builtins) At(i int) *Builtin { return b.fns[i] }
+
+// Len returns the number of builtins in the table.
+func (b *Builtins) Len() int { return len(b.names) }
+
+// Names returns the names of the builtins in index order.
+func (b *Builtins) Names() []string {
+	return append([]string(nil), b.names...)
+}
+
+// Clone returns a copy of b which may be modified independently.
+func (b *Builtins) Clone() *Builtins {
+	c := &Builtins{
+		names: append([]string(nil), b.names...),
+		fns:   append([]*Builtin(nil), b.fns...),
+		caps:  append([]Capability(nil), b.caps...),
+		index: make(map[string]int, len(b.index)),
+	}
+	for name, i := range b.index {
+		c.index[name] = i
+	}
+	return c
+}
+
+// outputBuiltins returns the standard builtins which write output, writing
+// to w.
+func outputBuiltins(w io.Writer) map[string]BuiltinFunction {
+	return map[string]BuiltinFunction{
+		"puts":  puts(w),
+		"print": printBuiltin(w),
+	}
+}
+
+// stdout is the output of the standard builtins which SetOutput has not
+// redirected, shared so that their writes are serialized.
+var stdout = SyncWriter(nil)
+
+// puts returns the puts builtin writing to w. The lines of a call are
+// written at once, so that they do not interleave with those of other tasks.
+func puts(w io.Writer) BuiltinFunction {
+	return func(args ...Object) Object {
+		var buf bytes.Buffer
+		for _, arg := range args {
+			Fprint(&buf, arg)
+			buf.WriteByte('\n')
+		}
+		w.Write(buf.Bytes())
+
+		return Null{}
+	}
+}
+
+// printBuiltin returns the print builtin, which writes its arguments as puts
+// does but separated by spaces and without a trailing newline, to w.
+func printBuiltin(w io.Writer) BuiltinFunction {
+	return func(args ...Object) Object {
+		var buf bytes.Buffer
+		for i, arg := range args {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			Fprint(&buf, arg)
+		}
+		w.Write(buf.Bytes())
+
+		return Null{}
+	}
+}
+
+var standardBuiltins = []struct {
+	name string
+	fn   BuiltinFunction
+}{
+	{
+		"len",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				return Integer(len(*arg))
+			case String:
+				return Integer(len(arg))
+			default:
+				return newError("argument to `len` not supported, got %s",
+					args[0].Type())
+			}
+		},
+	},
+	{
+		"puts",
+		puts(stdout),
+	},
+	{
+		"print",
+		printBuiltin(stdout),
+	},
+	{
+		"first",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY {
+				return newError("argument to `first` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(*arr) > 0 {
+				return (*arr)[0]
+			}
+			return Null{}
+		},
+	},
+	{
+		"last",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY {
+				return newError("argument to `last` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			length := len(*arr)
+			if length > 0 {
+				return (*arr)[length-1]
+			}
+
+			return Null{}
+		},
+	},
+	{
+		"rest",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY {
+				return newError("argument to `rest` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			arr := *args[0].(*Array)
+			length := len(arr)
+			if length > 0 {
+				return Slice(arr, 1, length)
+			}
+
+			return Null{}
+		},
+	},
+	{
+		"push",
+		func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			if args[0].Type() != ARRAY {
+				return newError("argument to `push` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			return Push(*args[0].(*Array), args[1])
+		},
+	},
+	{
+		"pop",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY {
+				return newError("argument to `pop` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			arr := *args[0].(*Array)
+			length := len(arr)
+			if length > 0 {
+				return Slice(arr, 0, length-1)
+			}
+
+			return Null{}
+		},
+	},
+	{
+		"reverse",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY {
+				return newError("argument to `reverse` must be ARRAY, got %s",
+					args[0].Type())
+			}
+
+			arr := *args[0].(*Array)
+			reversed := make(Array, len(arr))
+			for i, el := range arr {
+				reversed[len(arr)-1-i] = el
+			}
+			return &reversed
+		},
+	},
+	{
+		"concat",
+		func(args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want at least 1",
+					len(args))
+			}
+			var elems Array
+			for i, arg := range args {
+				if arg.Type() != ARRAY {
+					return newError("argument %d to `concat` must be ARRAY, got %s",
+						i+1, arg.Type())
+				}
+				if i > 0 {
+					elems = append(elems, *arg.(*Array)...)
+				}
+			}
+
+			return Push(*args[0].(*Array), elems...)
+		},
+	},
+	{
+		"keys",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			h, ok := args[0].(Hash)
+			if !ok {
+				return newError("argument to `keys` must be HASH, got %s",
+					args[0].Type())
+			}
+
+			keys := append(Array(nil), h.Keys()...)
+			return &keys
+		},
+	},
+	{
+		"values",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			h, ok := args[0].(Hash)
+			if !ok {
+				return newError("argument to `values` must be HASH, got %s",
+					args[0].Type())
+			}
+
+			values := make(Array, 0, h.Len())
+			for _, v := range h.All() {
+				values = append(values, v)
+			}
+			return &values
+		},
+	},
+	{
+		"hasKey",
+		func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			h, ok := args[0].(Hash)
+			if !ok {
+				return newError("first argument to `hasKey` must be HASH, got %s",
+					args[0].Type())
+			}
+			if !Hashable(args[1]) {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			_, ok = h.Get(args[1])
+			return Bool(ok)
+		},
+	},
+	{
+		"delete",
+		func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			h, ok := args[0].(Hash)
+			if !ok {
+				return newError("first argument to `delete` must be HASH, got %s",
+					args[0].Type())
+			}
+			if !Hashable(args[1]) {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+
+			deleted := NewHash(h.Len())
+			for k, v := range h.All() {
+				if k != args[1] {
+					deleted.Set(k, v)
+				}
+			}
+			return deleted
+		},
+	},
+	{
+		"merge",
+		func(args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want at least 1",
+					len(args))
+			}
+			merged := Hash{}
+			for i, arg := range args {
+				h, ok := arg.(Hash)
+				if !ok {
+					return newError("argument %d to `merge` must be HASH, got %s",
+						i+1, arg.Type())
+				}
+				for k, v := range h.All() {
+					merged.Set(k, v)
+				}
+			}
+			return merged
+		},
+	},
+	{
+		"type",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return String(args[0].Type().String())
+		},
+	},
+	{
+		"int",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			switch arg := args[0].(type) {
+			case Integer:
+				return arg
+			case Float:
+				f := math.Trunc(float64(arg))
+				if !(f >= math.MinInt64 && f < math.MaxInt64) {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return Integer(f)
+			case Bool:
+				if arg {
+					return Integer(1)
+				}
+				return Integer(0)
+			case String:
+				i, err := strconv.ParseInt(string(arg), 10, 64)
+				if err != nil {
+					return newError("cannot convert %q to INTEGER", string(arg))
+				}
+				return Integer(i)
+			default:
+				return newError("argument to `int` not supported, got %s",
+					args[0].Type())
+			}
+		},
+	},
+	{
+		"float",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			switch arg := args[0].(type) {
+			case Integer:
+				return Float(arg)
+			case Float:
+				return arg
+			case String:
+				f, err := strconv.ParseFloat(string(arg), 64)
+				if err != nil {
+					return newError("cannot convert %q to FLOAT", string(arg))
+				}
+				return Float(f)
+			default:
+				return newError("argument to `float` not supported, got %s",
+					args[0].Type())
+			}
+		},
+	},
+	{
+		"str",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return String(args[0].Inspect())
+		},
+	},
+	{
+		"split",
+		stringsBuiltin("split", 2, func(args []string) Object {
+			parts := strings.Split(args[0], args[1])
+			arr := make(Array, len(parts))
+			for i, part := range parts {
+				arr[i] = String(part)
+			}
+			return &arr
+		}),
+	},
+	{
+		"join",
+		func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			arr, ok := args[0].(*Array)
+			if !ok {
+				return newError("argument 1 to `join` must be ARRAY, got %s",
+					args[0].Type())
+			}
+			sep, ok := args[1].(String)
+			if !ok {
+				return newError("argument 2 to `join` must be STRING, got %s",
+					args[1].Type())
+			}
+			elems := make([]string, len(*arr))
+			for i, el := range *arr {
+				s, ok := el.(String)
+				if !ok {
+					return newError("element %d of the array passed to `join` must be STRING, got %s",
+						i, el.Type())
+				}
+				elems[i] = string(s)
+			}
+			return String(strings.Join(elems, string(sep)))
+		},
+	},
+	{
+		"upper",
+		stringsBuiltin("upper", 1, func(args []string) Object {
+			return String(strings.ToUpper(args[0]))
+		}),
+	},
+	{
+		"lower",
+		stringsBuiltin("lower", 1, func(args []string) Object {
+			return String(strings.ToLower(args[0]))
+		}),
+	},
+	{
+		"trim",
+		stringsBuiltin("trim", 1, func(args []string) Object {
+			return String(strings.TrimSpace(args[0]))
+		}),
+	},
+	{
+		"replace",
+		stringsBuiltin("replace", 3, func(args []string) Object {
+			return String(strings.ReplaceAll(args[0], args[1], args[2]))
+		}),
+	},
+	{
+		"contains",
+		stringsBuiltin("contains", 2, func(args []string) Object {
+			return Bool(strings.Contains(args[0], args[1]))
+		}),
+	},
+	{
+		"startsWith",
+		stringsBuiltin("startsWith", 2, func(args []string) Object {
+			return Bool(strings.HasPrefix(args[0], args[1]))
+		}),
+	},
+	{
+		"all",
+		func(args ...Object) Object {
+			fs, err := futures("all", args)
+			if err != nil {
+				return err
+			}
+			return All(fs)
+		},
+	},
+	{
+		"race",
+		func(args ...Object) Object {
+			fs, err := futures("race", args)
+			if err != nil {
+				return err
+			}
+			if len(fs) == 0 {
+				return newError("argument to `race` must not be empty")
+			}
+			return Race(fs)
+		},
+	},
+	{
+		"chan",
+		func(args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1",
+					len(args))
+			}
+			capacity := Integer(0)
+			if len(args) == 1 {
+				n, ok := args[0].(Integer)
+				if !ok || n < 0 {
+					return newError("argument to `chan` must be a non-negative INTEGER, got %s",
+						args[0].Inspect())
+				}
+				if n > MaxChanCapacity {
+					return newError("argument to `chan` must be at most %d, got %d",
+						MaxChanCapacity, n)
+				}
+				capacity = n
+			}
+			return NewChannel(int(capacity))
+		},
+	},
+	{
+		"closeChan",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return newError("argument to `closeChan` must be CHANNEL, got %s",
+					args[0].Type())
+			}
+			if err := ch.Close(); err != nil {
+				return Error{Err: err}
+			}
+			return Null{}
+		},
+	},
+	{
+		"pool",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			n, ok := args[0].(Integer)
+			if !ok || n < 1 {
+				return newError("argument to `pool` must be a positive INTEGER, got %s",
+					args[0].Inspect())
+			}
+			return NewPool(int(n))
+		},
+	},
+	{
+		"cancel",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			t, ok := args[0].(*Timer)
+			if !ok {
+				return newError("argument to `cancel` must be TIMER, got %s",
+					args[0].Type())
+			}
+			t.Cancel()
+			return Null{}
+		},
+	},
+	{
+		"mutex",
+		func(args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0",
+					len(args))
+			}
+			return NewMutex()
+		},
+	},
+	{
+		"unlock",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			m, ok := args[0].(*Mutex)
+			if !ok {
+				return newError("argument to `unlock` must be MUTEX, got %s",
+					args[0].Type())
+			}
+			if err := m.Unlock(); err != nil {
+				return Error{Err: err}
+			}
+			return Null{}
+		},
+	},
+	{
+		"atomic",
+		func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			n, ok := args[0].(Integer)
+			if !ok {
+				return newError("argument to `atomic` must be INTEGER, got %s",
+					args[0].Type())
+			}
+			a := &Atomic{}
+			a.Add(int64(n))
+			return a
+		},
+	},
+	{
+		"atomicAdd",
+		func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			a, ok := args[0].(*Atomic)
+			if !ok {
+				return newError("first argument to `atomicAdd` must be ATOMIC, got %s",
+					args[0].Type())
+			}
+			delta, ok := args[1].(Integer)
+			if !ok {
+				return newError("second argument to `atomicAdd` must be INTEGER, got %s",
+					args[1].Type())
+			}
+			return Integer(a.Add(int64(delta)))
+		},
+	},
+}
+
+// contextBuiltins are the standard builtins which call back into the engine,
+// registered after standardBuiltins.
+var contextBuiltins = []struct {
+	name string
+	fn   ContextFunction
+}{
+	{
+		"wait",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			f, ok := args[0].(*Future)
+			if !ok {
+				return newError("argument to `wait` must be FUTURE, got %s",
+					args[0].Type())
+			}
+			return f.WaitContext(ctx.Context())
+		},
+	},
+	{
+		"spawn",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. got=%d, want at least 1",
+					len(args))
+			}
+			return ctx.Go(args[0], args[1:]...)
+		},
+	},
+	{
+		"send",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return newError("argument to `send` must be CHANNEL, got %s",
+					args[0].Type())
+			}
+			if err := ch.Send(ctx.Context(), args[1]); err != nil {
+				return Error{Err: err}
+			}
+			return Null{}
+		},
+	},
+	{
+		"recv",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			ch, ok := args[0].(*Channel)
+			if !ok {
+				return newError("argument to `recv` must be CHANNEL, got %s",
+					args[0].Type())
+			}
+			obj, ok, err := ch.Recv(ctx.Context())
+			if err != nil {
+				return Error{Err: err}
+			}
+			if !ok {
+				return Null{}
+			}
+			return obj
+		},
+	},
+	{
+		"select",
+		selectBuiltin,
+	},
+	{
+		"pmap",
+		pmapBuiltin,
+	},
+	{
+		"map",
+		func(ctx BuiltinContext, args ...Object) Object {
+			arr, err := callbackArgs("map", 2, args)
+			if err != nil {
+				return err
+			}
+			results := make(Array, len(arr))
+			for i, el := range arr {
+				result := ctx.Apply(args[1], el)
+				if _, ok := result.(Error); ok {
+					return result
+				}
+				results[i] = result
+			}
+			return &results
+		},
+	},
+	{
+		"filter",
+		func(ctx BuiltinContext, args ...Object) Object {
+			arr, err := callbackArgs("filter", 2, args)
+			if err != nil {
+				return err
+			}
+			var kept Array
+			for _, el := range arr {
+				result := ctx.Apply(args[1], el)
+				if _, ok := result.(Error); ok {
+					return result
+				}
+				if ctx.Truthiness().IsTruthy(result) {
+					kept = append(kept, el)
+				}
+			}
+			return &kept
+		},
+	},
+	{
+		"reduce",
+		func(ctx BuiltinContext, args ...Object) Object {
+			arr, err := callbackArgs("reduce", 3, args)
+			if err != nil {
+				return err
+			}
+			acc := args[2]
+			for _, el := range arr {
+				acc = ctx.Apply(args[1], acc, el)
+				if _, ok := acc.(Error); ok {
+					return acc
+				}
+			}
+			return acc
+		},
+	},
+	{
+		"bool",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return Bool(ctx.Truthiness().IsTruthy(args[0]))
+		},
+	},
+	{
+		"submit",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) < 2 {
+				return newError("wrong number of arguments. got=%d, want at least 2",
+					len(args))
+			}
+			p, ok := args[0].(*Pool)
+			if !ok {
+				return newError("first argument to `submit` must be POOL, got %s",
+					args[0].Type())
+			}
+			return p.Submit(ctx, args[1], args[2:]...)
+		},
+	},
+	{
+		"drain",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			p, ok := args[0].(*Pool)
+			if !ok {
+				return newError("argument to `drain` must be POOL, got %s",
+					args[0].Type())
+			}
+			return p.Drain(ctx.Context())
+		},
+	},
+	{
+		"sleep",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			ms, ok := args[0].(Integer)
+			if !ok {
+				return newError("argument to `sleep` must be INTEGER, got %s",
+					args[0].Type())
+			}
+			timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				return Null{}
+			case <-ctx.Context().Done():
+				return Error{Err: ctx.Context().Err()}
+			}
+		},
+	},
+	{
+		"after",
+		func(ctx BuiltinContext, args ...Object) Object {
+			return scheduleBuiltin(ctx, "after", false, args)
+		},
+	},
+	{
+		"every",
+		func(ctx BuiltinContext, args ...Object) Object {
+			return scheduleBuiltin(ctx, "every", true, args)
+		},
+	},
+	{
+		"lock",
+		func(ctx BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			m, ok := args[0].(*Mutex)
+			if !ok {
+				return newError("argument to `lock` must be MUTEX, got %s",
+					args[0].Type())
+			}
+			if err := m.Lock(ctx.Context()); err != nil {
+				return Error{Err: err}
+			}
+			return Null{}
+		},
+	},
+	{
+		"memo",
+		memoBuiltin,
+	},
+}
+
+// futures returns the futures in args[0], an array, for the builtin name.
+func futures(name string, args []Object) ([]*Future, Object) {
+	if len(args) != 1 {
+		return nil, newError("wrong number of arguments. got=%d, want=1",
+			len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s",
+			name, args[0].Type())
+	}
+	fs := make([]*Future, len(*arr))
+	for i, el := range *arr {
+		if fs[i], ok = el.(*Future); !ok {
+			return nil, newError("argument to `%s` must be an ARRAY of FUTURE, got %s at index %d",
+				name, el.Type(), i)
+		}
+	}
+	return fs, nil
+}
+
+// callbackArgs checks the arguments of the builtin name, which takes an
+// array, a function to call on its elements and n-2 more arguments, and
+// returns the array.
+func callbackArgs(name string, n int, args []Object) (Array, Object) {
+	if len(args) != n {
+		return nil, newError("wrong number of arguments. got=%d, want=%d",
+			len(args), n)
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, newError("first argument to `%s` must be ARRAY, got %s",
+			name, args[0].Type())
+	}
+	switch args[1].(type) {
+	case *Function, *Builtin:
+	default:
+		return nil, newError("second argument to `%s` must be FUNCTION, got %s",
+			name, args[1].Type())
+	}
+	return *arr, nil
+}
+
+// pmapBuiltin returns an array of the results of calling fn on each element
+// of an array, on up to workers tasks at once, or the first Error which a
+// call returns.
+func pmapBuiltin(ctx BuiltinContext, args ...Object) Object {
+	if len(args) != 3 {
+		return newError("wrong number of arguments. got=%d, want=3",
+			len(args))
+	}
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("first argument to `pmap` must be ARRAY, got %s",
+			args[0].Type())
+	}
+	workers, ok := args[2].(Integer)
+	if !ok || workers < 1 {
+		return newError("third argument to `pmap` must be a positive INTEGER, got %s",
+			args[2].Inspect())
+	}
+	elements := *Isolate(arr).(*Array)
+	results := make(Array, len(elements))
+	// Each worker is a task which calls fn, its argument, on the elements
+	// it claims until none remain or a call fails.
+	var next atomic.Int64
+	worker := &Builtin{ContextFn: func(ctx BuiltinContext, args ...Object) Object {
+		for {
+			i := next.Add(1) - 1
+			if i >= int64(len(elements)) {
+				return Null{}
+			}
+			result := ctx.Apply(args[0], elements[i])
+			if _, ok := result.(Error); ok {
+				next.Store(int64(len(elements)))
+				return result
+			}
+			results[i] = result
+		}
+	}}
+	fs := make([]*Future, min(int(workers), len(elements)))
+	for i := range fs {
+		fs[i] = ctx.Go(worker, args[1])
+	}
+	for _, f := range fs {
+		if result, ok := f.WaitContext(ctx.Context()).(Error); ok {
+			return result
+		}
+	}
+	return &results
+}
+
+// scheduleBuiltin implements after and every, which take a number of
+// milliseconds and a function.
+func scheduleBuiltin(ctx BuiltinContext, name string, repeat bool, args []Object) Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2",
+			len(args))
+	}
+	ms, ok := args[0].(Integer)
+	if !ok || ms < 1 {
+		return newError("first argument to `%s` must be a positive INTEGER, got %s",
+			name, args[0].Inspect())
+	}
+	return Schedule(ctx, time.Duration(ms)*time.Millisecond, repeat, args[1])
+}
+
+// selectBuiltin waits for the first of several cases and returns the result
+// of its handler. Each argument is a case: [ch, fn(v) { ... }] receives v
+// from the channel ch, or NULL once it is closed and drained, and
+// [ms, fn() { ... }] fires after ms milliseconds.
+func selectBuiltin(ctx BuiltinContext, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	// A channel's case waits on both C and Done, so that closing it
+	// fires the case. ctx.Done() is the last case.
+	type selectCase struct {
+		arg    int  // index in args, or -1 for ctx.Done()
+		closed bool // whether the case is a channel's Done
+	}
+	var cases []reflect.SelectCase
+	var caseArgs []selectCase
+	recvCase := func(c selectCase, ch interface{}) {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)})
+		caseArgs = append(caseArgs, c)
+	}
+	for i, arg := range args {
+		c, ok := arg.(*Array)
+		if !ok || len(*c) != 2 {
+			return newError("case %d of `select` must be [CHANNEL or INTEGER, FUNCTION], got %s",
+				i, arg.Inspect())
+		}
+		switch on := (*c)[0].(type) {
+		case *Channel:
+			recvCase(selectCase{arg: i}, on.C)
+			recvCase(selectCase{arg: i, closed: true}, on.done)
+		case Integer:
+			timer := time.NewTimer(time.Duration(on) * time.Millisecond)
+			defer timer.Stop()
+			recvCase(selectCase{arg: i}, timer.C)
+		default:
+			return newError("case %d of `select` must be [CHANNEL or INTEGER, FUNCTION], got %s",
+				i, arg.Inspect())
+		}
+	}
+	recvCase(selectCase{arg: -1}, ctx.Context().Done())
+
+	chosen, recv, _ := reflect.Select(cases)
+	sc := caseArgs[chosen]
+	if sc.arg < 0 {
+		return Error{Err: ctx.Context().Err()}
+	}
+	c := *args[sc.arg].(*Array)
+	ch, ok := c[0].(*Channel)
+	switch {
+	case !ok:
+		return ctx.Apply(c[1])
+	case sc.closed:
+		obj, ok := ch.drain()
+		if !ok {
+			obj = Null{}
+		}
+		return ctx.Apply(c[1], obj)
+	default:
+		return ctx.Apply(c[1], recv.Interface().(Object))
+	}
+}
+
+// stringsBuiltin returns a builtin named name taking n strings, which it
+// passes to fn.
+func stringsBuiltin(name string, n int, fn func(args []string) Object) BuiltinFunction {
+	return func(args ...Object) Object {
+		if len(args) != n {
+			return newError("wrong number of arguments. got=%d, want=%d",
+				len(args), n)
+		}
+		strs := make([]string, n)
+		for i, arg := range args {
+			s, ok := arg.(String)
+			if !ok {
+				return newError("argument %d to `%s` must be STRING, got %s",
+					i+1, name, arg.Type())
+			}
+			strs[i] = string(s)
+		}
+		return fn(strs)
+	}
+}
+
+func newError(format string, a ...interface{}) Error {
+	return Error{Err: fmt.Errorf(format, a...)}
+}
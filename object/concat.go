@@ -0,0 +1,42 @@
+package object
+
+import "unsafe"
+
+// minAppend is the length of the shortest concatenation for which an
+// Appender keeps spare capacity. Shorter strings are concatenated directly.
+const minAppend = 64
+
+// Appender concatenates strings so that repeatedly appending to the result
+// of the last concatenation, as a program building a string in a loop does,
+// takes time linear in the length of the result rather than quadratic. The
+// result shares a buffer with spare capacity, and a concatenation whose left
+// operand is the last result appends to the buffer in place. This is safe
+// because the appended bytes lie beyond the end of every string sharing the
+// buffer, and strings themselves are never changed.
+//
+// The zero value is ready to use. An Appender must not be used by several
+// goroutines at once, but the strings it returns may be.
+type Appender struct {
+	buf []byte // holds the last result, which is len(buf) long
+}
+
+// Concat returns left + right.
+func (a *Appender) Concat(left, right String) String {
+	n := len(left) + len(right)
+	if n < minAppend {
+		return left + right
+	}
+	if !a.isLast(left) || cap(a.buf)-len(a.buf) < len(right) {
+		buf := make([]byte, len(left), 2*n)
+		copy(buf, left)
+		a.buf = buf
+	}
+	a.buf = append(a.buf, right...)
+	return String(unsafe.String(unsafe.SliceData(a.buf), len(a.buf)))
+}
+
+// isLast reports whether s is the last result of a.
+func (a *Appender) isLast(s String) bool {
+	return len(s) == len(a.buf) && len(s) > 0 &&
+		unsafe.StringData(string(s)) == unsafe.SliceData(a.buf)
+}
@@ -0,0 +1,29 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// The errors wrapped by those of index expressions which find nothing, in
+// the strict index mode of the evaluator and the VM. Otherwise such
+// expressions evaluate to NULL.
+var (
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrKeyNotFound     = errors.New("key not found")
+)
+
+// IndexError returns the error of indexing arr with i, which is out of
+// range.
+func IndexError(arr Array, i Integer) error {
+	return fmt.Errorf("%w: %d with length %d", ErrIndexOutOfRange, i, len(arr))
+}
+
+// KeyError returns the error of indexing a hash with key, which it lacks.
+func KeyError(key Object) error {
+	if s, ok := key.(String); ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, strconv.Quote(string(s)))
+	}
+	return fmt.Errorf("%w: %s", ErrKeyNotFound, key.Inspect())
+}
@@ -0,0 +1,60 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotIterable is wrapped by the error of a for loop over a value which
+// is neither an array nor a hash.
+var ErrNotIterable = errors.New("not iterable")
+
+// Iterator visits the elements of an array, keyed by their indexes, or the
+// pairs of a hash, in order, for the for loops of both engines.
+type Iterator struct {
+	array Array
+	hash  *Hash
+	i     int
+}
+
+// NewIterator returns an Iterator of obj, or an error wrapping
+// ErrNotIterable if obj is neither an array nor a hash.
+func NewIterator(obj Object) (*Iterator, error) {
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{array: *obj}, nil
+	case Array:
+		return &Iterator{array: obj}, nil
+	case Hash:
+		return &Iterator{hash: &obj}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotIterable, obj.Type())
+}
+
+// Next returns the index and element of the next element of an array, or
+// the key and value of the next pair of a hash. ok is false once every one
+// has been visited.
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	if it.hash != nil {
+		if it.i >= it.hash.Len() {
+			return nil, nil, false
+		}
+		key = it.hash.keys[it.i]
+		it.i++
+		return key, it.hash.values[key], true
+	}
+	if it.i >= len(it.array) {
+		return nil, nil, false
+	}
+	it.i++
+	return Integer(it.i - 1), it.array[it.i-1], true
+}
+
+// Single returns which of key and value, returned by Next, a loop with a
+// single variable binds: the element of an array or the key of a hash.
+func (it *Iterator) Single(key, value Object) Object {
+	if it.hash != nil {
+		return key
+	}
+	return value
+}
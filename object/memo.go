@@ -0,0 +1,75 @@
+package object
+
+import "sync"
+
+// memoBuiltin returns a builtin which calls a function, caching its results
+// by its arguments so that each distinct call runs once. Calls with an
+// argument which is not hashable are not cached, and neither are errors.
+func memoBuiltin(ctx BuiltinContext, args ...Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1",
+			len(args))
+	}
+	switch args[0].(type) {
+	case *Function, *Builtin:
+	default:
+		return newError("argument to `memo` must be FUNCTION, got %s",
+			args[0].Type())
+	}
+	return newMemo(args[0])
+}
+
+func newMemo(fn Object) *Builtin {
+	m := &memo{fn: fn}
+	return &Builtin{ContextFn: m.call, memo: m}
+}
+
+// memo caches the results of fn in a trie keyed by the arguments of each
+// call in turn.
+type memo struct {
+	fn Object
+
+	mu   sync.Mutex
+	root memoNode
+}
+
+type memoNode struct {
+	result Object // nil if no call has ended here
+	next   map[Object]*memoNode
+}
+
+func (m *memo) call(ctx BuiltinContext, args ...Object) Object {
+	for _, arg := range args {
+		if !Hashable(arg) {
+			return ctx.Apply(m.fn, args...)
+		}
+	}
+	m.mu.Lock()
+	n := &m.root
+	for _, arg := range args {
+		next, ok := n.next[arg]
+		if !ok {
+			if n.next == nil {
+				n.next = map[Object]*memoNode{}
+			}
+			next = &memoNode{}
+			n.next[arg] = next
+		}
+		n = next
+	}
+	result := n.result
+	m.mu.Unlock()
+	if result != nil {
+		return result
+	}
+
+	// The lock is not held during the call, which may recurse, so
+	// concurrent callers may both compute the same result.
+	result = ctx.Apply(m.fn, args...)
+	if result.Type() != ERROR {
+		m.mu.Lock()
+		n.result = result
+		m.mu.Unlock()
+	}
+	return result
+}
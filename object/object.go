@@ -2,13 +2,23 @@ package object
 
 import (
 	"fmt"
+	"iter"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/monkeyerr"
 )
 
 type BuiltinFunction func(args ...Object) Object
 
+// Importer returns the module named name for an import expression. ctx is
+// the engine running the import, on which source modules are evaluated, or
+// nil if there is none.
+type Importer func(ctx BuiltinContext, name string) (Object, error)
+
 //go:generate stringer -type ObjectType
 
 type ObjectType int
@@ -26,6 +36,16 @@ const (
 	ARRAY
 	HASH
 	RETURN_VALUE
+	EXTERNAL
+	FUTURE
+	CHANNEL
+	MUTEX
+	ATOMIC
+	POOL
+	TIMER
+	THUNK
+	BREAK
+	CONTINUE
 )
 
 func NewEnclosedEnvironment(parent *Environment) *Environment {
@@ -40,12 +60,35 @@ func NewEnvironment() *Environment {
 	}
 }
 
+// NewScopedEnvironment returns an environment for a call of a function with
+// scope s, which holds the bindings of s's names in slots rather than in a
+// map. Bindings of other names, which the evaluator does not make, go to a
+// map as in other environments.
+func NewScopedEnvironment(s *Scope, parent *Environment) *Environment {
+	return &Environment{
+		slots:  make([]Object, len(s.Names)),
+		scope:  s,
+		parent: parent,
+	}
+}
+
+// Environment binds names to values. The environments of programs map names
+// to values, so that any name may be added to them, for example by a REPL.
+// The environments of function calls hold the names known from the
+// function's Scope in slots, which the evaluator reads by their Ref.
 type Environment struct {
 	store  map[string]Object
+	slots  []Object // indexed as scope.Names; nil until set
+	scope  *Scope
 	parent *Environment
 }
 
-func (e Environment) Get(name string) (Object, bool) {
+func (e *Environment) Get(name string) (Object, bool) {
+	if e.scope != nil {
+		if i, ok := e.scope.index[name]; ok && e.slots[i] != nil {
+			return e.slots[i], true
+		}
+	}
 	obj, ok := e.store[name]
 	if !ok && e.parent != nil {
 		obj, ok = e.parent.Get(name)
@@ -53,11 +96,161 @@ func (e Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
-func (e Environment) Set(name string, val Object) Object {
+func (e *Environment) Set(name string, val Object) Object {
+	if e.scope != nil {
+		if i, ok := e.scope.index[name]; ok {
+			e.slots[i] = val
+			return val
+		}
+	}
+	if e.store == nil {
+		e.store = map[string]Object{}
+	}
 	e.store[name] = val
 	return val
 }
 
+// Names returns the names bound directly in e, not in its parents, in
+// sorted order.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store)+len(e.slots))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	for i, val := range e.slots {
+		if name := e.scope.Names[i]; val != nil && e.scope.index[name] == i {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Scope returns the scope of e's slots, or nil if e holds its bindings in a
+// map.
+func (e *Environment) Scope() *Scope { return e.scope }
+
+// Lookup returns the value of the identifier name resolved to ref in e, the
+// environment in which the identifier is evaluated. If the slot is not yet
+// set, as when a function reads a global before binding a local of the same
+// name, it looks the name up in the enclosing environments instead.
+func (e *Environment) Lookup(ref Ref, name string) (Object, bool) {
+	for i := 0; i < ref.Depth; i++ {
+		e = e.parent
+	}
+	if ref.Index >= 0 {
+		if val := e.slots[ref.Index]; val != nil {
+			return val, true
+		}
+	}
+	return e.Get(name)
+}
+
+// Assign rebinds the identifier name resolved to ref in e, the environment
+// in which the assignment is evaluated, to val in the nearest environment
+// which binds it, as Lookup would find it. It reports false, binding
+// nothing, if no environment binds it.
+func (e *Environment) Assign(ref Ref, name string, val Object) bool {
+	for i := 0; i < ref.Depth; i++ {
+		e = e.parent
+	}
+	if ref.Index >= 0 && e.slots[ref.Index] != nil {
+		e.slots[ref.Index] = val
+		return true
+	}
+	return e.Update(name, val)
+}
+
+// Update rebinds name to val in the nearest of e and its parents which binds
+// it, reporting false, binding nothing, if none does.
+func (e *Environment) Update(name string, val Object) bool {
+	for ; e != nil; e = e.parent {
+		if e.scope != nil {
+			if i, ok := e.scope.index[name]; ok && e.slots[i] != nil {
+				e.slots[i] = val
+				return true
+			}
+		}
+		if _, ok := e.store[name]; ok {
+			e.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
+// Reset makes e an environment for a call of a function with scope s, as
+// NewScopedEnvironment(s, parent) would, reusing e's slots. A nil s leaves e
+// without bindings. Nothing may refer to e's earlier bindings.
+func (e *Environment) Reset(s *Scope, parent *Environment) {
+	clear(e.slots)
+	e.slots = e.slots[:0]
+	if s != nil {
+		e.slots = append(e.slots, make([]Object, len(s.Names))...)
+	}
+	clear(e.store)
+	e.scope, e.parent = s, parent
+}
+
+// SetSlot binds the name in slot i of e's scope to val.
+func (e *Environment) SetSlot(i int, val Object) Object {
+	e.slots[i] = val
+	return val
+}
+
+// Scope describes the environments of the calls of a function: the names
+// bound in them, which are its parameters followed by the names of the let
+// statements in its body, and where each identifier in its body is bound.
+type Scope struct {
+	Names []string
+
+	// Refs locates the bindings of the identifiers in the function's body,
+	// excluding those in nested function literals.
+	Refs map[*ast.Identifier]Ref
+
+	// Funcs holds the scopes of the function literals nested directly in
+	// the function's body. It is nil if there are none.
+	Funcs map[*ast.FunctionLiteral]*Scope
+
+	// Hashes holds the layouts of the hash literals in the function's body
+	// whose keys are all literals. It is nil if there are none.
+	Hashes map[*ast.HashLiteral]*HashLayout
+
+	// Leaf reports that the function's body contains no function literals,
+	// so no closure made by a call refers to the call's environment.
+	Leaf bool
+
+	index map[string]int
+}
+
+// NewScope returns a Scope binding names, without Refs, Funcs or Hashes. A name
+// which occurs more than once is bound in its last slot, as the last of
+// several parameters of the same name is.
+func NewScope(names []string) *Scope {
+	s := &Scope{
+		Names: names,
+		index: make(map[string]int, len(names)),
+	}
+	for i, name := range names {
+		s.index[name] = i
+	}
+	return s
+}
+
+// Index returns the slot of name in s.
+func (s *Scope) Index(name string) (int, bool) {
+	i, ok := s.index[name]
+	return i, ok
+}
+
+// Ref locates the binding of an identifier relative to the environment in
+// which it is evaluated: slot Index of the environment Depth parents up. An
+// Index of -1 means that the name is not bound in any enclosing function
+// and is looked up by name from there.
+type Ref struct {
+	Depth, Index int
+}
+
 type String string
 
 func (s String) Type() ObjectType { return STRING }
@@ -67,6 +260,10 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+
+	// Scope, if non-nil, describes the environments of the function's
+	// calls, which then hold its parameters and locals in slots.
+	Scope *Scope
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION }
@@ -94,6 +291,20 @@ type ReturnValue struct {
 func (rv ReturnValue) Type() ObjectType { return RETURN_VALUE }
 func (rv ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break is the result of a break statement, which the innermost loop stops
+// at, as a function stops a ReturnValue.
+type Break struct{}
+
+func (Break) Type() ObjectType { return BREAK }
+func (Break) Inspect() string  { return "break" }
+
+// Continue is the result of a continue statement, which the innermost loop
+// stops at.
+type Continue struct{}
+
+func (Continue) Type() ObjectType { return CONTINUE }
+func (Continue) Inspect() string  { return "continue" }
+
 type Error struct {
 	Err error
 }
@@ -101,6 +312,46 @@ type Error struct {
 func (e Error) Type() ObjectType { return ERROR }
 func (e Error) Inspect() string  { return e.Err.Error() }
 
+// RuntimeError is an error raised while running a program, with the line of
+// the statement which raised it and the function calls active at the time.
+type RuntimeError struct {
+	Line  int // 1-based line of the failing statement, if known
+	Err   error
+	Stack []Frame // outermost call first
+}
+
+// InternalError reports a bug in the interpreter: a panic, which the entry
+// points of the engines and StartFuture recover from rather than crash the
+// host.
+type InternalError struct {
+	Value interface{} // the value passed to panic
+	Stack []byte      // the stack of the panicking goroutine
+}
+
+func (e *InternalError) Error() string {
+	return fmt.Sprintf("internal error: %v", e.Value)
+}
+
+// Frame is an active function call.
+type Frame struct {
+	Function string // the called expression, e.g. "add"
+	Line     int    // line of the call
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// Diagnostic implements monkeyerr.Diagnoser.
+func (e *RuntimeError) Diagnostic() monkeyerr.Diagnostic {
+	return monkeyerr.Diagnostic{Phase: monkeyerr.PhaseRun, Pos: monkeyerr.Position{Line: e.Line}, Msg: e.Err.Error(), Err: e}
+}
+
 type Integer int64
 
 func (i Integer) Type() ObjectType { return INTEGER }
@@ -109,7 +360,16 @@ func (i Integer) Inspect() string  { return fmt.Sprintf("%d", i) }
 type Float float64
 
 func (f Float) Type() ObjectType { return FLOAT }
-func (f Float) Inspect() string  { return fmt.Sprintf("%f", f) }
+
+// Inspect returns the shortest decimal form which reads back as f, with a
+// ".0" suffix if it would otherwise look like an integer.
+func (f Float) Inspect() string {
+	s := strconv.FormatFloat(float64(f), 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
 
 type Object interface {
 	Type() ObjectType
@@ -124,10 +384,23 @@ func (b Bool) Inspect() string  { return fmt.Sprintf("%t", b) }
 type Null struct{}
 
 func (n Null) Type() ObjectType { return NULL }
-func (n Null) Inspect() string  { return "NULL" }
+func (n Null) Inspect() string  { return "null" }
 
 type Builtin struct {
 	Fn BuiltinFunction
+	// ContextFn, if set, is called instead of Fn by builtins which call
+	// back into the engine.
+	ContextFn ContextFunction
+
+	memo *memo // the cache of a builtin returned by memo, for Isolate
+}
+
+// Call calls the builtin with args on behalf of ctx.
+func (b *Builtin) Call(ctx BuiltinContext, args ...Object) Object {
+	if b.ContextFn != nil {
+		return b.ContextFn(ctx, args...)
+	}
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN }
@@ -143,28 +416,117 @@ func (ao Array) Inspect() string {
 		if i > 0 {
 			out.WriteString(", ")
 		}
-		out.WriteString(e.Inspect())
+		out.WriteString(inspectElem(e))
 	}
 	out.WriteString("]")
 	return out.String()
 }
 
-type Hash map[Object]Object
+// External is an opaque handle to a host value, such as a database
+// connection, which builtins return to scripts and accept back as
+// arguments.
+type External struct {
+	Value interface{}
+}
+
+func (e *External) Type() ObjectType { return EXTERNAL }
+func (e *External) Inspect() string  { return fmt.Sprintf("external(%T)", e.Value) }
+
+// Hash maps keys to values, keeping its keys in the order in which they
+// were first set, so that printing and iterating over a hash are
+// deterministic. Only Hashable objects are keys, and those are all
+// comparable values rather than pointers, so two keys built separately, by
+// either engine, are the same key exactly when they have the same type and
+// value. The zero value is an empty hash. Scripts cannot modify a hash, so a
+// hash must not be Set once it has been given to one.
+type Hash struct {
+	keys   []Object
+	values map[Object]Object
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) Hash {
+	return Hash{
+		keys:   make([]Object, 0, size),
+		values: make(map[Object]Object, size),
+	}
+}
+
+// HashOf returns a hash of the keys and values in kvs, which alternate
+// between them, in order. It panics if kvs has odd length.
+func HashOf(kvs ...Object) Hash {
+	if len(kvs)%2 != 0 {
+		panic("object: HashOf given an odd number of arguments")
+	}
+	h := NewHash(len(kvs) / 2)
+	for i := 0; i < len(kvs); i += 2 {
+		h.Set(kvs[i], kvs[i+1])
+	}
+	return h
+}
+
+// Set sets the value of key, which must be Hashable. A new key goes after
+// every existing one; an existing key keeps its place.
+func (h *Hash) Set(key, value Object) {
+	if h.values == nil {
+		h.values = map[Object]Object{}
+	}
+	if _, ok := h.values[key]; !ok {
+		h.keys = append(h.keys, key)
+	}
+	h.values[key] = value
+}
+
+// Get returns the value of key, and whether h has it.
+func (h Hash) Get(key Object) (Object, bool) {
+	v, ok := h.values[key]
+	return v, ok
+}
+
+// Len returns the number of pairs in h.
+func (h Hash) Len() int { return len(h.keys) }
+
+// Keys returns the keys of h in order. The caller must not modify them.
+func (h Hash) Keys() []Object { return h.keys }
+
+// All returns an iterator over the pairs of h in order.
+func (h Hash) All() iter.Seq2[Object, Object] {
+	return func(yield func(Object, Object) bool) {
+		for _, k := range h.keys {
+			if !yield(k, h.values[k]) {
+				return
+			}
+		}
+	}
+}
 
 func (h Hash) Type() ObjectType { return HASH }
 
 func (h Hash) Inspect() string {
 	var out strings.Builder
 	out.WriteString("{")
-	for k, v := range h {
-		out.WriteString(k.Inspect())
+	sep := ""
+	for k, v := range h.All() {
+		out.WriteString(sep)
+		out.WriteString(inspectElem(k))
 		out.WriteString(": ")
-		out.WriteString(v.Inspect())
+		out.WriteString(inspectElem(v))
+		sep = ", "
 	}
 	out.WriteString("}")
 	return out.String()
 }
 
+// Comparable reports whether the == and != operators can compare o with
+// another object of the same type, which is not so for hashes: comparing
+// them as interfaces panics.
+func Comparable(o Object) bool {
+	return reflect.TypeOf(o).Comparable()
+}
+
+// Hashable reports whether o may be a key of a Hash. A type is hashable
+// only if equal values of it compare equal as interfaces, so a pointer type
+// never is.
 func Hashable(o Object) bool {
 	switch o.Type() {
 	case BOOL, STRING, INTEGER:
@@ -173,3 +535,36 @@ func Hashable(o Object) bool {
 		return false
 	}
 }
+
+// HashLayout is a hash literal whose keys are all literals, with its keys
+// evaluated once so that each evaluation of the literal need evaluate only
+// its values.
+type HashLayout struct {
+	Keys   []Object
+	Values []ast.Expression // the value of Keys[i] is Values[i]
+}
+
+// NewHashLayout returns the layout of node, or nil if any of its keys is not
+// a literal. The keys are in the order of their source text.
+func NewHashLayout(node *ast.HashLiteral) *HashLayout {
+	l := &HashLayout{
+		Keys:   make([]Object, 0, len(node.Pairs)),
+		Values: make([]ast.Expression, 0, len(node.Pairs)),
+	}
+	for _, pair := range node.Pairs {
+		var key Object
+		switch k := pair.Key.(type) {
+		case *ast.StringLiteral:
+			key = String(k.Value)
+		case *ast.IntegerLiteral:
+			key = Integer(k.Value)
+		case *ast.Bool:
+			key = Bool(k.Value)
+		default:
+			return nil
+		}
+		l.Keys = append(l.Keys, key)
+		l.Values = append(l.Values, pair.Value)
+	}
+	return l
+}
@@ -1 +1,314 @@
 package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBuiltinsRestrict(t *testing.T) {
+	noop := func(args ...Object) Object { return Null{} }
+	b := NewBuiltins()
+	b.RegisterWithCapabilities("readFile", CapFile, noop)
+	b.RegisterWithCapabilities("fetch", CapNetwork, noop)
+	b.RegisterWithCapabilities("exec", CapExec|CapFile, noop)
+
+	c := b.Clone()
+	c.Restrict(CapFile)
+	expected := []string{"len", "puts", "print", "first", "last", "rest", "push", "pop", "reverse", "concat", "keys", "values", "hasKey", "delete", "merge", "type", "int", "float", "str", "split", "join", "upper", "lower", "trim", "replace", "contains", "startsWith", "all", "race", "chan", "closeChan", "pool", "cancel", "mutex", "unlock", "atomic", "atomicAdd", "wait", "spawn", "send", "recv", "select", "pmap", "map", "filter", "reduce", "bool", "submit", "drain", "sleep", "after", "every", "lock", "memo", "fetch"}
+	if got := c.Names(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong names after Restrict. want=%v, got=%v", expected, got)
+	}
+	if i, _ := c.Index("fetch"); c.Capabilities("fetch") != CapNetwork || i != 54 {
+		t.Errorf("wrong entry for fetch: index %d, capabilities %d", i, c.Capabilities("fetch"))
+	}
+
+	c = b.Clone()
+	c.RemoveIO()
+	if got := c.Names(); !reflect.DeepEqual(got, expected[:54]) {
+		t.Errorf("wrong names after RemoveIO. want=%v, got=%v", expected[:54], got)
+	}
+
+	b.Keep("len", "exec", "missing")
+	if got := b.Names(); !reflect.DeepEqual(got, []string{"len", "exec"}) {
+		t.Errorf("wrong names after Keep. got=%v", got)
+	}
+}
+
+func TestBuiltinsSetOutput(t *testing.T) {
+	var out strings.Builder
+	b := NewBuiltins()
+	c := b.Clone()
+	c.SetOutput(&out)
+	puts, _ := c.Lookup("puts")
+	puts.Fn(String("a"), Integer(1))
+	if out.String() != "a\n1\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+	out.Reset()
+	p, _ := c.Lookup("print")
+	p.Fn(String("a"), &Array{String("b")}, Integer(1))
+	if out.String() != `a ["b"] 1` {
+		t.Errorf("wrong print output. got=%q", out.String())
+	}
+	if orig, _ := b.Lookup("puts"); orig == puts {
+		t.Error("SetOutput on a clone modified the original table")
+	}
+}
+
+func TestIsolate(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("x", Integer(1))
+	fn := &Function{Env: NewEnclosedEnvironment(global)}
+	global.Set("f", fn)
+	arr := &Array{Integer(1), String("a")}
+
+	if got := Isolate(arr); got != arr {
+		t.Errorf("expected an array without functions to be returned as is")
+	}
+	got := *Isolate(&Array{fn, HashOf(String("f"), fn)}).(*Array)
+	copied := got[0].(*Function)
+	if f, _ := got[1].(Hash).Get(String("f")); copied == fn || f != copied {
+		t.Fatalf("expected each reference to fn to be replaced by one copy, got %v", got)
+	}
+	global.Set("x", Integer(2))
+	if x, _ := copied.Env.Get("x"); x != Integer(1) {
+		t.Errorf("expected snapshot to hold x = 1, got %v", x)
+	}
+	if f, _ := copied.Env.Get("f"); f != copied {
+		t.Errorf("expected the snapshot to refer to the copied function, got %v", f)
+	}
+}
+
+func TestStartFuturePanic(t *testing.T) {
+	f := StartFuture(func() Object { panic("boom") })
+	errObj, ok := f.Wait().(Error)
+	var ie *InternalError
+	if !ok || !errors.As(errObj.Err, &ie) || ie.Value != "boom" {
+		t.Errorf("expected an Error reporting the panic, got %v", f.Wait())
+	}
+}
+
+func TestScopedEnvironment(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("x", Integer(1))
+	s := NewScope([]string{"a", "x"})
+	env := NewScopedEnvironment(s, global)
+	env.SetSlot(0, Integer(2))
+
+	if x, _ := env.Lookup(Ref{Depth: 0, Index: 1}, "x"); x != Integer(1) {
+		t.Errorf("expected an unset slot to fall back to the global, got %v", x)
+	}
+	env.Set("x", Integer(3))
+	env.Set("other", Integer(4))
+	if x, _ := env.Lookup(Ref{Depth: 0, Index: 1}, "x"); x != Integer(3) {
+		t.Errorf("expected Set to bind the slot, got %v", x)
+	}
+	if x, _ := env.Lookup(Ref{Depth: 1, Index: -1}, "x"); x != Integer(1) {
+		t.Errorf("expected the global x, got %v", x)
+	}
+	if got, want := env.Names(), []string{"a", "other", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected names %v, got %v", want, got)
+	}
+
+	fn := &Function{Env: env, Scope: s}
+	copied := Isolate(fn).(*Function)
+	env.SetSlot(0, Integer(5))
+	if a, _ := copied.Env.Get("a"); a != Integer(2) {
+		t.Errorf("expected snapshot to hold a = 2, got %v", a)
+	}
+
+	env.Reset(NewScope([]string{"b"}), nil)
+	if got := env.Names(); len(got) != 0 {
+		t.Errorf("expected Reset to remove every binding, got %v", got)
+	}
+	if x, ok := env.Get("x"); ok {
+		t.Errorf("expected Reset to replace the parent, got x = %v", x)
+	}
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	global := NewEnvironment()
+	global.Set("x", Integer(1))
+	s := NewScope([]string{"a", "x"})
+	env := NewScopedEnvironment(s, global)
+	env.SetSlot(0, Integer(2))
+
+	if !env.Assign(Ref{Depth: 0, Index: 0}, "a", Integer(3)) {
+		t.Fatal("expected the slot of a to be assigned")
+	}
+	if a, _ := env.Get("a"); a != Integer(3) {
+		t.Errorf("expected a = 3, got %v", a)
+	}
+	// x's slot is unset, so x refers to the global, as in Lookup.
+	if !env.Assign(Ref{Depth: 0, Index: 1}, "x", Integer(4)) {
+		t.Fatal("expected the global x to be assigned")
+	}
+	if x, _ := global.Get("x"); x != Integer(4) {
+		t.Errorf("expected the global x = 4, got %v", x)
+	}
+	if _, ok := env.Get("x"); !ok || env.slots[1] != nil {
+		t.Errorf("expected the slot of x to remain unset")
+	}
+	if env.Assign(Ref{Depth: 1, Index: -1}, "y", Integer(5)) || env.Update("y", Integer(5)) {
+		t.Errorf("expected assigning an unbound name to fail")
+	}
+	if _, ok := global.Get("y"); ok {
+		t.Errorf("expected a failed assignment to bind nothing")
+	}
+}
+
+func TestAppender(t *testing.T) {
+	var a Appender
+	long := String(strings.Repeat("x", minAppend))
+	s := a.Concat(long, "a")
+	t1 := a.Concat(s, "b")
+	t2 := a.Concat(s, "c")
+	t3 := a.Concat(t1, "d")
+	for _, tt := range []struct{ got, want String }{
+		{s, long + "a"},
+		{t1, long + "ab"},
+		{t2, long + "ac"},
+		{t3, long + "abd"},
+		{a.Concat("a", "b"), "ab"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, tt.got)
+		}
+	}
+
+	var built String
+	for i := 0; i < 100; i++ {
+		built = a.Concat(built, long)
+	}
+	if allocs := testing.AllocsPerRun(10, func() {
+		built = a.Concat(built, "y")
+	}); allocs > 1 {
+		t.Errorf("expected appending to the last result to reuse its buffer, got %v allocs", allocs)
+	}
+}
+
+func TestPush(t *testing.T) {
+	a := Push(Array{Integer(1)}, Integer(2))
+	b := Push(*a, Integer(3))
+	c := Push(*a, Integer(4))
+	d := Push(*Slice(*b, 0, 1), Integer(5))
+	e := Push(*Slice(*b, 1, 3), Integer(6))
+	for _, tt := range []struct {
+		got  *Array
+		want string
+	}{
+		{a, "[1, 2]"},
+		{b, "[1, 2, 3]"},
+		{c, "[1, 2, 4]"},
+		{d, "[1, 5]"},
+		{e, "[2, 3, 6]"},
+	} {
+		if tt.got.Inspect() != tt.want {
+			t.Errorf("expected %s, got %s", tt.want, tt.got.Inspect())
+		}
+	}
+	if &(*b)[0] != &(*a)[0] {
+		t.Errorf("expected pushing onto the last push to share elements")
+	}
+
+	// Concurrent pushes onto the same array each get their own element.
+	var wg sync.WaitGroup
+	results := make([]*Array, 8)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = Push(*a, Integer(i))
+		}()
+	}
+	wg.Wait()
+	for i, r := range results {
+		if got := (*r)[2]; got != Integer(i) {
+			t.Errorf("push %d: expected last element %d, got %v", i, i, got)
+		}
+	}
+}
+
+// BenchmarkArrayBuiltins builds an array of a million elements with push and
+// takes it apart again with first and rest.
+func BenchmarkArrayBuiltins(b *testing.B) {
+	const n = 1_000_000
+	builtins := NewBuiltins()
+	push, _ := builtins.Lookup("push")
+	rest, _ := builtins.Lookup("rest")
+	first, _ := builtins.Lookup("first")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		arr := Object(&Array{})
+		for j := 0; j < n; j++ {
+			arr = push.Fn(arr, Integer(j))
+		}
+		var sum Integer
+		for j := 0; j < n; j++ {
+			sum += first.Fn(arr).(Integer)
+			arr = rest.Fn(arr)
+		}
+		if sum != n*(n-1)/2 {
+			b.Fatalf("wrong sum %d", sum)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	inner := Array{String("a, b"), Null{}}
+	tests := []struct {
+		obj  Object
+		want string
+	}{
+		{String("text"), "text"},
+		{Null{}, "null"},
+		{&inner, `["a, b", null]`},
+		{Array{String("a"), String("b")}, `["a", "b"]`},
+		{Array{String(`say "hi"`)}, `["say \"hi\""]`},
+		{HashOf(String("k"), &inner), `{"k": ["a, b", null]}`},
+		{HashOf(Integer(1), Bool(true)), "{1: true}"},
+		{HashOf(Integer(3), Integer(4), Integer(1), Integer(2)), "{3: 4, 1: 2}"},
+		{HashOf(String("a"), Integer(1), String("b"), Integer(2), String("a"), Integer(3)), `{"a": 3, "b": 2}`},
+		{ReturnValue{Value: String("v")}, "v"},
+		{Float(2), "2.0"},
+		{Float(-2.5), "-2.5"},
+		{Float(0.1), "0.1"},
+		{Float(1e19), "1e+19"},
+		{Float(math.Inf(-1)), "-Inf"},
+		{Float(math.NaN()), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := tt.obj.Inspect(); got != tt.want {
+			t.Errorf("Inspect: expected %s, got %s", tt.want, got)
+		}
+		if got := fmt.Sprint(tt.obj); got != tt.want {
+			t.Errorf("fmt.Sprint: expected %s, got %s", tt.want, got)
+		}
+	}
+}
+
+func TestFprint(t *testing.T) {
+	inner := Array{Integer(1), String("a")}
+	for _, obj := range []Object{
+		Integer(3),
+		String("text"),
+		&Array{},
+		&Array{&inner, Bool(true), Null{}, HashOf(String("k"), &inner, Integer(1), Null{})},
+		ReturnValue{Value: &inner},
+		Error{Err: errors.New("failed")},
+	} {
+		var buf bytes.Buffer
+		if err := Fprint(&buf, obj); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), obj.Inspect(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
@@ -4,9 +4,9 @@ package object
 
 import "strconv"
 
-const _ObjectType_name = "INTEGERFLOATBOOLNULLERRORFUNCTIONSTRINGBUILTINARRAYHASHRETURN_VALUE"
+const _ObjectType_name = "INTEGERFLOATBOOLNULLERRORFUNCTIONSTRINGBUILTINARRAYHASHRETURN_VALUEEXTERNALFUTURECHANNELMUTEXATOMICPOOLTIMERTHUNKBREAKCONTINUE"
 
-var _ObjectType_index = [...]uint8{0, 7, 12, 16, 20, 25, 33, 39, 46, 51, 55, 67}
+var _ObjectType_index = [...]uint8{0, 7, 12, 16, 20, 25, 33, 39, 46, 51, 55, 67, 75, 81, 88, 93, 99, 103, 108, 113, 118, 126}
 
 func (i ObjectType) String() string {
 	i -= 1
@@ -0,0 +1,124 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// The String methods implement fmt.Stringer for every object, so that
+// formatting one with %v or %s renders it as Inspect does.
+
+func (s String) String() string       { return s.Inspect() }
+func (f *Function) String() string    { return f.Inspect() }
+func (rv ReturnValue) String() string { return rv.Inspect() }
+func (b Break) String() string        { return b.Inspect() }
+func (c Continue) String() string     { return c.Inspect() }
+func (e Error) String() string        { return e.Inspect() }
+func (i Integer) String() string      { return i.Inspect() }
+func (f Float) String() string        { return f.Inspect() }
+func (b Bool) String() string         { return b.Inspect() }
+func (n Null) String() string         { return n.Inspect() }
+func (b *Builtin) String() string     { return b.Inspect() }
+func (ao Array) String() string       { return ao.Inspect() }
+func (e *External) String() string    { return e.Inspect() }
+func (h Hash) String() string         { return h.Inspect() }
+func (f *Future) String() string      { return f.Inspect() }
+func (c *Channel) String() string     { return c.Inspect() }
+func (m *Mutex) String() string       { return m.Inspect() }
+func (a *Atomic) String() string      { return a.Inspect() }
+func (p *Pool) String() string        { return p.Inspect() }
+func (t *Timer) String() string       { return t.Inspect() }
+func (t *Thunk) String() string       { return t.Inspect() }
+
+// inspectElem returns the Inspect form of an element or key of an array or
+// hash, in which strings are quoted so that ["a, b"] and ["a", "b"] differ.
+func inspectElem(obj Object) string {
+	if s, ok := obj.(String); ok {
+		return strconv.Quote(string(s))
+	}
+	return obj.Inspect()
+}
+
+// SyncWriter returns a writer which writes to w, or to os.Stdout if w is nil,
+// holding a lock for each Write so that concurrent writes do not race. It
+// returns w itself if w is already such a writer.
+func SyncWriter(w io.Writer) io.Writer {
+	if w, ok := w.(*syncWriter); ok {
+		return w
+	}
+	return &syncWriter{w: w}
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		// Resolved on each Write, so that os.Stdout may be replaced.
+		return os.Stdout.Write(p)
+	}
+	return s.w.Write(p)
+}
+
+// Fprint writes obj to w as obj.Inspect() would return it, but writes the
+// elements of arrays and hashes as it reaches them rather than building the
+// whole string first, so printing a large array needs little memory.
+func Fprint(w io.Writer, obj Object) error {
+	bw, ok := w.(*bufio.Writer)
+	if !ok {
+		bw = bufio.NewWriter(w)
+	}
+	fprint(bw, obj)
+	return bw.Flush()
+}
+
+// fprint writes obj to w, which records the first error.
+func fprint(w *bufio.Writer, obj Object) {
+	switch obj := obj.(type) {
+	case String:
+		w.WriteString(string(obj))
+	case *Array:
+		fprint(w, *obj)
+	case Array:
+		w.WriteByte('[')
+		for i, el := range obj {
+			if i > 0 {
+				w.WriteString(", ")
+			}
+			fprintElem(w, el)
+		}
+		w.WriteByte(']')
+	case Hash:
+		w.WriteByte('{')
+		sep := ""
+		for k, v := range obj.All() {
+			w.WriteString(sep)
+			fprintElem(w, k)
+			w.WriteString(": ")
+			fprintElem(w, v)
+			sep = ", "
+		}
+		w.WriteByte('}')
+	case ReturnValue:
+		fprint(w, obj.Value)
+	default:
+		w.WriteString(obj.Inspect())
+	}
+}
+
+// fprintElem writes an element or key of an array or hash to w, quoting
+// strings as inspectElem does.
+func fprintElem(w *bufio.Writer, obj Object) {
+	if s, ok := obj.(String); ok {
+		w.WriteString(strconv.Quote(string(s)))
+		return
+	}
+	fprint(w, obj)
+}
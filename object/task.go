@@ -0,0 +1,509 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ajwerner/monkey/ast"
+)
+
+// ContextFunction is a builtin which calls back into the engine running it,
+// such as spawn.
+type ContextFunction func(ctx BuiltinContext, args ...Object) Object
+
+// BuiltinContext is the engine running a call to a ContextFunction.
+type BuiltinContext interface {
+	// Context returns the context of the running program, which is done
+	// once the program should stop.
+	Context() context.Context
+	// Apply calls fn, a function or builtin, with args.
+	Apply(fn Object, args ...Object) Object
+	// Truthiness returns the policy deciding which values the engine
+	// treats as true.
+	Truthiness() Truthiness
+	// Go calls fn with args on a new goroutine, on an engine configured
+	// like this one, and returns its Future. The call sees fn and
+	// args as isolated by Isolate when Go is called.
+	Go(fn Object, args ...Object) *Future
+	// Eval evaluates node in env with the builtins, limits and policies
+	// of the engine, stopping once Context is done.
+	Eval(node ast.Node, env *Environment) Object
+	// EvalModule evaluates program, a source module, in env as Eval does,
+	// but with builtins, unless they are nil, and resolving the module's
+	// own imports with importer.
+	EvalModule(program ast.Node, env *Environment, builtins *Builtins, importer Importer) Object
+}
+
+// Future is the eventual result of a function call running on its own
+// goroutine.
+type Future struct {
+	done   chan struct{}
+	result Object
+}
+
+// StartFuture calls fn on a new goroutine. A panic in fn, which would
+// otherwise crash the host, makes the result an Error wrapping an
+// InternalError.
+func StartFuture(fn func() Object) *Future {
+	f := &Future{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		defer func() {
+			if r := recover(); r != nil {
+				f.result = Error{Err: &InternalError{Value: r, Stack: debug.Stack()}}
+			}
+		}()
+		f.result = fn()
+	}()
+	return f
+}
+
+func (f *Future) Type() ObjectType { return FUTURE }
+func (f *Future) Inspect() string  { return "future" }
+
+// Wait waits for the call to return and returns its result.
+func (f *Future) Wait() Object {
+	<-f.done
+	return f.result
+}
+
+// WaitContext is like Wait but returns an Error wrapping ctx.Err() if ctx is
+// done first.
+func (f *Future) WaitContext(ctx context.Context) Object {
+	select {
+	case <-f.done:
+		return f.result
+	case <-ctx.Done():
+		return Error{Err: ctx.Err()}
+	}
+}
+
+// Done returns a channel which is closed once the call has returned.
+func (f *Future) Done() <-chan struct{} { return f.done }
+
+// All returns a Future of an array of the results of fs, or of the first
+// Error among them in the order of fs.
+func All(fs []*Future) *Future {
+	return StartFuture(func() Object {
+		results := make(Array, len(fs))
+		for i, f := range fs {
+			results[i] = f.Wait()
+			if _, ok := results[i].(Error); ok {
+				return results[i]
+			}
+		}
+		return &results
+	})
+}
+
+// Race returns a Future of the result of whichever of fs returns first.
+func Race(fs []*Future) *Future {
+	return StartFuture(func() Object {
+		cases := make([]reflect.SelectCase, len(fs))
+		for i, f := range fs {
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(f.done)}
+		}
+		chosen, _, _ := reflect.Select(cases)
+		return fs[chosen].result
+	})
+}
+
+// Channel carries objects between tasks. Closing a Channel closes Done
+// rather than C, so that a send racing with Close fails instead of
+// panicking.
+type Channel struct {
+	C chan Object
+
+	once sync.Once
+	done chan struct{}
+}
+
+// MaxChanCapacity is the largest buffer chan creates, since the buffer is
+// allocated before the engine checks the result against its limits.
+const MaxChanCapacity = 1 << 20
+
+// NewChannel returns a Channel buffering up to capacity objects.
+func NewChannel(capacity int) *Channel {
+	return &Channel{C: make(chan Object, capacity), done: make(chan struct{})}
+}
+
+func (c *Channel) Type() ObjectType { return CHANNEL }
+func (c *Channel) Inspect() string  { return fmt.Sprintf("chan(%d)", cap(c.C)) }
+
+// errClosed is returned by operations on a closed Channel.
+var errClosed = errors.New("channel is closed")
+
+// Send sends obj, isolated, on c, waiting until it is received or buffered,
+// c is closed or ctx is done.
+func (c *Channel) Send(ctx context.Context, obj Object) error {
+	select {
+	case <-c.done:
+		return errClosed
+	default:
+	}
+	select {
+	case c.C <- Isolate(obj):
+		return nil
+	case <-c.done:
+		return errClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recv receives an object from c, waiting until one is sent, c is closed or
+// ctx is done. It returns false once c is closed and drained.
+func (c *Channel) Recv(ctx context.Context) (Object, bool, error) {
+	select {
+	case obj := <-c.C:
+		return obj, true, nil
+	case <-c.done:
+		obj, ok := c.drain()
+		return obj, ok, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// drain returns an object left in the buffer of a closed channel, if any.
+func (c *Channel) drain() (Object, bool) {
+	select {
+	case obj := <-c.C:
+		return obj, true
+	default:
+		return nil, false
+	}
+}
+
+// Done returns a channel which is closed when c is closed.
+func (c *Channel) Done() <-chan struct{} { return c.done }
+
+// Close closes c. Closing a closed channel is an error.
+func (c *Channel) Close() error {
+	err := errClosed
+	c.once.Do(func() {
+		close(c.done)
+		err = nil
+	})
+	return err
+}
+
+// Mutex is a lock which tasks hold while using state they share, such as a
+// connection returned by a builtin.
+type Mutex struct{ sem chan struct{} }
+
+// NewMutex returns an unlocked Mutex.
+func NewMutex() *Mutex { return &Mutex{sem: make(chan struct{}, 1)} }
+
+func (m *Mutex) Type() ObjectType { return MUTEX }
+func (m *Mutex) Inspect() string  { return "mutex" }
+
+// Lock locks m, waiting until it is unlocked or ctx is done.
+func (m *Mutex) Lock(ctx context.Context) error {
+	select {
+	case m.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unlock unlocks m, which any task may do. Unlocking an unlocked Mutex is an
+// error.
+func (m *Mutex) Unlock() error {
+	select {
+	case <-m.sem:
+		return nil
+	default:
+		return errors.New("unlock of unlocked mutex")
+	}
+}
+
+// Atomic is an integer which tasks update atomically.
+type Atomic struct{ n atomic.Int64 }
+
+func (a *Atomic) Type() ObjectType { return ATOMIC }
+func (a *Atomic) Inspect() string  { return fmt.Sprintf("atomic(%d)", a.n.Load()) }
+
+// Add adds delta to a and returns the new value.
+func (a *Atomic) Add(delta int64) int64 { return a.n.Add(delta) }
+
+// Pool bounds the number of tasks submitted to it which run at once.
+type Pool struct {
+	sem chan struct{}
+
+	mu      sync.Mutex
+	pending []*Future // submitted since the last Drain
+}
+
+// NewPool returns a Pool running up to n tasks at once.
+func NewPool(n int) *Pool { return &Pool{sem: make(chan struct{}, n)} }
+
+func (p *Pool) Type() ObjectType { return POOL }
+func (p *Pool) Inspect() string  { return fmt.Sprintf("pool(%d)", cap(p.sem)) }
+
+// Submit calls fn with args on ctx.Go once fewer than the pool's limit of
+// the tasks submitted to it are running.
+func (p *Pool) Submit(ctx BuiltinContext, fn Object, args ...Object) *Future {
+	gated := &Builtin{ContextFn: func(ctx BuiltinContext, args ...Object) Object {
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Context().Done():
+			return Error{Err: ctx.Context().Err()}
+		}
+		defer func() { <-p.sem }()
+		return ctx.Apply(args[0], args[1:]...)
+	}}
+	f := ctx.Go(gated, append([]Object{fn}, args...)...)
+	p.mu.Lock()
+	p.pending = append(p.pending, f)
+	p.mu.Unlock()
+	return f
+}
+
+// Drain waits for the tasks submitted since the last Drain and returns
+// their results in the order they were submitted, or the first Error among
+// them.
+func (p *Pool) Drain(ctx context.Context) Object {
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	var failed Object
+	results := make(Array, len(pending))
+	for i, f := range pending {
+		results[i] = f.WaitContext(ctx)
+		if _, ok := results[i].(Error); ok && failed == nil {
+			failed = results[i]
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	return &results
+}
+
+// Timer is a handle to a function scheduled by after or every, which
+// Cancel stops.
+type Timer struct {
+	once     sync.Once
+	canceled chan struct{}
+}
+
+func (t *Timer) Type() ObjectType { return TIMER }
+func (t *Timer) Inspect() string  { return "timer" }
+
+// Cancel stops t from calling its function again. A call in progress
+// finishes.
+func (t *Timer) Cancel() { t.once.Do(func() { close(t.canceled) }) }
+
+// timerGroup holds the timers scheduled by one run of a program.
+type timerGroup struct {
+	mu      sync.Mutex
+	timers  []*Timer
+	stopped bool
+}
+
+type timerGroupKey struct{}
+
+// WithTimers returns a context for one run of a program and a function
+// which cancels every timer scheduled under that context, including by the
+// program's tasks. Hosts call it so that the timers of a program stop when
+// it returns, even if its context never ends.
+func WithTimers(ctx context.Context) (context.Context, func()) {
+	g := &timerGroup{}
+	return context.WithValue(ctx, timerGroupKey{}, g), func() {
+		g.mu.Lock()
+		timers := g.timers
+		g.timers, g.stopped = nil, true
+		g.mu.Unlock()
+		for _, t := range timers {
+			t.Cancel()
+		}
+	}
+}
+
+// add adds t to g, canceling it if g is already stopped.
+func (g *timerGroup) add(t *Timer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		t.Cancel()
+		return
+	}
+	g.timers = append(g.timers, t)
+}
+
+// Schedule calls fn on a task after d and then, if repeat is set, every d
+// until t is canceled, ctx's program stops or a call returns an Error. If
+// the program's context came from WithTimers, t is also canceled when the
+// run stops its timers.
+func Schedule(ctx BuiltinContext, d time.Duration, repeat bool, fn Object) *Timer {
+	t := &Timer{canceled: make(chan struct{})}
+	if g, ok := ctx.Context().Value(timerGroupKey{}).(*timerGroup); ok {
+		g.add(t)
+	}
+	run := &Builtin{ContextFn: func(ctx BuiltinContext, args ...Object) Object {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-t.canceled:
+				return Null{}
+			case <-ctx.Context().Done():
+				return Error{Err: ctx.Context().Err()}
+			}
+			// select picks at random among ready cases, so a tick may be
+			// received after t was canceled or the program stopped.
+			select {
+			case <-t.canceled:
+				return Null{}
+			case <-ctx.Context().Done():
+				return Error{Err: ctx.Context().Err()}
+			default:
+			}
+			result := ctx.Apply(args[0])
+			if _, ok := result.(Error); ok || !repeat {
+				return result
+			}
+		}
+	}}
+	ctx.Go(run, fn)
+	return t
+}
+
+// Isolate returns obj in a form which may be used on another goroutine while
+// the program which created it continues. Values are immutable except for
+// environments, which programs extend with let statements, so Isolate
+// replaces each function reachable from obj, including through arrays,
+// hashes and the environments of other functions, with a copy whose
+// environment is a snapshot, and each builtin returned by memo with one
+// calling such a copy. Thunks in the environments are forced first, since
+// forcing one uses the evaluator which made it. Objects which reach no
+// function are returned as is. Builtins which change arrays or hashes in
+// place break the first assumption, and scripts sharing such values must
+// serialize their calls with a Mutex.
+func Isolate(obj Object) Object {
+	s := snapshotter{
+		envs:  map[*Environment]*Environment{},
+		fns:   map[*Function]*Function{},
+		memos: map[*memo]*Builtin{},
+	}
+	isolated, _ := s.object(obj)
+	return isolated
+}
+
+type snapshotter struct {
+	envs  map[*Environment]*Environment
+	fns   map[*Function]*Function
+	memos map[*memo]*Builtin
+}
+
+// object returns the isolated form of obj and whether it differs from obj.
+func (s *snapshotter) object(obj Object) (Object, bool) {
+	switch obj := obj.(type) {
+	case *Function:
+		return s.function(obj), true
+	case *Array:
+		var copied Array
+		for i, el := range *obj {
+			iso, changed := s.object(el)
+			if !changed {
+				continue
+			}
+			if copied == nil {
+				copied = append(Array(nil), *obj...)
+			}
+			copied[i] = iso
+		}
+		if copied == nil {
+			return obj, false
+		}
+		return &copied, true
+	case Hash:
+		var copied *Hash
+		for k, v := range obj.All() {
+			iso, changed := s.object(v)
+			if !changed {
+				continue
+			}
+			if copied == nil {
+				h := NewHash(obj.Len())
+				for k, v := range obj.All() {
+					h.Set(k, v)
+				}
+				copied = &h
+			}
+			copied.Set(k, iso)
+		}
+		if copied == nil {
+			return obj, false
+		}
+		return *copied, true
+	case *Builtin:
+		if obj.memo == nil {
+			return obj, false
+		}
+		if copied, ok := s.memos[obj.memo]; ok {
+			return copied, true
+		}
+		// The copy starts with an empty cache, since the cached results
+		// may hold functions.
+		copied := newMemo(nil)
+		s.memos[obj.memo] = copied
+		copied.memo.fn, _ = s.object(obj.memo.fn)
+		return copied, true
+	case ReturnValue:
+		iso, changed := s.object(obj.Value)
+		return ReturnValue{Value: iso}, changed
+	case *Thunk:
+		iso, _ := s.object(obj.Force())
+		return iso, true
+	default:
+		return obj, false
+	}
+}
+
+func (s *snapshotter) function(fn *Function) *Function {
+	if copied, ok := s.fns[fn]; ok {
+		return copied
+	}
+	copied := &Function{Parameters: fn.Parameters, Body: fn.Body, Scope: fn.Scope}
+	s.fns[fn] = copied
+	copied.Env = s.env(fn.Env)
+	return copied
+}
+
+func (s *snapshotter) env(env *Environment) *Environment {
+	if env == nil {
+		return nil
+	}
+	if copied, ok := s.envs[env]; ok {
+		return copied
+	}
+	copied := &Environment{scope: env.scope}
+	s.envs[env] = copied
+	copied.parent = s.env(env.parent)
+	if env.store != nil {
+		copied.store = make(map[string]Object, len(env.store))
+		for name, val := range env.store {
+			copied.store[name], _ = s.object(val)
+		}
+	}
+	if env.slots != nil {
+		copied.slots = make([]Object, len(env.slots))
+		for i, val := range env.slots {
+			if val != nil {
+				copied.slots[i], _ = s.object(val)
+			}
+		}
+	}
+	return copied
+}
@@ -0,0 +1,57 @@
+package object
+
+import "errors"
+
+// errThunkCycle is the error of a thunk whose value depends on itself, as
+// that of x in let x = x + 1 does.
+var errThunkCycle = errors.New("lazy binding depends on itself")
+
+// Thunk is a value whose computation is deferred until it is first needed,
+// as an evaluator using lazy evaluation binds names to the values of let
+// statements and function arguments. Evaluators force thunks as they read
+// them from environments, so no other value, builtin or host sees one.
+// A Thunk must not be forced by several goroutines at once.
+type Thunk struct {
+	fn      func() Object // nil once forced
+	val     Object
+	forcing bool
+}
+
+// NewThunk returns a thunk whose value is the result of fn, which is called
+// at most once.
+func NewThunk(fn func() Object) *Thunk {
+	return &Thunk{fn: fn}
+}
+
+func (t *Thunk) Type() ObjectType { return THUNK }
+
+func (t *Thunk) Inspect() string {
+	if t.fn != nil {
+		return "<thunk>"
+	}
+	return t.val.Inspect()
+}
+
+// Force returns the value of t, computing it the first time. The value may
+// be an Error, which is returned again by later calls.
+func (t *Thunk) Force() Object {
+	if t.fn == nil {
+		return t.val
+	}
+	if t.forcing {
+		return Error{Err: errThunkCycle}
+	}
+	t.forcing = true
+	val := Force(t.fn())
+	t.forcing = false
+	t.fn, t.val = nil, val
+	return val
+}
+
+// Force returns obj, or its value if it is a thunk.
+func Force(obj Object) Object {
+	if t, ok := obj.(*Thunk); ok {
+		return t.Force()
+	}
+	return obj
+}
@@ -0,0 +1,56 @@
+package object
+
+import "fmt"
+
+// Truthiness is a policy deciding which values count as true in conditions,
+// such as those of if expressions, and as operands of the ! operator.
+type Truthiness int
+
+const (
+	// ClassicTruthiness treats false and NULL as false and every other
+	// value as true.
+	ClassicTruthiness Truthiness = iota
+	// ExtendedTruthiness also treats zero numbers, the empty string and
+	// empty arrays and hashes as false.
+	ExtendedTruthiness
+)
+
+func (t Truthiness) String() string {
+	switch t {
+	case ClassicTruthiness:
+		return "classic"
+	case ExtendedTruthiness:
+		return "extended"
+	default:
+		return fmt.Sprintf("Truthiness(%d)", int(t))
+	}
+}
+
+// IsTruthy reports whether obj counts as true under t.
+func (t Truthiness) IsTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case Bool:
+		return bool(obj)
+	case Null:
+		return false
+	}
+	if t != ExtendedTruthiness {
+		return true
+	}
+	switch obj := obj.(type) {
+	case Integer:
+		return obj != 0
+	case Float:
+		return obj != 0
+	case String:
+		return obj != ""
+	case *Array:
+		return len(*obj) != 0
+	case Array:
+		return len(obj) != 0
+	case Hash:
+		return obj.Len() != 0
+	default:
+		return true
+	}
+}
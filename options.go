@@ -0,0 +1,303 @@
+package monkey
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+
+	"github.com/ajwerner/monkey/feature"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/stdlib"
+	"github.com/ajwerner/monkey/token"
+)
+
+// Engine selects how an Interpreter executes programs.
+type Engine int
+
+const (
+	// EngineEval walks the AST with the evaluator package.
+	EngineEval Engine = iota
+	// EngineVM compiles each program to bytecode and runs it on the vm
+	// package's virtual machine, which does not yet support functions.
+	EngineVM
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineEval:
+		return "eval"
+	case EngineVM:
+		return "vm"
+	default:
+		return fmt.Sprintf("Engine(%d)", int(e))
+	}
+}
+
+// Config holds the settings of an Interpreter. New builds a Config by
+// applying each Option to the zero value in turn and validates the result.
+type Config struct {
+	// Engine executes programs passed to Eval.
+	Engine Engine
+	// Limits bounds the resources used by each call to Eval and by each
+	// run of a compiled Script.
+	Limits sandbox.Limits
+	// Output receives the output of builtins such as puts. Nil means
+	// os.Stdout.
+	Output io.Writer
+	// Builtins is copied to form the interpreter's builtin table. Nil means
+	// the standard builtins.
+	Builtins *object.Builtins
+	// OnlyBuiltins, if non-empty, names the only builtins to keep.
+	OnlyBuiltins []string
+	// RemoveBuiltins names builtins to remove.
+	RemoveBuiltins []string
+	// DeniedCapabilities removes the builtins which require any of them.
+	DeniedCapabilities object.Capability
+	// ForbiddenSyntax lists the token types rejected before parsing.
+	ForbiddenSyntax []token.TokenType
+	// Modules are importable in addition to, and in preference to, the
+	// modules registered with the stdlib package. DeniedCapabilities and
+	// Output apply to the builtins of every imported module.
+	Modules map[string]stdlib.Builder
+	// ModulePath lists the locations searched, in order, for source modules
+	// which are not Go modules. The directories in $MONKEY_PATH are searched
+	// after them unless DeniedCapabilities includes CapFile.
+	ModulePath []stdlib.Dir
+	// TypeCheck rejects programs in which the types package finds a type
+	// mismatch before running them.
+	TypeCheck bool
+	// Truthiness decides which values conditions and the ! operator treat
+	// as true, with either engine.
+	Truthiness object.Truthiness
+	// DisabledFeatures lists the optional language features programs may
+	// not use, whose keywords are identifiers instead. Source modules are
+	// parsed with every feature.
+	DisabledFeatures feature.Feature
+	// StrictIndex makes indexing an array out of range or a hash with a
+	// missing key an error rather than NULL, with either engine.
+	StrictIndex bool
+	// Lazy defers the evaluation of let bindings and function arguments
+	// until their first use. It requires EngineEval.
+	Lazy bool
+}
+
+// Option configures an Interpreter.
+type Option func(*Config) error
+
+// WithEngine selects the engine used by Eval.
+func WithEngine(e Engine) Option {
+	return func(c *Config) error {
+		c.Engine = e
+		return nil
+	}
+}
+
+// WithLimits bounds the resources used by each call to Eval and by each run
+// of a compiled Script.
+func WithLimits(limits sandbox.Limits) Option {
+	return func(c *Config) error {
+		c.Limits = limits
+		return nil
+	}
+}
+
+// WithOutput directs the output of builtins such as puts to w instead of
+// os.Stdout.
+func WithOutput(w io.Writer) Option {
+	return func(c *Config) error {
+		if w == nil {
+			return errors.New("nil output writer")
+		}
+		c.Output = w
+		return nil
+	}
+}
+
+// WithBuiltins replaces the standard builtins with a copy of b.
+func WithBuiltins(b *object.Builtins) Option {
+	return func(c *Config) error {
+		if b == nil {
+			return errors.New("nil builtins")
+		}
+		c.Builtins = b
+		return nil
+	}
+}
+
+// WithoutBuiltins removes the named builtins.
+func WithoutBuiltins(names ...string) Option {
+	return func(c *Config) error {
+		c.RemoveBuiltins = append(c.RemoveBuiltins, names...)
+		return nil
+	}
+}
+
+// WithOnlyBuiltins removes every builtin not named in names.
+func WithOnlyBuiltins(names ...string) Option {
+	return func(c *Config) error {
+		c.OnlyBuiltins = append(c.OnlyBuiltins, names...)
+		return nil
+	}
+}
+
+// WithoutCapabilities removes the builtins which require any of caps, for
+// example object.CapIO to deny access to the host's files, network and
+// processes.
+func WithoutCapabilities(caps object.Capability) Option {
+	return func(c *Config) error {
+		c.DeniedCapabilities |= caps
+		return nil
+	}
+}
+
+// WithForbiddenSyntax rejects source containing tokens of the given types
+// before it is parsed; for example token.FUNCTION forbids function literals.
+func WithForbiddenSyntax(types ...token.TokenType) Option {
+	return func(c *Config) error {
+		c.ForbiddenSyntax = append(c.ForbiddenSyntax, types...)
+		return nil
+	}
+}
+
+// WithModule makes the module built by b importable as name by this
+// interpreter's scripts, taking precedence over a stdlib module of the same
+// name.
+func WithModule(name string, b stdlib.Builder) Option {
+	return func(c *Config) error {
+		if b == nil {
+			return errors.New("nil module builder")
+		}
+		if c.Modules == nil {
+			c.Modules = map[string]stdlib.Builder{}
+		}
+		c.Modules[name] = b
+		return nil
+	}
+}
+
+// WithModuleFS adds fsys, described as name in errors, to the locations
+// searched for source modules, after those added before it.
+func WithModuleFS(name string, fsys fs.FS) Option {
+	return func(c *Config) error {
+		if fsys == nil {
+			return errors.New("nil module file system")
+		}
+		c.ModulePath = append(c.ModulePath, stdlib.Dir{Name: name, FS: fsys})
+		return nil
+	}
+}
+
+// WithTypeCheck checks each program with the types package before running
+// it and returns the mismatches found as a *TypeError instead of running it.
+func WithTypeCheck() Option {
+	return func(c *Config) error {
+		c.TypeCheck = true
+		return nil
+	}
+}
+
+// WithTruthiness selects which values conditions and the ! operator treat
+// as true. The default, object.ClassicTruthiness, treats only false and NULL
+// as false.
+func WithTruthiness(t object.Truthiness) Option {
+	return func(c *Config) error {
+		if t != object.ClassicTruthiness && t != object.ExtendedTruthiness {
+			return fmt.Errorf("unknown truthiness %v", t)
+		}
+		c.Truthiness = t
+		return nil
+	}
+}
+
+// WithFeatures restricts programs to the language features f, such as
+// feature.Classic for the language of the book, in which the keywords of the
+// other features are identifiers. By default programs may use every
+// feature.
+func WithFeatures(f feature.Feature) Option {
+	return func(c *Config) error {
+		c.DisabledFeatures = feature.Extended &^ f
+		return nil
+	}
+}
+
+// WithStrictIndex makes indexing an array out of range or a hash with a
+// missing key fail with an error wrapping object.ErrIndexOutOfRange or
+// object.ErrKeyNotFound, rather than evaluate to NULL.
+func WithStrictIndex() Option {
+	return func(c *Config) error {
+		c.StrictIndex = true
+		return nil
+	}
+}
+
+// WithLazyEvaluation makes the interpreter evaluate the values of let
+// statements and the arguments of function calls only when they are first
+// used, as described in the README. It is experimental and requires
+// EngineEval.
+func WithLazyEvaluation() Option {
+	return func(c *Config) error {
+		c.Lazy = true
+		return nil
+	}
+}
+
+// WithLogger directs the records written by the log module to l.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Config) error {
+		if l == nil {
+			return errors.New("nil logger")
+		}
+		return WithModule("log", stdlib.NewLogModule(l))(c)
+	}
+}
+
+// modulePath returns the locations searched for source modules.
+func (c *Config) modulePath() []stdlib.Dir {
+	path := append([]stdlib.Dir(nil), c.ModulePath...)
+	if c.DeniedCapabilities&object.CapFile == 0 {
+		path = append(path, stdlib.EnvPath()...)
+	}
+	return path
+}
+
+// validate reports the first invalid setting in c, in which Builtins must be
+// set.
+func (c *Config) validate() error {
+	if c.Engine != EngineEval && c.Engine != EngineVM {
+		return fmt.Errorf("unknown engine %v", c.Engine)
+	}
+	if c.Lazy && c.Engine != EngineEval {
+		return fmt.Errorf("lazy evaluation is not supported by engine %v", c.Engine)
+	}
+	l := c.Limits
+	if l.MaxSteps < 0 || l.MaxDepth < 0 || l.MaxMemory < 0 || l.MaxStringLen < 0 || l.MaxArrayLen < 0 {
+		return errors.New("limits must not be negative")
+	}
+	for _, names := range [][]string{c.OnlyBuiltins, c.RemoveBuiltins} {
+		for _, name := range names {
+			if _, ok := c.Builtins.Lookup(name); !ok {
+				return fmt.Errorf("unknown builtin %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// builtins returns a new builtin table configured by c.
+func (c *Config) builtins() *object.Builtins {
+	b := c.Builtins.Clone()
+	if len(c.OnlyBuiltins) > 0 {
+		b.Keep(c.OnlyBuiltins...)
+	}
+	for _, name := range c.RemoveBuiltins {
+		b.Remove(name)
+	}
+	b.Restrict(c.DeniedCapabilities)
+	if c.Output != nil {
+		b.SetOutput(c.Output)
+	}
+	return b
+}
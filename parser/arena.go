@@ -0,0 +1,111 @@
+package parser
+
+import "github.com/ajwerner/monkey/ast"
+
+// arenaChunk is the number of nodes of each type allocated at once by a
+// parser created with NewWithArena.
+const arenaChunk = 256
+
+// arena allocates the most common kinds of node. A parser without an arena
+// uses chunks of one node, which is the same as allocating each node.
+type arena struct {
+	idents      slab[ast.Identifier]
+	ints        slab[ast.IntegerLiteral]
+	strings     slab[ast.StringLiteral]
+	bools       slab[ast.Bool]
+	prefixes    slab[ast.PrefixExpression]
+	infixes     slab[ast.InfixExpression]
+	calls       slab[ast.CallExpression]
+	indexes     slab[ast.IndexExpression]
+	ifs         slab[ast.IfExpression]
+	funcs       slab[ast.FunctionLiteral]
+	blocks      slab[ast.BlockStatement]
+	lets        slab[ast.LetStatement]
+	returns     slab[ast.ReturnStatement]
+	expressions slab[ast.ExpressionStatement]
+
+	statementLists  lists[ast.Statement]
+	expressionLists lists[ast.Expression]
+	identLists      lists[*ast.Identifier]
+}
+
+func newArena(chunk int) *arena {
+	a := &arena{}
+	for _, size := range []*int{
+		&a.idents.chunk, &a.ints.chunk, &a.strings.chunk, &a.bools.chunk,
+		&a.prefixes.chunk, &a.infixes.chunk, &a.calls.chunk, &a.indexes.chunk,
+		&a.ifs.chunk, &a.funcs.chunk, &a.blocks.chunk, &a.lets.chunk,
+		&a.returns.chunk, &a.expressions.chunk, &a.statementLists.chunk,
+		&a.expressionLists.chunk, &a.identLists.chunk,
+	} {
+		*size = chunk
+	}
+	return a
+}
+
+// slab allocates values of type T chunk at a time. A chunk is freed once
+// none of its values is referenced.
+type slab[T any] struct {
+	chunk int
+	free  []T
+}
+
+// new returns a pointer to a copy of v.
+func (s *slab[T]) new(v T) *T {
+	if len(s.free) == 0 {
+		s.free = make([]T, s.chunk)
+	}
+	p := &s.free[0]
+	s.free = s.free[1:]
+	*p = v
+	return p
+}
+
+// lists allocates slices of T, carving short ones from chunks of chunk
+// elements. Each slice's capacity is its length, so appending to one never
+// overwrites the next.
+type lists[T any] struct {
+	chunk int
+	free  []T
+}
+
+// make returns a slice of n zero values, which is empty but not nil if n is
+// zero.
+func (l *lists[T]) make(n int) []T {
+	if n == 0 {
+		return []T{}
+	}
+	if n > len(l.free) {
+		// A long list would waste the rest of a chunk.
+		if n > l.chunk/4 {
+			return make([]T, n)
+		}
+		l.free = make([]T, l.chunk)
+	}
+	s := l.free[:n:n]
+	l.free = l.free[n:]
+	return s
+}
+
+// scratch is a stack holding the elements of the lists being parsed, which
+// nest as the lists do. A list's elements are pushed as they are parsed and
+// popped into a slice of the right length once it ends, so that one stack,
+// grown once, serves every list rather than each list growing its own slice.
+type scratch[T any] struct {
+	stack []T
+}
+
+// mark returns the position of the first element of a new list.
+func (s *scratch[T]) mark() int { return len(s.stack) }
+
+func (s *scratch[T]) push(v T) { s.stack = append(s.stack, v) }
+
+// pop removes the elements pushed since mark and returns them in a slice
+// allocated by l.
+func (s *scratch[T]) pop(mark int, l *lists[T]) []T {
+	list := l.make(len(s.stack) - mark)
+	copy(list, s.stack[mark:])
+	clear(s.stack[mark:])
+	s.stack = s.stack[:mark]
+	return list
+}
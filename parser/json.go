@@ -0,0 +1,65 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/lexer"
+)
+
+// ParseJSON parses src, a JSON document, as the monkey expression with the
+// same meaning: objects become hash literals, arrays array literals, and
+// null, true, false, numbers and strings the corresponding literals. Strings
+// are read with lexer.NewJSON, so every JSON escape sequence is decoded. It
+// reports an error if src is anything other than a single JSON value.
+func ParseJSON(src string) (ast.Expression, error) {
+	p := New(lexer.NewJSON(src))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(program.Statements) != 1 {
+		return nil, &Error{Line: program.Line(), Msg: "expected a single JSON value"}
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return nil, &Error{Line: program.Line(), Msg: "expected a single JSON value"}
+	}
+	if err := checkJSON(stmt.Expression); err != nil {
+		return nil, err
+	}
+	return stmt.Expression, nil
+}
+
+// checkJSON reports an error if expr is not a JSON value.
+func checkJSON(expr ast.Expression) error {
+	switch expr := expr.(type) {
+	case *ast.NullLiteral, *ast.Bool, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral:
+		return nil
+	case *ast.PrefixExpression:
+		switch expr.Right.(type) {
+		case *ast.IntegerLiteral, *ast.FloatLiteral:
+			if expr.Operator == "-" {
+				return nil
+			}
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range expr.Elements {
+			if err := checkJSON(el); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *ast.HashLiteral:
+		for _, pair := range expr.Pairs {
+			if _, ok := pair.Key.(*ast.StringLiteral); !ok {
+				return &Error{Line: pair.Key.Line(), Msg: "object keys must be strings, got " + pair.Key.String()}
+			}
+			if err := checkJSON(pair.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return &Error{Line: expr.Line(), Msg: "not a JSON value: " + expr.String()}
+}
@@ -2,11 +2,16 @@
 package parser
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 
 	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/feature"
 	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/monkeyerr"
 	"github.com/ajwerner/monkey/token"
 )
 
@@ -20,8 +25,9 @@ type precedence int
 const (
 	_ precedence = iota
 	LOWEST
+	ASSIGN      // x = y
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // >, <, >= or <=
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -30,16 +36,24 @@ const (
 )
 
 var precedences = map[token.TokenType]precedence{
-	token.EQ:       EQUALS,
-	token.NEQ:      EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.STAR:     PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:     ASSIGN,
+	token.EQ:         EQUALS,
+	token.NEQ:        EQUALS,
+	token.LT:         LESSGREATER,
+	token.GT:         LESSGREATER,
+	token.LTE:        LESSGREATER,
+	token.GTE:        LESSGREATER,
+	token.IS:         LESSGREATER,
+	token.AS:         LESSGREATER,
+	token.PLUS:       SUM,
+	token.MINUS:      SUM,
+	token.SLASH:      PRODUCT,
+	token.FLOORSLASH: PRODUCT,
+	token.PERCENT:    PRODUCT,
+	token.STAR:       PRODUCT,
+	token.LPAREN:     CALL,
+	token.LBRACKET:   INDEX,
+	token.DOT:        INDEX,
 }
 
 type Parser struct {
@@ -47,14 +61,49 @@ type Parser struct {
 	curToken  token.Token
 	peekToken token.Token
 
+	ctx    context.Context
+	tokens int
+	depth  int  // of the expressions being parsed
+	loops  int  // enclosing the statement being parsed, in the innermost function
+	halted bool // by an error after which no others are recorded
+
+	// control is whether the statement being parsed may be break or
+	// continue: one of a loop body, or of an if statement in one, rather
+	// than of a block whose value an expression uses.
+	control bool
+	// statement is whether the expression about to be parsed is a whole
+	// expression statement.
+	statement bool
+	// controls are the break and continue statements parsed so far.
+	controls []token.Token
+
 	errors []error
 
+	arena *arena
+
+	// Scratch space for the lists being parsed.
+	statements  scratch[ast.Statement]
+	expressions scratch[ast.Expression]
+	idents      scratch[*ast.Identifier]
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l}
+	return newParser(l, 1)
+}
+
+// NewWithArena is like New but allocates the most common kinds of node in
+// chunks, which makes parsing large programs faster and lightens the load on
+// the garbage collector. The nodes of a chunk are freed together, so a node
+// kept after the rest of the program is discarded keeps its chunk alive.
+func NewWithArena(l *lexer.Lexer) *Parser {
+	return newParser(l, arenaChunk)
+}
+
+func newParser(l *lexer.Lexer, chunk int) *Parser {
+	p := &Parser{arena: newArena(chunk)}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
@@ -64,44 +113,127 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBool)
 	p.registerPrefix(token.FALSE, p.parseBool)
+	p.registerPrefix(token.NULL, p.parseNull)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.WHILE, p.parseWhileExpression)
+	p.registerPrefix(token.FOR, p.parseForExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
+	p.registerPrefix(token.AWAIT, p.parseAwaitExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
+	p.registerInfix(token.FLOORSLASH, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	p.registerInfix(token.STAR, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NEQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LTE, p.parseInfixExpression)
+	p.registerInfix(token.GTE, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.IS, p.parseTypeAssertion)
+	p.registerInfix(token.AS, p.parseTypeAssertion)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
-	// Read two tokens, so curToken and peekToken are both set
-	p.nextToken()
-	p.nextToken()
+	p.registerInfix(token.DOT, p.parseSelectorExpression)
+	p.Reset(l)
 
 	return p
 }
 
+// Errors returns the errors encountered while parsing: a *lexer.Error if
+// the lexer failed, an *Error for each syntax error and the context's error
+// if parsing was canceled.
 func (p *Parser) Errors() []error {
 	return p.errors
 }
 
+// Error is a syntax error.
+type Error struct {
+	Line int // 1-based line at which the error occurred, if known
+	Msg  string
+
+	// Incomplete reports that the error is at the end of the input, such as
+	// a block missing its closing brace, so that more input may complete the
+	// program.
+	Incomplete bool
+}
+
+// Unwrap returns io.ErrUnexpectedEOF if e is Incomplete.
+func (e *Error) Unwrap() error {
+	if e.Incomplete {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// Incomplete reports whether errs, the errors of parsing a program, are all
+// at the end of the input, as those of the first lines of a longer program
+// are. Such errors wrap io.ErrUnexpectedEOF.
+func Incomplete(errs []error) bool {
+	for _, err := range errs {
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			return false
+		}
+	}
+	return len(errs) != 0
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// Diagnostic implements monkeyerr.Diagnoser.
+func (e *Error) Diagnostic() monkeyerr.Diagnostic {
+	return monkeyerr.Diagnostic{Phase: monkeyerr.PhaseParse, Pos: monkeyerr.Position{Line: e.Line}, Msg: e.Msg, Err: e}
+}
+
+// errorf records a syntax error at tok. If tok's line has an identifier
+// which is a keyword of a feature the lexer does not accept, the error names
+// the feature, as the program may have been written for it.
+func (p *Parser) errorf(tok token.Token, format string, a ...interface{}) {
+	if p.halted {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	for _, r := range p.l.Reserved() {
+		if r.Line == tok.Line {
+			msg += fmt.Sprintf(" (%q is a keyword of language feature %s)",
+				r.Literal, feature.Keyword(token.LookupIdent(r.Literal)))
+			break
+		}
+	}
+	p.errors = append(p.errors, &Error{Line: tok.Line, Msg: msg, Incomplete: tok.Type == token.EOF})
+}
+
+// requireFeature reports whether the lexer accepts f, and otherwise records
+// an error at tok saying that what requires f.
+func (p *Parser) requireFeature(tok token.Token, f feature.Feature, what string) bool {
+	if p.l.Features().Has(f) {
+		return true
+	}
+	p.errorf(tok, "%s require language feature %s", what, f)
+	return false
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Errorf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorf(p.curToken, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	err := fmt.Errorf("expected next token to be %s, got %s instead",
+	p.errorf(p.peekToken, "expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, err)
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -113,7 +245,7 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
 }
 
 func (p *Parser) peekPrecedence() precedence {
@@ -132,27 +264,64 @@ func (p *Parser) curPrecedence() precedence {
 	return LOWEST
 }
 
+// MaxNesting is the deepest expressions may nest, such as in ((1)) or [[1]],
+// past which the parser reports an error rather than risk exhausting the
+// stack of the parser, type checker, compiler or evaluator, which recurse as
+// deep.
+const MaxNesting = 1000
+
+// ctxCheckInterval is the number of tokens between checks of the context.
+const ctxCheckInterval = 1 << 10
+
+// nextToken advances the parser. Once the lexer fails or the context is done,
+// the error is recorded and every following token is EOF, so that parsing
+// winds down.
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
+	if p.peekToken.Type == token.EOF && p.l.Token().Type != token.EOF {
+		return
+	}
+	p.tokens++
+	if p.ctx != nil && p.tokens%ctxCheckInterval == 0 {
+		if err := p.ctx.Err(); err != nil {
+			p.peekToken = token.Token{Type: token.EOF}
+			p.errors = append(p.errors, err)
+			return
+		}
+	}
 	if p.l.Next() {
 		p.peekToken = p.l.Token()
 	} else {
-		p.peekToken = token.Token{Type: token.ILLEGAL}
+		p.peekToken = token.Token{Type: token.EOF}
 		p.errors = append(p.errors, p.l.Err())
 	}
 }
 
+// ParseProgramContext is like ParseProgram but stops with an error wrapping
+// ctx.Err() once ctx is done.
+func (p *Parser) ParseProgramContext(ctx context.Context) *ast.Program {
+	p.ctx = ctx
+	defer func() { p.ctx = nil }()
+	if err := ctx.Err(); err != nil {
+		p.errors = append(p.errors, err)
+		return &ast.Program{}
+	}
+	return p.ParseProgram()
+}
+
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
-	program.Statements = []ast.Statement{}
 
+	mark := p.statements.mark()
 	for p.curToken.Type != token.EOF {
 		stmt := p.parseStatement()
 		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
+			p.statements.push(stmt)
 		}
 		p.nextToken()
 	}
+	program.Statements = p.statements.pop(mark, &p.arena.statementLists)
+	program.Comments = p.l.Comments()
 
 	return program
 }
@@ -163,6 +332,10 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.PROTOCOL:
+		return p.parseProtocolStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -187,7 +360,7 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
-	stmt := &ast.ReturnStatement{Token: p.curToken}
+	stmt := p.arena.returns.new(ast.ReturnStatement{Token: p.curToken})
 
 	p.nextToken()
 
@@ -200,14 +373,47 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+// parseLoopControlStatement parses break or continue, which may only appear
+// in the body of a loop, and not in a function literal within it. Nor may
+// they appear in a block whose value is used, as in let x = if (c) { break },
+// since the loop would have no value to give it.
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.curToken
+	// The semicolon is consumed even when the statement is rejected, so
+	// that it is not parsed as the start of another statement.
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	if p.loops == 0 {
+		p.errorf(tok, "%s is not in a loop", tok.Literal)
+		return nil
+	}
+	if !p.control {
+		p.errorf(tok, "%s cannot be used in an expression", tok.Literal)
+		return nil
+	}
+	p.controls = append(p.controls, tok)
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := p.arena.lets.new(ast.LetStatement{Token: p.curToken})
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name = p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if stmt.Type = p.parseType(); stmt.Type == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -225,9 +431,75 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 }
 
+func (p *Parser) parseProtocolStatement() ast.Statement {
+	stmt := &ast.ProtocolStatement{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	for !p.peekTokenIs(token.RBRACE) {
+		member := p.parseProtocolMember()
+		if member == nil {
+			return nil
+		}
+		stmt.Members = append(stmt.Members, member)
+		if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+	p.nextToken()
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseProtocolMember parses a method, fn name(params) -> result, or a
+// field, name: type, in which the types are optional.
+func (p *Parser) parseProtocolMember() *ast.ProtocolMember {
+	p.nextToken()
+	member := &ast.ProtocolMember{Token: p.curToken}
+	switch p.curToken.Type {
+	case token.FUNCTION:
+		member.Method = true
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		member.Name = p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if member.Parameters = p.parseFunctionParameters(); member.Parameters == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.ARROW) {
+			p.nextToken()
+			if member.Type = p.parseType(); member.Type == nil {
+				return nil
+			}
+		}
+	case token.IDENT:
+		member.Name = p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			if member.Type = p.parseType(); member.Type == nil {
+				return nil
+			}
+		}
+	default:
+		p.errorf(p.curToken, "expected a protocol member, got %s instead", p.curToken.Type)
+		return nil
+	}
+	return member
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt := p.arena.expressions.new(ast.ExpressionStatement{Token: p.curToken})
 
+	p.statement = true
 	stmt.Expression = p.parseExpression(LOWEST)
 
 	if p.peekTokenIs(token.SEMICOLON) {
@@ -238,13 +510,35 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(prec precedence) ast.Expression {
+	statement := p.statement
+	p.statement = false
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
 		return nil
 
 	}
+	if p.depth >= MaxNesting {
+		p.errorf(p.curToken, "expressions nested more than %d deep", MaxNesting)
+		p.halted = true
+		p.peekToken = token.Token{Type: token.EOF}
+		return nil
+	}
+	p.depth++
+	// Only an if expression which is a whole statement keeps control, for
+	// the statements of its blocks.
+	control := p.control
+	p.control = control && statement && p.curTokenIs(token.IF)
+	controls := len(p.controls)
+	defer func() { p.depth--; p.control = control }()
 	leftExp := prefix()
+	p.control = false
+	if len(p.controls) > controls && !p.peekTokenIs(token.SEMICOLON) && prec < p.peekPrecedence() {
+		for _, tok := range p.controls[controls:] {
+			p.errorf(tok, "%s cannot be used in an expression", tok.Literal)
+		}
+		p.controls = p.controls[:controls]
+	}
 	for !p.peekTokenIs(token.SEMICOLON) && prec < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
@@ -262,8 +556,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	lit := &ast.FloatLiteral{Token: p.curToken}
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := fmt.Errorf("could not parse %q as float", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -273,12 +566,11 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	lit := &ast.IntegerLiteral{Token: p.curToken}
+	lit := p.arena.ints.new(ast.IntegerLiteral{Token: p.curToken})
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Errorf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -288,7 +580,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return p.arena.strings.new(ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
@@ -300,22 +592,22 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 }
 
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
-	list := []ast.Expression{}
-
 	if p.peekTokenIs(end) {
 		p.nextToken()
-		return list
+		return []ast.Expression{}
 	}
 
+	mark := p.expressions.mark()
 	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
+	p.expressions.push(p.parseExpression(LOWEST))
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		list = append(list, p.parseExpression(LOWEST))
+		p.expressions.push(p.parseExpression(LOWEST))
 	}
 
+	list := p.expressions.pop(mark, &p.arena.expressionLists)
 	if !p.expectPeek(end) {
 		return nil
 	}
@@ -324,10 +616,10 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
-	expression := &ast.PrefixExpression{
+	expression := p.arena.prefixes.new(ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
-	}
+	})
 
 	p.nextToken()
 
@@ -337,11 +629,17 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	expression := &ast.InfixExpression{
+	switch p.curToken.Type {
+	case token.FLOORSLASH, token.PERCENT:
+		if !p.requireFeature(p.curToken, feature.Division, "the ~/ and % operators") {
+			return nil
+		}
+	}
+	expression := p.arena.infixes.new(ast.InfixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
 		Left:     left,
-	}
+	})
 
 	precedence := p.curPrecedence()
 	p.nextToken()
@@ -350,8 +648,24 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseTypeAssertion(left ast.Expression) ast.Expression {
+	expression := &ast.TypeAssertion{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Left:     left,
+	}
+	if expression.Type = p.parseType(); expression.Type == nil {
+		return nil
+	}
+	return expression
+}
+
 func (p *Parser) parseBool() ast.Expression {
-	return &ast.Bool{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
+	return p.arena.bools.new(ast.Bool{Token: p.curToken, Value: p.curTokenIs(token.TRUE)})
+}
+
+func (p *Parser) parseNull() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
@@ -366,8 +680,24 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseAwaitExpression() ast.Expression {
+	expression := &ast.AwaitExpression{Token: p.curToken}
+	p.nextToken()
+	expression.Value = p.parseExpression(PREFIX)
+	return expression
+}
+
+func (p *Parser) parseImportExpression() ast.Expression {
+	expression := &ast.ImportExpression{Token: p.curToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	expression.Module = p.arena.strings.new(ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+	return expression
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
-	expression := &ast.IfExpression{Token: p.curToken}
+	expression := p.arena.ifs.new(ast.IfExpression{Token: p.curToken})
 
 	if !p.expectPeek(token.LPAREN) {
 		return nil
@@ -384,6 +714,8 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		return nil
 	}
 
+	// The blocks are parsed with the control of the if, which parsing the
+	// condition restores.
 	expression.Consequence = p.parseBlockStatement()
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
@@ -398,25 +730,128 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseWhileExpression() ast.Expression {
+	expression := &ast.WhileExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseLoopBody()
+
+	return expression
+}
+
+// parseLoopBody parses the body of a loop, in which break and continue may
+// appear.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	control := p.control
+	p.control = true
+	defer func() { p.loops--; p.control = control }()
+	return p.parseBlockStatement()
+}
+
+// parseAssignExpression parses the value assigned to left, which must be an
+// identifier. Assignment is right-associative, so a = b = 1 assigns 1 to b
+// and then to a.
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	if !p.requireFeature(p.curToken, feature.Assignment, "assignment expressions") {
+		return nil
+	}
+	name, ok := left.(*ast.Identifier)
+	if !ok {
+		p.errorf(p.curToken, "cannot assign to %s", left)
+		return nil
+	}
+	expression := &ast.AssignExpression{Token: p.curToken, Name: name}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(LOWEST)
+
+	return expression
+}
+
+// parseForExpression parses for (value in iterable) { body } or
+// for (key, value in iterable) { body }.
+func (p *Parser) parseForExpression() ast.Expression {
+	expression := &ast.ForExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Value = p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.Key = expression.Value
+		expression.Value = p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseLoopBody()
+
+	return expression
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
-	block := &ast.BlockStatement{Token: p.curToken}
-	block.Statements = []ast.Statement{}
+	block := p.arena.blocks.new(ast.BlockStatement{Token: p.curToken})
 
 	p.nextToken()
 
+	mark := p.statements.mark()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		stmt := p.parseStatement()
 		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
+			p.statements.push(stmt)
 		}
 		p.nextToken()
 	}
+	block.Statements = p.statements.pop(mark, &p.arena.statementLists)
+	if p.curTokenIs(token.EOF) {
+		p.errorf(p.curToken, "expected %s to close the block, got EOF", token.RBRACE)
+	}
 
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	lit := &ast.FunctionLiteral{Token: p.curToken}
+	lit := p.arena.funcs.new(ast.FunctionLiteral{Token: p.curToken})
+
+	if p.peekTokenIs(token.LT) {
+		if lit.TypeParams = p.parseTypeParams(); lit.TypeParams == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.LPAREN) {
 		return nil
@@ -424,35 +859,44 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 
 	lit.Parameters = p.parseFunctionParameters()
 
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		if lit.Result = p.parseType(); lit.Result == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
+	// A loop around the literal does not enclose its body.
+	loops, control := p.loops, p.control
+	p.loops, p.control = 0, false
 	lit.Body = p.parseBlockStatement()
+	p.loops, p.control = loops, control
 
 	return lit
 }
 
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
-
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return []*ast.Identifier{}
 	}
 
+	mark := p.idents.mark()
 	p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+	p.idents.push(p.parseParameter())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
+		p.idents.push(p.parseParameter())
 	}
 
+	identifiers := p.idents.pop(mark, &p.arena.identLists)
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
@@ -460,14 +904,116 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	return identifiers
 }
 
+// parseParameter parses a function parameter and its optional annotation.
+func (p *Parser) parseParameter() *ast.Identifier {
+	ident := p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		ident.Type = p.parseType()
+	}
+	return ident
+}
+
+// parseType parses the type annotation following the current token: a name
+// with optional type arguments, such as hash<string, int>, or a function type
+// such as fn(int, int) -> bool, or fn alone for any function. It returns nil
+// after recording an error.
+func (p *Parser) parseType() *ast.TypeExpr {
+	if !p.requireFeature(p.curToken, feature.Types, "type annotations") {
+		return nil
+	}
+	p.nextToken()
+	t := &ast.TypeExpr{Token: p.curToken, Name: p.curToken.Literal}
+	switch p.curToken.Type {
+	case token.IDENT, token.NULL:
+		if !p.peekTokenIs(token.LT) {
+			return t
+		}
+		p.nextToken()
+		if t.Args = p.parseTypeList(token.GT); t.Args == nil {
+			return nil
+		}
+	case token.FUNCTION:
+		if p.peekTokenIs(token.LT) {
+			if t.TypeParams = p.parseTypeParams(); t.TypeParams == nil {
+				return nil
+			}
+		} else if !p.peekTokenIs(token.LPAREN) {
+			return t
+		}
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if t.Args = p.parseTypeList(token.RPAREN); t.Args == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.ARROW) {
+			p.nextToken()
+			if t.Result = p.parseType(); t.Result == nil {
+				return nil
+			}
+		}
+	default:
+		p.errorf(p.curToken, "expected a type, got %s instead", p.curToken.Type)
+		return nil
+	}
+	return t
+}
+
+// parseTypeParams parses the type parameters of a generic function, <T, U>,
+// following the current token. It returns nil after recording an error.
+func (p *Parser) parseTypeParams() []*ast.Identifier {
+	p.nextToken()
+	var params []*ast.Identifier
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		params = append(params, p.arena.idents.new(ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}))
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(token.GT) {
+		return nil
+	}
+	return params
+}
+
+// parseTypeList parses comma-separated types up to end. It returns nil after
+// recording an error and an empty slice if there are no types.
+func (p *Parser) parseTypeList(end token.TokenType) []*ast.TypeExpr {
+	list := []*ast.TypeExpr{}
+	if p.peekTokenIs(end) {
+		p.nextToken()
+		return list
+	}
+	for {
+		t := p.parseType()
+		if t == nil {
+			return nil
+		}
+		list = append(list, t)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(end) {
+		return nil
+	}
+	return list
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	exp := &ast.CallExpression{Token: p.curToken, Function: function}
+	exp := p.arena.calls.new(ast.CallExpression{Token: p.curToken, Function: function})
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
 	return exp
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	exp := p.arena.indexes.new(ast.IndexExpression{Token: p.curToken, Left: left})
 
 	p.nextToken()
 	exp.Index = p.parseExpression(LOWEST)
@@ -479,9 +1025,20 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseSelectorExpression parses x.name as the index expression x["name"].
+func (p *Parser) parseSelectorExpression(left ast.Expression) ast.Expression {
+	exp := p.arena.indexes.new(ast.IndexExpression{Token: p.curToken, Left: left})
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Index = p.arena.strings.new(ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -494,7 +1051,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -1,11 +1,15 @@
 package parser
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/feature"
 	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -196,8 +200,12 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 - 5;", 5, "-", 5},
 		{"5 * 5;", 5, "*", 5},
 		{"5 / 5;", 5, "/", 5},
+		{"5 ~/ 5;", 5, "~/", 5},
+		{"5 % 5;", 5, "%", 5},
 		{"5 > 5;", 5, ">", 5},
 		{"5 < 5;", 5, "<", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"5 <= 5;", 5, "<=", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
 		{"foobar + barfoo;", "foobar", "+", "barfoo"},
@@ -250,6 +258,18 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"!-a",
 			"(!(-a))",
 		},
+		{
+			"await f(x) + 1",
+			"((await f(x)) + 1)",
+		},
+		{
+			"a + b is int == true",
+			"(((a + b) is int) == true)",
+		},
+		{
+			"x as array<int>[0]",
+			"((x as array<int>)[0])",
+		},
 		{
 			"a + b + c",
 			"((a + b) + c)",
@@ -266,6 +286,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a * b / c",
 			"((a * b) / c)",
 		},
+		{
+			"a + b ~/ c * d",
+			"(a + ((b ~/ c) * d))",
+		},
+		{
+			"a - b % c * d",
+			"(a - ((b % c) * d))",
+		},
 		{
 			"a + b / c",
 			"(a + (b / c))",
@@ -350,17 +378,39 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"-db.Get(a).b * 2",
+			"((-((db[Get])(a)[b])) * 2)",
+		},
+		{
+			`import "strings".upper(a) + b`,
+			`((import "strings"[upper])(a) + b)`,
+		},
+		{
+			"x = 1 + 2 * 3",
+			"(x = (1 + (2 * 3)))",
+		},
+		{
+			"a = b = c == d",
+			"(a = (b = (c == d)))",
+		},
+		{
+			"f(x = 1)",
+			"f((x = 1))",
+		},
 	}
 
-	for _, tt := range tests {
-		l := lexer.New(tt.input)
-		p := New(l)
-		program := p.ParseProgram()
-		checkParserErrors(t, p)
+	// Parsers with arenas must produce the same programs.
+	for _, parse := range []func(*lexer.Lexer) *Parser{New, NewWithArena} {
+		for _, tt := range tests {
+			p := parse(lexer.New(tt.input))
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
 
-		actual := program.String()
-		if actual != tt.expected {
-			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+			actual := program.String()
+			if actual != tt.expected {
+				t.Errorf("expected=%q, got=%q", tt.expected, actual)
+			}
 		}
 	}
 }
@@ -402,6 +452,97 @@ func TestBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestWhileExpression(t *testing.T) {
+	input := `while (x < y) { x }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	exp, ok := stmt.Expression.(*ast.WhileExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.WhileExpression. got=%T",
+			stmt.Expression)
+	}
+
+	if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(exp.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d\n", len(exp.Body.Statements))
+	}
+
+	body, ok := exp.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Statements[0] is not ast.ExpressionStatement. got=%T",
+			exp.Body.Statements[0])
+	}
+
+	testIdentifier(t, body.Expression, "x")
+}
+
+func TestForExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		key      string
+		value    string
+		iterable string
+		body     string
+	}{
+		{"for (x in xs) { x }", "", "x", "xs", "x"},
+		{"for (k, v in {1: 2}) { k + v }", "k", "v", "{1:2}", "(k + v)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%s: expected 1 statement, got %d", tt.input, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("%s: statement is not ast.ExpressionStatement. got=%T", tt.input, program.Statements[0])
+		}
+		exp, ok := stmt.Expression.(*ast.ForExpression)
+		if !ok {
+			t.Fatalf("%s: expression is not ast.ForExpression. got=%T", tt.input, stmt.Expression)
+		}
+		if key := exp.Key; (key == nil) != (tt.key == "") || key != nil && key.Value != tt.key {
+			t.Errorf("%s: wrong key %v", tt.input, key)
+		}
+		testIdentifier(t, exp.Value, tt.value)
+		if got := exp.Iterable.String(); got != tt.iterable {
+			t.Errorf("%s: expected iterable %s, got %s", tt.input, tt.iterable, got)
+		}
+		if got := exp.Body.String(); got != tt.body {
+			t.Errorf("%s: expected body %s, got %s", tt.input, tt.body, got)
+		}
+	}
+
+	for _, input := range []string{"for (x) { x }", "for (1 in xs) { 1 }", "for (a, b, c in xs) {}"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected errors", input)
+		}
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := `if (x < y) { x }`
 
@@ -587,6 +728,64 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 5;", "let x: int = 5;"},
+		{"let f = fn(a: int, b) -> bool { a };", "let f = fn(a: int, b) -> bool a;"},
+		{"let h: hash<string, array<int>> = {};", "let h: hash<string, array<int>> = {};"},
+		{"let g: fn(int, fn() -> null) -> any = f;", "let g: fn(int, fn() -> null) -> any = f;"},
+		{"let g: fn() = f;", "let g: fn() = f;"},
+		{"let head = fn<T>(xs: array<T>) -> T { xs[0] };", "let head = fn<T>(xs: array<T>) -> T (xs[0]);"},
+		{"let g: fn<K, V>(hash<K, V>) -> array<K> = keys;", "let g: fn<K, V>(hash<K, V>) -> array<K> = keys;"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	for input, expected := range map[string]string{
+		"let x: = 5;":           "line 1: expected a type, got = instead",
+		"let x: array<int = 5;": "line 1: expected next token to be >, got = instead",
+		"fn(a) -> { a }":        "line 1: expected a type, got { instead",
+		"fn<>(a) { a }":         "line 1: expected next token to be IDENT, got > instead",
+	} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if errs := p.Errors(); len(errs) == 0 || errs[0].Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", input, expected, errs)
+		}
+	}
+}
+
+func TestProtocolStatement(t *testing.T) {
+	input := `protocol Shape {
+	fn area() -> float;
+	fn scale(by: float, other)
+	name: string,
+	tag
+};`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	expected := "protocol Shape { fn area() -> float; fn scale(by: float, other); name: string; tag; }"
+	if actual := program.String(); actual != expected {
+		t.Errorf("expected=%q, got=%q", expected, actual)
+	}
+
+	p = New(lexer.New("protocol P { 1 }"))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) == 0 || errs[0].Error() != "line 1: expected a protocol member, got INT instead" {
+		t.Errorf("wrong errors %v", errs)
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -841,6 +1040,25 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestImportExpression(t *testing.T) {
+	program := New(lexer.New(`let s = import "strings";`)).ParseProgram()
+	stmt := program.Statements[0].(*ast.LetStatement)
+	imp, ok := stmt.Value.(*ast.ImportExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.ImportExpression. got=%T", stmt.Value)
+	}
+	if imp.Module.Value != "strings" {
+		t.Errorf("wrong module. want=strings, got=%q", imp.Module.Value)
+	}
+
+	p := New(lexer.New(`import strings`))
+	p.ParseProgram()
+	expected := "line 1: expected next token to be STRING, got IDENT instead"
+	if errs := p.Errors(); len(errs) == 0 || errs[0].Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, errs)
+	}
+}
+
 func TestParsingArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 
@@ -905,21 +1123,26 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 
-	expected := map[string]int64{
-		"one":   1,
-		"two":   2,
-		"three": 3,
+	expected := []struct {
+		key   string
+		value int64
+	}{
+		{"one", 1},
+		{"two", 2},
+		{"three", 3},
 	}
 
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	for i, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
+			continue
 		}
 
-		expectedValue := expected[literal.String()]
-
-		testIntegerLiteral(t, value, expectedValue)
+		if literal.String() != expected[i].key {
+			t.Errorf("pair %d has wrong key. want=%q, got=%q", i, expected[i].key, literal.String())
+		}
+		testIntegerLiteral(t, pair.Value, expected[i].value)
 	}
 }
 
@@ -972,10 +1195,10 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	for _, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not ast.StringLiteral. got=%T", key)
+			t.Errorf("key is not ast.StringLiteral. got=%T", pair.Key)
 			continue
 		}
 
@@ -985,6 +1208,275 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			continue
 		}
 
-		testFunc(value)
+		testFunc(pair.Value)
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		input      string
+		incomplete bool
+	}{
+		{"let f = fn(x) {", true},
+		{"while (true) { puts(1);", true},
+		{"if (x", true},
+		{"if (x) { 1 } else", true},
+		{"[1, 2", true},
+		{`{"a": `, true},
+		{"f(1,", true},
+		{"1 +", true},
+		{`"abc`, true},
+		{"/* a", true},
+		{"let x = 1;", false},
+		{"let = 1", false},
+		{"1 ) +", false},
+		{"fn(x) { x ) }", false},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if got := Incomplete(p.Errors()); got != tt.incomplete {
+			t.Errorf("%q: expected Incomplete to be %t, got %t for errors %v", tt.input, tt.incomplete, got, p.Errors())
+		}
+	}
+}
+
+func TestLexerErrorStopsParsing(t *testing.T) {
+	p := New(lexer.New(`let x = "abc`))
+	p.ParseProgram()
+	errs := p.Errors()
+	if len(errs) == 0 {
+		t.Fatal("expected errors")
+	}
+	lexErr, ok := errs[0].(*lexer.Error)
+	if !ok || lexErr.Line != 1 || lexErr.Column != 9 || lexErr.Msg != "unterminated string" {
+		t.Fatalf("expected lexer error first, got %v", errs)
+	}
+}
+
+func TestErrorLines(t *testing.T) {
+	p := New(lexer.New("let x = 1;\nlet = 2;\n\nlet y = ;"))
+	p.ParseProgram()
+	expected := []string{
+		"line 2: expected next token to be IDENT, got = instead",
+		"line 2: no prefix parse function for = found",
+		"line 4: no prefix parse function for ; found",
+	}
+	errs := p.Errors()
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if _, ok := err.(*Error); !ok || err.Error() != expected[i] {
+			t.Errorf("error %d: expected *Error %q, got %T %q", i, expected[i], err, err)
+		}
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	tests := []struct {
+		input    string
+		features feature.Feature
+		err      string
+	}{
+		{"let x: int = 1;", feature.Extended, ""},
+		{"let x: int = 1;", feature.Classic, "line 1: type annotations require language feature types"},
+		{"fn(x) -> int { x }", feature.Import, "line 1: type annotations require language feature types"},
+		{"let import = 1; import", feature.Classic, ""},
+		{"let while = 1; while", feature.Classic, ""},
+		{"x = 1", feature.Loops, "line 1: assignment expressions require language feature assignment"},
+		{"x = 1", feature.Assignment, ""},
+		{"x[0] = 1", feature.Extended, "line 1: cannot assign to (x[0])"},
+		{"1 = 2", feature.Extended, "line 1: cannot assign to 1"},
+		{"while (true) { break; }", feature.Loops, ""},
+		{"let break = 1; break", feature.Classic, ""},
+		{"while (true) { 1 }", feature.Types, `line 1: expected next token to be :, got } instead ("while" is a keyword of language feature loops)`},
+		{`let m = import "m";`, feature.Import, ""},
+		{"if (x is int) { 1 }", feature.Classic, `line 1: expected next token to be ), got IDENT instead ("is" is a keyword of language feature types)`},
+		{"7 / 2", feature.Classic, ""},
+		{"7 ~/ 2", feature.Classic, "line 1: the ~/ and % operators require language feature division"},
+		{"7 % 2", feature.Loops, "line 1: the ~/ and % operators require language feature division"},
+		{"7 % 2", feature.Division, ""},
+	}
+	for _, tt := range tests {
+		p := New(lexer.NewWithFeatures(tt.input, tt.features))
+		p.ParseProgram()
+		errs := p.Errors()
+		switch {
+		case tt.err == "" && len(errs) != 0:
+			t.Errorf("%v: %s: unexpected errors %v", tt.features, tt.input, errs)
+		case tt.err != "" && (len(errs) == 0 || errs[0].Error() != tt.err):
+			t.Errorf("%v: %s: expected %q, got %v", tt.features, tt.input, tt.err, errs)
+		}
+	}
+}
+
+func TestLoopControl(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // the program, or its errors separated by "; "
+	}{
+		{"while (x) { break; continue }", "whilex break;continue;"},
+		{"for (x in xs) { if (x) { continue; } }", "for (x in xs) ifx continue;"},
+		{"break;", "line 1: break is not in a loop"},
+		{"if (x) { continue; }", "line 1: continue is not in a loop"},
+		{"while (x) { fn() { break; } }", "line 1: break is not in a loop"},
+		{"while (x) { fn() { while (y) { break; } } }", "whilex fn() whiley break;"},
+		{"while (x) { if (a) { if (b) { break; } } }", "whilex ifa ifb break;"},
+		{"while (x) { let y = if (a) { break; } else { x }; }", "line 1: break cannot be used in an expression"},
+		{"while (x) { puts(if (a) { continue; }) }", "line 1: continue cannot be used in an expression"},
+		{"while (x) { if (a) { break; } + 1 }", "line 1: break cannot be used in an expression"},
+		{"while (x) { if (if (a) { break; }) { 1 } }", "line 1: break cannot be used in an expression"},
+		{"while (x) { let y = if (a) { if (b) { break; } }; }", "line 1: break cannot be used in an expression"},
+		{"while (x) { return if (a) { break; } }", "line 1: break cannot be used in an expression"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		got := program.String()
+		if errs := p.Errors(); len(errs) > 0 {
+			msgs := make([]string, len(errs))
+			for i, err := range errs {
+				msgs[i] = err.Error()
+			}
+			got = strings.Join(msgs, "; ")
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMaxNesting(t *testing.T) {
+	for _, tt := range []struct {
+		depth int
+		err   string
+	}{
+		{MaxNesting, ""},
+		{MaxNesting + 1, "line 1: expressions nested more than 1000 deep"},
+		{1000000, "line 1: expressions nested more than 1000 deep"},
+	} {
+		// The outermost parenthesis starts an expression containing the
+		// depth-1 nested ones.
+		input := strings.Repeat("(", tt.depth-1) + "1" + strings.Repeat(")", tt.depth-1)
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		errs := p.Errors()
+		switch {
+		case tt.err == "" && len(errs) != 0:
+			t.Errorf("depth %d: unexpected errors %v", tt.depth, errs)
+		case tt.err != "" && (len(errs) != 1 || errs[0].Error() != tt.err):
+			t.Errorf("depth %d: expected only %q, got %v", tt.depth, tt.err, errs)
+		}
+	}
+}
+
+func TestParseProgramContext(t *testing.T) {
+	input := strings.Repeat("let x = 1 + 2;\n", 10000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := New(lexer.New(input))
+	p.ParseProgramContext(ctx)
+	if errs := p.Errors(); len(errs) != 1 || errs[0] != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", errs)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	p = New(lexer.New(input))
+	p.prefixParseFns[token.INT] = func() ast.Expression {
+		if p.curToken.Line == 100 {
+			cancel()
+		}
+		return p.parseIntegerLiteral()
+	}
+	program := p.ParseProgramContext(ctx)
+	if errs := p.Errors(); len(errs) == 0 || errs[0] != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", errs)
+	}
+	if n := len(program.Statements); n >= 10000 {
+		t.Errorf("expected parsing to stop early, parsed %d statements", n)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{`{"a": [1, -2.5, true, null]}`, `{a:[1, (-2.5), true, null]}`, ""},
+		{`{"b": {"c": "d"}}`, `{b:{c:d}}`, ""},
+		{`null`, `null`, ""},
+		{`[]`, `[]`, ""},
+		{`{"a": x}`, "", "line 1: not a JSON value: x"},
+		{`{1: 2}`, "", "line 1: object keys must be strings, got 1"},
+		{"[1,\n-true]", "", "line 2: not a JSON value: (-true)"},
+		{`1 2`, "", "line 1: expected a single JSON value"},
+		{`let x = 1;`, "", "line 1: expected a single JSON value"},
+	}
+	for _, tt := range tests {
+		expr, err := ParseJSON(tt.input)
+		switch {
+		case tt.err != "":
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+		case expr.String() != tt.expected:
+			t.Errorf("%q: wrong expression. want=%q, got=%q", tt.input, tt.expected, expr.String())
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	var pool Pool
+	p := pool.Get("// a\nlet x = 1;")
+	first := p.ParseProgram()
+	checkParserErrors(t, p)
+	pool.Put(p)
+
+	p = pool.Get("let y = ;")
+	p.ParseProgram()
+	errs := p.Errors()
+	pool.Put(p)
+	if len(errs) == 0 {
+		t.Fatal("expected a parse error")
+	}
+
+	p = pool.Get("// b\nlet z = 3;")
+	third := p.ParseProgram()
+	checkParserErrors(t, p)
+	pool.Put(p)
+
+	for _, tt := range []struct {
+		program  *ast.Program
+		expected string
+		comment  string
+	}{
+		{first, "let x = 1;", "// a"},
+		{third, "let z = 3;", "// b"},
+	} {
+		if got := tt.program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+		if len(tt.program.Comments) != 1 || tt.program.Comments[0].Literal != tt.comment {
+			t.Errorf("expected comment %q, got %v", tt.comment, tt.program.Comments)
+		}
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected the errors to be kept, got %v", errs)
+	}
+}
+
+func TestListsDoNotAlias(t *testing.T) {
+	p := NewWithArena(lexer.New(`f(a, b); g(c, d);`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	first := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	second := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	_ = append(first.Arguments, &ast.Identifier{Value: "x"})
+	if got := second.String(); got != "g(c, d)" {
+		t.Errorf("appending to one list changed the next: got %q", got)
 	}
 }
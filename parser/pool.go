@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"sync"
+
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/token"
+)
+
+// Reset makes p parse the tokens of l from the start, as New(l) would,
+// reusing the tables it built for its previous lexer.
+func (p *Parser) Reset(l *lexer.Lexer) {
+	p.l = l
+	p.curToken, p.peekToken = token.Token{}, token.Token{}
+	p.ctx = nil
+	p.tokens = 0
+	p.depth, p.loops, p.halted = 0, 0, false
+	p.control, p.statement, p.controls = false, false, p.controls[:0]
+	p.errors = nil
+	// Read two tokens, so curToken and peekToken are both set
+	p.nextToken()
+	p.nextToken()
+}
+
+// Pool recycles Parsers and their Lexers, for servers which parse many small
+// programs, since creating a Parser costs more than parsing a short program.
+// It is safe for concurrent use, and the zero value is ready to use.
+type Pool struct {
+	parsers sync.Pool
+	lexers  lexer.Pool
+}
+
+// Get returns a Parser of src, reusing one put in the pool if there is one.
+func (p *Pool) Get(src string) *Parser {
+	l := p.lexers.Get(src)
+	parser, ok := p.parsers.Get().(*Parser)
+	if !ok {
+		return New(l)
+	}
+	parser.Reset(l)
+	return parser
+}
+
+// Put returns parser and its Lexer to the pool. The programs and errors
+// parser returned remain valid, but parser must not be used again.
+func (p *Pool) Put(parser *Parser) {
+	p.lexers.Put(parser.l)
+	parser.l = nil
+	p.parsers.Put(parser)
+}
@@ -0,0 +1,257 @@
+// Package playground implements an HTTP handler serving a web page on which
+// monkey programs can be edited and run.
+//
+// Programs are run by the evaluator under the limits in Config. Output from
+// puts is captured and returned to the caller rather than written to the
+// server's stdout.
+package playground
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/stdlib"
+)
+
+// Config bounds the resources available to each submitted program.
+type Config struct {
+	// Timeout is the wall time a program may run for.
+	Timeout time.Duration
+	// Limits are applied to the evaluator.
+	Limits sandbox.Limits
+	// MaxSource is the largest request body, in bytes, which will be
+	// accepted. Zero means no limit.
+	MaxSource int64
+	// MaxOutput is the number of bytes of output which will be retained.
+	// Zero means no limit.
+	MaxOutput int
+}
+
+// DefaultConfig is a conservative configuration suitable for a public server.
+var DefaultConfig = Config{
+	Timeout: 2 * time.Second,
+	Limits: sandbox.Limits{
+		MaxSteps:  1000000,
+		MaxDepth:  1000,
+		MaxMemory: 16 << 20,
+
+		MaxStringLen: 1 << 20,
+		MaxArrayLen:  1 << 16,
+	},
+	MaxSource: 64 << 10,
+	MaxOutput: 64 << 10,
+}
+
+// Request is the body of a POST to /run.
+type Request struct {
+	Code string `json:"code"`
+}
+
+// Response is the body returned from /run. Result holds the Inspect output of
+// the program's final value, and Errors any parse or runtime errors.
+type Response struct {
+	Output string   `json:"output"`
+	Result string   `json:"result,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Handler serves the playground page at / and runs programs at /run.
+type Handler struct {
+	cfg Config
+	mux *http.ServeMux
+}
+
+// NewHandler creates a Handler using cfg.
+func NewHandler(cfg Config) *Handler {
+	h := &Handler{cfg: cfg, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/", h.serveIndex)
+	h.mux.HandleFunc("/run", h.serveRun)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, nil)
+}
+
+func (h *Handler) serveRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req Request
+	body := r.Body
+	if h.cfg.MaxSource > 0 {
+		body = http.MaxBytesReader(w, body, h.cfg.MaxSource)
+	}
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if h.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Timeout)
+		defer cancel()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Run(ctx, req.Code))
+}
+
+// parsers recycles the parsers of the programs the playground runs.
+var parsers parser.Pool
+
+// Run evaluates code under the handler's limits.
+func (h *Handler) Run(ctx context.Context, code string) (resp Response) {
+	out := &limitedBuffer{max: h.cfg.MaxOutput}
+	defer func() {
+		if r := recover(); r != nil {
+			resp.Errors = append(resp.Errors, fmt.Sprintf("internal error: %v", r))
+		}
+		resp.Output = out.String()
+	}()
+
+	p := parsers.Get(code)
+	defer parsers.Put(p)
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 0 {
+		for _, err := range errs {
+			resp.Errors = append(resp.Errors, err.Error())
+		}
+		return resp
+	}
+
+	e := evaluator.Evaluator{
+		Limits:   h.cfg.Limits,
+		Builtins: sandboxBuiltins(out),
+		Importer: stdlib.NewImporter(object.CapIO),
+	}
+	switch result := e.EvalContext(ctx, program, object.NewEnvironment()).(type) {
+	case object.Error:
+		msg := result.Err.Error()
+		if errors.Is(result.Err, context.DeadlineExceeded) {
+			msg = "timeout exceeded"
+		}
+		resp.Errors = append(resp.Errors, msg)
+	default:
+		resp.Result = result.Inspect()
+	}
+	return resp
+}
+
+// sandboxBuiltins returns the standard builtins without IO and with puts
+// redirected to out.
+func sandboxBuiltins(out io.Writer) *object.Builtins {
+	b := object.NewBuiltins()
+	b.RemoveIO()
+	b.SetOutput(out)
+	return b
+}
+
+// limitedBuffer retains at most max bytes, noting when output was dropped.
+// Tasks still running when the program returns may write to it while it is
+// read.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.max > 0 && b.buf.Len()+len(p) > b.max {
+		b.buf.Write(p[:b.max-b.buf.Len()])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(strings.TrimSpace(`
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Monkey Playground</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+textarea, pre { font-family: monospace; font-size: 14px; width: 100%; box-sizing: border-box; }
+textarea { height: 20em; }
+pre { background: #f4f4f4; padding: 1em; min-height: 4em; white-space: pre-wrap; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Monkey Playground</h1>
+<textarea id="code" spellcheck="false">let fib = fn(n) {
+  if (n < 2) {
+    return n;
+  }
+  fib(n - 1) + fib(n - 2);
+};
+puts(fib(15));
+</textarea>
+<p><button id="run">Run</button></p>
+<pre id="output"></pre>
+<script>
+document.getElementById("run").onclick = async function() {
+  const output = document.getElementById("output");
+  output.textContent = "Running...";
+  const resp = await fetch("run", {
+    method: "POST",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({code: document.getElementById("code").value}),
+  });
+  if (!resp.ok) {
+    output.textContent = await resp.text();
+    return;
+  }
+  const res = await resp.json();
+  output.textContent = res.output;
+  if (res.result) {
+    output.textContent += res.result + "\n";
+  }
+  for (const err of res.errors || []) {
+    const span = document.createElement("span");
+    span.className = "error";
+    span.textContent = err + "\n";
+    output.appendChild(span);
+  }
+};
+</script>
+</body>
+</html>
+`)))
@@ -0,0 +1,107 @@
+package playground
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajwerner/monkey/sandbox"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected Response
+	}{
+		{
+			`puts("hello"); 1 + 2`,
+			Response{Output: "hello\n", Result: "3"},
+		},
+		{
+			`let = 5;`,
+			Response{Errors: []string{
+				"line 1: expected next token to be IDENT, got = instead",
+				"line 1: no prefix parse function for = found",
+			}},
+		},
+		{
+			`let f = fn(x) { f(x) }; f(1)`,
+			Response{Errors: []string{"line 1: call depth limit exceeded"}},
+		},
+		{
+			`let fs = import "fs"; [fs.basename("/a/b"), fs.stat]`,
+			Response{Result: `["b", null]`},
+		},
+		{
+			`puts("aaaaaaaaaaaaaaaaaaaa")`,
+			Response{Output: "aaaaaaaaaa\n[output truncated]", Result: "null"},
+		},
+	}
+
+	cfg := DefaultConfig
+	cfg.MaxOutput = 10
+	h := NewHandler(cfg)
+	for _, tt := range tests {
+		srv := httptest.NewServer(h)
+		body, _ := json.Marshal(Request{Code: tt.code})
+		resp, err := http.Post(srv.URL+"/run", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Response
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: wrong response.\nwant=%+v\ngot =%+v", tt.code, tt.expected, got)
+		}
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	h := NewHandler(Config{
+		Timeout: 10 * time.Millisecond,
+		Limits:  sandbox.Limits{MaxDepth: 1000},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/run",
+		strings.NewReader(`{"code": "let f = fn(n) { if (n == 0) { return 0; } f(n - 1); f(n - 1) }; f(40)"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var got Response
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Errors, []string{"timeout exceeded"}) {
+		t.Errorf("expected timeout, got %+v", got)
+	}
+}
+
+func TestRunTasks(t *testing.T) {
+	h := NewHandler(DefaultConfig)
+	got := h.Run(context.Background(), `let loop = fn() { let i = 0; while (i < 1000) { puts(1, 2); i = i + 1 } };
+		await all([spawn(loop), spawn(loop), spawn(loop)]); 0`)
+	if expected := strings.Repeat("1\n2\n", 3000); got.Output != expected || len(got.Errors) != 0 {
+		t.Errorf("wrong response. want output %q, got %+v", expected, got)
+	}
+}
+
+func TestIndexAndMethods(t *testing.T) {
+	h := NewHandler(DefaultConfig)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Monkey Playground") {
+		t.Errorf("GET / returned %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/run", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /run returned %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,52 @@
+package monkey
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/vm"
+)
+
+// Pool runs a compiled Script from many goroutines at once, reusing a VM and
+// globals store between runs instead of allocating them for each run as
+// Script.Run does.
+//
+// The script's bytecode, constants and builtin table are shared by every run
+// and are never modified. Each run has its own stack and globals, so runs
+// never observe each other's bindings. Objects converted from Go parameters
+// and objects returned by Run belong to the run's caller. Parameters which
+// are already objects are passed by reference: a Hash or Array given to
+// concurrent runs is shared by them and must not be modified, by the host or
+// by a builtin, while they execute. Builtins registered on the table must
+// themselves be safe for concurrent use.
+type Pool struct {
+	script *Script
+	vms    sync.Pool // of *pooledVM
+}
+
+type pooledVM struct {
+	vm      *vm.VM
+	globals []object.Object
+}
+
+// NewPool returns a Pool which runs s.
+func NewPool(s *Script) *Pool {
+	p := &Pool{script: s}
+	p.vms.New = func() interface{} {
+		globals := make([]object.Object, s.numGlobals)
+		return &pooledVM{vm: s.newVM(globals), globals: globals}
+	}
+	return p
+}
+
+// Run is like Script.RunContext. It is safe to call from many goroutines.
+func (p *Pool) Run(ctx context.Context, params map[string]interface{}) (object.Object, error) {
+	m := p.vms.Get().(*pooledVM)
+	defer func() {
+		m.vm.Reset()
+		clear(m.globals)
+		p.vms.Put(m)
+	}()
+	return p.script.run(ctx, m.vm, m.globals, params)
+}
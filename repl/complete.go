@@ -0,0 +1,64 @@
+package repl
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/token"
+)
+
+// hashKeyPrefix matches the end of a line indexing a bound hash with a
+// string key which is being typed, as in h["na.
+var hashKeyPrefix = regexp.MustCompile(`([\pL_][\pL\pN_]*)\["([^"\\]*)$`)
+
+// complete returns the word ending before, the text before the cursor, and
+// the sorted completions of it: the string keys of a hash being indexed,
+// followed by the closing quote and bracket, or else the names bound so
+// far, the builtins and the keywords.
+func (s *session) complete(before string) (word string, candidates []string) {
+	if m := hashKeyPrefix.FindStringSubmatch(before); m != nil {
+		word = m[2]
+		if h, ok := s.value(m[1]).(object.Hash); ok {
+			for _, k := range h.Keys() {
+				if k, ok := k.(object.String); ok && strings.HasPrefix(string(k), word) {
+					candidates = append(candidates, string(k)+`"]`)
+				}
+			}
+		}
+		slices.Sort(candidates)
+		return word, candidates
+	}
+	start := strings.LastIndexFunc(before, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) + 1
+	word = before[start:]
+	if word == "" || unicode.IsDigit([]rune(word)[0]) {
+		return word, nil
+	}
+	for _, names := range [][]string{s.names(), s.builtins.Names(), token.Keywords()} {
+		for _, name := range names {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	slices.Sort(candidates)
+	return word, slices.Compact(candidates)
+}
+
+// value returns the value bound to name by the lines run so far, or nil.
+func (s *session) value(name string) object.Object {
+	if s.comp == nil {
+		obj, _ := s.env.Get(name)
+		return obj
+	}
+	sym, ok := s.comp.Symbols().Resolve(name)
+	if !ok || sym.Scope != compiler.GlobalScope {
+		return nil
+	}
+	return s.globals[sym.Index]
+}
@@ -0,0 +1,290 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxHistory is the number of lines which the history keeps.
+const maxHistory = 1000
+
+// errInterrupted is returned by readLine when Ctrl-C abandons the line.
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the lines of input which the REPL runs.
+type lineReader interface {
+	// readLine writes prompt and returns the next line without its line
+	// ending, or io.EOF once there are no more.
+	readLine(prompt string) (string, error)
+}
+
+// scanner reads lines from input which is not a terminal.
+type scanner struct {
+	s   *bufio.Scanner
+	out io.Writer
+}
+
+func (s *scanner) readLine(prompt string) (string, error) {
+	io.WriteString(s.out, prompt)
+	if !s.s.Scan() {
+		if err := s.s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.s.Text(), nil
+}
+
+// newLineReader returns a lineEditor completing words with complete if in
+// is a terminal on which line editing works, and otherwise a scanner.
+func newLineReader(
+	in io.Reader, out io.Writer, historyFile string, complete func(string) (string, []string),
+) lineReader {
+	if f, ok := in.(*os.File); ok {
+		if restore, err := makeRaw(f); err == nil {
+			restore()
+			e := newLineEditor(f, out, historyFile)
+			e.complete = complete
+			return &terminal{f: f, e: e}
+		}
+	}
+	return &scanner{s: bufio.NewScanner(in), out: out}
+}
+
+// terminal reads lines with a lineEditor, keeping the terminal in raw mode
+// only while a line is read, so that programs write to it as usual.
+type terminal struct {
+	f *os.File
+	e *lineEditor
+}
+
+func (t *terminal) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(t.f)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	return t.e.readLine(prompt)
+}
+
+// lineEditor reads lines key by key, as typed at a terminal in raw mode,
+// supporting the usual Emacs-style keys to move within and edit the line:
+// the arrow keys, Home and End, Ctrl-A and Ctrl-E to go to the start and
+// end, Ctrl-B and Ctrl-F to go back and forward, Ctrl-K and Ctrl-U to
+// delete to the end and start, the up and down arrows or Ctrl-P and Ctrl-N
+// to recall the lines of the history, and Tab to complete the word before
+// the cursor.
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// complete, if not nil, returns the word which ends the text before the
+	// cursor and the words which could replace it.
+	complete func(before string) (word string, candidates []string)
+
+	history []string
+	// historyFile, if not empty, is the file to which lines are added and
+	// from which the history was loaded.
+	historyFile string
+}
+
+// newLineEditor returns a lineEditor whose history is loaded from
+// historyFile if it exists.
+func newLineEditor(in io.Reader, out io.Writer, historyFile string) *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(in), out: out, historyFile: historyFile}
+	if historyFile == "" {
+		return e
+	}
+	data, err := os.ReadFile(historyFile)
+	if err != nil {
+		return e
+	}
+	e.history = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+		// Keep the file from growing without bound.
+		os.WriteFile(historyFile, []byte(strings.Join(e.history, "\n")+"\n"), 0o600)
+	}
+	return e
+}
+
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	// The line being typed is kept at the end of the history while earlier
+	// lines are recalled.
+	history := append(e.history[:len(e.history):len(e.history)], "")
+	recalled := len(history) - 1
+	recall := func(i int) {
+		if i < 0 || i >= len(history) {
+			return
+		}
+		history[recalled] = string(line)
+		recalled = i
+		line = []rune(history[i])
+		pos = len(line)
+	}
+	io.WriteString(e.out, prompt)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			io.WriteString(e.out, "\r\n")
+			e.add(string(line))
+			return string(line), nil
+		case ctrl('C'):
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case ctrl('D'):
+			if len(line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case ctrl('A'):
+			pos = 0
+		case ctrl('E'):
+			pos = len(line)
+		case ctrl('B'):
+			pos = max(pos-1, 0)
+		case ctrl('F'):
+			pos = min(pos+1, len(line))
+		case ctrl('K'):
+			line = line[:pos]
+		case ctrl('U'):
+			line, pos = line[pos:], 0
+		case ctrl('P'):
+			recall(recalled - 1)
+		case ctrl('N'):
+			recall(recalled + 1)
+		case '\t':
+			if e.complete == nil {
+				break
+			}
+			word, candidates := e.complete(string(line[:pos]))
+			if common := commonPrefix(candidates); len(common) > len(word) {
+				insert := []rune(common[len(word):])
+				line = append(line[:pos], append(insert, line[pos:]...)...)
+				pos += len(insert)
+			} else if len(candidates) > 1 {
+				fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+			}
+		case ctrl('H'), 0x7f:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case 0x1b:
+			switch e.escape() {
+			case 'A':
+				recall(recalled - 1)
+			case 'B':
+				recall(recalled + 1)
+			case 'C':
+				pos = min(pos+1, len(line))
+			case 'D':
+				pos = max(pos-1, 0)
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case 'X':
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				break
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+		// Rewrite the line, clear what is left of the previous one and move
+		// back to the cursor.
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if n := len(line) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+}
+
+// escape reads the rest of an escape sequence sent by a key and returns
+// the letter of the arrow key, H for Home, F for End, X for Delete, or 0
+// for any other key.
+func (e *lineEditor) escape() byte {
+	b, err := e.in.ReadByte()
+	if err != nil || b != '[' && b != 'O' {
+		return 0
+	}
+	var param []byte
+	for {
+		b, err := e.in.ReadByte()
+		switch {
+		case err != nil:
+			return 0
+		case b >= '0' && b <= '9' || b == ';':
+			param = append(param, b)
+		case b == '~':
+			switch string(param) {
+			case "1", "7":
+				return 'H'
+			case "4", "8":
+				return 'F'
+			case "3":
+				return 'X'
+			}
+			return 0
+		default:
+			return b
+		}
+	}
+}
+
+// add adds line to the history unless it is blank or repeats the last one.
+func (e *lineEditor) add(line string) {
+	if strings.TrimSpace(line) == "" ||
+		len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// commonPrefix returns the longest prefix shared by every string in ss.
+func commonPrefix(ss []string) string {
+	if len(ss) == 0 {
+		return ""
+	}
+	prefix := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// ctrl returns the character typed by pressing Ctrl with the letter c.
+func ctrl(c rune) rune { return c & 0x1f }
@@ -0,0 +1,86 @@
+package repl
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(file, []byte("let a = 1;\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys := strings.Join([]string{
+		"1 + 2\r",
+		"bc\x01a\x05d\r",                      // Ctrl-A and Ctrl-E
+		"ac\x1b[Db\x1b[C!\r",                  // left and right arrows
+		"abcd\x1b[D\x1b[D\x0b\r",              // Ctrl-K
+		"abcd\x1b[D\x15\r",                    // Ctrl-U
+		"ax\x7fb\x08c\r",                      // backspace
+		"abc\x1b[H\x1b[3~\x1b[F!\r",           // Home, Delete and End
+		"\x1b[A\x1b[A\r",                      // recall the line before last
+		"new\x1b[A\x1b[B\r",                   // recall and return to the typed line
+		strings.Repeat("\x10", 12) + "\x0e\r", // Ctrl-P past the oldest line
+		"discarded\x03",                       // Ctrl-C
+		"   \r",                               // blank lines are not in the history
+		"x\x01\x04\r",                         // Ctrl-D deletes under the cursor
+		"\x04",                                // and ends the input on an empty line
+	}, "")
+	e := newLineEditor(strings.NewReader(keys), io.Discard, file)
+	want := []string{
+		"1 + 2", "abcd", "abc!", "ab", "d", "ac", "bc!", "ac", "new",
+		"1 + 2", "", "   ", "",
+	}
+	for i, w := range want {
+		line, err := e.readLine(PROMPT)
+		if i == 10 {
+			if !errors.Is(err, errInterrupted) {
+				t.Fatalf("line %d: expected an interruption, got %q, %v", i+1, line, err)
+			}
+			continue
+		}
+		if err != nil || line != w {
+			t.Fatalf("line %d: expected %q, got %q, %v", i+1, w, line, err)
+		}
+	}
+	if line, err := e.readLine(PROMPT); err != io.EOF {
+		t.Fatalf("expected EOF, got %q, %v", line, err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHistory := "let a = 1;\n1 + 2\nabcd\nabc!\nab\nd\nac\nbc!\nac\nnew\n1 + 2\n"
+	if string(data) != wantHistory {
+		t.Errorf("wrong history file. want=%q, got=%q", wantHistory, data)
+	}
+}
+
+func TestLineEditorComplete(t *testing.T) {
+	keys := "pr\t(1)\rle\t\tn(x)\rx\x01pr\t\r"
+	var out strings.Builder
+	e := newLineEditor(strings.NewReader(keys), &out, "")
+	e.complete = func(before string) (string, []string) {
+		i := strings.LastIndex(before, " ") + 1
+		var candidates []string
+		for _, name := range []string{"len", "let", "print", "puts"} {
+			if strings.HasPrefix(name, before[i:]) {
+				candidates = append(candidates, name)
+			}
+		}
+		return before[i:], candidates
+	}
+	for _, want := range []string{"print(1)", "len(x)", "printx"} {
+		if line, err := e.readLine(PROMPT); err != nil || line != want {
+			t.Fatalf("expected %q, got %q, %v", want, line, err)
+		}
+	}
+	if !strings.Contains(out.String(), "\r\nlen  let\r\n") {
+		t.Errorf("expected the candidates to be listed, got %q", out.String())
+	}
+}
@@ -2,65 +2,441 @@
 package repl
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
 
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/compiler"
 	"github.com/ajwerner/monkey/evaluator"
 	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/monkeyerr"
 	"github.com/ajwerner/monkey/object"
 	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/stdlib"
+	"github.com/ajwerner/monkey/vm"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT = ">> "
+	// CONTINUATION_PROMPT is written instead of PROMPT while the lines read
+	// since the last one are the start of a longer program, such as a
+	// function literal without its closing brace.
+	CONTINUATION_PROMPT = ".. "
+)
+
+// Engine selects how the REPL runs each line.
+type Engine int
+
+const (
+	// Eval evaluates each line with the evaluator.
+	Eval Engine = iota
+	// VM compiles each line and runs it on the VM. Once a line uses a
+	// feature which the compiler does not support yet, the REPL warns and
+	// runs that line and all later ones with the evaluator, which takes
+	// over the values of the globals defined so far.
+	VM
+)
+
+func (e Engine) String() string {
+	switch e {
+	case Eval:
+		return "eval"
+	case VM:
+		return "vm"
+	default:
+		return fmt.Sprintf("Engine(%d)", int(e))
+	}
+}
+
+// ParseEngine returns the Engine named s, "eval" or "vm".
+func ParseEngine(s string) (Engine, error) {
+	for _, e := range []Engine{Eval, VM} {
+		if s == e.String() {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown engine %q; want eval or vm", s)
+}
+
+// An Option configures the REPL run by Start.
+type Option func(*options)
+
+type options struct {
+	engine      Engine
+	historyFile string
+}
+
+// WithEngine makes the REPL start with engine e rather than Eval. The
+// :engine command switches engines during a session.
+func WithEngine(e Engine) Option {
+	return func(o *options) { o.engine = e }
+}
+
+// WithHistoryFile saves the lines typed at a terminal to path, and recalls
+// the lines saved there by earlier sessions.
+func WithHistoryFile(path string) Option {
+	return func(o *options) { o.historyFile = path }
+}
+
+// Start reads lines from in and runs each, writing its value or error to
+// out. If in is a terminal, the line being typed can be edited and earlier
+// lines recalled with the arrow keys; Ctrl-C abandons the input and Ctrl-D
+// on an empty line ends the REPL. An interrupt signal, such as Ctrl-C at a
+// terminal, stops the line running and returns to the prompt. A line which
+// leaves a block, call or literal open is continued on the following lines
+// until the input parses; an empty line abandons such an input, writing its
+// errors. Lines starting with a colon are commands, as listed by :help.
+func Start(in io.Reader, out io.Writer, opts ...Option) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := newSession(out, o.engine)
+	start(newLineReader(in, out, o.historyFile, s.complete), s)
+}
+
+// StartVM is Start with WithEngine(VM).
+func StartVM(in io.Reader, out io.Writer) {
+	Start(in, out, WithEngine(VM))
+}
 
-func Start(in io.Reader, out io.Writer) {
-	env := object.NewEnvironment()
-	scanner := bufio.NewScanner(in)
+func start(in lineReader, s *session) {
+	out := s.out
+	var input strings.Builder
 	for {
-		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		prompt := PROMPT
+		if input.Len() != 0 {
+			prompt = CONTINUATION_PROMPT
+		}
+		line, err := in.readLine(prompt)
+		if errors.Is(err, errInterrupted) {
+			input.Reset()
+			continue
+		}
+		if err != nil {
+			if input.Len() != 0 {
+				// Report why the last input was incomplete.
+				_, errs := parse(input.String())
+				monkeyerr.Fprint(out, monkeyerr.From(errors.Join(errs...)))
+			}
 			return
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
+		if input.Len() == 0 && strings.HasPrefix(line, ":") {
+			if quit := s.command(line); quit {
+				return
+			}
+			continue
+		}
+		if input.Len() != 0 {
+			input.WriteString("\n")
+		}
+		input.WriteString(line)
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		program, errs := parse(input.String())
+		if len(errs) != 0 && parser.Incomplete(errs) && strings.TrimSpace(line) != "" {
 			continue
 		}
+		input.Reset()
+		if len(errs) != 0 {
+			monkeyerr.Fprint(out, monkeyerr.From(errors.Join(errs...)))
+			continue
+		}
+		s.runAndPrint(program)
+	}
+}
 
-		// comp := compiler.New()
-		// err := comp.Compile(program)
-		// if err != nil {
-		// 	fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
-		// 	continue
-		// }
+const help = `:help             list the commands
+:quit             leave the REPL
+:reset            forget every binding made so far
+:load FILE        evaluate the program in FILE, keeping its bindings
+:env              list the names bound so far
+:ast [INPUT]      print the syntax tree of INPUT or of the last input
+:bytecode [INPUT] print the bytecode of INPUT or of the last input
+:engine [NAME]    print the engine running lines, or switch to eval or vm
+`
 
-		// machine := vm.New(comp.Bytecode())
-		// err = machine.Run()
-		// if err != nil {
-		// 	fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
-		// 	continue
-		// }
+// command runs the command in line, which starts with a colon, and reports
+// whether it ends the REPL.
+func (s *session) command(line string) (quit bool) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":help":
+		io.WriteString(s.out, help)
+	case ":quit":
+		return true
+	case ":reset":
+		*s = *newSession(s.out, s.engine)
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(s.out, "usage: :load FILE")
+			break
+		}
+		src, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			break
+		}
+		program, errs := parse(string(src))
+		if len(errs) != 0 {
+			monkeyerr.Fprint(s.out, monkeyerr.From(errors.Join(errs...)))
+			break
+		}
+		s.runAndPrint(program)
+	case ":env":
+		for _, name := range s.names() {
+			fmt.Fprintln(s.out, name)
+		}
+	case ":ast":
+		if program := s.inspected(arg); program != nil {
+			ast.DumpPretty(s.out, program)
+		}
+	case ":bytecode":
+		program := s.inspected(arg)
+		if program == nil {
+			break
+		}
+		bytecode, err := s.preview(program)
+		if err != nil {
+			monkeyerr.Fprint(s.out, monkeyerr.From(err))
+			break
+		}
+		io.WriteString(s.out, bytecode.String())
+	case ":engine":
+		if arg == "" {
+			fmt.Fprintln(s.out, s.current())
+			break
+		}
+		engine, err := ParseEngine(arg)
+		if err != nil {
+			fmt.Fprintln(s.out, err)
+			break
+		}
+		s.switchTo(engine)
+	default:
+		fmt.Fprintf(s.out, "unknown command %s; :help lists the commands\n", name)
+	}
+	return false
+}
 
-		// stackTop := machine.StackTop()
-		// io.WriteString(out, stackTop.Inspect())
-		// io.WriteString(out, "\n")
+// inspected returns the program which :ast and :bytecode show: input
+// parsed, or the last program run if input is empty. It writes why and
+// returns nil if there is no such program.
+func (s *session) inspected(input string) *ast.Program {
+	if input == "" {
+		if s.last == nil {
+			fmt.Fprintln(s.out, "no input yet")
+		}
+		return s.last
+	}
+	program, errs := parse(input)
+	if len(errs) != 0 {
+		monkeyerr.Fprint(s.out, monkeyerr.From(errors.Join(errs...)))
+		return nil
+	}
+	return program
+}
+
+// preview compiles program against the globals bound so far without
+// running it or defining its globals. Once the session has fallen back to
+// the evaluator, the globals are those of its environment.
+func (s *session) preview(program *ast.Program) (*compiler.Bytecode, error) {
+	comp := s.comp
+	if comp == nil {
+		symbols := compiler.NewBuiltinSymbolTable(s.builtins)
+		for _, name := range s.env.Names() {
+			symbols.Define(name)
+		}
+		comp = compiler.NewSession(symbols)
+		comp.Importer = s.eval.Importer
+	}
+	return comp.Preview(program)
+}
+
+// notifyContext is signal.NotifyContext, which tests replace.
+var notifyContext = signal.NotifyContext
+
+// runAndPrint runs program and writes its value, if it ends with an
+// expression, or its error. An interrupt signal, as sent by Ctrl-C, stops
+// the program, keeping the bindings it made so far.
+func (s *session) runAndPrint(program *ast.Program) {
+	s.last = program
+	ctx, stop := notifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := s.run(ctx, program)
+	if err != nil && ctx.Err() != nil && errors.Is(err, context.Canceled) {
+		fmt.Fprintln(s.out, "interrupted")
+		return
+	}
+	if err != nil {
+		monkeyerr.Fprint(s.out, monkeyerr.From(err))
+		return
+	}
+	if _, ok := result.(object.Null); ok && !endsWithExpression(program) {
+		// Lines such as let statements have no value to print.
+		return
+	}
+	object.Fprint(s.out, result)
+	io.WriteString(s.out, "\n")
+}
+
+// parse parses input as a program.
+func parse(input string) (*ast.Program, []error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	return program, p.Errors()
+}
+
+// session holds the state which the lines of a REPL share.
+type session struct {
+	out io.Writer
+	// engine is the engine the session started with or was last switched
+	// to, which :reset restores.
+	engine   Engine
+	env      *object.Environment
+	eval     evaluator.Evaluator
+	builtins *object.Builtins
+	// last is the program most recently run, for :ast and :bytecode.
+	last *ast.Program
+
+	// The compiler session and globals of the VM, until a line falls back
+	// to the evaluator and comp becomes nil.
+	comp    *compiler.Session
+	globals []object.Object
+}
+
+func newSession(out io.Writer, engine Engine) *session {
+	builtins := object.NewBuiltins()
+	builtins.SetOutput(out)
+	importer := stdlib.NewImporter(0, stdlib.EnvPath()...)
+	s := &session{
+		out:      out,
+		engine:   engine,
+		env:      object.NewEnvironment(),
+		eval:     evaluator.Evaluator{Builtins: builtins, Importer: importer},
+		builtins: builtins,
+	}
+	if engine == VM {
+		s.startVM()
+	}
+	return s
+}
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+// startVM makes the session run lines on the VM, whose globals take over
+// the bindings of the evaluator's environment.
+func (s *session) startVM() {
+	symbols := compiler.NewBuiltinSymbolTable(s.builtins)
+	s.globals = make([]object.Object, vm.GlobalsSize)
+	for _, name := range s.env.Names() {
+		obj, _ := s.env.Get(name)
+		s.globals[symbols.Define(name).Index] = obj
+	}
+	s.comp = compiler.NewSession(symbols)
+	s.comp.Importer = s.eval.Importer
+}
+
+// current returns the engine running lines, which is Eval once a session
+// started with VM has fallen back to the evaluator.
+func (s *session) current() Engine {
+	if s.comp != nil {
+		return VM
+	}
+	return Eval
+}
+
+// switchTo makes the session run lines with engine, keeping the bindings
+// made so far.
+func (s *session) switchTo(engine Engine) {
+	s.engine = engine
+	switch {
+	case engine == VM && s.comp == nil:
+		s.startVM()
+	case engine == Eval && s.comp != nil:
+		s.stopVM()
+	}
+}
+
+// run runs program with the VM, if the session still uses it, or else with
+// the evaluator, until ctx is done.
+func (s *session) run(ctx context.Context, program *ast.Program) (object.Object, error) {
+	if s.comp != nil {
+		s.comp.Engine = s.eval.BuiltinContext(ctx, nil)
+		bytecode, err := s.comp.CompileContext(ctx, program)
+		switch {
+		case err == nil:
+			machine := vm.NewWithGlobalsStore(bytecode, s.builtins, s.globals)
+			machine.Evaluator = &s.eval
+			if err := machine.RunContext(ctx); err != nil {
+				return nil, err
+			}
+			if obj := machine.LastPoppedStackElem(); obj != nil && endsWithExpression(program) {
+				return obj, nil
+			}
+			return object.Null{}, nil
+		case !errors.Is(err, compiler.ErrUnsupported):
+			return nil, err
+		}
+		s.fallBack(err)
+	}
+	result := s.eval.EvalContext(ctx, program, s.env)
+	if errObj, ok := result.(object.Error); ok {
+		return nil, errObj.Err
+	}
+	return result, nil
+}
+
+// fallBack switches the session from the VM to the evaluator after err,
+// the error of compiling a line which the compiler does not support, moving
+// the VM's globals into the evaluator's environment.
+func (s *session) fallBack(err error) {
+	ds := monkeyerr.From(err)
+	for i := range ds {
+		ds[i].Severity = monkeyerr.SeverityWarning
+		ds[i].Msg += "; continuing with the evaluator"
+	}
+	monkeyerr.Fprint(s.out, ds)
+	s.stopVM()
+}
+
+// stopVM makes the session run lines with the evaluator, moving the VM's
+// globals into the evaluator's environment.
+func (s *session) stopVM() {
+	for _, sym := range s.comp.Symbols().Globals() {
+		if obj := s.globals[sym.Index]; obj != nil {
+			s.env.Set(sym.Name, obj)
+		}
+	}
+	s.comp, s.globals = nil, nil
+}
+
+// names returns the names bound by the lines run so far, in sorted order.
+func (s *session) names() []string {
+	if s.comp == nil {
+		return s.env.Names()
+	}
+	var names []string
+	for _, sym := range s.comp.Symbols().Globals() {
+		if s.globals[sym.Index] != nil {
+			names = append(names, sym.Name)
 		}
 	}
+	sort.Strings(names)
+	return names
 }
 
-func printParserErrors(out io.Writer, errors []error) {
-	for _, err := range errors {
-		io.WriteString(out, "\t"+err.Error()+"\n")
+// endsWithExpression reports whether the last statement of program is an
+// expression statement.
+func endsWithExpression(program *ast.Program) bool {
+	n := len(program.Statements)
+	if n == 0 {
+		return false
 	}
+	_, ok := program.Statements[n-1].(*ast.ExpressionStatement)
+	return ok
 }
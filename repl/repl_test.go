@@ -0,0 +1,234 @@
+package repl
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		start func(in *strings.Reader, out *strings.Builder)
+		input string
+		want  []string
+	}{
+		{
+			func(in *strings.Reader, out *strings.Builder) { Start(in, out) },
+			"let a = 5;\na * 2\nlet sq = fn(n) { n * n };\nsq(a)\nlet = 1",
+			[]string{"", "10", "", "25", "line 1: parse error: expected next token to be IDENT, got = instead\nline 1: parse error: no prefix parse function for = found"},
+		},
+		{
+			func(in *strings.Reader, out *strings.Builder) { StartVM(in, out) },
+			"let a = 5;\na * 2\n1 + true\nlet sq = fn(n) { n * n };\nsq(a)\nlet b = a + 1;\nb",
+			[]string{
+				"", "10", "line 1: runtime error: type mismatch: INTEGER + BOOL",
+				"line 1: compile warning: unsupported node type *ast.FunctionLiteral; continuing with the evaluator",
+				"25", "", "6",
+			},
+		},
+		{
+			func(in *strings.Reader, out *strings.Builder) { Start(in, out) },
+			"let f = fn(x) {\n  x * 2\n};\nf(3)\n[1,\n2]\nif (true) {\n\n\"a\nb\"",
+			[]string{
+				CONTINUATION_PROMPT + CONTINUATION_PROMPT, "6", CONTINUATION_PROMPT + "[1, 2]",
+				CONTINUATION_PROMPT + "line 2: parse error: expected } to close the block, got EOF",
+				CONTINUATION_PROMPT + "a\nb",
+			},
+		},
+		{
+			func(in *strings.Reader, out *strings.Builder) { StartVM(in, out) },
+			"let c = 1; let d = nope;\nc\nlet c = 2;\nc",
+			[]string{
+				"line 1: runtime error: identifier not found: nope",
+				"1", "", "2",
+			},
+		},
+		{
+			func(in *strings.Reader, out *strings.Builder) { Start(in, out) },
+			"let c = 2; let d = 1 / 0;\nd\nc",
+			[]string{
+				"line 1: runtime error: division by zero",
+				"line 1: runtime error: identifier not found: d",
+				"2",
+			},
+		},
+		{
+			func(in *strings.Reader, out *strings.Builder) { StartVM(in, out) },
+			"let c = 2; let d = 1 / 0;\nd\nc",
+			[]string{
+				"line 1: runtime error: division by zero",
+				"line 1: runtime error: identifier not found: d",
+				"2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		tt.start(strings.NewReader(tt.input), &out)
+		got := strings.Split(out.String(), PROMPT)
+		// Each line's output follows its prompt; the last prompt finds no
+		// more input.
+		got = got[1 : len(got)-1]
+		if len(got) != len(tt.want) {
+			t.Fatalf("%q: expected %d outputs, got %q", tt.input, len(tt.want), got)
+		}
+		for i, want := range tt.want {
+			if got := strings.TrimSuffix(got[i], "\n"); got != want {
+				t.Errorf("%q: line %d: expected %q, got %q", tt.input, i+1, want, got)
+			}
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib.monkey")
+	if err := os.WriteFile(file, []byte("let c = 3;\nc * 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		"let b = 1;", ":load " + file, ":env", ":reset", ":env", "c", ":nope", ":quit", "1",
+	}, "\n")
+	for _, engine := range []Engine{Eval, VM} {
+		var out strings.Builder
+		Start(strings.NewReader(input), &out, WithEngine(engine))
+		got := strings.Split(out.String(), PROMPT)[1:]
+		want := []string{
+			"", "6\n", "b\nc\n", "", "", "line 1: runtime error: identifier not found: c\n",
+			"unknown command :nope; :help lists the commands\n", "",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong output. want=%q, got=%q", want, got)
+		}
+	}
+}
+
+func TestInspectCommands(t *testing.T) {
+	input := strings.Join([]string{
+		":ast", "let x = 1 + 2;", ":ast", ":bytecode", ":bytecode x * 2", ":ast 1 +", `:bytecode import "nope"`, "1",
+	}, "\n")
+	letAST := `(LetStatement
+  (Identifier "x")
+  (InfixExpression (IntegerLiteral 1) "+" (IntegerLiteral 2)))
+`
+	letBytecode := `0000 OpConstant 0
+0003 OpConstant 1
+0006 OpAdd
+0007 OpSetGlobal 0
+constants:
+0000 INTEGER 1
+0001 INTEGER 2
+`
+	for _, tt := range []struct {
+		engine   Engine
+		bytecode string
+	}{
+		// The evaluator has no pool of constants to share.
+		{Eval, "0000 OpGetGlobal 0\n0003 OpConstant 0\n0006 OpMul\n0007 OpPop\nconstants:\n0000 INTEGER 2\n"},
+		{VM, "0000 OpGetGlobal 0\n0003 OpConstant 1\n0006 OpMul\n0007 OpPop\nconstants:\n0000 INTEGER 1\n0001 INTEGER 2\n"},
+	} {
+		var out strings.Builder
+		Start(strings.NewReader(input), &out, WithEngine(tt.engine))
+		got := strings.Split(out.String(), PROMPT)[1:]
+		want := []string{
+			"no input yet\n", "", letAST, letBytecode, tt.bytecode,
+			"line 1: parse error: no prefix parse function for EOF found\n",
+			"line 1: compile error: no module named \"nope\"\n",
+			"1\n", "",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong output. want=%q, got=%q", want, got)
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+	input := strings.Join([]string{
+		"let a = 2;", ":engine", ":engine vm", ":engine", "a * 3", "let b = a + 1;",
+		"let f = fn() { b };", ":engine", ":engine vm", "let c = b * 2;", ":engine eval", "c + a",
+		":engine jit", "1",
+	}, "\n")
+	var out strings.Builder
+	Start(strings.NewReader(input), &out)
+	got := strings.Split(out.String(), PROMPT)[1:]
+	want := []string{
+		"", "eval\n", "", "vm\n", "6\n", "",
+		"line 1: compile warning: unsupported node type *ast.FunctionLiteral; continuing with the evaluator\n",
+		"eval\n", "", "", "", "8\n",
+		"unknown engine \"jit\"; want eval or vm\n", "1\n", "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong output. want=%q, got=%q", want, got)
+	}
+	for _, name := range []string{"eval", "vm"} {
+		e, err := ParseEngine(name)
+		if err != nil || e.String() != name {
+			t.Errorf("ParseEngine(%q) = %v, %v", name, e, err)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		before     string
+		word       string
+		candidates []string
+	}{
+		{"pri", "pri", []string{"print"}},
+		{"le", "le", []string{"len", "let", "letters"}},
+		{"1 + lett", "lett", []string{"letters"}},
+		{`cfg["`, "", []string{`debug"]`, `name"]`, `names"]`}},
+		{`puts(cfg["na`, "na", []string{`name"]`, `names"]`}},
+		{`letters["`, "", nil},
+		{"1 + 2", "2", nil},
+		{"x + ", "", nil},
+	}
+	for _, engine := range []Engine{Eval, VM} {
+		s := newSession(io.Discard, engine)
+		program, _ := parse(`let letters = "abc"; let cfg = {"name": 1, "debug": true, "names": [], 1: 2};`)
+		if _, err := s.run(context.Background(), program); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			word, candidates := s.complete(tt.before)
+			if word != tt.word || !reflect.DeepEqual(candidates, tt.candidates) {
+				t.Errorf("%s: complete(%q) = %q, %q; want %q, %q",
+					engine, tt.before, word, candidates, tt.word, tt.candidates)
+			}
+		}
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	defer func(orig func(context.Context, ...os.Signal) (context.Context, context.CancelFunc)) {
+		notifyContext = orig
+	}(notifyContext)
+	// The builtin interrupt interrupts the line calling it, as Ctrl-C
+	// would.
+	var interrupt context.CancelFunc
+	notifyContext = func(ctx context.Context, _ ...os.Signal) (context.Context, context.CancelFunc) {
+		ctx, interrupt = context.WithCancel(ctx)
+		return ctx, interrupt
+	}
+	input := "let i = 0;\nwhile (true) { i = i + 1; if (i == 100) { interrupt() } }\ni >= 100"
+	for _, engine := range []Engine{Eval, VM} {
+		var out strings.Builder
+		s := newSession(&out, Eval)
+		s.builtins.Register("interrupt", func(args ...object.Object) object.Object {
+			interrupt()
+			return object.Null{}
+		})
+		s.switchTo(engine)
+		start(newLineReader(strings.NewReader(input), &out, "", s.complete), s)
+		got := strings.Split(out.String(), PROMPT)[1:]
+		want := []string{"", "interrupted\n", "true\n", ""}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: wrong output. want=%q, got=%q", engine, want, got)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package repl
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw puts the terminal f into raw mode, in which each key is read as
+// it is typed, without being echoed and without Ctrl-C raising a signal,
+// and returns a function restoring the mode f had before. It fails if f is
+// not a terminal.
+func makeRaw(f *os.File) (restore func(), err error) {
+	fd := f.Fd()
+	var old syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, &old); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(fd, syscall.TCSETS, &old) }, nil
+}
+
+func ioctl(fd, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
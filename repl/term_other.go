@@ -0,0 +1,15 @@
+//go:build !linux
+
+package repl
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+// makeRaw fails, as line editing is only implemented on Linux; the REPL
+// reads whole lines instead.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("line editing is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,134 @@
+// Package sandbox defines the restrictions shared by the evaluator and the VM
+// for running untrusted programs: resource limits, checked while a program
+// runs, and forbidden syntax, checked before it is parsed.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/token"
+)
+
+// Limits bounds the resources a program may use. Zero values mean no limit.
+type Limits struct {
+	// MaxSteps is the number of statements the evaluator, or instructions
+	// the VM, may execute.
+	MaxSteps int64
+	// MaxDepth is the maximum depth of nested function calls. If it is
+	// zero, the evaluator still stops calls nested 10000 deep, before they
+	// exhaust the Go stack.
+	MaxDepth int
+	// MaxMemory is the approximate number of bytes which may be allocated
+	// for strings, arrays and hashes over the course of the execution. It
+	// does not account for memory released by the garbage collector.
+	MaxMemory int64
+	// MaxStringLen is the maximum length in bytes of a string.
+	MaxStringLen int
+	// MaxArrayLen is the maximum number of elements of an array, pairs of
+	// a hash or capacity of a channel.
+	MaxArrayLen int
+}
+
+// Errors reported when a limit is exceeded.
+var (
+	ErrStepLimit   = errors.New("step limit exceeded")
+	ErrDepthLimit  = errors.New("call depth limit exceeded")
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+	ErrStringLimit = errors.New("string length limit exceeded")
+	ErrArrayLimit  = errors.New("array length limit exceeded")
+)
+
+// CheckSize returns an error if obj exceeds MaxStringLen or MaxArrayLen.
+func (l Limits) CheckSize(obj object.Object) error {
+	switch obj := obj.(type) {
+	case object.String:
+		if l.MaxStringLen > 0 && len(obj) > l.MaxStringLen {
+			return ErrStringLimit
+		}
+	case *object.Array:
+		if l.MaxArrayLen > 0 && len(*obj) > l.MaxArrayLen {
+			return ErrArrayLimit
+		}
+	case object.Hash:
+		if l.MaxArrayLen > 0 && obj.Len() > l.MaxArrayLen {
+			return ErrArrayLimit
+		}
+	case *object.Channel:
+		if l.MaxArrayLen > 0 && cap(obj.C) > l.MaxArrayLen {
+			return ErrArrayLimit
+		}
+	}
+	return nil
+}
+
+// Usage counts the steps and memory used against Limits by engines which
+// share a budget, such as a program and the tasks it spawns. It is safe for
+// concurrent use, and the zero value has used nothing.
+type Usage struct {
+	steps atomic.Int64
+	mem   atomic.Int64
+}
+
+// Step counts a step and returns the number taken.
+func (u *Usage) Step() int64 { return u.steps.Add(1) }
+
+// Alloc counts n bytes and returns the number allocated.
+func (u *Usage) Alloc(n int64) int64 { return u.mem.Add(n) }
+
+// Add counts steps and bytes used elsewhere.
+func (u *Usage) Add(steps, mem int64) {
+	u.steps.Add(steps)
+	u.mem.Add(mem)
+}
+
+// Steps returns the number of steps taken.
+func (u *Usage) Steps() int64 { return u.steps.Load() }
+
+// Memory returns the number of bytes allocated.
+func (u *Usage) Memory() int64 { return u.mem.Load() }
+
+// SizeOf returns a rough estimate of the bytes directly held by obj, as
+// charged against MaxMemory.
+func SizeOf(obj object.Object) int64 {
+	const wordSize = 8
+	switch obj := obj.(type) {
+	case object.String:
+		return int64(len(obj))
+	case *object.Array:
+		return int64(len(*obj)) * 2 * wordSize
+	case object.Array:
+		return int64(len(obj)) * 2 * wordSize
+	case object.Hash:
+		return int64(obj.Len()) * 6 * wordSize
+	case *object.Channel:
+		return int64(cap(obj.C)) * 2 * wordSize
+	default:
+		return 0
+	}
+}
+
+// CheckSyntax returns an error naming the first token of src whose type is in
+// forbidden, so that, for example, forbidding token.FUNCTION rejects function
+// literals. Lexing errors are left for the parser to report.
+func CheckSyntax(src string, forbidden []token.TokenType) error {
+	if len(forbidden) == 0 {
+		return nil
+	}
+	l := lexer.New(src)
+	for l.Next() {
+		tok := l.Token()
+		if tok.Type == token.EOF {
+			return nil
+		}
+		for _, t := range forbidden {
+			if tok.Type == t {
+				return fmt.Errorf("line %d: %q is not allowed", tok.Line, tok.Literal)
+			}
+		}
+	}
+	return nil
+}
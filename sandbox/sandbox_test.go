@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/token"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	tests := []struct {
+		input     string
+		forbidden []token.TokenType
+		err       string
+	}{
+		{"let f = fn(x) { x };", nil, ""},
+		{"let f = fn(x) { x };", []token.TokenType{token.FUNCTION}, `line 1: "fn" is not allowed`},
+		{"let a = 1;\na[0]", []token.TokenType{token.FUNCTION, token.LBRACKET}, `line 2: "[" is not allowed`},
+		{"let s = \"fn\";", []token.TokenType{token.FUNCTION}, ""},
+	}
+	for _, tt := range tests {
+		err := CheckSyntax(tt.input, tt.forbidden)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+		}
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	limits := Limits{MaxStringLen: 2, MaxArrayLen: 1}
+	tests := []struct {
+		obj      object.Object
+		expected error
+	}{
+		{object.String("ab"), nil},
+		{object.String("abc"), ErrStringLimit},
+		{&object.Array{object.Integer(1), object.Integer(2)}, ErrArrayLimit},
+		{object.HashOf(object.Integer(1), object.Null{}), nil},
+		{object.Integer(1 << 40), nil},
+	}
+	for _, tt := range tests {
+		if err := limits.CheckSize(tt.obj); err != tt.expected {
+			t.Errorf("CheckSize(%v): expected %v, got %v", tt.obj.Inspect(), tt.expected, err)
+		}
+	}
+	if err := (Limits{}).CheckSize(object.String("unlimited")); err != nil {
+		t.Errorf("expected no error without limits, got %v", err)
+	}
+}
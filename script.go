@@ -0,0 +1,175 @@
+package monkey
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/compiler"
+	"github.com/ajwerner/monkey/objconv"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/vm"
+)
+
+// Script is a program compiled once to bytecode and run many times, each run
+// with fresh globals. Identifiers which the program uses but never defines
+// are its parameters and are supplied to Run. A Script is safe for
+// concurrent use; the objects passed to and returned from Run are not shared
+// between runs.
+type Script struct {
+	bytecode    *compiler.Bytecode
+	builtins    *object.Builtins
+	limits      sandbox.Limits
+	truthiness  object.Truthiness
+	strictIndex bool
+	intDivision bool
+	evaluator   vm.Evaluator
+	params      []compiler.Symbol
+	numGlobals  int
+	hasResult   bool
+}
+
+// Compile compiles src for the VM with the standard builtins.
+func Compile(src string) (*Script, error) {
+	in, err := New()
+	if err != nil {
+		return nil, err
+	}
+	return in.Compile(src)
+}
+
+// Compile compiles src for the VM with the interpreter's builtins, limits,
+// truthiness, index mode and syntax restrictions. The builtin table must
+// not be modified while the Script is in use.
+func (in *Interpreter) Compile(src string) (*Script, error) {
+	return in.CompileContext(context.Background(), src)
+}
+
+// CompileContext is like Compile but stops with an error wrapping ctx.Err()
+// once ctx is done.
+func (in *Interpreter) CompileContext(ctx context.Context, src string) (_ *Script, err error) {
+	defer recoverInternal(&err)
+	program, err := in.parse(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	symbols := compiler.NewBuiltinSymbolTable(in.builtins)
+	var params []compiler.Symbol
+	for _, name := range freeIdentifiers(program, symbols) {
+		params = append(params, symbols.Define(name))
+	}
+	comp := compiler.NewWithState(symbols, []object.Object{})
+	comp.Importer = in.importModule
+	comp.Engine = in.newEvaluator().BuiltinContext(ctx, nil)
+	if err := comp.CompileContext(ctx, program); err != nil {
+		return nil, err
+	}
+	return &Script{
+		bytecode:    comp.Bytecode(),
+		builtins:    in.builtins,
+		limits:      in.eval.Limits,
+		truthiness:  in.eval.Truthiness,
+		strictIndex: in.eval.StrictIndex,
+		intDivision: in.eval.IntegerDivision,
+		evaluator:   in.newEvaluator(),
+		params:      params,
+		numGlobals:  len(symbols.Globals()),
+		hasResult:   endsWithExpression(program),
+	}, nil
+}
+
+// Params returns the names of the script's parameters in order of first use.
+func (s *Script) Params() []string {
+	names := make([]string, len(s.params))
+	for i, p := range s.params {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Run executes the script with its parameters set to the values in params,
+// converted with objconv.FromGo, and returns the value of its last statement
+// as for Interpreter.Eval. Every parameter must be present in params; other
+// entries are ignored.
+func (s *Script) Run(params map[string]interface{}) (object.Object, error) {
+	return s.RunContext(context.Background(), params)
+}
+
+// RunContext is like Run but stops with ctx.Err() once ctx is done. Like
+// Interpreter.EvalContext, it cancels the timers the script schedules when
+// it returns.
+func (s *Script) RunContext(ctx context.Context, params map[string]interface{}) (object.Object, error) {
+	globals := make([]object.Object, s.numGlobals)
+	return s.run(ctx, s.newVM(globals), globals, params)
+}
+
+// newVM returns a VM for the script which stores its globals in globals.
+func (s *Script) newVM(globals []object.Object) *vm.VM {
+	machine := vm.NewWithGlobalsStore(s.bytecode, s.builtins, globals)
+	machine.Limits = s.limits
+	machine.Truthiness = s.truthiness
+	machine.StrictIndex = s.strictIndex
+	machine.IntegerDivision = s.intDivision
+	machine.Evaluator = s.evaluator
+	return machine
+}
+
+// run sets the script's parameters in globals, which must be the zeroed
+// globals store of machine, and runs machine.
+func (s *Script) run(ctx context.Context, machine *vm.VM, globals []object.Object, params map[string]interface{}) (_ object.Object, err error) {
+	defer recoverInternal(&err)
+	ctx, stopTimers := object.WithTimers(ctx)
+	defer stopTimers()
+	for _, p := range s.params {
+		v, ok := params[p.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter %s", p.Name)
+		}
+		obj, err := objconv.FromGo(v)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		globals[p.Index] = obj
+	}
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	return result(machine, s.hasResult), nil
+}
+
+// freeIdentifiers returns the identifiers used by program which are neither
+// defined by a let statement or a for loop nor resolved by symbols, in order
+// of first use.
+func freeIdentifiers(program *ast.Program, symbols *compiler.SymbolTable) []string {
+	defined := map[string]bool{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LetStatement:
+			defined[n.Name.Value] = true
+		case *ast.ForExpression:
+			if n.Key != nil {
+				defined[n.Key.Value] = true
+			}
+			defined[n.Value.Value] = true
+		}
+		return true
+	})
+	var free []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		if _, ok := n.(*ast.ProtocolStatement); ok {
+			// Protocols name members, not variables.
+			return false
+		}
+		id, ok := n.(*ast.Identifier)
+		if !ok {
+			return true
+		}
+		if _, ok := symbols.Resolve(id.Value); !ok && !defined[id.Value] {
+			defined[id.Value] = true
+			free = append(free, id.Value)
+		}
+		return true
+	})
+	return free
+}
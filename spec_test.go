@@ -0,0 +1,149 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajwerner/monkey/monkeyerr"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected results of the programs in spec/")
+
+// TestSpec runs each program spec/NAME.monkey with every engine, which must
+// agree with each other and with the expected results: NAME.out holds what
+// the program prints followed by its value unless that is null, and
+// NAME.err, if the program fails, its error without a line number, which
+// not every engine reports. A first line of the form
+//
+//	// engines: eval
+//
+// restricts a program to the engines listed, for features which the others
+// lack. Run with -update to rewrite the expected results.
+func TestSpec(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("spec", "*.monkey"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no spec programs: %v", err)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(path, ".monkey")
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			engines, err := specEngines(string(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want specResult
+			for i, engine := range engines {
+				got := runSpec(t, engine, string(src))
+				if i > 0 && got != want {
+					t.Fatalf("%v and %v diverge:\n%v:\n%s\n%v:\n%s", engines[0], engine, engines[0], want, engine, got)
+				}
+				want = got
+			}
+			if *update {
+				writeSpecFile(t, name+".out", want.out)
+				writeSpecFile(t, name+".err", want.err)
+				return
+			}
+			expected := specResult{out: readSpecFile(t, name+".out"), err: readSpecFile(t, name+".err")}
+			if want != expected {
+				t.Errorf("wrong result:\ngot:\n%s\nwant:\n%s", want, expected)
+			}
+		})
+	}
+}
+
+type specResult struct {
+	out, err string
+}
+
+func (r specResult) String() string {
+	if r.err == "" {
+		return r.out
+	}
+	return r.out + "error: " + r.err
+}
+
+// specEngines returns the engines which run src, as listed by its first
+// line if that is an engines comment, and otherwise all of them.
+func specEngines(src string) ([]Engine, error) {
+	first, _, _ := strings.Cut(src, "\n")
+	list, ok := strings.CutPrefix(first, "// engines:")
+	if !ok {
+		return []Engine{EngineEval, EngineVM}, nil
+	}
+	var engines []Engine
+	for _, name := range strings.Fields(list) {
+		switch name {
+		case EngineEval.String():
+			engines = append(engines, EngineEval)
+		case EngineVM.String():
+			engines = append(engines, EngineVM)
+		default:
+			return nil, fmt.Errorf("unknown engine %q", name)
+		}
+	}
+	return engines, nil
+}
+
+// runSpec runs src with engine and returns its output and error.
+func runSpec(t *testing.T, engine Engine, src string) specResult {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var out bytes.Buffer
+	interp := newInterpreter(t,
+		WithEngine(engine),
+		WithOutput(&out),
+		WithLimits(sandbox.Limits{MaxSteps: 1e6}),
+	)
+	var r specResult
+	result, err := interp.EvalContext(ctx, src)
+	if err != nil {
+		for _, d := range monkeyerr.From(err) {
+			d.Pos = monkeyerr.Position{}
+			r.err += d.String() + "\n"
+		}
+	} else if result != (object.Null{}) {
+		object.Fprint(&out, result)
+		out.WriteByte('\n')
+	}
+	r.out = out.String()
+	return r
+}
+
+// readSpecFile returns the contents of path, or "" if it does not exist.
+func readSpecFile(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// writeSpecFile writes s to path, removing path instead if s is empty.
+func writeSpecFile(t *testing.T, path, s string) {
+	var err error
+	if s == "" {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(path, []byte(s), 0o644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+package stdlib
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "compress" module works on compressed data held in strings, such as
+// the contents of a file: gzipCompress and gzipDecompress convert to and
+// from gzip, zipList returns the names of the files in a zip archive and
+// zipRead returns the contents of one of them.
+func init() {
+	Register("compress", func() *object.Builtins {
+		return table(0, map[string]interface{}{
+			"gzipCompress":   gzipCompress,
+			"gzipDecompress": gzipDecompress,
+			"zipList":        zipList,
+			"zipRead":        zipRead,
+		})
+	})
+}
+
+// maxDecompressed bounds the size of decompressed data.
+const maxDecompressed = 256 << 20
+
+func gzipCompress(s string) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(w, s); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func gzipDecompress(s string) (string, error) {
+	r, err := gzip.NewReader(strings.NewReader(s))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return readLimited(r)
+}
+
+func zipList(s string) ([]string, error) {
+	r, err := zip.NewReader(strings.NewReader(s), int64(len(s)))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(r.File))
+	for i, f := range r.File {
+		names[i] = f.Name
+	}
+	return names, nil
+}
+
+func zipRead(s, name string) (string, error) {
+	r, err := zip.NewReader(strings.NewReader(s), int64(len(s)))
+	if err != nil {
+		return "", err
+	}
+	f, err := r.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readLimited(f)
+}
+
+// readLimited reads all of r, failing if it holds more than maxDecompressed
+// bytes.
+func readLimited(r io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxDecompressed+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxDecompressed {
+		return "", fmt.Errorf("decompressed data exceeds %d bytes", maxDecompressed)
+	}
+	return string(b), nil
+}
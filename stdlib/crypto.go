@@ -0,0 +1,45 @@
+package stdlib
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "crypto" module computes hex-encoded digests: sha256(s), sha1(s),
+// md5(s) and hmac(key, msg, algo), where algo names one of the hashes.
+func init() {
+	Register("crypto", func() *object.Builtins {
+		return table(0, map[string]interface{}{
+			"sha256": func(s string) string { return digest(sha256.New(), s) },
+			"sha1":   func(s string) string { return digest(sha1.New(), s) },
+			"md5":    func(s string) string { return digest(md5.New(), s) },
+			"hmac":   hmacDigest,
+		})
+	})
+}
+
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+func digest(h hash.Hash, s string) string {
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hmacDigest(key, msg, algo string) (string, error) {
+	newHash, ok := hashes[algo]
+	if !ok {
+		return "", fmt.Errorf("unknown hash %q", algo)
+	}
+	return digest(hmac.New(newHash, []byte(key)), msg), nil
+}
@@ -0,0 +1,163 @@
+package stdlib
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ajwerner/monkey/objconv"
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "db" module gives scripts access to the database/sql drivers
+// registered by the host:
+//
+//	let db = import "db";
+//	let conn = db.open("sqlite", "data.db");
+//	db.exec(conn, "INSERT INTO t VALUES (?, ?)", "a", 1);
+//	let rows = db.query(conn, "SELECT * FROM t WHERE n > ?", 0);
+//	let stmt = db.prepare(conn, "SELECT * FROM t WHERE name = ?");
+//	db.query(stmt, "a");
+//
+// Connections and prepared statements are EXTERNAL handles. query returns an
+// array of hashes keyed by column name, and exec a hash with the keys
+// rowsAffected and, if the driver supports it, lastInsertId.
+func init() {
+	Register("db", func() *object.Builtins {
+		return table(object.CapFile|object.CapNetwork, map[string]interface{}{
+			"open":    dbOpen,
+			"query":   dbQuery,
+			"exec":    dbExec,
+			"prepare": dbPrepare,
+			"close":   dbClose,
+		})
+	})
+}
+
+func dbOpen(driver, dsn string) (object.Object, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &object.External{Value: db}, nil
+}
+
+func dbPrepare(db *sql.DB, query string) (object.Object, error) {
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &object.External{Value: stmt}, nil
+}
+
+func dbClose(handle interface{}) error {
+	switch h := handle.(type) {
+	case *sql.DB:
+		return h.Close()
+	case *sql.Stmt:
+		return h.Close()
+	default:
+		return fmt.Errorf("cannot close %T", handle)
+	}
+}
+
+func dbQuery(handle interface{}, args ...interface{}) (object.Object, error) {
+	var rows *sql.Rows
+	var err error
+	switch h := handle.(type) {
+	case *sql.DB:
+		query, rest, qerr := splitQuery(args)
+		if qerr != nil {
+			return nil, qerr
+		}
+		rows, err = h.Query(query, rest...)
+	case *sql.Stmt:
+		rows, err = h.Query(args...)
+	default:
+		return nil, fmt.Errorf("cannot query %T", handle)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := object.Array{}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := object.NewHash(len(columns))
+		for i, name := range columns {
+			v, err := columnValue(values[i])
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", name, err)
+			}
+			row.Set(object.String(name), v)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func dbExec(handle interface{}, args ...interface{}) (object.Object, error) {
+	var res sql.Result
+	var err error
+	switch h := handle.(type) {
+	case *sql.DB:
+		query, rest, qerr := splitQuery(args)
+		if qerr != nil {
+			return nil, qerr
+		}
+		res, err = h.Exec(query, rest...)
+	case *sql.Stmt:
+		res, err = h.Exec(args...)
+	default:
+		return nil, fmt.Errorf("cannot exec %T", handle)
+	}
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	var result object.Hash
+	result.Set(object.String("rowsAffected"), object.Integer(affected))
+	if id, err := res.LastInsertId(); err == nil {
+		result.Set(object.String("lastInsertId"), object.Integer(id))
+	}
+	return result, nil
+}
+
+// splitQuery splits the arguments following a connection into the query
+// and its parameters.
+func splitQuery(args []interface{}) (string, []interface{}, error) {
+	if len(args) == 0 {
+		return "", nil, errors.New("missing query")
+	}
+	query, ok := args[0].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("query must be a string, got %T", args[0])
+	}
+	return query, args[1:], nil
+}
+
+// columnValue converts a value scanned from a column to an object.
+func columnValue(v interface{}) (object.Object, error) {
+	if t, ok := v.(time.Time); ok {
+		return object.String(t.Format(time.RFC3339Nano)), nil
+	}
+	return objconv.FromGo(v)
+}
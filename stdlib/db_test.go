@@ -0,0 +1,122 @@
+package stdlib
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// fakeDriver stores the arguments of every exec as a row of the columns
+// name and n, and answers every query with the rows stored so far whose name
+// equals the first query argument, if any.
+type fakeDriver struct{ rows [][]driver.Value }
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.Contains(query, "syntax error") {
+		return nil, errors.New("syntax error")
+	}
+	return fakeStmt{c.d}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("unsupported") }
+
+type fakeStmt struct{ d *fakeDriver }
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.rows = append(s.d.rows, args)
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var rows [][]driver.Value
+	for _, row := range s.d.rows {
+		if len(args) == 0 || row[0] == args[0] {
+			rows = append(rows, row)
+		}
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct{ rows [][]driver.Value }
+
+func (r *fakeRows) Columns() []string { return []string{"name", "n"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("monkeytest", &fakeDriver{})
+}
+
+func TestDB(t *testing.T) {
+	build, _ := Lookup("db")
+	b := build()
+	call := func(name string, args ...object.Object) object.Object {
+		fn, _ := b.Lookup(name)
+		return fn.Fn(args...)
+	}
+	if caps := b.Capabilities("open"); caps != object.CapFile|object.CapNetwork {
+		t.Errorf("wrong capabilities for open: %d", caps)
+	}
+
+	conn := call("open", object.String("monkeytest"), object.String(""))
+	if conn.Type() != object.EXTERNAL {
+		t.Fatalf("expected EXTERNAL connection, got %v", conn)
+	}
+	res := call("exec", conn, object.String("INSERT"), object.String("a"), object.Integer(1))
+	if !reflect.DeepEqual(res, object.HashOf(object.String("rowsAffected"), object.Integer(1))) {
+		t.Errorf("wrong exec result: %v", res)
+	}
+	stmt := call("prepare", conn, object.String("INSERT"))
+	call("exec", stmt, object.String("b"), object.Integer(2))
+
+	got := call("query", conn, object.String("SELECT"))
+	expected := &object.Array{
+		object.HashOf(object.String("name"), object.String("a"), object.String("n"), object.Integer(1)),
+		object.HashOf(object.String("name"), object.String("b"), object.String("n"), object.Integer(2)),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong query result. want=%v, got=%v", expected, got)
+	}
+	stmt = call("prepare", conn, object.String("SELECT"))
+	if got := call("query", stmt, object.String("b")); !reflect.DeepEqual(got, &object.Array{(*expected)[1]}) {
+		t.Errorf("wrong prepared query result: %v", got)
+	}
+
+	for _, tt := range []struct {
+		result object.Object
+		err    string
+	}{
+		{call("query", conn), "missing query"},
+		{call("query", object.Integer(1), object.String("SELECT")), "cannot query int64"},
+		{call("prepare", conn, object.String("syntax error")), "syntax error"},
+		{call("open", object.String("nope"), object.String("")), `sql: unknown driver "nope" (forgotten import?)`},
+		{call("close", stmt), ""},
+		{call("close", conn), ""},
+	} {
+		errObj, isErr := tt.result.(object.Error)
+		switch {
+		case tt.err == "" && isErr:
+			t.Errorf("unexpected error %v", errObj.Err)
+		case tt.err != "" && (!isErr || errObj.Err.Error() != tt.err):
+			t.Errorf("expected error %q, got %v", tt.err, tt.result)
+		}
+	}
+}
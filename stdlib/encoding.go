@@ -0,0 +1,30 @@
+package stdlib
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "encoding" module converts strings, which may hold arbitrary bytes, to
+// and from base64, hex and URL query encoding.
+func init() {
+	Register("encoding", func() *object.Builtins {
+		return table(0, map[string]interface{}{
+			"base64Encode": base64.StdEncoding.EncodeToString,
+			"base64Decode": func(s string) (string, error) {
+				b, err := base64.StdEncoding.DecodeString(s)
+				return string(b), err
+			},
+			"hexEncode": func(s string) string { return hex.EncodeToString([]byte(s)) },
+			"hexDecode": func(s string) (string, error) {
+				b, err := hex.DecodeString(s)
+				return string(b), err
+			},
+			"urlEncode": url.QueryEscape,
+			"urlDecode": url.QueryUnescape,
+		})
+	})
+}
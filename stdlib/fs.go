@@ -0,0 +1,57 @@
+package stdlib
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "fs" module works with the host's files: glob(pattern), stat(path),
+// mkdir(path), which creates any missing parents, and remove(path), which
+// removes a file or empty directory, all of which require CapFile; and
+// joinPath(parts...), basename(path) and dirname(path), which only
+// manipulate strings.
+func init() {
+	Register("fs", func() *object.Builtins {
+		b := table(object.CapFile, map[string]interface{}{
+			"glob":   filepath.Glob,
+			"stat":   stat,
+			"mkdir":  func(path string) error { return os.MkdirAll(path, 0o777) },
+			"remove": os.Remove,
+		})
+		paths := table(0, map[string]interface{}{
+			"joinPath": filepath.Join,
+			"basename": filepath.Base,
+			"dirname":  filepath.Dir,
+		})
+		for i, name := range paths.Names() {
+			b.Register(name, paths.At(i).Fn)
+		}
+		return b
+	})
+}
+
+// fileInfo is the hash returned by stat.
+type fileInfo struct {
+	Name    string `monkey:"name"`
+	Size    int64  `monkey:"size"`
+	Mode    string `monkey:"mode"`
+	ModTime string `monkey:"modTime"`
+	IsDir   bool   `monkey:"isDir"`
+}
+
+func stat(path string) (fileInfo, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	return fileInfo{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		Mode:    fi.Mode().String(),
+		ModTime: fi.ModTime().Format(time.RFC3339Nano),
+		IsDir:   fi.IsDir(),
+	}, nil
+}
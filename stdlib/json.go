@@ -0,0 +1,33 @@
+package stdlib
+
+import (
+	"encoding/json"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+)
+
+func init() {
+	Register("json", func() *object.Builtins {
+		return table(0, map[string]interface{}{
+			"parse":     jsonParse,
+			"stringify": jsonStringify,
+		})
+	})
+}
+
+// jsonParse parses src as a monkey literal with parser.ParseJSON, so that
+// JSON text means the same in a script as in a string passed to parse.
+func jsonParse(src string) (object.Object, error) {
+	expr, err := parser.ParseJSON(src)
+	if err != nil {
+		return nil, err
+	}
+	return evaluator.Eval(expr, object.NewEnvironment()), nil
+}
+
+func jsonStringify(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,73 @@
+package stdlib
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/ajwerner/monkey/objconv"
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "log" module writes leveled, structured records with info(msg),
+// warn(msg) and error(msg), each taking an optional hash of fields:
+//
+//	let log = import "log";
+//	log.info("order placed", {"id": 42, "total": 9.5});
+//
+// The module registered here logs to slog.Default(); hosts choose the
+// destination and format, such as JSON, with NewLogModule.
+func init() {
+	Register("log", NewLogModule(nil))
+}
+
+// NewLogModule returns a Builder for a "log" module which writes to l, or to
+// slog.Default() at the time of each call if l is nil.
+func NewLogModule(l *slog.Logger) Builder {
+	return func() *object.Builtins {
+		b := &object.Builtins{}
+		for _, level := range []struct {
+			name  string
+			level slog.Level
+		}{
+			{"info", slog.LevelInfo},
+			{"warn", slog.LevelWarn},
+			{"error", slog.LevelError},
+		} {
+			b.Register(level.name, logBuiltin(l, level.level))
+		}
+		return b
+	}
+}
+
+func logBuiltin(l *slog.Logger, level slog.Level) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 1 && len(args) != 2 {
+			return object.Error{Err: fmt.Errorf("wrong number of arguments. got=%d, want=1 or 2", len(args))}
+		}
+		msg, ok := args[0].(object.String)
+		if !ok {
+			return object.Error{Err: fmt.Errorf("message must be STRING, got %s", args[0].Type())}
+		}
+		var attrs []slog.Attr
+		if len(args) == 2 {
+			fields, ok := args[1].(object.Hash)
+			if !ok {
+				return object.Error{Err: fmt.Errorf("fields must be HASH, got %s", args[1].Type())}
+			}
+			for k, v := range fields.All() {
+				var value interface{}
+				if err := objconv.ToGo(v, &value); err != nil {
+					value = v.Inspect()
+				}
+				attrs = append(attrs, slog.Any(k.Inspect(), value))
+			}
+		}
+		logger := l
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.LogAttrs(context.Background(), level, string(msg), attrs...)
+		return object.Null{}
+	}
+}
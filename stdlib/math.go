@@ -0,0 +1,106 @@
+package stdlib
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "math" module follows the promotion rules of the arithmetic
+// operators: integer arguments give integer results and an integer mixed
+// with a float is converted to a float, so math.max(1, 2) is 2 and
+// math.max(1, 2.5) is 2.5. sqrt always returns a float, as / does, and pow
+// does unless both arguments are integers and the exponent is not negative.
+func init() {
+	Register("math", func() *object.Builtins {
+		b := &object.Builtins{}
+		b.Register("abs", unaryMath("abs", func(i object.Integer) object.Object {
+			if i < 0 {
+				return -i
+			}
+			return i
+		}, math.Abs))
+		b.Register("ceil", unaryMath("ceil", nil, math.Ceil))
+		b.Register("floor", unaryMath("floor", nil, math.Floor))
+		b.Register("round", unaryMath("round", nil, math.Round))
+		b.Register("sqrt", unaryMath("sqrt", func(i object.Integer) object.Object {
+			return object.Float(math.Sqrt(float64(i)))
+		}, math.Sqrt))
+		b.Register("max", binaryMath("max", func(x, y object.Integer) object.Object {
+			return max(x, y)
+		}, math.Max))
+		b.Register("min", binaryMath("min", func(x, y object.Integer) object.Object {
+			return min(x, y)
+		}, math.Min))
+		b.Register("pow", binaryMath("pow", func(x, y object.Integer) object.Object {
+			if y < 0 {
+				return object.Float(math.Pow(float64(x), float64(y)))
+			}
+			return powInt(x, y)
+		}, math.Pow))
+		return b
+	})
+}
+
+// unaryMath returns the builtin name of one number, which applies intFn to
+// an integer, or returns it unchanged if intFn is nil, and floatFn to a
+// float.
+func unaryMath(name string, intFn func(object.Integer) object.Object, floatFn func(float64) float64) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 1 {
+			return object.Error{Err: fmt.Errorf("wrong number of arguments. got=%d, want=1", len(args))}
+		}
+		switch x := args[0].(type) {
+		case object.Integer:
+			if intFn == nil {
+				return x
+			}
+			return intFn(x)
+		case object.Float:
+			return object.Float(floatFn(float64(x)))
+		default:
+			return object.Error{Err: fmt.Errorf("argument to `%s` must be INTEGER or FLOAT, got %s", name, args[0].Type())}
+		}
+	}
+}
+
+// binaryMath returns the builtin name of two numbers, which applies intFn
+// to two integers and floatFn to any other numbers, converted to floats.
+func binaryMath(name string, intFn func(a, b object.Integer) object.Object, floatFn func(a, b float64) float64) object.BuiltinFunction {
+	return func(args ...object.Object) object.Object {
+		if len(args) != 2 {
+			return object.Error{Err: fmt.Errorf("wrong number of arguments. got=%d, want=2", len(args))}
+		}
+		var fs [2]float64
+		ints := true
+		for i, arg := range args {
+			switch x := arg.(type) {
+			case object.Integer:
+				fs[i] = float64(x)
+			case object.Float:
+				fs[i] = float64(x)
+				ints = false
+			default:
+				return object.Error{Err: fmt.Errorf("argument %d to `%s` must be INTEGER or FLOAT, got %s", i+1, name, arg.Type())}
+			}
+		}
+		if ints {
+			return intFn(args[0].(object.Integer), args[1].(object.Integer))
+		}
+		return object.Float(floatFn(fs[0], fs[1]))
+	}
+}
+
+// powInt returns a to the power of the non-negative exponent b, wrapping
+// around on overflow as integer multiplication does.
+func powInt(a, b object.Integer) object.Integer {
+	result := object.Integer(1)
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			result *= a
+		}
+		a *= a
+	}
+	return result
+}
@@ -0,0 +1,178 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+)
+
+// PathEnv is the environment variable listing the directories, separated by
+// os.PathListSeparator, which EnvPath searches for source modules.
+const PathEnv = "MONKEY_PATH"
+
+// Ext is the file name extension of source modules, so that import "utils"
+// loads utils.monkey.
+const Ext = ".monkey"
+
+// Dir is a location searched for source modules.
+type Dir struct {
+	Name string // describes FS in errors, e.g. its directory
+	FS   fs.FS
+}
+
+// EnvPath returns the directories listed in $MONKEY_PATH, in order.
+func EnvPath() []Dir {
+	var dirs []Dir
+	for _, dir := range filepath.SplitList(os.Getenv(PathEnv)) {
+		if dir != "" {
+			dirs = append(dirs, Dir{Name: dir, FS: os.DirFS(dir)})
+		}
+	}
+	return dirs
+}
+
+// Resolver resolves the names of import expressions to modules. A name is
+// first looked up as a Go module and then as a source module: a file named
+// name+Ext in the first Dir of Path which holds one. A source module is
+// evaluated once, and its value is a hash of its top-level bindings.
+// Modules are cached after their first import. A Resolver is safe for
+// concurrent use.
+type Resolver struct {
+	// Lookup returns the builder of a Go module. Nil means the modules
+	// registered with this package.
+	Lookup func(name string) (Builder, bool)
+	// Prepare, if not nil, adjusts the builtins of each Go module, for
+	// example to restrict them.
+	Prepare func(b *object.Builtins)
+	// Builtins are available to source modules. Nil means the builtins of
+	// the program which imports the module, or the standard builtins
+	// adjusted by Prepare if it is imported without a BuiltinContext.
+	Builtins *object.Builtins
+	// Path lists the locations searched for source modules, in order.
+	Path []Dir
+
+	mu     sync.Mutex
+	loaded map[string]object.Object
+}
+
+// Import returns the module named name, evaluating a source module on ctx
+// if it is not nil. It is an object.Importer.
+func (r *Resolver) Import(ctx object.BuiltinContext, name string) (object.Object, error) {
+	return r.resolve(ctx, name, nil)
+}
+
+// resolve returns the module named name, imported by the source modules in
+// chain, outermost first.
+func (r *Resolver) resolve(ctx object.BuiltinContext, name string, chain []string) (object.Object, error) {
+	for _, imported := range chain {
+		if imported == name {
+			return nil, fmt.Errorf("import cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+	r.mu.Lock()
+	module, ok := r.loaded[name]
+	r.mu.Unlock()
+	if ok {
+		return module, nil
+	}
+
+	lookup := r.Lookup
+	if lookup == nil {
+		lookup = Lookup
+	}
+	var err error
+	if build, ok := lookup(name); ok {
+		b := build()
+		if r.Prepare != nil {
+			r.Prepare(b)
+		}
+		module = Module(b)
+	} else if module, err = r.loadSource(ctx, name, chain); err != nil {
+		return nil, err
+	}
+
+	// Source modules are evaluated without the lock held, so that they may
+	// import others, and a concurrent import may have finished first.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if loaded, ok := r.loaded[name]; ok {
+		return loaded, nil
+	}
+	if r.loaded == nil {
+		r.loaded = map[string]object.Object{}
+	}
+	r.loaded[name] = module
+	return module, nil
+}
+
+// loadSource finds and evaluates the source module named name on ctx, so
+// that the module is bound by the context, limits and policies of the
+// program importing it, or on an Evaluator of its own if ctx is nil.
+func (r *Resolver) loadSource(ctx object.BuiltinContext, name string, chain []string) (object.Object, error) {
+	file := name + Ext
+	if len(r.Path) == 0 || !fs.ValidPath(file) {
+		return nil, fmt.Errorf("no module named %q", name)
+	}
+	var src []byte
+	var where string
+	searched := make([]string, 0, len(r.Path))
+	for _, dir := range r.Path {
+		where = path.Join(dir.Name, file)
+		var err error
+		src, err = fs.ReadFile(dir.FS, file)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", where, err)
+		}
+		searched = append(searched, where)
+	}
+	if len(searched) == len(r.Path) {
+		return nil, fmt.Errorf("no module named %q, searched: %s", name, strings.Join(searched, ", "))
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", where, errs[0])
+	}
+	builtins := r.Builtins
+	if builtins == nil && ctx == nil {
+		builtins = object.NewBuiltins()
+		if r.Prepare != nil {
+			r.Prepare(builtins)
+		}
+	}
+	chain = append(chain[:len(chain):len(chain)], name)
+	importer := func(ctx object.BuiltinContext, name string) (object.Object, error) {
+		return r.resolve(ctx, name, chain)
+	}
+	env := object.NewEnvironment()
+	var result object.Object
+	if ctx != nil {
+		result = ctx.EvalModule(program, env, builtins, importer)
+	} else {
+		e := evaluator.Evaluator{Builtins: builtins, Importer: importer}
+		result = e.Eval(program, env)
+	}
+	if result, ok := result.(object.Error); ok {
+		return nil, fmt.Errorf("%s: %w", where, result.Err)
+	}
+	module := object.Hash{}
+	for _, binding := range env.Names() {
+		v, _ := env.Get(binding)
+		module.Set(object.String(binding), v)
+	}
+	return module, nil
+}
@@ -0,0 +1,108 @@
+// Package stdlib is a registry of modules: named groups of builtins which
+// scripts load with an import expression.
+//
+//	let strings = import "strings";
+//	strings.upper("monkey")
+//
+// Packages providing modules register them from an init function, as this
+// package does for "strings" and "math":
+//
+//	func init() {
+//		stdlib.Register("geo", func() *object.Builtins { ... })
+//	}
+package stdlib
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ajwerner/monkey/objconv"
+	"github.com/ajwerner/monkey/object"
+)
+
+// Builder returns a new table holding the builtins of a module. Interpreters
+// call it the first time a module is imported and may modify the table, for
+// example to remove builtins requiring denied capabilities.
+type Builder func() *object.Builtins
+
+var (
+	mu       sync.RWMutex
+	builders = map[string]Builder{}
+)
+
+// Register makes the module built by builder importable as name. It panics
+// if builder is nil or a module named name is already registered.
+func Register(name string, builder Builder) {
+	mu.Lock()
+	defer mu.Unlock()
+	if builder == nil {
+		panic("stdlib: Register builder is nil")
+	}
+	if _, dup := builders[name]; dup {
+		panic(fmt.Sprintf("stdlib: Register called twice for module %q", name))
+	}
+	builders[name] = builder
+}
+
+// Lookup returns the builder of the module registered as name.
+func Lookup(name string) (Builder, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	builder, ok := builders[name]
+	return builder, ok
+}
+
+// Names returns the names of the registered modules in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Module returns the builtins in b as the value of an import expression: a
+// Hash from each name to its builtin.
+func Module(b *object.Builtins) object.Hash {
+	module := object.NewHash(b.Len())
+	for i, name := range b.Names() {
+		module.Set(object.String(name), b.At(i))
+	}
+	return module
+}
+
+// NewImporter returns an Importer which loads registered modules, removing
+// the builtins which require any of the denied capabilities, and then source
+// modules found on path, which see the builtins of the program importing
+// them. Each module is loaded once, on its first import.
+// The Importer is safe for concurrent use.
+func NewImporter(denied object.Capability, path ...Dir) object.Importer {
+	r := &Resolver{
+		Prepare: func(b *object.Builtins) { b.Restrict(denied) },
+		Path:    path,
+	}
+	return r.Import
+}
+
+// table returns a table of the Go functions in fns, converted with
+// objconv.Func, each requiring caps.
+func table(caps object.Capability, fns map[string]interface{}) *object.Builtins {
+	b := &object.Builtins{}
+	names := make([]string, 0, len(fns))
+	for name := range fns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fn, err := objconv.Func(fns[name])
+		if err != nil {
+			panic(fmt.Sprintf("stdlib: %s: %v", name, err))
+		}
+		b.RegisterWithCapabilities(name, caps, fn)
+	}
+	return b
+}
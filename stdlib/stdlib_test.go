@@ -0,0 +1,420 @@
+package stdlib
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ajwerner/monkey/evaluator"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/sandbox"
+)
+
+func TestRegister(t *testing.T) {
+	Register("test", func() *object.Builtins { return &object.Builtins{} })
+	if _, ok := Lookup("test"); !ok {
+		t.Fatal("registered module not found")
+	}
+	expected := []string{"compress", "crypto", "db", "encoding", "fs", "json", "log", "math", "strings", "template", "test", "url", "ws"}
+	if got := Names(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong names. want=%v, got=%v", expected, got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Register to panic on a duplicate name")
+		}
+	}()
+	Register("test", func() *object.Builtins { return &object.Builtins{} })
+}
+
+// hashField returns the value of the key name in h, or nil if it has none.
+func hashField(h object.Hash, name string) object.Object {
+	v, _ := h.Get(object.String(name))
+	return v
+}
+
+func TestModules(t *testing.T) {
+	tests := []struct {
+		module   string
+		fn       string
+		args     []object.Object
+		expected object.Object
+	}{
+		{"strings", "upper", []object.Object{object.String("abc")}, object.String("ABC")},
+		{"strings", "contains", []object.Object{object.String("abc"), object.String("b")}, object.Bool(true)},
+		{"strings", "index", []object.Object{object.String("abc"), object.String("c")}, object.Integer(2)},
+		{"math", "sqrt", []object.Object{object.Integer(16)}, object.Float(4)},
+		{"math", "max", []object.Object{object.Float(1.5), object.Integer(2)}, object.Float(2)},
+		{"math", "max", []object.Object{object.Integer(1), object.Integer(2)}, object.Integer(2)},
+		{"math", "min", []object.Object{object.Integer(-1), object.Float(2)}, object.Float(-1)},
+		{"math", "abs", []object.Object{object.Integer(-3)}, object.Integer(3)},
+		{"math", "abs", []object.Object{object.Float(-2.5)}, object.Float(2.5)},
+		{"math", "floor", []object.Object{object.Integer(7)}, object.Integer(7)},
+		{"math", "floor", []object.Object{object.Float(-2.5)}, object.Float(-3)},
+		{"math", "ceil", []object.Object{object.Float(1.2)}, object.Float(2)},
+		{"math", "round", []object.Object{object.Float(2.5)}, object.Float(3)},
+		{"math", "pow", []object.Object{object.Integer(3), object.Integer(4)}, object.Integer(81)},
+		{"math", "pow", []object.Object{object.Integer(2), object.Integer(-1)}, object.Float(0.5)},
+		{"math", "pow", []object.Object{object.Float(4), object.Float(0.5)}, object.Float(2)},
+		{"crypto", "sha256", []object.Object{object.String("abc")}, object.String("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")},
+		{"crypto", "sha1", []object.Object{object.String("abc")}, object.String("a9993e364706816aba3e25717850c26c9cd0d89d")},
+		{"crypto", "md5", []object.Object{object.String("abc")}, object.String("900150983cd24fb0d6963f7d28e17f72")},
+		{"crypto", "hmac", []object.Object{object.String("key"), object.String("The quick brown fox jumps over the lazy dog"), object.String("sha256")}, object.String("f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8")},
+		{"encoding", "base64Encode", []object.Object{object.String("hi\x00")}, object.String("aGkA")},
+		{"encoding", "base64Decode", []object.Object{object.String("aGkA")}, object.String("hi\x00")},
+		{"encoding", "hexEncode", []object.Object{object.String("hi")}, object.String("6869")},
+		{"encoding", "hexDecode", []object.Object{object.String("6869")}, object.String("hi")},
+		{"encoding", "urlEncode", []object.Object{object.String("a b&c")}, object.String("a+b%26c")},
+		{"encoding", "urlDecode", []object.Object{object.String("a+b%26c")}, object.String("a b&c")},
+		{"json", "parse", []object.Object{object.String(`-1.5`)}, object.Float(-1.5)},
+		{"json", "stringify", []object.Object{&object.Array{object.Integer(1), object.Null{}, object.String("a")}}, object.String(`[1,null,"a"]`)},
+	}
+	for _, tt := range tests {
+		build, ok := Lookup(tt.module)
+		if !ok {
+			t.Fatalf("no module %s", tt.module)
+		}
+		fn, ok := hashField(Module(build()), tt.fn).(*object.Builtin)
+		if !ok {
+			t.Fatalf("no builtin %s.%s", tt.module, tt.fn)
+		}
+		if got := fn.Fn(tt.args...); got != tt.expected {
+			t.Errorf("%s.%s: wrong result. want=%v, got=%v", tt.module, tt.fn, tt.expected, got)
+		}
+	}
+
+	build, _ := Lookup("crypto")
+	hmacFn := hashField(Module(build()), "hmac").(*object.Builtin)
+	if got := hmacFn.Fn(object.String("k"), object.String("m"), object.String("sha3")); got.Type() != object.ERROR {
+		t.Errorf("crypto.hmac: expected error for an unknown hash, got %v", got)
+	}
+
+	build, _ = Lookup("encoding")
+	for _, name := range []string{"base64Decode", "hexDecode", "urlDecode"} {
+		fn := hashField(Module(build()), name).(*object.Builtin)
+		if got := fn.Fn(object.String("%zz")); got.Type() != object.ERROR {
+			t.Errorf("encoding.%s: expected error for invalid input, got %v", name, got)
+		}
+	}
+
+	build, _ = Lookup("strings")
+	fn := hashField(Module(build()), "split").(*object.Builtin)
+	got := fn.Fn(object.String("a,b"), object.String(","))
+	expected := &object.Array{object.String("a"), object.String("b")}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("strings.split: wrong result. want=%v, got=%v", expected, got)
+	}
+}
+
+func TestURL(t *testing.T) {
+	build, _ := Lookup("url")
+	m := Module(build())
+	parse := hashField(m, "parse").(*object.Builtin)
+	buildURL := hashField(m, "build").(*object.Builtin)
+
+	parts := parse.Fn(object.String("https://example.com:8080/a%20b?q=monkey&q=ape#top"))
+	expected := object.HashOf(
+		object.String("scheme"), object.String("https"),
+		object.String("host"), object.String("example.com:8080"),
+		object.String("path"), object.String("/a b"),
+		object.String("query"), object.HashOf(object.String("q"), object.String("monkey")),
+		object.String("fragment"), object.String("top"),
+	)
+	if !reflect.DeepEqual(parts, expected) {
+		t.Fatalf("wrong parse result. want=%v, got=%v", expected, parts)
+	}
+	if got := buildURL.Fn(parts); got != object.String("https://example.com:8080/a%20b?q=monkey#top") {
+		t.Errorf("wrong build result: %v", got)
+	}
+	got := buildURL.Fn(object.HashOf(object.String("scheme"), object.String("http"), object.String("host"), object.String("h")))
+	if got != object.String("http://h") {
+		t.Errorf("wrong build result for a partial hash: %v", got)
+	}
+	if got := parse.Fn(object.String("http://[::1")); got.Type() != object.ERROR {
+		t.Errorf("expected error for an invalid URL, got %v", got)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	build, _ := Lookup("compress")
+	m := Module(build())
+	call := func(name string, args ...object.Object) object.Object {
+		return hashField(m, name).(*object.Builtin).Fn(args...)
+	}
+
+	text := object.String(strings.Repeat("monkey\n", 100))
+	gz := call("gzipCompress", text)
+	if gz.Type() != object.STRING || len(gz.(object.String)) >= len(text) {
+		t.Fatalf("wrong gzipCompress result: %v", gz)
+	}
+	if got := call("gzipDecompress", gz); got != text {
+		t.Errorf("gzipDecompress did not round trip: %.20v", got)
+	}
+	if got := call("gzipDecompress", text); got.Type() != object.ERROR {
+		t.Errorf("expected error decompressing plain text, got %.20v", got)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"a.log", "dir/b.log"} {
+		f, _ := w.Create(name)
+		io.WriteString(f, "contents of "+name)
+	}
+	w.Close()
+	archive := object.String(buf.String())
+	expected := &object.Array{object.String("a.log"), object.String("dir/b.log")}
+	if got := call("zipList", archive); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong zipList result. want=%v, got=%v", expected, got)
+	}
+	if got := call("zipRead", archive, object.String("dir/b.log")); got != object.String("contents of dir/b.log") {
+		t.Errorf("wrong zipRead result: %v", got)
+	}
+	if got := call("zipRead", archive, object.String("c.log")); got.Type() != object.ERROR {
+		t.Errorf("expected error reading a missing file, got %v", got)
+	}
+}
+
+func TestJSONParse(t *testing.T) {
+	got, err := jsonParse(`{"a": [1, true, null], "b": {"c": "d"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := object.HashOf(
+		object.String("a"), &object.Array{object.Integer(1), object.Bool(true), object.Null{}},
+		object.String("b"), object.HashOf(object.String("c"), object.String("d")),
+	)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong result. want=%v, got=%v", expected, got)
+	}
+	got, err = jsonParse(`{"a": "x\/y\b\f\ud83d\ude00"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := object.HashOf(object.String("a"), object.String("x/y\b\f\U0001F600")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong result. want=%v, got=%v", expected, got)
+	}
+	if _, err := jsonParse(`[1, x]`); err == nil || err.Error() != "line 1: not a JSON value: x" {
+		t.Errorf("expected error for an identifier, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := object.HashOf(
+		object.String("name"), object.String("monkey"),
+		object.String("items"), &object.Array{
+			object.HashOf(object.String("title"), object.String("eat")),
+			object.HashOf(object.String("title"), object.String("sleep")),
+		},
+		object.String("none"), &object.Array{},
+	)
+	tests := []struct {
+		template string
+		expected string
+		err      string
+	}{
+		{"Hello {{ name }}! {{ 1 + 2 }} {not a tag}", "Hello monkey! 3 {not a tag}", ""},
+		{"{% for item in items %}- {{ item.title }}\n{% endfor %}", "- eat\n- sleep\n", ""},
+		{"{% if len(none) > 0 %}some{% else %}none{% endif %}", "none", ""},
+		{"{% if name %}{% for i in [1, 2] %}{{ i }}{% endfor %}{% endif %}", "12", ""},
+		{"a\n{{ missing }}", "", "template: line 2: identifier not found: missing"},
+		{"{% if true %}\nunclosed", "", "template: line 2: {% if %} on line 1 is not closed by {% endif %}"},
+		{"{% endfor %}", "", "template: line 1: unexpected {% endfor %}"},
+		{"{% while x %}", "", "template: line 1: unknown tag {% while %}"},
+		{"{{ name", "", "template: line 1: unclosed {{"},
+		{"{% for x in name %}{% endfor %}", "", "template: line 1: cannot range over STRING"},
+		{"{{ let x = 1; }}", "", `template: line 1: expected an expression, got "let x = 1;"`},
+	}
+	build, _ := Lookup("template")
+	fn, _ := build().Lookup("render")
+	var e evaluator.Evaluator
+	for _, tt := range tests {
+		got := e.Apply(fn, object.String(tt.template), data)
+		errObj, isErr := got.(object.Error)
+		switch {
+		case tt.err != "":
+			if !isErr || errObj.Err.Error() != tt.err {
+				t.Errorf("%q: expected error %q, got %v", tt.template, tt.err, got)
+			}
+		case isErr:
+			t.Errorf("%q: unexpected error %v", tt.template, errObj)
+		case got != object.String(tt.expected):
+			t.Errorf("%q: wrong output. want=%q, got=%v", tt.template, tt.expected, got)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	build, _ := Lookup("fs")
+	b := build()
+	call := func(name string, args ...object.Object) object.Object {
+		fn, _ := b.Lookup(name)
+		return fn.Fn(args...)
+	}
+	for _, name := range []string{"glob", "stat", "mkdir", "remove"} {
+		if b.Capabilities(name) != object.CapFile {
+			t.Errorf("%s does not require CapFile", name)
+		}
+	}
+	for _, name := range []string{"joinPath", "basename", "dirname"} {
+		if b.Capabilities(name) != 0 {
+			t.Errorf("%s requires capabilities", name)
+		}
+	}
+
+	dir := object.String(t.TempDir())
+	sub := call("joinPath", dir, object.String("a"), object.String("b"))
+	if got := call("mkdir", sub); got != (object.Null{}) {
+		t.Fatalf("mkdir failed: %v", got)
+	}
+	info, ok := call("stat", sub).(object.Hash)
+	if !ok || hashField(info, "isDir") != object.Bool(true) || hashField(info, "name") != object.String("b") {
+		t.Errorf("wrong stat result: %v", info)
+	}
+	matches := call("glob", call("joinPath", dir, object.String("*")))
+	if !reflect.DeepEqual(matches, &object.Array{call("joinPath", dir, object.String("a"))}) {
+		t.Errorf("wrong glob result: %v", matches)
+	}
+	if got := call("basename", sub); got != object.String("b") {
+		t.Errorf("wrong basename: %v", got)
+	}
+	if got := call("dirname", sub); got != call("joinPath", dir, object.String("a")) {
+		t.Errorf("wrong dirname: %v", got)
+	}
+	if got := call("remove", sub); got != (object.Null{}) {
+		t.Errorf("remove failed: %v", got)
+	}
+	if got := call("stat", sub); got.Type() != object.ERROR {
+		t.Errorf("expected error from stat of a removed directory, got %v", got)
+	}
+}
+
+func TestNewImporter(t *testing.T) {
+	importer := NewImporter(object.CapFile)
+	fs, err := importer(nil, "fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := fs.(object.Hash)
+	if _, ok := module.Get(object.String("stat")); ok {
+		t.Error("expected stat to be removed")
+	}
+	if _, ok := module.Get(object.String("basename")); !ok {
+		t.Error("expected basename to remain")
+	}
+	if again, _ := importer(nil, "fs"); !reflect.DeepEqual(again, fs) {
+		t.Error("expected the module to be cached")
+	}
+	if _, err := importer(nil, "nope"); err == nil || err.Error() != `no module named "nope"` {
+		t.Errorf("expected no module error, got %v", err)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "env.monkey"), []byte(`let x = 1;`), 0o644)
+	t.Setenv(PathEnv, dir)
+	lib := fstest.MapFS{
+		"twice.monkey":  {Data: []byte(`let u = import "util"; let twice = fn(x) { u.double(u.double(x)) };`)},
+		"util.monkey":   {Data: []byte(`let double = fn(x) { x * 2 };`)},
+		"a.monkey":      {Data: []byte(`let b = import "b";`)},
+		"b.monkey":      {Data: []byte(`let a = import "a";`)},
+		"broken.monkey": {Data: []byte(`let = 1;`)},
+		"fails.monkey":  {Data: []byte(`let x = 1 + "a";`)},
+		"spin.monkey":   {Data: []byte(`while (true) {}`)},
+		"shout.monkey":  {Data: []byte(`let a = upper("a");`)},
+	}
+	importer := NewImporter(object.CapFile, append([]Dir{{Name: "lib", FS: lib}}, EnvPath()...)...)
+
+	twice, err := importer(nil, "twice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := hashField(twice.(object.Hash), "twice")
+	var e evaluator.Evaluator
+	if got := e.Apply(fn, object.Integer(3)); got != object.Integer(12) {
+		t.Errorf("wrong result from twice: %v", got)
+	}
+	if again, _ := importer(nil, "twice"); !reflect.DeepEqual(again, twice) {
+		t.Error("expected the module to be cached")
+	}
+	if env, err := importer(nil, "env"); err != nil || hashField(env.(object.Hash), "x") != object.Integer(1) {
+		t.Errorf("expected a module from $%s, got %v, %v", PathEnv, env, err)
+	}
+
+	errs := map[string]string{
+		"a":      `import cycle: a -> b -> a`,
+		"broken": `lib/broken.monkey: line 1: expected next token to be IDENT, got = instead`,
+		"fails":  `lib/fails.monkey: line 1: type mismatch: INTEGER + STRING`,
+		"nope":   `no module named "nope", searched: lib/nope.monkey, ` + filepath.Join(dir, "nope.monkey"),
+		"../x":   `no module named "../x"`,
+	}
+	for name, expected := range errs {
+		if _, err := importer(nil, name); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %v", name, expected, err)
+		}
+	}
+
+	// A source module is evaluated with the limits and context of the
+	// program importing it.
+	program := parser.New(lexer.New(`import "spin"`)).ParseProgram()
+	limited := evaluator.Evaluator{Importer: importer, Limits: sandbox.Limits{MaxSteps: 1000}}
+	if got, ok := limited.Eval(program, object.NewEnvironment()).(object.Error); !ok || !errors.Is(got.Err, sandbox.ErrStepLimit) {
+		t.Errorf("expected the step limit to stop spin, got %v", got)
+	}
+	// It sees the builtins of the program importing it.
+	restricted := evaluator.Evaluator{Importer: importer, Builtins: object.NewBuiltins()}
+	restricted.Builtins.Remove("upper")
+	program = parser.New(lexer.New(`import "shout"`)).ParseProgram()
+	if got, ok := restricted.Eval(program, object.NewEnvironment()).(object.Error); !ok || !strings.Contains(got.Err.Error(), "identifier not found: upper") {
+		t.Errorf("expected upper to be unavailable to shout, got %v", got)
+	}
+	program = parser.New(lexer.New(`import "spin"`)).ParseProgram()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	unlimited := evaluator.Evaluator{Importer: importer}
+	if got, ok := unlimited.EvalContext(ctx, program, object.NewEnvironment()).(object.Error); !ok || !errors.Is(got.Err, context.DeadlineExceeded) {
+		t.Errorf("expected the context to stop spin, got %v", got)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var out strings.Builder
+	handler := slog.NewJSONHandler(&out, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	b := NewLogModule(slog.New(handler))()
+	info, _ := b.Lookup("info")
+	warn, _ := b.Lookup("warn")
+	info.Fn(object.String("started"))
+	warn.Fn(object.String("slow"), object.HashOf(
+		object.String("ms"), object.Integer(1500),
+		object.String("path"), object.String("/a"),
+		object.String("tags"), &object.Array{object.String("x")},
+	))
+	expected := `{"level":"INFO","msg":"started"}
+{"level":"WARN","msg":"slow","ms":1500,"path":"/a","tags":["x"]}
+`
+	if out.String() != expected {
+		t.Errorf("wrong output. want=%q, got=%q", expected, out.String())
+	}
+	if got := info.Fn(object.Integer(1)); got.Type() != object.ERROR {
+		t.Errorf("expected error for a non-string message, got %v", got)
+	}
+}
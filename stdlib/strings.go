@@ -0,0 +1,25 @@
+package stdlib
+
+import (
+	"strings"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+func init() {
+	Register("strings", func() *object.Builtins {
+		return table(0, map[string]interface{}{
+			"contains":  strings.Contains,
+			"hasPrefix": strings.HasPrefix,
+			"hasSuffix": strings.HasSuffix,
+			"index":     strings.Index,
+			"join":      strings.Join,
+			"lower":     strings.ToLower,
+			"repeat":    strings.Repeat,
+			"replace":   strings.ReplaceAll,
+			"split":     strings.Split,
+			"trim":      strings.TrimSpace,
+			"upper":     strings.ToUpper,
+		})
+	})
+}
@@ -0,0 +1,298 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+)
+
+// The "template" module's render(template, data) fills in a text template.
+// The keys of the data hash are variables in the template's expressions,
+// which are monkey expressions evaluated with the builtins, limits and
+// truthiness of the program calling render:
+//
+//	Hello {{ name }}!
+//	{% if len(items) > 0 %}{% for item in items %}- {{ item.title }}
+//	{% endfor %}{% else %}nothing to do{% endif %}
+//
+// {{ expr }} inserts the value of expr, strings verbatim and other values as
+// they are printed by puts; no escaping is done. {% if %} takes an optional
+// {% else %}, and {% for name in expr %} repeats its body for each element
+// of an array.
+func init() {
+	Register("template", func() *object.Builtins {
+		b := &object.Builtins{}
+		b.RegisterContext("render", 0, renderBuiltin)
+		return b
+	})
+}
+
+func renderBuiltin(ctx object.BuiltinContext, args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return object.Error{Err: fmt.Errorf("wrong number of arguments. got=%d, want=2", len(args))}
+	}
+	src, ok := args[0].(object.String)
+	if !ok {
+		return object.Error{Err: fmt.Errorf("argument 1 to `render` must be STRING, got %s", args[0].Type())}
+	}
+	data, ok := args[1].(object.Hash)
+	if !ok {
+		return object.Error{Err: fmt.Errorf("argument 2 to `render` must be HASH, got %s", args[1].Type())}
+	}
+	out, err := render(ctx, string(src), data)
+	if err != nil {
+		return object.Error{Err: err}
+	}
+	return object.String(out)
+}
+
+func render(ctx object.BuiltinContext, src string, data object.Hash) (string, error) {
+	nodes, err := parseTemplate(src)
+	if err != nil {
+		return "", err
+	}
+	env := object.NewEnvironment()
+	for k, v := range data.All() {
+		name, ok := k.(object.String)
+		if !ok {
+			return "", fmt.Errorf("data keys must be STRING, got %s", k.Type())
+		}
+		env.Set(string(name), v)
+	}
+	var out strings.Builder
+	if err := executeTemplate(ctx, &out, nodes, env); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// templateNode is one of templateText, templateExpr, templateIf and
+// templateFor.
+type templateNode interface{}
+
+type templateText string
+
+type templateExpr struct {
+	line int
+	expr ast.Expression
+}
+
+type templateIf struct {
+	cond      templateExpr
+	then, els []templateNode
+}
+
+type templateFor struct {
+	name string
+	iter templateExpr
+	body []templateNode
+}
+
+// templateParser parses a template into nodes.
+type templateParser struct {
+	src  string
+	line int
+}
+
+func parseTemplate(src string) ([]templateNode, error) {
+	p := &templateParser{src: src, line: 1}
+	nodes, end, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	if end != "" {
+		return nil, p.errorf("unexpected {%% %s %%}", end)
+	}
+	return nodes, nil
+}
+
+// parseNodes parses nodes until the end of the template or a tag which
+// closes a block, which it returns.
+func (p *templateParser) parseNodes() (nodes []templateNode, end string, err error) {
+	for p.src != "" {
+		i := nextDelim(p.src)
+		if i < 0 {
+			nodes = append(nodes, templateText(p.src))
+			p.advance(len(p.src))
+			break
+		}
+		if i > 0 {
+			nodes = append(nodes, templateText(p.src[:i]))
+			p.advance(i)
+		}
+		isTag := p.src[1] == '%'
+		closing := "}}"
+		if isTag {
+			closing = "%}"
+		}
+		j := strings.Index(p.src, closing)
+		if j < 0 {
+			return nil, "", p.errorf("unclosed %s", p.src[:2])
+		}
+		body := strings.TrimSpace(p.src[2:j])
+		line := p.line
+		p.advance(j + 2)
+		if !isTag {
+			expr, err := p.parseExpr(line, body)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, expr)
+			continue
+		}
+		keyword, rest, _ := strings.Cut(body, " ")
+		switch keyword {
+		case "if":
+			node, err := p.parseIf(line, rest)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, node)
+		case "for":
+			node, err := p.parseFor(line, rest)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, node)
+		case "else", "endif", "endfor":
+			return nodes, keyword, nil
+		default:
+			return nil, "", p.errorf("unknown tag {%% %s %%}", keyword)
+		}
+	}
+	return nodes, "", nil
+}
+
+func (p *templateParser) parseIf(line int, cond string) (templateNode, error) {
+	expr, err := p.parseExpr(line, cond)
+	if err != nil {
+		return nil, err
+	}
+	node := templateIf{cond: expr}
+	var end string
+	if node.then, end, err = p.parseNodes(); err != nil {
+		return nil, err
+	}
+	if end == "else" {
+		if node.els, end, err = p.parseNodes(); err != nil {
+			return nil, err
+		}
+	}
+	if end != "endif" {
+		return nil, p.errorf("{%% if %%} on line %d is not closed by {%% endif %%}", line)
+	}
+	return node, nil
+}
+
+func (p *templateParser) parseFor(line int, clause string) (templateNode, error) {
+	name, iter, ok := strings.Cut(clause, " in ")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t\n") {
+		return nil, p.errorf("expected {%% for name in expression %%}")
+	}
+	expr, err := p.parseExpr(line, iter)
+	if err != nil {
+		return nil, err
+	}
+	node := templateFor{name: name, iter: expr}
+	var end string
+	if node.body, end, err = p.parseNodes(); err != nil {
+		return nil, err
+	}
+	if end != "endfor" {
+		return nil, p.errorf("{%% for %%} on line %d is not closed by {%% endfor %%}", line)
+	}
+	return node, nil
+}
+
+func (p *templateParser) parseExpr(line int, src string) (templateExpr, error) {
+	mp := parser.New(lexer.New(src))
+	program := mp.ParseProgram()
+	if errs := mp.Errors(); len(errs) != 0 {
+		return templateExpr{}, fmt.Errorf("template: line %d: %w", line, errors.Join(errs...))
+	}
+	if len(program.Statements) != 1 {
+		return templateExpr{}, fmt.Errorf("template: line %d: expected an expression, got %q", line, src)
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		return templateExpr{}, fmt.Errorf("template: line %d: expected an expression, got %q", line, src)
+	}
+	return templateExpr{line: line, expr: stmt.Expression}, nil
+}
+
+// nextDelim returns the index of the first "{{" or "{%" in s, or -1.
+func nextDelim(s string) int {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] == '{' && (s[i+1] == '{' || s[i+1] == '%') {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *templateParser) advance(n int) {
+	p.line += strings.Count(p.src[:n], "\n")
+	p.src = p.src[n:]
+}
+
+func (p *templateParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("template: line %d: %s", p.line, fmt.Sprintf(format, a...))
+}
+
+func executeTemplate(ctx object.BuiltinContext, out *strings.Builder, nodes []templateNode, env *object.Environment) error {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case templateText:
+			out.WriteString(string(node))
+		case templateExpr:
+			v, err := node.eval(ctx, env)
+			if err != nil {
+				return err
+			}
+			out.WriteString(v.Inspect())
+		case templateIf:
+			v, err := node.cond.eval(ctx, env)
+			if err != nil {
+				return err
+			}
+			branch := node.els
+			if ctx.Truthiness().IsTruthy(v) {
+				branch = node.then
+			}
+			if err := executeTemplate(ctx, out, branch, env); err != nil {
+				return err
+			}
+		case templateFor:
+			v, err := node.iter.eval(ctx, env)
+			if err != nil {
+				return err
+			}
+			arr, ok := v.(*object.Array)
+			if !ok {
+				return fmt.Errorf("template: line %d: cannot range over %s", node.iter.line, v.Type())
+			}
+			for _, el := range *arr {
+				loopEnv := object.NewEnclosedEnvironment(env)
+				loopEnv.Set(node.name, el)
+				if err := executeTemplate(ctx, out, node.body, loopEnv); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (e templateExpr) eval(ctx object.BuiltinContext, env *object.Environment) (object.Object, error) {
+	v := ctx.Eval(e.expr, env)
+	if errObj, ok := v.(object.Error); ok {
+		return nil, fmt.Errorf("template: line %d: %w", e.line, errObj.Err)
+	}
+	return v, nil
+}
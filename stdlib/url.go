@@ -0,0 +1,61 @@
+package stdlib
+
+import (
+	"net/url"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "url" module splits URLs into their parts and puts them back together.
+// parse(s) returns a hash with the keys scheme, host, path, query and
+// fragment, where query maps each parameter to its first value, as in the
+// requests of the "http" module, and build(parts) is its inverse.
+func init() {
+	Register("url", func() *object.Builtins {
+		return table(0, map[string]interface{}{
+			"parse": urlParse,
+			"build": urlBuild,
+		})
+	})
+}
+
+type urlParts struct {
+	Scheme   string            `monkey:"scheme"`
+	Host     string            `monkey:"host"`
+	Path     string            `monkey:"path"`
+	Query    map[string]string `monkey:"query"`
+	Fragment string            `monkey:"fragment"`
+}
+
+func urlParse(s string) (urlParts, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return urlParts{}, err
+	}
+	query := map[string]string{}
+	for name, values := range u.Query() {
+		query[name] = values[0]
+	}
+	return urlParts{
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+		Path:     u.Path,
+		Query:    query,
+		Fragment: u.Fragment,
+	}, nil
+}
+
+func urlBuild(p urlParts) string {
+	query := url.Values{}
+	for name, value := range p.Query {
+		query.Set(name, value)
+	}
+	u := url.URL{
+		Scheme:   p.Scheme,
+		Host:     p.Host,
+		Path:     p.Path,
+		RawQuery: query.Encode(),
+		Fragment: p.Fragment,
+	}
+	return u.String()
+}
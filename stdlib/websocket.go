@@ -0,0 +1,351 @@
+package stdlib
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// The "ws" module is a WebSocket client: connect(url) opens a connection to
+// a ws:// or wss:// URL as an EXTERNAL handle, send(conn, msg) sends a text
+// message, recv(conn) waits for the next message and returns it, or NULL
+// once the server has closed the connection, and close(conn) closes it.
+// Pings are answered automatically. connect and recv give up when the
+// program's context is canceled or its deadline passes.
+func init() {
+	Register("ws", func() *object.Builtins {
+		b := table(object.CapNetwork, map[string]interface{}{
+			"send":  func(c *wsConn, msg string) error { return c.writeFrame(wsText, []byte(msg)) },
+			"close": (*wsConn).Close,
+		})
+		b.RegisterContext("connect", object.CapNetwork, wsConnectBuiltin)
+		b.RegisterContext("recv", object.CapNetwork, wsRecvBuiltin)
+		return b
+	})
+}
+
+func wsConnectBuiltin(ctx object.BuiltinContext, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return object.Error{Err: fmt.Errorf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	rawURL, ok := args[0].(object.String)
+	if !ok {
+		return object.Error{Err: fmt.Errorf("argument to `connect` must be STRING, got %s", args[0].Type())}
+	}
+	conn, err := wsConnect(ctx.Context(), string(rawURL))
+	if err != nil {
+		return object.Error{Err: err}
+	}
+	return conn
+}
+
+func wsRecvBuiltin(ctx object.BuiltinContext, args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return object.Error{Err: fmt.Errorf("wrong number of arguments. got=%d, want=1", len(args))}
+	}
+	var c *wsConn
+	if ext, ok := args[0].(*object.External); ok {
+		c, _ = ext.Value.(*wsConn)
+	}
+	if c == nil {
+		return object.Error{Err: fmt.Errorf("argument to `recv` must be a WebSocket connection, got %s", args[0].Type())}
+	}
+	msg, err := wsRecv(ctx.Context(), c)
+	if err != nil {
+		return object.Error{Err: err}
+	}
+	return msg
+}
+
+// WebSocket opcodes, from RFC 6455.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// wsGUID is appended to the handshake key to compute the accept header.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSMessage bounds the size of a received message.
+const maxWSMessage = 16 << 20
+
+// wsConn is one end of a WebSocket connection. Clients mask the frames they
+// send; servers do not.
+type wsConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	client bool
+
+	mu     sync.Mutex // serializes writes
+	closed bool
+}
+
+func wsConnect(ctx context.Context, rawURL string) (object.Object, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		d := &tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}
+		conn, err = d.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c *wsConn
+	err = withDeadline(ctx, conn.SetDeadline, func() (err error) {
+		c, err = wsHandshake(conn, u)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &object.External{Value: c}, nil
+}
+
+// wsHandshake upgrades conn to a WebSocket connection to u.
+func wsHandshake(conn net.Conn, u *url.URL) (*wsConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.EscapedPath(), RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("handshake failed: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return nil, errors.New("handshake failed: bad Sec-WebSocket-Accept")
+	}
+	return &wsConn{conn: conn, r: r, client: true}, nil
+}
+
+// wsAccept returns the Sec-WebSocket-Accept value for key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// withDeadline calls fn with a deadline set by set: that of ctx, if it has
+// one, moved to the past once ctx is done so that blocked I/O returns. The
+// deadline is cleared when fn returns, and an error due to ctx is reported
+// as the error of ctx.
+func withDeadline(ctx context.Context, set func(time.Time) error, fn func() error) error {
+	d, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		set(d)
+	}
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		set(time.Unix(1, 0))
+		close(done)
+	})
+	err := fn()
+	if hasDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+		<-ctx.Done() // the deadline of conn may pass just before that of ctx
+	}
+	if !stop() {
+		<-done
+	}
+	set(time.Time{})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func wsRecv(ctx context.Context, c *wsConn) (object.Object, error) {
+	var msg []byte
+	err := withDeadline(ctx, c.conn.SetReadDeadline, func() (err error) {
+		msg, err = c.readMessage()
+		return err
+	})
+	if err == io.EOF {
+		return object.Null{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object.String(msg), nil
+}
+
+// readMessage returns the payload of the next data message, answering
+// control frames as they arrive. It returns io.EOF once the connection is
+// closed by the peer.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsPong:
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			c.conn.Close()
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			if len(msg)+len(payload) > maxWSMessage {
+				return nil, errors.New("message too large")
+			}
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("unknown opcode %#x", opcode)
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	n := uint64(header[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxWSMessage {
+		return false, 0, nil, errors.New("message too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("connection is closed")
+	}
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame and closes the connection.
+func (c *wsConn) Close() error {
+	c.writeFrame(wsClose, nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,150 @@
+package stdlib
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajwerner/monkey/object"
+)
+
+// wsEchoServer upgrades every request to a WebSocket connection, pings the
+// client, echoes each message in upper case and closes the connection after
+// "bye".
+func wsEchoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + wsAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		rw.Flush()
+		c := &wsConn{conn: conn, r: bufio.NewReader(rw)}
+		c.writeFrame(wsPing, []byte("ping"))
+		for {
+			msg, err := c.readMessage()
+			if err != nil {
+				return
+			}
+			if string(msg) == "bye" {
+				c.Close()
+				return
+			}
+			c.writeFrame(wsText, []byte(strings.ToUpper(string(msg))))
+		}
+	}))
+}
+
+// ctxOnly is a BuiltinContext which only provides a context.
+type ctxOnly struct {
+	object.BuiltinContext
+	ctx context.Context
+}
+
+func (c ctxOnly) Context() context.Context { return c.ctx }
+
+func TestWebSocket(t *testing.T) {
+	srv := wsEchoServer(t)
+	defer srv.Close()
+
+	build, _ := Lookup("ws")
+	b := build()
+	call := func(name string, args ...object.Object) object.Object {
+		fn, _ := b.Lookup(name)
+		return fn.Call(ctxOnly{ctx: context.Background()}, args...)
+	}
+	for _, name := range []string{"connect", "send", "recv", "close"} {
+		if b.Capabilities(name) != object.CapNetwork {
+			t.Errorf("%s does not require CapNetwork", name)
+		}
+	}
+
+	url := object.String("ws" + strings.TrimPrefix(srv.URL, "http"))
+	conn := call("connect", url)
+	if conn.Type() != object.EXTERNAL {
+		t.Fatalf("expected EXTERNAL connection, got %v", conn)
+	}
+	for _, msg := range []string{"hello", strings.Repeat("x", 70000)} {
+		if got := call("send", conn, object.String(msg)); got != (object.Null{}) {
+			t.Fatalf("send failed: %v", got)
+		}
+		if got := call("recv", conn); got != object.String(strings.ToUpper(msg)) {
+			t.Errorf("wrong reply to a message of length %d: %.20v", len(msg), got)
+		}
+	}
+	call("send", conn, object.String("bye"))
+	if got := call("recv", conn); got != (object.Null{}) {
+		t.Errorf("expected NULL after close, got %v", got)
+	}
+	if got := call("send", conn, object.String("again")); got.Type() != object.ERROR {
+		t.Errorf("expected error sending on a closed connection, got %v", got)
+	}
+
+	if got := call("connect", object.String("http://example.com")); got.Type() != object.ERROR {
+		t.Errorf("expected error for an http URL, got %v", got)
+	}
+}
+
+func TestWebSocketContext(t *testing.T) {
+	srv := wsEchoServer(t)
+	defer srv.Close()
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	build, _ := Lookup("ws")
+	b := build()
+	call := func(ctx context.Context, name string, args ...object.Object) object.Object {
+		fn, _ := b.Lookup(name)
+		return fn.Call(ctxOnly{ctx: ctx}, args...)
+	}
+	expired := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 50*time.Millisecond)
+	}
+
+	ctx, cancel := expired()
+	defer cancel()
+	got := call(ctx, "connect", object.String("ws://"+silent.Addr().String()))
+	if err, ok := got.(object.Error); !ok || err.Err != context.DeadlineExceeded {
+		t.Errorf("expected the handshake with a silent server to time out, got %v", got)
+	}
+
+	conn := call(context.Background(), "connect", object.String("ws"+strings.TrimPrefix(srv.URL, "http")))
+	if conn.Type() != object.EXTERNAL {
+		t.Fatalf("expected EXTERNAL connection, got %v", conn)
+	}
+	ctx, cancel = expired()
+	defer cancel()
+	if got := call(ctx, "recv", conn); got.(object.Error).Err != context.DeadlineExceeded {
+		t.Errorf("expected recv without a message to time out, got %v", got)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if got := call(ctx, "recv", conn); got.(object.Error).Err != context.Canceled {
+		t.Errorf("expected recv to stop when canceled, got %v", got)
+	}
+	call(context.Background(), "send", conn, object.String("hi"))
+	if got := call(context.Background(), "recv", conn); got != object.String("HI") {
+		t.Errorf("expected the connection to be usable after a timeout, got %v", got)
+	}
+}
@@ -1,17 +1,26 @@
 // Package token defines the tokens for monkey.
 package token
 
+import (
+	"maps"
+	"slices"
+)
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // 1-based line on which the token starts
+	Column  int // 1-based column, in runes, at which the token starts
+	Offset  int // 0-based byte offset in the input at which the token starts
 }
 
 const (
 	ILLEGAL TokenType = "ILLEGAL"
 	EOF     TokenType = "EOF"
 	STRING  TokenType = "STRING"
+	COMMENT TokenType = "COMMENT" // recorded by the lexer, never returned from Next
 
 	// Identifiers + literals
 	IDENT TokenType = "IDENT" // add, foobar, x, y, ...
@@ -19,23 +28,31 @@ const (
 	FLOAT TokenType = "FLOAT" // 1.2312, 1e9, -2.223e-1
 
 	// Operators
-	ASSIGN TokenType = "="
-	PLUS   TokenType = "+"
-	MINUS  TokenType = "-"
-	BANG   TokenType = "!"
-	STAR   TokenType = "*"
-	SLASH  TokenType = "/"
+	ASSIGN  TokenType = "="
+	PLUS    TokenType = "+"
+	MINUS   TokenType = "-"
+	BANG    TokenType = "!"
+	STAR    TokenType = "*"
+	SLASH   TokenType = "/"
+	PERCENT TokenType = "%"
+
+	FLOORSLASH TokenType = "~/"
 
-	LT TokenType = "<"
-	GT TokenType = ">"
+	LT  TokenType = "<"
+	GT  TokenType = ">"
+	LTE TokenType = "<="
+	GTE TokenType = ">="
 
 	EQ  TokenType = "=="
 	NEQ TokenType = "!="
 
+	ARROW TokenType = "->"
+
 	// Delimiters
 	COMMA     TokenType = ","
 	SEMICOLON TokenType = ";"
 	COLON     TokenType = ":"
+	DOT       TokenType = "."
 
 	LPAREN   TokenType = "("
 	RPAREN   TokenType = ")"
@@ -49,19 +66,46 @@ const (
 	LET      TokenType = "LET"
 	TRUE     TokenType = "TRUE"
 	FALSE    TokenType = "FALSE"
+	NULL     TokenType = "NULL"
 	IF       TokenType = "IF"
 	ELSE     TokenType = "ELSE"
 	RETURN   TokenType = "RETURN"
+	IMPORT   TokenType = "IMPORT"
+	AWAIT    TokenType = "AWAIT"
+	IS       TokenType = "IS"
+	AS       TokenType = "AS"
+	PROTOCOL TokenType = "PROTOCOL"
+	WHILE    TokenType = "WHILE"
+	FOR      TokenType = "FOR"
+	IN       TokenType = "IN"
+	BREAK    TokenType = "BREAK"
+	CONTINUE TokenType = "CONTINUE"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"null":     NULL,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"import":   IMPORT,
+	"await":    AWAIT,
+	"is":       IS,
+	"as":       AS,
+	"protocol": PROTOCOL,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+}
+
+// Keywords returns the keywords of the language in sorted order.
+func Keywords() []string {
+	return slices.Sorted(maps.Keys(keywords))
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,786 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/monkeyerr"
+	"github.com/ajwerner/monkey/object"
+)
+
+// Error is a type mismatch found by a Checker.
+type Error struct {
+	Line int // 1-based line of the offending expression, if known
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// Diagnostic implements monkeyerr.Diagnoser.
+func (e *Error) Diagnostic() monkeyerr.Diagnostic {
+	return monkeyerr.Diagnostic{Phase: monkeyerr.PhaseCheck, Pos: monkeyerr.Position{Line: e.Line}, Msg: e.Msg, Err: e}
+}
+
+// Binding is a top-level let statement and the inferred type of its value.
+type Binding struct {
+	Name string
+	Type Type
+	Line int
+}
+
+// Info is the result of checking a program.
+type Info struct {
+	// Types holds the inferred type of each expression.
+	Types map[ast.Expression]Type
+	// Bindings lists the program's top-level bindings in order.
+	Bindings []Binding
+	// Warnings holds the mismatches found when not checking strictly.
+	Warnings []*Error
+}
+
+// Checker infers types across a sequence of programs, such as the lines
+// entered in a REPL, keeping the types of their top-level bindings.
+type Checker struct {
+	// Strict makes Check fail with the mismatches it finds rather than
+	// reporting them as warnings.
+	Strict bool
+
+	globals   *scope
+	protocols map[string]*Protocol
+}
+
+// NewChecker returns a Checker for programs using the builtins in b.
+// Builtins other than the standard ones accept any arguments.
+func NewChecker(b *object.Builtins) *Checker {
+	c := &Checker{globals: newScope(nil), protocols: map[string]*Protocol{}}
+	for _, name := range b.Names() {
+		t, ok := builtinTypes[name]
+		if !ok {
+			t = &Function{Params: []Type{Any}, Result: Any, Variadic: true}
+		}
+		c.globals.define(name, t)
+	}
+	return c
+}
+
+// Declare gives the global name type t, for globals bound by the host.
+func (c *Checker) Declare(name string, t Type) {
+	c.globals.define(name, t)
+}
+
+// Check infers the types in program. In strict mode it returns an error
+// joining every mismatch found; otherwise the mismatches are warnings.
+func (c *Checker) Check(program *ast.Program) (*Info, error) {
+	ck := &check{info: &Info{Types: map[ast.Expression]Type{}}, protocols: c.protocols}
+	for _, stmt := range program.Statements {
+		ck.statement(stmt, c.globals)
+		if let, ok := stmt.(*ast.LetStatement); ok {
+			t, _ := c.globals.lookup(let.Name.Value)
+			ck.info.Bindings = append(ck.info.Bindings, Binding{
+				Name: let.Name.Value,
+				Type: t,
+				Line: let.Token.Line,
+			})
+		}
+	}
+	if !c.Strict {
+		ck.info.Warnings = ck.errs
+		return ck.info, nil
+	}
+	errs := make([]error, len(ck.errs))
+	for i, err := range ck.errs {
+		errs[i] = err
+	}
+	return ck.info, errors.Join(errs...)
+}
+
+// Diagnostics returns the warnings of info as diagnostics.
+func (info *Info) Diagnostics() []monkeyerr.Diagnostic {
+	ds := make([]monkeyerr.Diagnostic, len(info.Warnings))
+	for i, w := range info.Warnings {
+		ds[i] = w.Diagnostic()
+		ds[i].Severity = monkeyerr.SeverityWarning
+	}
+	return ds
+}
+
+// elem is the type parameter of the generic builtins operating on arrays.
+var elem = &TypeParam{Name: "T"}
+
+// hashKey and hashValue are the type parameters of the generic builtins
+// operating on hashes.
+var (
+	hashKey   = &TypeParam{Name: "K"}
+	hashValue = &TypeParam{Name: "V"}
+)
+
+// memoized is the type parameter of memo, which returns a function of the
+// same type as its argument.
+var memoized = &TypeParam{Name: "F"}
+
+// builtinTypes are the types of the standard builtins with signatures more
+// precise than accepting and returning anything.
+var builtinTypes = map[string]Type{
+	"len":        &Function{Params: []Type{Any}, Result: Int},
+	"puts":       &Function{Params: []Type{Any}, Result: Null, Variadic: true},
+	"print":      &Function{Params: []Type{Any}, Result: Null, Variadic: true},
+	"first":      &Function{TypeParams: []*TypeParam{elem}, Params: []Type{&Array{Elem: elem}}, Result: elem},
+	"last":       &Function{TypeParams: []*TypeParam{elem}, Params: []Type{&Array{Elem: elem}}, Result: elem},
+	"rest":       &Function{TypeParams: []*TypeParam{elem}, Params: []Type{&Array{Elem: elem}}, Result: &Array{Elem: elem}},
+	"push":       &Function{Params: []Type{&Array{Elem: Any}, Any}, Result: &Array{Elem: Any}},
+	"pop":        &Function{TypeParams: []*TypeParam{elem}, Params: []Type{&Array{Elem: elem}}, Result: &Array{Elem: elem}},
+	"reverse":    &Function{TypeParams: []*TypeParam{elem}, Params: []Type{&Array{Elem: elem}}, Result: &Array{Elem: elem}},
+	"concat":     &Function{Params: []Type{&Array{Elem: Any}}, Result: &Array{Elem: Any}, Variadic: true},
+	"keys":       &Function{TypeParams: []*TypeParam{hashKey, hashValue}, Params: []Type{&Hash{Key: hashKey, Value: hashValue}}, Result: &Array{Elem: hashKey}},
+	"values":     &Function{TypeParams: []*TypeParam{hashKey, hashValue}, Params: []Type{&Hash{Key: hashKey, Value: hashValue}}, Result: &Array{Elem: hashValue}},
+	"hasKey":     &Function{Params: []Type{&Hash{Key: Any, Value: Any}, Any}, Result: Bool},
+	"delete":     &Function{TypeParams: []*TypeParam{hashKey, hashValue}, Params: []Type{&Hash{Key: hashKey, Value: hashValue}, Any}, Result: &Hash{Key: hashKey, Value: hashValue}},
+	"merge":      &Function{Params: []Type{&Hash{Key: Any, Value: Any}}, Result: &Hash{Key: Any, Value: Any}, Variadic: true},
+	"type":       &Function{Params: []Type{Any}, Result: String},
+	"int":        &Function{Params: []Type{Any}, Result: Int},
+	"float":      &Function{Params: []Type{Any}, Result: Float},
+	"str":        &Function{Params: []Type{Any}, Result: String},
+	"bool":       &Function{Params: []Type{Any}, Result: Bool},
+	"split":      &Function{Params: []Type{String, String}, Result: &Array{Elem: String}},
+	"join":       &Function{Params: []Type{&Array{Elem: String}, String}, Result: String},
+	"upper":      &Function{Params: []Type{String}, Result: String},
+	"lower":      &Function{Params: []Type{String}, Result: String},
+	"trim":       &Function{Params: []Type{String}, Result: String},
+	"replace":    &Function{Params: []Type{String, String, String}, Result: String},
+	"contains":   &Function{Params: []Type{String, String}, Result: Bool},
+	"startsWith": &Function{Params: []Type{String, String}, Result: Bool},
+	"map":        &Function{TypeParams: []*TypeParam{elem}, Params: []Type{&Array{Elem: elem}, &Function{Params: []Type{elem}, Result: Any}}, Result: &Array{Elem: Any}},
+	"filter":     &Function{TypeParams: []*TypeParam{elem}, Params: []Type{&Array{Elem: elem}, &Function{Params: []Type{elem}, Result: Any}}, Result: &Array{Elem: elem}},
+	"memo":       &Function{TypeParams: []*TypeParam{memoized}, Params: []Type{memoized}, Result: memoized},
+}
+
+type scope struct {
+	names  map[string]Type
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{names: map[string]Type{}, parent: parent}
+}
+
+func (s *scope) define(name string, t Type) { s.names[name] = t }
+
+func (s *scope) lookup(name string) (Type, bool) {
+	for ; s != nil; s = s.parent {
+		if t, ok := s.names[name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// check is the state of a single call to Check.
+type check struct {
+	info      *Info
+	errs      []*Error
+	protocols map[string]*Protocol
+	// typeParams holds the type parameters of the enclosing generic
+	// functions, innermost last.
+	typeParams []*TypeParam
+	// returns collects the types of the return statements of the
+	// innermost function being checked.
+	returns *[]Type
+}
+
+func (ck *check) errorf(node ast.Node, format string, a ...interface{}) {
+	ck.errs = append(ck.errs, &Error{Line: node.Line(), Msg: fmt.Sprintf(format, a...)})
+}
+
+// statement checks stmt and returns the type of its value.
+func (ck *check) statement(stmt ast.Statement, s *scope) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		var want Type
+		if stmt.Type != nil {
+			want = ck.resolve(stmt.Type)
+		}
+		// Declare functions first so that they may call themselves.
+		if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+			s.define(stmt.Name.Value, ck.signature(fn))
+		}
+		t := ck.expr(stmt.Value, s)
+		if want != nil {
+			if !assignable(t, want) {
+				ck.errorf(stmt, "cannot use %s as %s in let %s%s", t, want, stmt.Name.Value, why(t, want))
+			}
+			t = want
+		}
+		s.define(stmt.Name.Value, t)
+		return Null
+	case *ast.ProtocolStatement:
+		ck.protocol(stmt)
+		return Null
+	case *ast.ReturnStatement:
+		t := ck.expr(stmt.ReturnValue, s)
+		if ck.returns != nil {
+			*ck.returns = append(*ck.returns, t)
+		}
+		return t
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		return Null
+	case *ast.ExpressionStatement:
+		return ck.expr(stmt.Expression, s)
+	case *ast.BlockStatement:
+		return ck.block(stmt, s)
+	}
+	return Any
+}
+
+// protocol declares the protocol of stmt. Protocols may refer to
+// themselves, as in a method taking another value of the same protocol.
+func (ck *check) protocol(stmt *ast.ProtocolStatement) {
+	p := &Protocol{Name: stmt.Name.Value}
+	ck.protocols[p.Name] = p
+	seen := map[string]bool{}
+	for _, m := range stmt.Members {
+		if seen[m.Name.Value] {
+			ck.errorf(m, "duplicate member %s in protocol %s", m.Name.Value, p.Name)
+			continue
+		}
+		seen[m.Name.Value] = true
+		var t Type = Any
+		if m.Type != nil {
+			t = ck.resolve(m.Type)
+		}
+		if m.Method {
+			fn := &Function{Params: make([]Type, len(m.Parameters)), Result: t}
+			for i, param := range m.Parameters {
+				fn.Params[i] = Any
+				if param.Type != nil {
+					fn.Params[i] = ck.resolve(param.Type)
+				}
+			}
+			t = fn
+		}
+		p.Members = append(p.Members, Member{Name: m.Name.Value, Type: t, Method: m.Method})
+	}
+}
+
+func (ck *check) block(block *ast.BlockStatement, s *scope) Type {
+	var t Type = Null
+	for _, stmt := range block.Statements {
+		t = ck.statement(stmt, s)
+	}
+	return t
+}
+
+func (ck *check) expr(node ast.Expression, s *scope) Type {
+	if node == nil {
+		return Any
+	}
+	t := ck.infer(node, s)
+	ck.info.Types[node] = t
+	return t
+}
+
+func (ck *check) infer(node ast.Expression, s *scope) Type {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.FloatLiteral:
+		return Float
+	case *ast.StringLiteral:
+		return String
+	case *ast.Bool:
+		return Bool
+	case *ast.NullLiteral:
+		return Null
+	case *ast.Identifier:
+		if t, ok := s.lookup(node.Value); ok {
+			return t
+		}
+		// Functions may refer to globals defined after them, and hosts may
+		// bind globals the checker does not know of.
+		return Any
+	case *ast.PrefixExpression:
+		return ck.prefix(node, ck.expr(node.Right, s))
+	case *ast.InfixExpression:
+		return ck.infix(node, ck.expr(node.Left, s), ck.expr(node.Right, s))
+	case *ast.IfExpression:
+		ck.expr(node.Condition, s)
+		t := ck.block(node.Consequence, newScope(s))
+		if node.Alternative == nil {
+			return unify(t, Null)
+		}
+		return unify(t, ck.block(node.Alternative, newScope(s)))
+	case *ast.WhileExpression:
+		ck.expr(node.Condition, s)
+		ck.block(node.Body, newScope(s))
+		return Null
+	case *ast.ForExpression:
+		ck.block(node.Body, ck.forScope(node, ck.expr(node.Iterable, s), s))
+		return Null
+	case *ast.AssignExpression:
+		// A variable first bound to null is usually assigned another type
+		// later.
+		t := ck.expr(node.Value, s)
+		if want, ok := s.lookup(node.Name.Value); ok && want != Null && !unknown(want) && !assignable(t, want) {
+			ck.errorf(node, "cannot use %s as %s in assignment to %s%s", t, want, node.Name.Value, why(t, want))
+		}
+		return t
+	case *ast.FunctionLiteral:
+		return ck.function(node, s)
+	case *ast.CallExpression:
+		return ck.call(node, s)
+	case *ast.ArrayLiteral:
+		var elem Type
+		for _, el := range node.Elements {
+			t := ck.expr(el, s)
+			if elem == nil {
+				elem = t
+			} else {
+				elem = unify(elem, t)
+			}
+		}
+		if elem == nil {
+			elem = Any
+		}
+		return &Array{Elem: elem}
+	case *ast.HashLiteral:
+		var key, value Type
+		for _, pair := range node.Pairs {
+			kt, vt := ck.expr(pair.Key, s), ck.expr(pair.Value, s)
+			if !hashable(kt) {
+				ck.errorf(pair.Key, "unusable as hash key: %s", kt)
+			}
+			if key == nil {
+				key, value = kt, vt
+			} else {
+				key, value = unify(key, kt), unify(value, vt)
+			}
+		}
+		if key == nil {
+			key, value = Any, Any
+		}
+		h := &Hash{Key: key, Value: value, Fields: map[string]Type{}}
+		for _, pair := range node.Pairs {
+			lit, ok := pair.Key.(*ast.StringLiteral)
+			if !ok {
+				h.Fields = nil
+				break
+			}
+			h.Fields[lit.Value] = ck.info.Types[pair.Value]
+		}
+		return h
+	case *ast.IndexExpression:
+		return ck.index(node, ck.expr(node.Left, s), ck.expr(node.Index, s))
+	case *ast.ImportExpression:
+		return &Hash{Key: String, Value: Any}
+	case *ast.AwaitExpression:
+		ck.expr(node.Value, s)
+		return Any
+	case *ast.TypeAssertion:
+		left, t := ck.expr(node.Left, s), ck.resolve(node.Type)
+		if node.Operator == "is" {
+			return Bool
+		}
+		if !assignable(left, t) && !assignable(t, left) {
+			ck.errorf(node, "impossible type assertion: %s as %s", left, t)
+		}
+		return t
+	}
+	return Any
+}
+
+func (ck *check) prefix(node *ast.PrefixExpression, right Type) Type {
+	switch node.Operator {
+	case "!":
+		return Bool
+	case "-":
+		if right == Int || right == Float {
+			return right
+		}
+		if unknown(right) {
+			return Any
+		}
+	}
+	ck.errorf(node, "unknown operator: %s%s", node.Operator, right)
+	return Any
+}
+
+func (ck *check) infix(node *ast.InfixExpression, left, right Type) Type {
+	op := node.Operator
+	if unknown(left) || unknown(right) {
+		switch op {
+		case "<", ">", "<=", ">=", "==", "!=":
+			return Bool
+		}
+		return Any
+	}
+	numeric := func(t Type) bool { return t == Int || t == Float }
+	switch {
+	case numeric(left) && numeric(right):
+		switch op {
+		case "<", ">", "<=", ">=", "==", "!=":
+			return Bool
+		}
+		if left == Float || right == Float || op == "/" {
+			return Float
+		}
+		return Int
+	case left == String && right == String && op == "+":
+		return String
+	case op == "==" || op == "!=":
+		return Bool
+	case !Identical(left, right):
+		ck.errorf(node, "type mismatch: %s %s %s", left, op, right)
+	default:
+		ck.errorf(node, "unknown operator: %s %s %s", left, op, right)
+	}
+	return Any
+}
+
+func (ck *check) function(node *ast.FunctionLiteral, s *scope) Type {
+	sig := ck.signature(node)
+	fs := newScope(s)
+	for i, p := range node.Parameters {
+		fs.define(p.Value, sig.Params[i])
+	}
+	outerParams := ck.typeParams
+	ck.typeParams = append(ck.typeParams[:len(ck.typeParams):len(ck.typeParams)], sig.TypeParams...)
+	outer := ck.returns
+	var returns []Type
+	ck.returns = &returns
+	result := ck.block(node.Body, fs)
+	ck.returns = outer
+	ck.typeParams = outerParams
+	if node.Result == nil {
+		for _, t := range returns {
+			result = unify(result, t)
+		}
+		sig.Result = result
+		return sig
+	}
+	for _, t := range append(returns, result) {
+		if !assignable(t, sig.Result) {
+			ck.errorf(node, "cannot return %s from function returning %s%s", t, sig.Result, why(t, sig.Result))
+			break
+		}
+	}
+	return sig
+}
+
+// signature returns the type of node given by its annotations, in which
+// parameters and the result default to Any.
+func (ck *check) signature(node *ast.FunctionLiteral) *Function {
+	sig := &Function{
+		TypeParams: newTypeParams(node.TypeParams),
+		Params:     make([]Type, len(node.Parameters)),
+		Result:     Any,
+	}
+	outer := ck.typeParams
+	ck.typeParams = append(ck.typeParams[:len(ck.typeParams):len(ck.typeParams)], sig.TypeParams...)
+	defer func() { ck.typeParams = outer }()
+	for i, p := range node.Parameters {
+		sig.Params[i] = Any
+		if p.Type != nil {
+			sig.Params[i] = ck.resolve(p.Type)
+		}
+	}
+	if node.Result != nil {
+		sig.Result = ck.resolve(node.Result)
+	}
+	return sig
+}
+
+// resolve returns the type named by an annotation, reporting errors in it.
+func (ck *check) resolve(t *ast.TypeExpr) Type {
+	named := func(name string) (Type, bool) {
+		for i := len(ck.typeParams) - 1; i >= 0; i-- {
+			if p := ck.typeParams[i]; p.Name == name {
+				return p, true
+			}
+		}
+		p, ok := ck.protocols[name]
+		return p, ok
+	}
+	return resolve(t, named, func(t *ast.TypeExpr, msg string) {
+		ck.errs = append(ck.errs, &Error{Line: t.Token.Line, Msg: msg})
+	})
+}
+
+func (ck *check) call(node *ast.CallExpression, s *scope) Type {
+	callee := ck.expr(node.Function, s)
+	args := make([]Type, len(node.Arguments))
+	for i, a := range node.Arguments {
+		args[i] = ck.expr(a, s)
+	}
+	if unknown(callee) {
+		return Any
+	}
+	switch fn := callee.(type) {
+	case *Function:
+		if len(fn.TypeParams) > 0 {
+			fn = instantiate(fn, args)
+		}
+		if fn.Variadic && len(args) < len(fn.Params)-1 ||
+			!fn.Variadic && len(args) != len(fn.Params) {
+			ck.errorf(node, "wrong number of arguments. got=%d, want=%d", len(args), len(fn.Params))
+			return fn.Result
+		}
+		for i, arg := range args {
+			param := fn.Params[min(i, len(fn.Params)-1)]
+			if !assignable(arg, param) {
+				ck.errorf(node.Arguments[i], "cannot use %s as %s in argument %d%s", arg, param, i+1, why(arg, param))
+			}
+		}
+		return fn.Result
+	}
+	ck.errorf(node, "not a function: %s", callee)
+	return Any
+}
+
+func (ck *check) index(node *ast.IndexExpression, left, index Type) Type {
+	switch left := left.(type) {
+	case *Array:
+		if index != Int && index != Any {
+			ck.errorf(node, "cannot index array with %s", index)
+		}
+		return unify(left.Elem, Null)
+	case *Hash:
+		if !hashable(index) {
+			ck.errorf(node, "unusable as hash key: %s", index)
+		}
+		if lit, ok := node.Index.(*ast.StringLiteral); ok && left.Fields != nil {
+			if t, ok := left.Fields[lit.Value]; ok {
+				return t
+			}
+		}
+		return Any
+	case *Protocol:
+		lit, ok := node.Index.(*ast.StringLiteral)
+		if !ok {
+			return Any
+		}
+		for _, m := range left.Members {
+			if m.Name == lit.Value {
+				return m.Type
+			}
+		}
+		ck.errorf(node, "%s has no member %s", left, lit.Value)
+		return Any
+	}
+	if unknown(left) {
+		return Any
+	}
+	ck.errorf(node, "index operator not supported: %s", left)
+	return Any
+}
+
+// forScope returns the scope of the body of node, a loop over a value of type
+// iterable, which binds its variables.
+func (ck *check) forScope(node *ast.ForExpression, iterable Type, s *scope) *scope {
+	key, value := Type(Any), Type(Any)
+	switch iterable := iterable.(type) {
+	case *Array:
+		key, value = Int, iterable.Elem
+	case *Hash:
+		key, value = iterable.Key, iterable.Value
+		if node.Key == nil {
+			value = key
+		}
+	default:
+		if !unknown(iterable) {
+			ck.errorf(node, "not iterable: %s", iterable)
+		}
+	}
+	body := newScope(s)
+	if node.Key != nil {
+		body.define(node.Key.Value, key)
+	}
+	body.define(node.Value.Value, value)
+	return body
+}
+
+// unknown reports whether values of type t may be of any type, so that any
+// operation on them may succeed.
+func unknown(t Type) bool {
+	_, isParam := t.(*TypeParam)
+	return t == Any || isParam
+}
+
+// instantiate returns the signature of a call of the generic function fn
+// with arguments of types args. Each type parameter takes the type of the
+// first argument from which it can be inferred, or Any.
+func instantiate(fn *Function, args []Type) *Function {
+	bound := map[*TypeParam]Type{}
+	for i, arg := range args {
+		if len(fn.Params) > 0 {
+			infer(fn.Params[min(i, len(fn.Params)-1)], arg, bound)
+		}
+	}
+	for _, p := range fn.TypeParams {
+		if _, ok := bound[p]; !ok {
+			bound[p] = Any
+		}
+	}
+	inst := &Function{Params: make([]Type, len(fn.Params)), Variadic: fn.Variadic}
+	for i, p := range fn.Params {
+		inst.Params[i] = subst(p, bound)
+	}
+	inst.Result = subst(fn.Result, bound)
+	return inst
+}
+
+// infer binds the type parameters in param to the corresponding parts of
+// arg, the type of an argument passed for param.
+func infer(param, arg Type, bound map[*TypeParam]Type) {
+	if arg == Any {
+		return
+	}
+	switch param := param.(type) {
+	case *TypeParam:
+		if _, ok := bound[param]; !ok {
+			bound[param] = arg
+		}
+	case *Array:
+		if arg, ok := arg.(*Array); ok {
+			infer(param.Elem, arg.Elem, bound)
+		}
+	case *Hash:
+		if arg, ok := arg.(*Hash); ok {
+			infer(param.Key, arg.Key, bound)
+			infer(param.Value, arg.Value, bound)
+		}
+	case *Function:
+		if arg, ok := arg.(*Function); ok && len(arg.Params) == len(param.Params) {
+			for i, p := range param.Params {
+				infer(p, arg.Params[i], bound)
+			}
+			infer(param.Result, arg.Result, bound)
+		}
+	}
+}
+
+// subst returns t with the type parameters in bound replaced.
+func subst(t Type, bound map[*TypeParam]Type) Type {
+	switch t := t.(type) {
+	case *TypeParam:
+		if b, ok := bound[t]; ok {
+			return b
+		}
+	case *Array:
+		return &Array{Elem: subst(t.Elem, bound)}
+	case *Hash:
+		return &Hash{Key: subst(t.Key, bound), Value: subst(t.Value, bound)}
+	case *Function:
+		fn := &Function{TypeParams: t.TypeParams, Params: make([]Type, len(t.Params)), Variadic: t.Variadic}
+		for i, p := range t.Params {
+			fn.Params[i] = subst(p, bound)
+		}
+		fn.Result = subst(t.Result, bound)
+		return fn
+	}
+	return t
+}
+
+// hashable reports whether values of type t may be hash keys.
+func hashable(t Type) bool {
+	switch t {
+	case Int, String, Bool:
+		return true
+	}
+	return unknown(t)
+}
+
+// assignable reports whether a value of type t may be used as type want.
+func assignable(t, want Type) bool {
+	if t == Any || want == Any {
+		return true
+	}
+	switch want := want.(type) {
+	case *Array:
+		t, ok := t.(*Array)
+		return ok && assignable(t.Elem, want.Elem)
+	case *Hash:
+		t, ok := t.(*Hash)
+		return ok && assignable(t.Key, want.Key) && assignable(t.Value, want.Value)
+	case *Protocol:
+		return missing(t, want) == ""
+	case *Function:
+		t, ok := t.(*Function)
+		if ok && isAnyFunction(want) {
+			return true
+		}
+		if !ok || t.Variadic != want.Variadic || len(t.Params) != len(want.Params) {
+			return false
+		}
+		for i, p := range t.Params {
+			if !assignable(want.Params[i], p) {
+				return false
+			}
+		}
+		return assignable(t.Result, want.Result)
+	}
+	return Identical(t, want)
+}
+
+// missing describes the first member of p which a value of type t lacks,
+// or returns "" if it has them all. Hashes whose keys are unknown are
+// assumed to have every member.
+func missing(t Type, p *Protocol) string {
+	var has func(name string) (Type, bool)
+	switch t := t.(type) {
+	case *Protocol:
+		if t == p {
+			return ""
+		}
+		has = func(name string) (Type, bool) {
+			for _, m := range t.Members {
+				if m.Name == name {
+					return m.Type, true
+				}
+			}
+			return nil, false
+		}
+	case *Hash:
+		if t.Fields == nil {
+			if t.Key == String || t.Key == Any {
+				return ""
+			}
+			return "its keys are not strings"
+		}
+		has = func(name string) (Type, bool) {
+			mt, ok := t.Fields[name]
+			return mt, ok
+		}
+	default:
+		return "it is not a hash"
+	}
+	for _, m := range p.Members {
+		kind := "field"
+		if m.Method {
+			kind = "method"
+		}
+		mt, ok := has(m.Name)
+		switch {
+		case !ok:
+			return fmt.Sprintf("missing %s %s", kind, m.Name)
+		case !assignable(mt, m.Type):
+			return fmt.Sprintf("%s %s has type %s, want %s", kind, m.Name, mt, m.Type)
+		}
+	}
+	return ""
+}
+
+// why explains why a value of type t cannot be used as type want, if want
+// is a protocol, for appending to an error message.
+func why(t, want Type) string {
+	if p, ok := want.(*Protocol); ok {
+		if reason := missing(t, p); reason != "" && t != Any {
+			return ": " + reason
+		}
+	}
+	return ""
+}
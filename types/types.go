@@ -0,0 +1,356 @@
+// Package types is an optional static type checker for monkey programs. It
+// walks a program before it is run, infers the type of each expression and
+// reports operations which would fail at run time whatever the values
+// involved, such as "a" - 1 or calling an integer.
+//
+// Inference is deliberately permissive: where the type of a value cannot be
+// known, such as a function parameter, it is Any, and any operation on Any
+// is accepted.
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ajwerner/monkey/ast"
+	"github.com/ajwerner/monkey/object"
+)
+
+// Type is the static type of an expression.
+type Type interface {
+	String() string
+}
+
+// Basic is a type without components.
+type Basic int
+
+const (
+	Any Basic = iota
+	Int
+	Float
+	String
+	Bool
+	Null
+)
+
+var basicNames = [...]string{
+	Any:    "any",
+	Int:    "int",
+	Float:  "float",
+	String: "string",
+	Bool:   "bool",
+	Null:   "null",
+}
+
+func (b Basic) String() string { return basicNames[b] }
+
+// basicTypes maps the names of the basic types in annotations to the types.
+var basicTypes = map[string]Basic{}
+
+func init() {
+	for b, name := range basicNames {
+		basicTypes[name] = Basic(b)
+	}
+}
+
+// Array is the type of arrays whose elements have type Elem.
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string {
+	if a.Elem == Any {
+		return "array"
+	}
+	return "array<" + a.Elem.String() + ">"
+}
+
+// Hash is the type of hashes from Key to Value.
+type Hash struct {
+	Key, Value Type
+	// Fields holds the types of the string keys known to be present, for
+	// hashes built by hash literals with string keys, and is nil otherwise.
+	Fields map[string]Type
+}
+
+func (h *Hash) String() string {
+	if h.Key == Any && h.Value == Any {
+		return "hash"
+	}
+	return "hash<" + h.Key.String() + ", " + h.Value.String() + ">"
+}
+
+// Function is the type of functions and builtins. A Variadic function
+// accepts any number of arguments, each of the type of its last parameter.
+// A generic function has TypeParams, which its parameters and result may
+// use and which each call instantiates from the types of its arguments.
+type Function struct {
+	TypeParams []*TypeParam
+	Params     []Type
+	Result     Type
+	Variadic   bool
+}
+
+func (f *Function) String() string {
+	var out strings.Builder
+	out.WriteString("fn")
+	for i, p := range f.TypeParams {
+		if i == 0 {
+			out.WriteString("<")
+		} else {
+			out.WriteString(", ")
+		}
+		out.WriteString(p.Name)
+		if i == len(f.TypeParams)-1 {
+			out.WriteString(">")
+		}
+	}
+	out.WriteString("(")
+	for i, p := range f.Params {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		if f.Variadic && i == len(f.Params)-1 {
+			out.WriteString("...")
+		}
+		out.WriteString(p.String())
+	}
+	out.WriteString(") -> ")
+	out.WriteString(f.Result.String())
+	return out.String()
+}
+
+// TypeParam is a type parameter of a generic function. Within the function
+// it stands for an unknown type, so that values of the type may be used in
+// any way but a value of another type may not be used in their place.
+type TypeParam struct {
+	Name string
+}
+
+func (p *TypeParam) String() string { return p.Name }
+
+// Protocol is the type of hashes having the protocol's members: a key for
+// each method, holding a function, and for each field.
+type Protocol struct {
+	Name    string
+	Members []Member
+}
+
+// Member is a method or field of a Protocol.
+type Member struct {
+	Name   string
+	Type   Type
+	Method bool
+}
+
+func (p *Protocol) String() string { return p.Name }
+
+// Identical reports whether a and b are the same type.
+func Identical(a, b Type) bool {
+	return a.String() == b.String()
+}
+
+// unify returns the type of a value which may be of type a or b.
+func unify(a, b Type) Type {
+	if !Identical(a, b) {
+		return Any
+	}
+	if ha, ok := a.(*Hash); ok {
+		// Keep the fields present in both.
+		hb := b.(*Hash)
+		h := &Hash{Key: ha.Key, Value: ha.Value}
+		if ha.Fields != nil && hb.Fields != nil {
+			h.Fields = map[string]Type{}
+			for name, t := range ha.Fields {
+				if u, ok := hb.Fields[name]; ok {
+					h.Fields[name] = unify(t, u)
+				}
+			}
+		}
+		return h
+	}
+	return a
+}
+
+// isAnyFunction reports whether f is the type of the bare annotation fn,
+// which accepts any function.
+func isAnyFunction(f *Function) bool {
+	return f.Variadic && len(f.Params) == 1 && f.Params[0] == Any && f.Result == Any
+}
+
+// newTypeParams returns the type parameters named by idents.
+func newTypeParams(idents []*ast.Identifier) []*TypeParam {
+	params := make([]*TypeParam, len(idents))
+	for i, ident := range idents {
+		params[i] = &TypeParam{Name: ident.Value}
+	}
+	return params
+}
+
+// Resolve returns the type named by the annotation t, or an error without
+// position information if t names no type.
+func Resolve(t *ast.TypeExpr) (Type, error) {
+	var first error
+	typ := resolve(t, nil, func(_ *ast.TypeExpr, msg string) {
+		if first == nil {
+			first = errors.New(msg)
+		}
+	})
+	return typ, first
+}
+
+// resolve returns the type named by t, calling report with each erroneous
+// part of t and using Any in its place. Names other than those of the
+// predeclared types are looked up with named, if it is not nil.
+func resolve(t *ast.TypeExpr, named func(string) (Type, bool), report func(t *ast.TypeExpr, msg string)) Type {
+	args := make([]Type, len(t.Args))
+	if len(t.TypeParams) == 0 {
+		for i, a := range t.Args {
+			args[i] = resolve(a, named, report)
+		}
+	}
+	errorf := func(format string, a ...interface{}) Type {
+		report(t, fmt.Sprintf(format, a...))
+		return Any
+	}
+	wrongArgs := func() Type {
+		return errorf("wrong number of type arguments for %s: %d", t.Name, len(args))
+	}
+	switch t.Name {
+	case "any", "int", "float", "string", "bool", "null":
+		if len(args) != 0 {
+			return wrongArgs()
+		}
+		return basicTypes[t.Name]
+	case "array":
+		switch len(args) {
+		case 0:
+			return &Array{Elem: Any}
+		case 1:
+			return &Array{Elem: args[0]}
+		}
+		return wrongArgs()
+	case "hash":
+		switch len(args) {
+		case 0:
+			return &Hash{Key: Any, Value: Any}
+		case 2:
+			return &Hash{Key: args[0], Value: args[1]}
+		}
+		return wrongArgs()
+	case "fn":
+		if t.Args == nil {
+			return &Function{Params: []Type{Any}, Result: Any, Variadic: true}
+		}
+		fn := &Function{Params: args, Result: Any}
+		if len(t.TypeParams) > 0 {
+			// Resolve the parameters again with the type parameters in scope.
+			fn.TypeParams = newTypeParams(t.TypeParams)
+			outer := named
+			named = func(name string) (Type, bool) {
+				for _, p := range fn.TypeParams {
+					if p.Name == name {
+						return p, true
+					}
+				}
+				if outer == nil {
+					return nil, false
+				}
+				return outer(name)
+			}
+			for i, a := range t.Args {
+				fn.Params[i] = resolve(a, named, report)
+			}
+		}
+		if t.Result != nil {
+			fn.Result = resolve(t.Result, named, report)
+		}
+		return fn
+	}
+	if named != nil {
+		if typ, ok := named(t.Name); ok {
+			if len(args) != 0 {
+				return wrongArgs()
+			}
+			return typ
+		}
+	}
+	return errorf("unknown type %s", t.Name)
+}
+
+// Is reports whether obj is a value of type t. The elements of arrays and
+// hashes must be of their element types; functions are checked only for
+// their number of parameters.
+func Is(obj object.Object, t Type) bool {
+	switch t := t.(type) {
+	case Basic:
+		switch t {
+		case Any:
+			return true
+		case Int:
+			_, ok := obj.(object.Integer)
+			return ok
+		case Float:
+			_, ok := obj.(object.Float)
+			return ok
+		case String:
+			_, ok := obj.(object.String)
+			return ok
+		case Bool:
+			_, ok := obj.(object.Bool)
+			return ok
+		case Null:
+			_, ok := obj.(object.Null)
+			return ok
+		}
+	case *Array:
+		var arr object.Array
+		switch obj := obj.(type) {
+		case *object.Array:
+			arr = *obj
+		case object.Array:
+			arr = obj
+		default:
+			return false
+		}
+		for _, el := range arr {
+			if !Is(el, t.Elem) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		h, ok := obj.(object.Hash)
+		if !ok {
+			return false
+		}
+		for k, v := range h.All() {
+			if !Is(k, t.Key) || !Is(v, t.Value) {
+				return false
+			}
+		}
+		return true
+	case *Function:
+		switch fn := obj.(type) {
+		case *object.Builtin:
+			return true
+		case *object.Function:
+			return t.Variadic || len(fn.Parameters) == len(t.Params)
+		}
+	}
+	return false
+}
+
+// Assert returns the value of the expression obj op t, where op is "is" or
+// "as".
+func Assert(op string, obj object.Object, t Type) (object.Object, error) {
+	ok := Is(obj, t)
+	if op == "is" {
+		return object.Bool(ok), nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("type assertion failed: %s is not %s", obj.Type(), t)
+	}
+	return obj, nil
+}
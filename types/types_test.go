@@ -0,0 +1,176 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ajwerner/monkey/lexer"
+	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/parser"
+)
+
+// checkTest is a program and the bindings and warnings expected from
+// checking it.
+type checkTest struct {
+	input    string
+	bindings string
+	warnings []string
+}
+
+func runCheckTests(t *testing.T, tests []checkTest) {
+	t.Helper()
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parse %q: %v", tt.input, p.Errors())
+		}
+		info, err := NewChecker(object.NewBuiltins()).Check(program)
+		if err != nil {
+			t.Fatalf("check %q: unexpected error %v", tt.input, err)
+		}
+		var bindings []string
+		for _, b := range info.Bindings {
+			bindings = append(bindings, b.Name+" "+b.Type.String())
+		}
+		if got := strings.Join(bindings, ", "); got != tt.bindings {
+			t.Errorf("%q: wrong bindings. want=%q, got=%q", tt.input, tt.bindings, got)
+		}
+		var warnings []string
+		for _, w := range info.Warnings {
+			warnings = append(warnings, w.Error())
+		}
+		if strings.Join(warnings, "\n") != strings.Join(tt.warnings, "\n") {
+			t.Errorf("%q: wrong warnings. want=%q, got=%q", tt.input, tt.warnings, warnings)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []checkTest{
+		{`let a = 1; let b = 2.5; let c = a * b; let d = "x" + "y";`, "a int, b float, c float, d string", nil},
+		{`let a = 1 < 2; let b = !a; let c = -1;`, "a bool, b bool, c int", nil},
+		{`let a = 1 <= 2.5; let b = 2 >= 1;`, "a bool, b bool", nil},
+		{`let a = [1, 2]; let b = [1, "x"]; let c = {"a": 1}; let d = a[0];`, "a array<int>, b array, c hash<string, int>, d any", nil},
+		{`let f = fn(x) { x + 1 }; let g = fn() { 1 }; let h = g();`, "f fn(any) -> any, g fn() -> int, h int", nil},
+		{`let f = fn(n) { if (n < 2) { return 1; } f(n - 1) * n };`, "f fn(any) -> any", nil},
+		{`let a = if (true) { 1 } else { 2 }; let b = if (true) { 1 };`, "a int, b any", nil},
+		{`let n = len("abc"); let m = import "math";`, "n int, m hash<string, any>", nil},
+		{`"a" - 1`, "", []string{"line 1: type mismatch: string - int"}},
+		{`"a" * "b"`, "", []string{"line 1: unknown operator: string * string"}},
+		{`-"a"`, "", []string{"line 1: unknown operator: -string"}},
+		{"let x = 1;\nx(2)", "x int", []string{"line 2: not a function: int"}},
+		{`let f = fn(a) { a }; f(1, 2)`, "f fn(any) -> any", []string{"line 1: wrong number of arguments. got=2, want=1"}},
+		{`first(1)`, "", []string{"line 1: cannot use int as array in argument 1"}},
+		{`1[0]`, "", []string{"line 1: index operator not supported: int"}},
+		{`[1]["a"]`, "", []string{"line 1: cannot index array with string"}},
+		{`{[1]: 2}`, "", []string{"line 1: unusable as hash key: array<int>"}},
+		{`let f = fn(x) { x - 1 }; f("a"); y - 1; 1 == "a"`, "f fn(any) -> any", nil},
+		{`let a = 1 is string; let b = [] as array<int>; let c = (1 as any) as string;`, "a bool, b array<int>, c string", nil},
+		{`"1" as int`, "", []string{"line 1: impossible type assertion: string as int"}},
+		{`let q = 5 / 2; let f = 5 ~/ 2; let g = 5.0 ~/ 2;`, "q float, f int, g float", nil},
+		{`let r = 5 % 2; let s = 5 % 2.5;`, "r int, s float", nil},
+		{`for (x in [1, 2]) { x - "a" }`, "", []string{"line 1: type mismatch: int - string"}},
+		{`for (i, x in ["a"]) { i - x }`, "", []string{"line 1: type mismatch: int - string"}},
+		{`for (k, v in {"a": 1}) { v - k }`, "", []string{"line 1: type mismatch: int - string"}},
+		{`for (k in {"a": 1}) { k - 1 }`, "", []string{"line 1: type mismatch: string - int"}},
+		{`for (x in 1) { x }`, "", []string{"line 1: not iterable: int"}},
+		{`let f = fn(xs) { for (x in xs) { x - 1 } };`, "f fn(any) -> null", nil},
+		{`let i = 0; let b = (i = i + 1);`, "i int, b int", nil},
+		{`let x = null; x = 1; let s: string = "a"; s = 1;`, "x null, s string", []string{"line 1: cannot use int as string in assignment to s"}},
+	}
+	runCheckTests(t, tests)
+}
+
+func TestProtocols(t *testing.T) {
+	const decl = `protocol Shape { fn area() -> float; name: string }
+protocol Comparable { fn cmp(other: Comparable) -> int }
+`
+	runCheckTests(t, []checkTest{
+		{decl + `let show = fn(s: Shape) { s.name + ": " }; let a = show({"name": "sq", "area": fn() { 4.0 }});`,
+			"show fn(Shape) -> string, a string", nil},
+		{decl + `let show = fn(s: Shape) { s.name + s.area() };`, "show fn(Shape) -> any", []string{
+			"line 3: type mismatch: string + float",
+		}},
+		{decl + `let total = fn(s: Shape) -> float { s.area() * 2 };`, "total fn(Shape) -> float", nil},
+		{decl + `let c: Comparable = {"cmp": fn(o) { 0 }, "n": 1};`, "c Comparable", nil},
+		{decl + `let c: Comparable = c;`, "c Comparable", nil},
+		{decl + `let d = fn(h: hash) { let s: Shape = h; s };`, "d fn(hash) -> Shape", nil},
+		{decl + `let s: Shape = {"name": "sq"};`, "s Shape", []string{
+			`line 3: cannot use hash<string, string> as Shape in let s: missing method area`,
+		}},
+		{decl + `let f = fn(s: Shape) { s.size }; f({"name": 1, "area": fn() { 1.0 }})`, "f fn(Shape) -> any", []string{
+			"line 3: Shape has no member size",
+			"line 3: cannot use hash<string, any> as Shape in argument 1: field name has type int, want string",
+		}},
+		{decl + `let f = fn(c: Comparable) { c }; f(1); f({1: 2})`, "f fn(Comparable) -> Comparable", []string{
+			"line 3: cannot use int as Comparable in argument 1: it is not a hash",
+			"line 3: cannot use hash<int, int> as Comparable in argument 1: its keys are not strings",
+		}},
+		{`protocol P { a; fn a() }`, "", []string{"line 1: duplicate member a in protocol P"}},
+	})
+}
+
+func TestGenerics(t *testing.T) {
+	runCheckTests(t, []checkTest{
+		{`let head = fn<T>(xs: array<T>) -> T { xs[0] }; let a = head([1, 2]); let b = head(["x"]); let c = head([]);`,
+			"head fn<T>(array<T>) -> T, a int, b string, c any", nil},
+		{`let apply = fn<A, B>(f: fn(A) -> B, x: A) -> B { f(x) }; let s = apply(fn(n: int) -> string { "n" }, 1);`,
+			"apply fn<A, B>(fn(A) -> B, A) -> B, s string", nil},
+		{`let pair = fn<K, V>(k: K, v: V) -> hash<K, V> { {k: v} }; let p = pair("a", 1.5);`,
+			"pair fn<K, V>(K, V) -> hash<K, V>, p hash<string, float>", nil},
+		{`let same = fn<T>(a: T, b: T) { [a, b] }; same(1, "a")`,
+			"same fn<T>(T, T) -> array<T>", []string{"line 1: cannot use string as int in argument 2"}},
+		{`let id = fn<T>(x: T) -> T { 1 };`, "id fn<T>(T) -> T", []string{"line 1: cannot return int from function returning T"}},
+		{`let f = fn<T>(x: T) { let y: T = x; y + 1 };`, "f fn<T>(T) -> any", nil},
+		{`let f: fn<T>(array<T>) -> T = first; let x = f([true]); let y = first([1.5]); let z = rest(["a"]);`,
+			"f fn<T>(array<T>) -> T, x bool, y float, z array<string>", nil},
+		{`let r = reverse([1, 2]); let p = pop(r); let c = concat([1], ["a"]); concat(r, 1)`,
+			"r array<int>, p array<int>, c array", []string{"line 1: cannot use int as array in argument 2"}},
+		{`let m = map([1, 2], fn(x) { x * 2 }); let f = filter(["a", ""], fn(s: string) -> bool { len(s) > 0 }); let l = map(["a"], len);`,
+			"m array, f array<string>, l array", nil},
+		{`filter([1], fn(s: string) -> bool { true })`, "", []string{"line 1: cannot use fn(string) -> bool as fn(int) -> any in argument 2"}},
+		{`let h = {"a": 1}; let k = keys(h); let v = values(h); let d = delete(h, "a"); let m = merge(h, {1: 2}); let b = hasKey(h, "a");`,
+			"h hash<string, int>, k array<string>, v array<int>, d hash<string, int>, m hash, b bool", nil},
+		{`let i = int("1"); let f = float(i); let s = str(f); let b = bool(s); let t = type(b);`,
+			"i int, f float, s string, b bool, t string", nil},
+		{`let p = split("a b", " "); let j = join(p, ","); let c = contains(j, "a"); startsWith(1, "a")`,
+			"p array<string>, j string, c bool", []string{"line 1: cannot use int as string in argument 1"}},
+		{`let sq = memo(fn(n: int) -> int { n * n }); let x = sq(3); sq("a")`,
+			"sq fn(int) -> int, x int", []string{"line 1: cannot use string as int in argument 1"}},
+	})
+}
+
+func TestStrict(t *testing.T) {
+	p := parser.New(lexer.New(`let x = "a"; x - 1; x(1)`))
+	program := p.ParseProgram()
+	c := NewChecker(object.NewBuiltins())
+	c.Strict = true
+	c.Declare("y", Int)
+	_, err := c.Check(program)
+	want := "line 1: type mismatch: string - int\nline 1: not a function: string"
+	if err == nil || err.Error() != want {
+		t.Fatalf("wrong error. want=%q, got=%v", want, err)
+	}
+	if _, ok := c.globals.lookup("x"); !ok {
+		t.Errorf("globals not kept between checks")
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	tests := []checkTest{
+		{`let x: int = 5; let f = fn(a: int, b: string) -> bool { a < len(b) };`, "x int, f fn(int, string) -> bool", nil},
+		{`let xs: array<float> = [1.5]; let h: hash<string, array> = {}; let g: fn(int) -> int = fn(n: int) -> int { n };`,
+			"xs array<float>, h hash<string, array>, g fn(int) -> int", nil},
+		{`let f = fn(a: int) { a + 1 }; let y = f(2);`, "f fn(int) -> int, y int", nil},
+		{`let x: int = "a";`, "x int", []string{"line 1: cannot use string as int in let x"}},
+		{`let f = fn(a: string) { a }; f(1)`, "f fn(string) -> string", []string{"line 1: cannot use int as string in argument 1"}},
+		{`let f = fn(a: string) { a - 1 };`, "f fn(string) -> any", []string{"line 1: type mismatch: string - int"}},
+		{`fn() -> int { return "a"; 1 }`, "", []string{"line 1: cannot return string from function returning int"}},
+		{`let x: integer = 1; let y: array<int, int> = [];`, "x any, y any", []string{
+			"line 1: unknown type integer",
+			"line 1: wrong number of type arguments for array: 2",
+		}},
+	}
+	runCheckTests(t, tests)
+}
@@ -1,60 +1,705 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
+	"github.com/ajwerner/monkey/ast"
 	"github.com/ajwerner/monkey/code"
 	"github.com/ajwerner/monkey/compiler"
 	"github.com/ajwerner/monkey/object"
+	"github.com/ajwerner/monkey/sandbox"
+	"github.com/ajwerner/monkey/types"
 )
 
+// Evaluator evaluates code for a VM, as *evaluator.Evaluator does.
+type Evaluator interface {
+	// BuiltinContext returns the object.BuiltinContext whose Eval and
+	// EvalModule evaluate code, stopping once ctx is done and counting
+	// resources in usage.
+	BuiltinContext(ctx context.Context, usage *sandbox.Usage) object.BuiltinContext
+}
+
 const StackSize = 2048
+const GlobalsSize = 65536
 
 type VM struct {
+	// Limits bounds the resources used by Run.
+	Limits sandbox.Limits
+
+	// Truthiness decides which values conditions and the ! operator treat
+	// as true.
+	Truthiness object.Truthiness
+
+	// StrictIndex makes indexing an array out of range or a hash with a
+	// missing key an error rather than NULL.
+	StrictIndex bool
+
+	// IntegerDivision makes OpDiv truncate the quotient of two integers to
+	// an integer, as in programs without language feature division.
+	IntegerDivision bool
+
+	// Evaluator, if not nil, evaluates code which the VM has not compiled
+	// for the builtins it calls, such as the expressions of templates. It
+	// should be configured like the VM, whose steps and memory it counts
+	// against the VM's limits. Tasks use it concurrently, each through a
+	// BuiltinContext of its own.
+	Evaluator Evaluator
+
+	ctx   context.Context
+	steps int64
+	mem   int64
+	// usage, once set, counts steps and memory in place of steps and mem,
+	// shared with the tasks and evaluations started by builtins.
+	usage *sandbox.Usage
+
 	constants    []object.Object
 	instructions code.Instructions
+	builtins     *object.Builtins
+	globals      []object.Object
+	globalNames  []string
+	lines        compiler.LineTable
 
 	stack []object.Object
 	sp    int // Always points to the next value. Top of stack is stack[sp-1]
+
+	iters []*object.Iterator // of the for loops being run, innermost last
+
+	concat object.Appender
 }
 
+// New creates a VM for bytecode compiled against the standard builtins.
 func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithBuiltins(bytecode, object.NewBuiltins())
+}
+
+// NewWithBuiltins creates a VM for bytecode compiled against the builtins in
+// b.
+func NewWithBuiltins(bytecode *compiler.Bytecode, b *object.Builtins) *VM {
 	return &VM{
 		instructions: bytecode.Instructions,
 		constants:    bytecode.Constants,
+		globalNames:  bytecode.GlobalNames,
+		lines:        bytecode.Lines,
+		builtins:     b,
+		globals:      make([]object.Object, GlobalsSize),
 
 		stack: make([]object.Object, StackSize),
 		sp:    0,
 	}
 }
 
+// NewWithGlobalsStore creates a VM like NewWithBuiltins which reads and
+// writes globals in s, so that they persist across runs. s must have a slot
+// for every global defined by the bytecode.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, b *object.Builtins, s []object.Object) *VM {
+	vm := NewWithBuiltins(bytecode, b)
+	vm.globals = s
+	return vm
+}
+
+// Reset prepares vm to run its bytecode again from the start with the same
+// globals store, clearing its stack and resource counters. The caller
+// resets the globals as needed.
+func (vm *VM) Reset() {
+	clear(vm.stack)
+	vm.sp = 0
+	clear(vm.iters)
+	vm.iters = vm.iters[:0]
+	vm.steps = 0
+	vm.mem = 0
+	vm.usage = nil
+}
+
+// share returns the Usage vm counts its resources in from now on, so that
+// the tasks and evaluations its builtins start count theirs against the
+// same limits. It must be called on the goroutine running vm.
+func (vm *VM) share() *sandbox.Usage {
+	if vm.usage == nil {
+		vm.usage = new(sandbox.Usage)
+		vm.usage.Add(vm.steps, vm.mem)
+	}
+	return vm.usage
+}
+
 func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
+// ctxCheckInterval is the number of instructions between checks of the
+// context.
+const ctxCheckInterval = 1 << 10
+
+// RunContext is like Run but stops with ctx.Err() once ctx is done.
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.ctx = ctx
+	defer func() { vm.ctx = nil }()
+	if err := ctx.Err(); err != nil {
+		return &object.RuntimeError{Err: err}
+	}
+	return vm.Run()
+}
+
+// Run runs the bytecode. Its error is an *object.RuntimeError recording
+// the line of the statement which failed, if the bytecode records lines.
+// The VM does not call functions, so the error has no stack.
 func (vm *VM) Run() error {
+	ip, err := vm.run()
+	if err == nil {
+		return nil
+	}
+	if _, located := err.(*object.RuntimeError); located {
+		return err
+	}
+	return &object.RuntimeError{Line: vm.lines.Line(ip), Err: err}
+}
+
+// run runs the bytecode, returning the position of the instruction which
+// failed with its error.
+func (vm *VM) run() (int, error) {
 	for ip := 0; ip < len(vm.instructions); ip++ {
 		op := code.Opcode(vm.instructions[ip])
 
+		steps := vm.steps + 1
+		if vm.usage != nil {
+			steps = vm.usage.Step()
+		} else {
+			vm.steps = steps
+		}
+		if vm.Limits.MaxSteps > 0 && steps > vm.Limits.MaxSteps {
+			return ip, sandbox.ErrStepLimit
+		}
+		if vm.ctx != nil && steps%ctxCheckInterval == 0 {
+			if err := vm.ctx.Err(); err != nil {
+				return ip, err
+			}
+		}
+
 		switch op {
 		case code.OpConstant:
 			constIndex := code.ReadUint16(vm.instructions[ip+1:])
 			ip += 2
 			err := vm.push(vm.constants[constIndex])
+			if err != nil {
+				return ip, err
+			}
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpFloorDiv, code.OpMod:
+			err := vm.executeBinaryOperation(op)
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+			code.OpLessThan, code.OpLessThanOrEqual:
+			err := vm.executeComparison(op)
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpBang:
+			err := vm.push(object.Bool(!vm.Truthiness.IsTruthy(vm.pop())))
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpMinus:
+			err := vm.executeMinusOperator()
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpTrue:
+			err := vm.push(object.Bool(true))
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpFalse:
+			err := vm.push(object.Bool(false))
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpNull:
+			err := vm.push(object.Null{})
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
+			ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
+			ip += 2
+
+			condition := vm.pop()
+			if !vm.Truthiness.IsTruthy(condition) {
+				ip = pos - 1
+			}
+
+		case code.OpIter:
+			it, err := object.NewIterator(vm.pop())
+			if err != nil {
+				return ip, err
+			}
+			vm.iters = append(vm.iters, it)
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
+			vars := code.ReadUint8(vm.instructions[ip+3:])
+			ip += 3
+
+			it := vm.iters[len(vm.iters)-1]
+			key, value, ok := it.Next()
+			if !ok {
+				ip = pos - 1
+				break
+			}
+			if vars == 2 {
+				if err := vm.push(key); err != nil {
+					return ip, err
+				}
+			} else {
+				value = it.Single(key, value)
+			}
+			if err := vm.push(value); err != nil {
+				return ip, err
+			}
+
+		case code.OpIterEnd:
+			vm.iters[len(vm.iters)-1] = nil
+			vm.iters = vm.iters[:len(vm.iters)-1]
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
+			ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
+			ip += 2
+
+			global := vm.globals[globalIndex]
+			if global == nil {
+				// A global is unset when the line of a session which
+				// defined it failed before reaching its definition.
+				if int(globalIndex) < len(vm.globalNames) {
+					return ip, fmt.Errorf("identifier not found: %s", vm.globalNames[globalIndex])
+				}
+				return ip, fmt.Errorf("global %d is not set", globalIndex)
+			}
+			err := vm.push(global)
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpNotFound:
+			constIndex := code.ReadUint16(vm.instructions[ip+1:])
+			ip += 2
+
+			return ip, fmt.Errorf("identifier not found: %s", vm.constants[constIndex].(object.String))
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(vm.instructions[ip+1:]))
+			ip += 2
+
+			array := make(object.Array, numElements)
+			copy(array, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp = vm.sp - numElements
+
+			err := vm.pushCharged(&array)
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(vm.instructions[ip+1:]))
+			ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return ip, err
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.pushCharged(hash)
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpConstHash:
+			keysIndex := code.ReadUint16(vm.instructions[ip+1:])
+			ip += 2
+
+			keys := *vm.constants[keysIndex].(*object.Array)
+			hash := object.NewHash(len(keys))
+			for i, key := range keys {
+				hash.Set(key, vm.stack[vm.sp-len(keys)+i])
+			}
+			vm.sp = vm.sp - len(keys)
+
+			err := vm.pushCharged(hash)
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpAwait:
+			err := vm.executeAwait(vm.pop())
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpIs, code.OpAs:
+			constIndex := code.ReadUint16(vm.instructions[ip+1:])
+			ip += 2
+			t := vm.constants[constIndex].(*object.External).Value.(types.Type)
+			operator := "is"
+			if op == code.OpAs {
+				operator = "as"
+			}
+			result, err := types.Assert(operator, vm.pop(), t)
+			if err != nil {
+				return ip, err
+			}
+			if err := vm.push(result); err != nil {
+				return ip, err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint16(vm.instructions[ip+1:])
+			ip += 2
+			err := vm.push(vm.builtins.At(int(builtinIndex)))
+			if err != nil {
+				return ip, err
+			}
+
+		case code.OpCall:
+			numArgs := int(code.ReadUint8(vm.instructions[ip+1:]))
+			ip += 1
+			err := vm.callFunction(numArgs)
+			if err != nil {
+				return ip, err
+			}
+		}
+	}
+
+	return len(vm.instructions), nil
+}
+
+func (vm *VM) callFunction(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Builtin:
+		args := vm.stack[vm.sp-numArgs : vm.sp]
+		result := callee.Call(builtinContext{vm: vm}, args...)
+		vm.sp = vm.sp - numArgs - 1
+		if errObj, ok := result.(object.Error); ok {
+			return errObj.Err
+		}
+		if result == nil {
+			result = object.Null{}
+		}
+		return vm.pushCharged(result)
+	default:
+		return fmt.Errorf("calling non-function")
+	}
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	leftType := left.Type()
+	rightType := right.Type()
+
+	if leftType == object.INTEGER && rightType == object.FLOAT {
+		left, leftType = object.Float(left.(object.Integer)), object.FLOAT
+	} else if leftType == object.FLOAT && rightType == object.INTEGER {
+		right, rightType = object.Float(right.(object.Integer)), object.FLOAT
+	}
+
+	switch {
+	case leftType == object.INTEGER && rightType == object.INTEGER:
+		return vm.executeBinaryIntegerOperation(op, left.(object.Integer), right.(object.Integer))
+	case leftType == object.FLOAT && rightType == object.FLOAT:
+		return vm.executeBinaryFloatOperation(op, left.(object.Float), right.(object.Float))
+	case leftType == object.STRING && rightType == object.STRING && op == code.OpAdd:
+		return vm.pushCharged(vm.concat.Concat(left.(object.String), right.(object.String)))
+	case leftType != rightType:
+		return fmt.Errorf("type mismatch: %s %s %s", leftType, operatorSymbol(op), rightType)
+	default:
+		return fmt.Errorf("unknown operator: %s %s %s", leftType, operatorSymbol(op), rightType)
+	}
+}
+
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Integer) error {
+	var result object.Integer
+
+	switch op {
+	case code.OpAdd:
+		result = left + right
+	case code.OpSub:
+		result = left - right
+	case code.OpMul:
+		result = left * right
+	case code.OpDiv:
+		if vm.IntegerDivision {
+			quo, err := object.Truncate(left, right)
 			if err != nil {
 				return err
 			}
-		case code.OpAdd:
-			// TODO: stack error checking
-			r := vm.pop()
-			l := vm.pop()
-			rv := r.(object.Integer)
-			lv := l.(object.Integer)
-			vm.push(lv + rv)
+			return vm.push(quo)
+		}
+		quo, err := object.Divide(left, right)
+		if err != nil {
+			return err
+		}
+		return vm.push(quo)
+	case code.OpFloorDiv:
+		quo, err := object.FloorDivide(left, right)
+		if err != nil {
+			return err
 		}
+		result = quo
+	case code.OpMod:
+		rem, err := object.Modulo(left, right)
+		if err != nil {
+			return err
+		}
+		result = rem
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 
-	return nil
+	return vm.push(result)
+}
+
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Float) error {
+	var result object.Float
+
+	switch op {
+	case code.OpAdd:
+		result = left + right
+	case code.OpSub:
+		result = left - right
+	case code.OpMul:
+		result = left * right
+	case code.OpDiv:
+		result = left / right
+	case code.OpFloorDiv:
+		result = object.FloorDivideFloat(left, right)
+	case code.OpMod:
+		result = object.ModuloFloat(left, right)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(result)
+}
+
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if left.Type() == object.INTEGER && right.Type() == object.FLOAT {
+		left = object.Float(left.(object.Integer))
+	} else if left.Type() == object.FLOAT && right.Type() == object.INTEGER {
+		right = object.Float(right.(object.Integer))
+	}
+
+	if left.Type() == right.Type() && !object.Comparable(left) {
+		return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
+	}
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(object.Bool(left == right))
+	case code.OpNotEqual:
+		return vm.push(object.Bool(left != right))
+	}
+
+	switch left := left.(type) {
+	case object.Integer:
+		if right, ok := right.(object.Integer); ok {
+			return vm.push(object.Bool(compareOrdered(op, left, right)))
+		}
+	case object.Float:
+		if right, ok := right.(object.Float); ok {
+			return vm.push(object.Bool(compareOrdered(op, left, right)))
+		}
+	}
+	if left.Type() != right.Type() {
+		return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
+	}
+	return fmt.Errorf("unknown operator: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
+}
+
+// compareOrdered returns the result of the ordering comparison op of left
+// and right.
+func compareOrdered[T object.Integer | object.Float](op code.Opcode, left, right T) bool {
+	switch op {
+	case code.OpGreaterThan:
+		return left > right
+	case code.OpGreaterThanOrEqual:
+		return left >= right
+	case code.OpLessThan:
+		return left < right
+	default:
+		return left <= right
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	switch operand := vm.pop().(type) {
+	case object.Integer:
+		return vm.push(-operand)
+	case object.Float:
+		return vm.push(-operand)
+	default:
+		return fmt.Errorf("unknown operator: -%s", operand.Type())
+	}
+}
+
+// executeAwait waits for the Future f and pushes its result. The VM has no
+// other work to do meanwhile, so it blocks.
+func (vm *VM) executeAwait(f object.Object) error {
+	future, ok := f.(*object.Future)
+	if !ok {
+		return fmt.Errorf("cannot await %s", f.Type())
+	}
+	result := future.WaitContext(builtinContext{vm: vm}.Context())
+	if errObj, ok := result.(object.Error); ok {
+		return errObj.Err
+	}
+	return vm.pushCharged(result)
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hash := object.NewHash((endIndex - startIndex) / 2)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		if !object.Hashable(key) {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		hash.Set(key, value)
+	}
+
+	return hash, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY && index.Type() == object.INTEGER:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.HASH:
+		return vm.executeHashIndex(left, index)
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := *array.(*object.Array)
+	i := index.(object.Integer)
+	max := object.Integer(len(arrayObject) - 1)
+
+	if i < 0 || i > max {
+		if vm.StrictIndex {
+			return object.IndexError(arrayObject, i)
+		}
+		return vm.push(object.Null{})
+	}
+
+	return vm.push(arrayObject[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(object.Hash)
+
+	if !object.Hashable(index) {
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+
+	value, ok := hashObject.Get(index)
+	if !ok {
+		if vm.StrictIndex {
+			return object.KeyError(index)
+		}
+		return vm.push(object.Null{})
+	}
+
+	return vm.push(value)
+}
+
+func operatorSymbol(op code.Opcode) string {
+	switch op {
+	case code.OpAdd:
+		return "+"
+	case code.OpSub:
+		return "-"
+	case code.OpMul:
+		return "*"
+	case code.OpDiv:
+		return "/"
+	case code.OpFloorDiv:
+		return "~/"
+	case code.OpMod:
+		return "%"
+	case code.OpGreaterThan:
+		return ">"
+	case code.OpGreaterThanOrEqual:
+		return ">="
+	case code.OpLessThan:
+		return "<"
+	case code.OpLessThanOrEqual:
+		return "<="
+	case code.OpEqual:
+		return "=="
+	case code.OpNotEqual:
+		return "!="
+	}
+	return fmt.Sprintf("op(%d)", op)
+}
+
+// pushCharged pushes a newly allocated o after checking it against the size
+// limits and accounting for the memory it uses.
+func (vm *VM) pushCharged(o object.Object) error {
+	if err := vm.Limits.CheckSize(o); err != nil {
+		return err
+	}
+	if vm.Limits.MaxMemory > 0 {
+		n := sandbox.SizeOf(o)
+		mem := vm.mem + n
+		if vm.usage != nil {
+			mem = vm.usage.Alloc(n)
+		} else {
+			vm.mem = mem
+		}
+		if mem > vm.Limits.MaxMemory {
+			return sandbox.ErrMemoryLimit
+		}
+	}
+	return vm.push(o)
 }
 
 func (vm *VM) push(o object.Object) error {
@@ -80,3 +725,79 @@ func (vm *VM) StackTop() object.Object {
 	}
 	return vm.stack[vm.sp-1]
 }
+
+// builtinContext is the object.BuiltinContext of the builtins called by a
+// VM. The VM does not yet support functions, so only builtins may be
+// applied.
+type builtinContext struct {
+	vm    *VM
+	ctx   context.Context // if not nil, in place of the VM's
+	usage *sandbox.Usage  // if not nil, a task's, in place of the VM's
+}
+
+func (c builtinContext) Context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	if c.vm.ctx == nil {
+		return context.Background()
+	}
+	return c.vm.ctx
+}
+
+func (c builtinContext) Apply(fn object.Object, args ...object.Object) object.Object {
+	builtin, ok := fn.(*object.Builtin)
+	if !ok {
+		return object.Error{Err: fmt.Errorf("calling non-function")}
+	}
+	return builtin.Call(c, args...)
+}
+
+func (c builtinContext) Truthiness() object.Truthiness { return c.vm.Truthiness }
+
+// Go copies args, which builtins receive as a slice of the VM's stack, and
+// the context of the run, which the VM forgets when the run returns. The
+// task counts its resources against the same limits as the VM, but never
+// touches the VM's own counters, which only the VM's goroutine may.
+func (c builtinContext) Go(fn object.Object, args ...object.Object) *object.Future {
+	args = append([]object.Object(nil), args...)
+	task := builtinContext{vm: c.vm, ctx: c.Context(), usage: c.shared()}
+	return object.StartFuture(func() object.Object { return task.Apply(fn, args...) })
+}
+
+// Eval evaluates node on the VM's Evaluator, as the VM cannot run code it
+// has not compiled.
+func (c builtinContext) Eval(node ast.Node, env *object.Environment) object.Object {
+	return c.evaluate(func(ctx object.BuiltinContext) object.Object {
+		return ctx.Eval(node, env)
+	})
+}
+
+// EvalModule evaluates program as Eval does, with builtins and importer.
+func (c builtinContext) EvalModule(program ast.Node, env *object.Environment, builtins *object.Builtins, importer object.Importer) object.Object {
+	return c.evaluate(func(ctx object.BuiltinContext) object.Object {
+		return ctx.EvalModule(program, env, builtins, importer)
+	})
+}
+
+// evaluate calls eval with a BuiltinContext of the VM's Evaluator, which
+// counts the steps and memory it uses against the VM's limits.
+func (c builtinContext) evaluate(eval func(object.BuiltinContext) object.Object) object.Object {
+	if c.vm.Evaluator == nil {
+		return object.Error{Err: errNoEvaluator}
+	}
+	return eval(c.vm.Evaluator.BuiltinContext(c.Context(), c.shared()))
+}
+
+// shared returns the Usage in which the code c starts counts its
+// resources: the task's, or else the VM's, shared from now on.
+func (c builtinContext) shared() *sandbox.Usage {
+	if c.usage != nil {
+		return c.usage
+	}
+	return c.vm.share()
+}
+
+// errNoEvaluator is the error of evaluating code on a VM without an
+// Evaluator.
+var errNoEvaluator = errors.New("cannot evaluate code which is not compiled without an evaluator")
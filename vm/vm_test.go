@@ -1,7 +1,10 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ajwerner/monkey/ast"
@@ -9,6 +12,7 @@ import (
 	"github.com/ajwerner/monkey/lexer"
 	"github.com/ajwerner/monkey/object"
 	"github.com/ajwerner/monkey/parser"
+	"github.com/ajwerner/monkey/sandbox"
 )
 
 func parse(input string) *ast.Program {
@@ -55,7 +59,7 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("vm error: %s", err)
 		}
 
-		stackElem := vm.StackTop()
+		stackElem := vm.LastPoppedStackElem()
 
 		testExpectedObject(t, tt.expected, stackElem)
 	}
@@ -70,6 +74,55 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case bool:
+		if actual != object.Bool(expected) {
+			t.Errorf("object is not %t. got=%T (%+v)", expected, actual, actual)
+		}
+	case float64:
+		if actual != object.Float(expected) {
+			t.Errorf("object is not %v. got=%T (%+v)", expected, actual, actual)
+		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(*array) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(*array))
+			return
+		}
+		for i, expectedElem := range expected {
+			err := testIntegerObject(object.Integer(expectedElem), (*array)[i])
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+	case map[object.Object]int64:
+		hash, ok := actual.(object.Hash)
+		if !ok {
+			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
+			return
+		}
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
+			return
+		}
+		for expectedKey, expectedValue := range expected {
+			value, _ := hash.Get(expectedKey)
+			err := testIntegerObject(object.Integer(expectedValue), value)
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+	case string:
+		if actual != object.String(expected) {
+			t.Errorf("object is not %q. got=%T (%+v)", expected, actual, actual)
+		}
+	case *object.Null:
+		if actual != (object.Null{}) {
+			t.Errorf("object is not Null. got=%T (%+v)", actual, actual)
+		}
 	}
 }
 
@@ -78,7 +131,427 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"1", 1},
 		{"2", 2},
 		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"1 * 2", 2},
+		{"4 / 2", 2.0},
+		{"5 / 2", 2.5},
+		{"50 ~/ 2 * 2 + 10 - 5", 55},
+		{"-7 ~/ 2", -4},
+		{"7.5 ~/ 2", 3.0},
+		{"-7 % 3", 2},
+		{"7 % -3", -2},
+		{"1 + 10 % 4 * 2", 5},
+		{"(0 - 7.5) % 2", 0.5},
+		{"5 * (2 + 10)", 60},
+		{"-5", -5},
+		{"-50 + 100 + -50", 0},
+		{"1.5 * 2", 3.0},
+		{"1 / 4.0", 0.25},
+		{"-1.5", -1.5},
+		{"-(0.5 - 2)", 1.5},
 	}
 
 	runVmTests(t, tests)
 }
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 <= 1", true},
+		{"2 <= 1", false},
+		{"1 >= 1", true},
+		{"1 >= 2", false},
+		{"2.5 >= 2.5", true},
+		{"1 <= 0.5", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 == 1.0", true},
+		{"2.5 > 2", true},
+		{"true == false", false},
+		{"(1 < 2) == true", true},
+		{`"a" == "a"`, true},
+		{"!true", false},
+		{"!5", false},
+		{"!!5", true},
+		{"!(if (false) { 5; })", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (true) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 } ", 20},
+		{"if (1) { 10 }", 10},
+		{"if (1 > 2) { 10 }", &object.Null{}},
+		{"if (false) { 10 }", &object.Null{}},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestWhileLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"while (false) { 10 }", &object.Null{}},
+		{"let i = 0; while (i < 3) { let i = i + 1; }; i", 3},
+		{"let i = 0; while (i < 3) { let i = i + 1; }", &object.Null{}},
+		{"let i = 0; if (true) { while (i < 2) { let i = i + 1; } }; i", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (i, x in [5, 6, 7]) { let sum = sum + i * x; }; sum", 20},
+		{`let sum = 0; for (k, v in {"a": 1, "b": 2}) { let sum = sum + v + len(k); }; sum`, 5},
+		{`let sum = 0; for (k in {1: "a", 2: "b"}) { let sum = sum + k; }; sum`, 3},
+		{"for (x in []) { x }", &object.Null{}},
+		{"let n = 0; for (x in [[1, 2], [3]]) { for (y in x) { let n = n + y; } }; n", 6},
+		{"for (x in [4, 5]) { }; x", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := []vmTestCase{
+		{"while (true) { break; }", &object.Null{}},
+		{"let i = 0; while (true) { i = i + 1; if (i == 3) { break; } }; i", 3},
+		{"let i = 0; let s = 0; while (i < 5) { i = i + 1; if (i == 2) { continue; } s = s + i; }; s", 13},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } s = s + x; }; s", 3},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x % 2 == 0) { continue; } s = s + x; }; s", 4},
+		{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break; } n = n + 1; } }; n", 2},
+		{"let n = 0; for (x in [1, 2, 3]) { while (true) { break; } if (x == 2) { break; } n = n + x; }; n", 1},
+		{"let i = 0; while (i < 5000) { i = i + 1; if (true) { continue; } }; i", 5000},
+	}
+
+	runVmTests(t, tests)
+}
+
+// TestLoopControlInExpressions checks that break and continue cannot leave
+// the operands of an expression on the stack.
+func TestLoopControlInExpressions(t *testing.T) {
+	for _, input := range []string{
+		"for (x in [1, 2, 3]) { let y = if (x == 2) { break; } else { x }; puts(y) }",
+		"let i = 0; while (i < 5000) { i = i + 1; puts(if (true) { continue; }); }",
+	} {
+		p := parser.New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%s: expected a parse error", input)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let i = 0; let sum = 0; while (i < 4) { sum = sum + i; i = i + 1; }; sum", 6},
+		{"let n = 0; for (x in [1, 2, 3]) { n = n + x; }; n", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = 2; one + two", 3},
+		{"let one = 1; let two = one + one; one + two", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"{}", map[object.Object]int64{}},
+		{"{1: 2, 2: 3}", map[object.Object]int64{object.Integer(1): 2, object.Integer(2): 3}},
+		{`{"a": 2 * 2, true: 4 + 4}`, map[object.Object]int64{object.String("a"): 4, object.Bool(true): 8}},
+		{`let x = 3; {x: 1, "b": x}`, map[object.Object]int64{object.Integer(3): 1, object.String("b"): 3}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", &object.Null{}},
+		{"[1, 2, 3][99]", &object.Null{}},
+		{"[1][-1]", &object.Null{}},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1}[0]", &object.Null{}},
+		{"{}[0]", &object.Null{}},
+		{`{"a": 5}.a`, 5},
+		{`{"ab": 5}["a" + "b"]`, 5},
+		{`{1 + 1: 5}[4 ~/ 2]`, 5},
+		{`let k = "a"; let h = {k + "b": 6}; h["a" + "b"]`, 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTypeAssertions(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 is int", true},
+		{"1 is float", false},
+		{`[1, 2] is array<int>`, true},
+		{`[1, "a"] is array<int>`, false},
+		{`{"a": 1} is hash<string, int>`, true},
+		{"len is fn", true},
+		{"null is null", true},
+		{"1 + 2 as int", 3},
+		{"protocol P { x }; 1 is int", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"1 + true", "type mismatch: INTEGER + BOOL"},
+		{"true - false", "unknown operator: BOOL - BOOL"},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+		{"-true", "unknown operator: -BOOL"},
+		{"1 / 0", "division by zero"},
+		{"1 ~/ 0", "division by zero"},
+		{"1 % 0", "division by zero"},
+		{`"a" % 2`, "type mismatch: STRING % INTEGER"},
+		{`"a" > 1`, "type mismatch: STRING > INTEGER"},
+		{`"a" >= 1`, "type mismatch: STRING >= INTEGER"},
+		{`"a" >= "b"`, "unknown operator: STRING >= STRING"},
+		{"{} == {}", "unknown operator: HASH == HASH"},
+		{`{"a": 1} != {"a": 1}`, "unknown operator: HASH != HASH"},
+		{"1[0]", "index operator not supported: INTEGER"},
+		{"{[1]: 2}", "unusable as hash key: ARRAY"},
+		{"{len: 2}", "unusable as hash key: BUILTIN"},
+		{"await 1", "cannot await INTEGER"},
+		{`"a" as int`, "type assertion failed: STRING is not int"},
+		{"for (x in 1) { x }", "not iterable: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := New(comp.Bytecode()).Run()
+		if err == nil || errors.Unwrap(err).Error() != tt.err {
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+		}
+	}
+}
+
+func TestRuntimeErrorLines(t *testing.T) {
+	tests := []struct {
+		input string
+		line  int
+	}{
+		{"1 + true", 1},
+		{"let a = 1;\na + true", 2},
+		{"let a = 1;\nif (a > 0) {\n  a;\n  a / 0\n}\na", 4},
+		{"let a = [1];\nwhile (true) {\n  a[0] + \"b\"\n}", 3},
+		{"if (true) {\n  1\n} else {\n  2\n}\nlet b = -true;", 6},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err := New(comp.Bytecode()).Run()
+		var rerr *object.RuntimeError
+		if !errors.As(err, &rerr) || rerr.Line != tt.line {
+			t.Errorf("%q: expected an error on line %d, got %v", tt.input, tt.line, err)
+		}
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	globals := make([]object.Object, GlobalsSize)
+	symbols := compiler.NewBuiltinSymbolTable(object.NewBuiltins())
+	var constants []object.Object
+	for _, input := range []string{"let x = 40;", "let y = x + 2;"} {
+		comp := compiler.NewWithState(symbols, constants)
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		constants = comp.Bytecode().Constants
+		if err := NewWithGlobalsStore(comp.Bytecode(), object.NewBuiltins(), globals).Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+	}
+	if globals[1] != object.Integer(42) {
+		t.Errorf("wrong global. want=42, got=%v", globals[1])
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len("hello world")`, 11},
+		{`puts("hello")`, &object.Null{}},
+		{`await spawn(len, "four")`, 4},
+		{`let fs = all([spawn(len, "a"), spawn(len, "bc")]); len(await fs)`, 2},
+		{`pmap(["a", "bc", "def"], len, 2)`, []int{1, 2, 3}},
+		{`pop([1, 2, 3])`, []int{1, 2}},
+		{`pop([])`, &object.Null{}},
+		{`reverse([1, 2, 3])`, []int{3, 2, 1}},
+		{`concat([1], [], [2, 3])`, []int{1, 2, 3}},
+		{`let a = [1]; let b = concat(a, [2]); let c = concat(a, [3]); b[1] * 10 + c[1]`, 23},
+		{`first(keys({"a": 1}))`, "a"},
+		{`values({"a": 1})`, []int{1}},
+		{`let h = {"a": 1, "b": 2}; len(keys(delete(h, "a"))) * 10 + len(keys(h))`, 12},
+		{`merge({"a": 1, "b": 2}, {"b": 3})`, map[object.Object]int64{object.String("a"): 1, object.String("b"): 3}},
+		{`hasKey({1: 2}, 1)`, true},
+		{`map(["a", "bc", "def"], len)`, []int{1, 2, 3}},
+		{`len(filter([[1], [], [2]], first))`, 2},
+		{`reduce([[1], [2, 3]], concat, [])`, []int{1, 2, 3}},
+		{`type({})`, "HASH"},
+		{`int("-12")`, -12},
+		{`float("1.5") + float(1)`, 2.5},
+		{`str(2.5)`, "2.5"},
+		{`str({"a": [1]})`, `{"a": [1]}`},
+		{`bool("")`, true},
+		{`join(split("a,b,c", ","), "-")`, "a-b-c"},
+		{`upper(trim("  ab "))`, "AB"},
+		{`lower(replace("A-B", "-", ""))`, "ab"},
+		{`contains("monkey", "key")`, true},
+		{`startsWith("monkey", "key")`, false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRegisteredBuiltins(t *testing.T) {
+	builtins := object.NewBuiltins()
+	builtins.Register("greet", func(args ...object.Object) object.Object {
+		return object.String("hello " + args[0].Inspect())
+	})
+	builtins.Register("fail", func(args ...object.Object) object.Object {
+		return object.Error{Err: fmt.Errorf("failed")}
+	})
+	// Indices past 255 need both bytes of OpGetBuiltin's operand.
+	for len(builtins.Names()) < 300 {
+		builtins.Register(fmt.Sprintf("filler%d", len(builtins.Names())), func(args ...object.Object) object.Object {
+			return object.Null{}
+		})
+	}
+	builtins.Register("last", func(args ...object.Object) object.Object {
+		return object.String("last")
+	})
+
+	tests := []struct {
+		input    string
+		expected object.Object
+		err      string
+	}{
+		{`greet("monkey")`, object.String("hello monkey"), ""},
+		{`fail()`, nil, "failed"},
+		{`last()`, object.String("last"), ""},
+		{`len(1)`, nil, "argument to `len` not supported, got INTEGER"},
+		{`pop(1)`, nil, "argument to `pop` must be ARRAY, got INTEGER"},
+		{`concat([1], 2)`, nil, "argument 2 to `concat` must be ARRAY, got INTEGER"},
+		{`hasKey({}, [1])`, nil, "unusable as hash key: ARRAY"},
+		{`int("1.5")`, nil, `cannot convert "1.5" to INTEGER`},
+		{`startsWith("a", 1)`, nil, "argument 2 to `startsWith` must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.NewWithBuiltins(builtins)
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := NewWithBuiltins(comp.Bytecode(), builtins)
+		err := vm.Run()
+		if tt.err != "" {
+			if err == nil || errors.Unwrap(err).Error() != tt.err {
+				t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem(); got != tt.expected {
+			t.Errorf("%q: wrong result. want=%v, got=%v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   sandbox.Limits
+		expected error
+	}{
+		{"1 + 2 + 3", sandbox.Limits{MaxSteps: 3}, sandbox.ErrStepLimit},
+		{"while (true) {}", sandbox.Limits{MaxSteps: 100}, sandbox.ErrStepLimit},
+		{`let s = "abc"; s + s`, sandbox.Limits{MaxStringLen: 5}, sandbox.ErrStringLimit},
+		{"[1, 2, 3]", sandbox.Limits{MaxArrayLen: 2}, sandbox.ErrArrayLimit},
+		{"{1: 1, 2: 2, 3: 3}", sandbox.Limits{MaxArrayLen: 2}, sandbox.ErrArrayLimit},
+		{"push([1, 2], 3)", sandbox.Limits{MaxArrayLen: 2}, sandbox.ErrArrayLimit},
+		{"chan(3)", sandbox.Limits{MaxArrayLen: 2}, sandbox.ErrArrayLimit},
+		{"[chan(1000), chan(1000)]", sandbox.Limits{MaxMemory: 20000}, sandbox.ErrMemoryLimit},
+		{`let s = "abcd"; let t = s + s; t + t`, sandbox.Limits{MaxMemory: 20}, sandbox.ErrMemoryLimit},
+		{`let s = "abcd"; [s + s, 1]`, sandbox.Limits{MaxSteps: 10, MaxStringLen: 8, MaxArrayLen: 2, MaxMemory: 40}, nil},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.Limits = tt.limits
+		if err := vm.Run(); !errors.Is(err, tt.expected) {
+			t.Errorf("%q: expected error %v, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	builtins := object.NewBuiltins()
+	builtins.Register("stop", func(args ...object.Object) object.Object {
+		cancel()
+		return object.Null{}
+	})
+	comp := compiler.NewWithBuiltins(builtins)
+	if err := comp.Compile(parse("stop();" + strings.Repeat("1;", 2000))); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := NewWithBuiltins(comp.Bytecode(), builtins)
+	if err := vm.RunContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := vm.RunContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled before running, got %v", err)
+	}
+}